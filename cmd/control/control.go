@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/base64"
 	"fmt"
 	"log/slog"
@@ -12,10 +15,16 @@ import (
 
 	"golang.org/x/crypto/ssh"
 
+	"github.com/gpuctl/gpuctl/internal/alerting"
+	"github.com/gpuctl/gpuctl/internal/broadcast"
 	"github.com/gpuctl/gpuctl/internal/config"
 	"github.com/gpuctl/gpuctl/internal/database"
+	"github.com/gpuctl/gpuctl/internal/filestore"
 	"github.com/gpuctl/gpuctl/internal/groundstation"
+	"github.com/gpuctl/gpuctl/internal/pki"
 	"github.com/gpuctl/gpuctl/internal/tunnel"
+	"github.com/gpuctl/gpuctl/internal/uplink"
+	"github.com/gpuctl/gpuctl/internal/uplinkauth"
 	"github.com/gpuctl/gpuctl/internal/webapi"
 )
 
@@ -28,12 +37,83 @@ func main() {
 		fatal("failed to get config: " + err.Error())
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err = migrateCommand(conf.Database, os.Args[2:])
+		if err != nil {
+			fatal("failed to migrate: " + err.Error())
+		}
+		return
+	}
+
 	db, err := initialiseDatabase(conf.Database)
 	if err != nil {
 		fatal("failed to initialise database: " + err.Error())
 	}
 
-	gs := groundstation.NewServer(db)
+	retention := database.DefaultRetention
+	if len(conf.Database.Retention) > 0 {
+		retention = nil
+		for _, tier := range conf.Database.Retention {
+			retention = append(retention, database.RetentionTier{After: tier.After, Step: tier.Step})
+		}
+	}
+	err = retention.Validate()
+	if err != nil {
+		fatal("failed to get config: " + err.Error())
+	}
+
+	rules, err := alerting.ParseRules(conf.Alerting.Rules)
+	if err != nil {
+		fatal("failed to get config: " + err.Error())
+	}
+
+	files, err := initialiseFileStore(conf.Files)
+	if err != nil {
+		fatal("failed to initialise file store: " + err.Error())
+	}
+
+	// files from before the store existed are kept in postgres, move them out
+	if legacy, ok := db.(database.LegacyFiles); ok {
+		moved, err := legacy.MoveLegacyFiles(func(file broadcast.FileInfo, contents []byte) (broadcast.FileInfo, error) {
+			saved, err := filestore.Save(context.Background(), files, bytes.NewReader(contents), 0)
+			file.Key = saved.Key
+			file.Size = saved.Size
+			file.Checksum = saved.Checksum
+			return file, err
+		})
+		if err != nil {
+			fatal("failed to move files into the file store: " + err.Error())
+		}
+		if moved > 0 {
+			log.Info("Moved files from the database into the file store", "files", moved)
+		}
+	}
+
+	uplinkSecret := conf.Auth.UplinkSecret
+	if uplinkSecret == "" {
+		uplinkSecret = os.Getenv("GPU_UPLINK_SECRET")
+	}
+
+	var uplinkAuth *uplinkauth.Verifier
+	if uplinkSecret != "" {
+		uplinkAuth = &uplinkauth.Verifier{Secret: []byte(uplinkSecret)}
+	} else {
+		// Same as the SSH key, allow running without for local development
+		log.Warn("No uplink secret given, will accept data from any satellite claiming to be any machine")
+	}
+
+	// satellites are asked to run as they would have been onboarded, so that
+	// changes here reach them without being onboarded again
+	remote := conf.SSH.RemoteConf.Satellite
+	settings := uplink.Settings{
+		DataInterval:      remote.DataInterval,
+		SampleInterval:    remote.SampleInterval,
+		HeartbeatInterval: remote.HeartbeatInterval,
+		Collectors:        remote.Collectors,
+		ProcessFilter:     remote.ProcessFilter,
+	}
+	gs := groundstation.NewServer(db, uplinkAuth, settings)
+	gs.CheckAlerts(rules)
 	gsPort := config.PortToAddress(conf.Server.GSPort)
 
 	var signer ssh.Signer
@@ -63,10 +143,21 @@ func main() {
 		log.Warn("No SSH key given, will not be able to handle onboard requests")
 	}
 
+	var ca *pki.CA
+	var gsTLS *tls.Config
+	if conf.TLS.Enabled {
+		ca, gsTLS, err = setupTLS(conf)
+		if err != nil {
+			fatal("failed to set up TLS: " + err.Error())
+		}
+	}
+
 	tunnelConf := tunnel.Config{
 		User:            conf.SSH.Username,
 		DataDirTemplate: conf.SSH.DataDir,
 		RemoteConf:      conf.SSH.RemoteConf,
+		UplinkSecret:    []byte(uplinkSecret),
+		CA:              ca,
 		Signer:          signer,
 		KeyCallback:     ssh.InsecureIgnoreHostKey(), // TODO: Be secure here.
 	}
@@ -93,13 +184,22 @@ func main() {
 	}()
 
 	authenticator := webapi.AuthenticatorFromConfig(conf)
-	wa := webapi.NewServer(db, &authenticator, tunnelConf, &totalEnergy)
+	// a gpu that's gone longer than this without a sample wasn't being
+	// reported on, so its users aren't charged for the time
+	maxSampleGap := max(conf.Timeouts.DeathTimeout(), 2*remote.SampleEvery())
+	wa := webapi.NewServer(db, &authenticator, tunnelConf, files, conf.Files.MaxSize, maxSampleGap, &totalEnergy)
 	waPort := config.PortToAddress(conf.Server.WAPort)
 
 	errs := make(chan (error), 1)
 
 	go func() {
-		err := http.ListenAndServe(gsPort, gs)
+		var err error
+		if gsTLS != nil {
+			srv := &http.Server{Addr: gsPort, Handler: gs, TLSConfig: gsTLS}
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = http.ListenAndServe(gsPort, gs)
+		}
 		errs <- fmt.Errorf("groundstation: %w", err)
 	}()
 	go func() {
@@ -111,13 +211,21 @@ func main() {
 		http.ListenAndServe(":6060", nil)
 	}()
 	go func() {
-		err := database.DownsampleOverTime(conf.Database.DownsampleInterval, db)
+		err := database.DownsampleOverTime(conf.Database.DownsampleInterval, retention, db)
 		errs <- fmt.Errorf("downsampler: %w", err)
 	}()
 	go func() {
 		err := groundstation.MonitorForDeadMachines(db, conf.Timeouts, log.With(), tunnelConf)
 		errs <- fmt.Errorf("dead machine monitor: %w", err)
 	}()
+	go func() {
+		err := groundstation.MonitorInventory(db, conf.Timeouts, settings, log.With())
+		errs <- fmt.Errorf("inventory monitor: %w", err)
+	}()
+	go func() {
+		err := alerting.Monitor(db, rules, conf.Timeouts, log.With())
+		errs <- fmt.Errorf("alerting: %w", err)
+	}()
 
 	slog.Info("started servers")
 	err = <-errs
@@ -125,17 +233,108 @@ func main() {
 }
 
 func initialiseDatabase(conf config.Database) (database.Database, error) {
+	set := 0
+	for _, enabled := range []bool{conf.InMemory, conf.Postgres, conf.Sqlite} {
+		if enabled {
+			set++
+		}
+	}
+
 	switch {
-	case conf.InMemory && conf.Postgres:
-		return nil, fmt.Errorf("cannot have both 'inmemory' and 'postgres' set")
+	case set > 1:
+		return nil, fmt.Errorf("can only set one of 'inmemory', 'postgres' or 'sqlite'")
 	case conf.InMemory:
 		return database.InMemory(), nil
 	case conf.Postgres:
 		return database.Postgres(conf.PostgresUrl)
+	case conf.Sqlite:
+		return database.Sqlite(conf.SqlitePath)
+	default:
+		return nil, fmt.Errorf("must set one of 'inmemory', 'postgres' or 'sqlite'")
+	}
+}
+
+func initialiseFileStore(conf config.FileStore) (filestore.Store, error) {
+	if conf.S3.Bucket == "" {
+		return filestore.Local(conf.Dir)
+	}
+
+	secret := conf.S3.SecretKey
+	if secret == "" {
+		secret = os.Getenv("GPU_S3_SECRET_KEY")
+	}
+	return filestore.S3(filestore.S3Config{
+		Endpoint:  conf.S3.Endpoint,
+		Bucket:    conf.S3.Bucket,
+		Region:    conf.S3.Region,
+		AccessKey: conf.S3.AccessKey,
+		SecretKey: secret,
+	})
+}
+
+// `control migrate status` lists the postgres schema migrations and whether
+// they've been applied, and `control migrate up` applies any that haven't,
+// without starting the servers
+func migrateCommand(conf config.Database, args []string) error {
+	if !conf.Postgres {
+		return fmt.Errorf("only postgres databases have migrations")
+	}
+
+	switch {
+	case len(args) == 1 && args[0] == "status":
+		statuses, err := database.PostgresMigrationStatus(conf.PostgresUrl)
+		if err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format(time.DateTime)
+			}
+			fmt.Printf("%04d %-24s %s\n", status.Version, status.Name, applied)
+		}
+		return nil
+	case len(args) == 1 && args[0] == "up":
+		applied, err := database.MigratePostgres(conf.PostgresUrl)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("applied %d migrations\n", applied)
+		return nil
 	default:
-		return nil, fmt.Errorf("must set one of 'inmemory' or 'postgres'")
+		return fmt.Errorf("usage: control migrate status|up")
+	}
+}
+
+// load (or create) our CA, and the groundstation's TLS config, which requires
+// satellites to have a certificate from it
+func setupTLS(conf config.ControlConfiguration) (*pki.CA, *tls.Config, error) {
+	ca, err := pki.LoadOrCreateCA(conf.TLS.CACert, conf.TLS.CAKey)
+	if err != nil {
+		return nil, nil, err
 	}
+
+	var certPEM, keyPEM []byte
+	if conf.TLS.ServerCert != "" {
+		certPEM, err = os.ReadFile(conf.TLS.ServerCert)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyPEM, err = os.ReadFile(conf.TLS.ServerKey)
+	} else {
+		// issue ourselves one for the name satellites are told to use
+		certPEM, keyPEM, err = ca.IssueServer(conf.SSH.RemoteConf.Groundstation.Hostname, "localhost")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gsTLS, err := ca.ServerConfig(certPEM, keyPEM)
+	return ca, gsTLS, err
 }
+
 func fatal(s string) {
 	slog.Error(s)
 	os.Exit(1)
@@ -52,13 +52,15 @@ func main() {
 		User:            *user,
 		DataDirTemplate: dataDir + *user,
 		Signer:          signer,
+		UplinkSecret:    []byte(os.Getenv("GPU_UPLINK_SECRET")),
 		RemoteConf: config.SatelliteConfiguration{
-			Groundstation: config.Groundstation{"https://", "gpuctl.perial.co.uk", 80},
+			Groundstation: config.Groundstation{Protocol: "https://", Hostname: "gpuctl.perial.co.uk", Port: 80},
 			Satellite: config.Satellite{
 				DataInterval:      10 * time.Second,
 				HeartbeatInterval: 5 * time.Second,
 				FakeGPU:           true,
 				Cache:             "/data/gpuctl/cache",
+				MaxBacklog:        10000,
 			},
 		},
 	}
@@ -1,20 +1,28 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
-	"strings"
+	"reflect"
+	"runtime/debug"
+	"slices"
+	"sync/atomic"
 	"time"
 
+	"github.com/gpuctl/gpuctl/internal/backlog"
 	"github.com/gpuctl/gpuctl/internal/config"
 	"github.com/gpuctl/gpuctl/internal/femto"
 	"github.com/gpuctl/gpuctl/internal/gpustats"
 	"github.com/gpuctl/gpuctl/internal/passwd"
+	"github.com/gpuctl/gpuctl/internal/pki"
 	"github.com/gpuctl/gpuctl/internal/procinfo"
+	"github.com/gpuctl/gpuctl/internal/types"
 	"github.com/gpuctl/gpuctl/internal/uplink"
+	"github.com/gpuctl/gpuctl/internal/uplinkauth"
 
 	"github.com/google/uuid"
 )
@@ -39,22 +47,88 @@ func main() {
 
 	satellite_configuration, err := config.GetSatellite("satellite.toml")
 
-	log.Info("got config", "config", satellite_configuration)
-
 	if err != nil {
 		log.Error("Failed to get satellite configuration from toml configuration file", "err", err)
 		os.Exit(1)
 	}
 
+	// don't log the token
+	logged := satellite_configuration
+	if logged.Satellite.Token != "" {
+		logged.Satellite.Token = "<redacted>"
+	}
+	log.Info("got config", "config", logged)
+
+	// we report under the name we were onboarded as, as that's what our
+	// token was issued for
+	if satellite_configuration.Satellite.Hostname != "" {
+		host = satellite_configuration.Satellite.Hostname
+		log.Info("reporting under configured hostname", "hostname", host)
+	}
+
+	transport, err := groundstationTransport(satellite_configuration.Groundstation)
+	if err != nil {
+		log.Error("Failed to load TLS certificates", "err", err)
+		os.Exit(1)
+	}
+
+	// Keep trying for a little while if the groundstation is briefly away,
+	// but not so long that we hold up the next heartbeat or collection
+	client := &femto.Client{
+		Transport:  transport,
+		Timeout:    10 * time.Second,
+		Retries:    3,
+		Backoff:    time.Second,
+		MaxBackoff: 10 * time.Second,
+		Logger:     log,
+
+		ContentType:     uplinkContentType(satellite_configuration.Satellite.UplinkFormat),
+		ContentEncoding: satellite_configuration.Satellite.UplinkCompression,
+	}
+	if err := client.CheckEncoding(); err != nil {
+		log.Error("Invalid uplink format or compression", "err", err)
+		os.Exit(1)
+	}
+	if satellite_configuration.Satellite.Token != "" {
+		client.Transport = uplinkauth.Signer{
+			Hostname: host,
+			Token:    satellite_configuration.Satellite.Token,
+			Base:     transport,
+		}
+	} else {
+		log.Warn("No uplink token configured, requests will not be signed")
+	}
+
 	s := satellite{
 		gsAddr: config.GenerateAddress(
 			satellite_configuration.Groundstation.Protocol,
 			satellite_configuration.Groundstation.Hostname,
 			satellite_configuration.Groundstation.Port),
 		hostname: host,
+		client:   client,
+		version:  uplink.Version{Protocol: uplink.ProtocolVersion, SatelliteVersion: buildVersion()},
+		statsUrl: uplink.GPUStatsUrl,
 	}
+	log.Info("Running satellite", "protocol", s.version.Protocol, "satellite_version", s.version.SatelliteVersion)
 
-	hndlr := setGPUHandler(log, satellite_configuration.Satellite.FakeGPU)
+	// settings from the groundstation are applied on top of what we were
+	// configured with, so that we go back to it if they're removed
+	configured := satellite_configuration.Satellite
+	current := configured
+
+	settings, err := s.handshake()
+	if err != nil {
+		// Carry on as configured, if the groundstation is old it'll tell us
+		// when we send stats
+		log.Error("Failed to handshake with the groundstation", "err", err)
+	} else {
+		current = applySettings(configured, settings)
+	}
+	logSettings(log, "Using settings", current)
+	s.setProcessFilter(current.ProcessFilter)
+
+	detected := setGPUHandler(log, current.FakeGPU, s.keepProcess)
+	hndlr := selectCollectors(log, detected, current.Collectors)
 
 	// Send initial infopacket of GPUInfo
 	log.Info("Sending initial GPU context")
@@ -63,53 +137,52 @@ func main() {
 		log.Error("Failed to send GPU context", "err", err)
 	}
 
+	// the groundstation replies to each heartbeat with how it wants us to run,
+	// which we pass on to be applied between collections
+	settingsUpdates := make(chan uplink.Settings, 1)
+	heartbeatInterval := current.HeartbeatInterval
+
 	go func() {
 		for {
 			log.Info("Sending heartbeat")
-			err := s.sendHeartBeat()
+			resp, err := s.sendHeartBeat()
 
 			if err != nil {
 				log.Error("failed to send heartbeat", "err", err)
+			} else {
+				heartbeatInterval = applySettings(configured, resp.Settings).HeartbeatInterval
+
+				// only the latest settings matter
+				select {
+				case <-settingsUpdates:
+				default:
+				}
+				settingsUpdates <- resp.Settings
 			}
 
-			time.Sleep(time.Duration(satellite_configuration.Satellite.HeartbeatInterval))
+			time.Sleep(heartbeatInterval)
 		}
 	}()
 
-	// backlog, _ := recoverState(satellite_configuration.Satellite.Cache)
-
-	// for stat := range backlog {
-	// 	err := s.sendGPUStatus(backlog[stat])
-
-	// 	if err != nil {
-	// 		log.Error("Failed to send backlogged GPU stat message", "err", err)
-	// 	}
-	// }
-
-	backlog := make([][]uplink.GPUStatSample, 0)
+	// Anything we collected but couldn't send before we last stopped is still
+	// in the cache, and will be sent with the first publish
+	queue, err := backlog.Open(satellite_configuration.Satellite.Cache, satellite_configuration.Satellite.MaxBacklog)
+	if err != nil {
+		log.Error("Failed to open backlog cache", "cache", satellite_configuration.Satellite.Cache, "err", err)
+		os.Exit(1)
+	}
+	log.Info("Recovered backlog", "batches", queue.Len())
 
-	collectGPUStatTicker := time.NewTicker(time.Duration(satellite_configuration.Satellite.DataInterval))
-	publishGPUStatTicker := time.NewTicker(time.Duration(satellite_configuration.Satellite.DataInterval))
+	collectGPUStatTicker := time.NewTicker(current.SampleEvery())
+	publishGPUStatTicker := time.NewTicker(current.DataInterval)
 
 	// Go has no API for a ticker with an instantaneous first tick (see
 	// https://github.com/golang/go/issues/17601) so we have to use a clunky
 	// work-around
 	publishGPUStats := func() {
-		log.Info("Sending status")
-
-		err = s.sendGPUStatus(processStats(backlog))
-
-		if err == errSuspectedServerMissingInfo {
-			// Send context again to try and refresh server
-			log.Info("Server could not handle our sample submission. Resubmitting GPU contextual information.")
-			err = s.sendGPUInfo(hndlr)
-			if err != nil {
-				// Just quit, server is either broken or refuses to acknowledge us
-				log.Error("Server did not accept our resubmittal of contextual information. Quitting...")
-				os.Exit(-1)
-			}
-		}
+		log.Info("Sending status", "batches", queue.Len())
 
+		err = s.publish(queue, hndlr)
 		if err != nil {
 			log.Error("Failed to publish current GPU stat message", "err", err)
 		}
@@ -124,8 +197,20 @@ func main() {
 			log.Error("Failed to get GPU stat from stat handler", "err", err)
 		}
 
-		backlog = append(backlog, stat)
-		saveState(satellite_configuration.Satellite.Cache, backlog)
+		if stat == nil {
+			return
+		}
+
+		// stamp samples now, as they may not be sent until much later
+		now := time.Now().Unix()
+		for i := range stat {
+			stat[i].Time = now
+		}
+
+		err = queue.Push(stat)
+		if err != nil {
+			log.Error("Failed to save GPU stats to the backlog", "err", err)
+		}
 	}
 
 	collectGPUStats()
@@ -137,6 +222,34 @@ func main() {
 			publishGPUStats()
 		case <-collectGPUStatTicker.C:
 			collectGPUStats()
+		case settings := <-settingsUpdates:
+			next := applySettings(configured, settings)
+			if reflect.DeepEqual(next, current) {
+				continue
+			}
+			logSettings(log, "Groundstation changed our settings", next)
+
+			if !reflect.DeepEqual(next.ProcessFilter, current.ProcessFilter) {
+				s.setProcessFilter(next.ProcessFilter)
+			}
+
+			if next.SampleEvery() != current.SampleEvery() {
+				collectGPUStatTicker.Reset(next.SampleEvery())
+			}
+			if next.DataInterval != current.DataInterval {
+				publishGPUStatTicker.Reset(next.DataInterval)
+			}
+			if !slices.Equal(next.Collectors, current.Collectors) {
+				hndlr = selectCollectors(log, detected, next.Collectors)
+
+				// we may be reporting GPUs the groundstation hasn't heard of
+				err := s.sendGPUInfo(hndlr)
+				if err != nil {
+					log.Error("Failed to send GPU context", "err", err)
+				}
+			}
+
+			current = next
 		}
 	}
 }
@@ -144,112 +257,339 @@ func main() {
 type satellite struct {
 	hostname string
 	gsAddr   string
+	client   *femto.Client
+	version  uplink.Version
+	statsUrl string // where to send GPU stats, which depends on how old the groundstation is
+	filter   atomic.Pointer[processMatcher]
+}
+
+type processMatcher = func(uplink.GPUProcInfo) bool
+
+// Unless we're configured otherwise, every process other than the desktop
+// counts as using a GPU
+var defaultProcessFilter = &uplink.ProcessFilter{IgnoreDesktop: true}
+
+// Use the given process filter from now on, or the default if nil. If it's
+// invalid, we keep using whichever we had before.
+func (s *satellite) setProcessFilter(filter *uplink.ProcessFilter) {
+	if filter == nil {
+		filter = defaultProcessFilter
+	}
+
+	matcher, err := filter.Matcher()
+	if err != nil {
+		slog.Error("Invalid process filter, ignoring it", "filter", filter, "err", err)
+		if s.filter.Load() != nil {
+			return
+		}
+		matcher, _ = defaultProcessFilter.Matcher()
+	}
+	s.filter.Store(&matcher)
+}
+
+// whether a process counts as using a GPU, safe to call while the filter
+// changes
+func (s *satellite) keepProcess(proc uplink.GPUProcInfo) bool {
+	return (*s.filter.Load())(proc)
 }
 
 type flags struct {
 	fakeGPUs bool
 }
 
-func saveState(filename string, items [][]uplink.GPUStatSample) error {
-	data, err := json.Marshal(items)
+// Most collections we'll put in a single upload, so that catching up after a
+// long outage doesn't make one enormous request
+const maxBatchesPerUpload = 500
+
+// Send everything in the backlog, oldest first, removing batches once the
+// groundstation has accepted them. Everything collected since the last publish
+// goes in one upload. We stop at the first failure so that the rest can be
+// retried in order on the next publish.
+func (s *satellite) publishBacklog(queue *backlog.Queue) error {
+	pending := queue.Pending()
 
+	for len(pending) > 0 {
+		n := min(len(pending), maxBatchesPerUpload)
+
+		var stats []uplink.GPUStatSample
+		for _, batch := range pending[:n] {
+			stats = append(stats, batch...)
+		}
+
+		err := s.sendGPUStatus(stats)
+		if err != nil {
+			return err
+		}
+
+		err = queue.Ack(n)
+		if err != nil {
+			return err
+		}
+
+		pending = pending[n:]
+	}
+	return nil
+}
+
+// Publish the backlog. If the groundstation can't handle it, likely because it
+// forgot about one of our GPUs, we send it our GPU information again and
+// retry. Should that fail too, some batch is never going to be accepted, so
+// rather than have it hold up everything behind it for good, the batches of
+// the upload that failed are sent one at a time and those still refused are
+// dropped.
+func (s *satellite) publish(queue *backlog.Queue, hndlr gpustats.GPUDataSource) error {
+	err := s.publishBacklog(queue)
+	if err != errSuspectedServerMissingInfo {
+		return err
+	}
+
+	slog.Info("Server could not handle our sample submission. Resubmitting GPU contextual information.")
+	err = s.sendGPUInfo(hndlr)
 	if err != nil {
+		slog.Error("Server did not accept our resubmittal of contextual information", "err", err)
+	}
+
+	err = s.publishBacklog(queue)
+	if err != errSuspectedServerMissingInfo {
 		return err
 	}
 
-	return os.WriteFile(filename, data, 0o644)
+	err = s.dropRejected(queue, maxBatchesPerUpload)
+	if err != nil {
+		return err
+	}
+	return s.publishBacklog(queue)
 }
 
-func recoverState(filename string) ([][]uplink.GPUStatSample, error) {
-	data, err := os.ReadFile(filename)
+// Send up to n batches from the head of the backlog one at a time, dropping
+// those the groundstation can't handle, and stopping at any other failure
+func (s *satellite) dropRejected(queue *backlog.Queue, n int) error {
+	pending := queue.Pending()
 
+	for _, batch := range pending[:min(n, len(pending))] {
+		err := s.sendGPUStatus(batch)
+		if err == errSuspectedServerMissingInfo {
+			slog.Error("Server will not accept a batch of samples, dropping it", "samples", batch)
+		} else if err != nil {
+			return err
+		}
+
+		err = queue.Ack(1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Transport for talking to the groundstation, trusting only the configured CA
+// and presenting our client certificate, if we have them
+func groundstationTransport(conf config.Groundstation) (http.RoundTripper, error) {
+	if conf.CA == "" {
+		return http.DefaultTransport, nil
+	}
+
+	caPEM, err := os.ReadFile(conf.CA)
 	if err != nil {
 		return nil, err
 	}
 
-	var state [][]uplink.GPUStatSample
-
-	err = json.Unmarshal(data, &state)
+	var certPEM, keyPEM []byte
+	if conf.Cert != "" {
+		certPEM, err = os.ReadFile(conf.Cert)
+		if err != nil {
+			return nil, err
+		}
+		keyPEM, err = os.ReadFile(conf.Key)
+		if err != nil {
+			return nil, err
+		}
+	}
 
+	tlsConf, err := pki.ClientConfig(caPEM, certPEM, keyPEM)
 	if err != nil {
 		return nil, err
 	}
 
-	return state, nil
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConf
+	return transport, nil
 }
 
-// Dummy function to process list of stats
-func processStats(stats [][]uplink.GPUStatSample) []uplink.GPUStatSample {
-	return stats[len(stats)-1]
-}
-
-func setGPUHandler(log *slog.Logger, isFakeGPUs bool) gpustats.GPUDataSource {
+func setGPUHandler(log *slog.Logger, isFakeGPUs bool, filter func(uplink.GPUProcInfo) bool) gpustats.GPUDataSource {
 	if isFakeGPUs {
-		// generate two random throwaway uuids for the fake gpu
-		fakeUuid1, err1 := uuid.NewRandom()
-		fakeUuid2, err2 := uuid.NewRandom()
-		err := errors.Join(err1, err2)
-		if err != nil {
-			log.Error("Could not generate random uuid, will use default constructed one", "err", err)
-			return gpustats.FakeGPU{}
-		}
-		return gpustats.FakeGPU{Uuids: [2]uuid.UUID{fakeUuid1, fakeUuid2}}
+		return fakeGPUHandler(log)
+	}
+
+	var lookup procinfo.UidLookup
+	passwdfile, err := os.Open("/etc/passwd")
+	if err != nil {
+		log.Error("Could not open passwd file, will not be able to report users' names", "err", err)
 	} else {
-		passwdfile, err := os.Open("/etc/passwd")
-		if err != nil {
-			log.Error("Could not open passwd file, will not be able to report users' names", "err", err)
-			return gpustats.NvidiaGPUHandler{}
-		}
 		passwd, err := passwd.Parse(passwdfile)
 		if err != nil {
 			log.Error("Could not read passwd file, will not be able to report users' names", "err", err)
-			return gpustats.NvidiaGPUHandler{}
+		} else {
+			lookup = procinfo.PasswdToLookup(passwd)
 		}
+	}
 
-		// Filter function for determining a busy process: it contains "python"
-		filter := func(proc uplink.GPUProcInfo) bool {
-			return strings.Contains(proc.Name, "python")
-		}
+	hndlr, err := gpustats.Detect(lookup, filter)
+	if err != nil {
+		// Keep going with nvidia-smi, so we report errors on every collection
+		log.Error("Could not find a GPU vendor tool, assuming nvidia-smi", "err", err)
+		return gpustats.NvidiaGPUHandler{Lookup: lookup, ProcFilter: filter}
+	}
 
-		return gpustats.NvidiaGPUHandler{Lookup: procinfo.PasswdToLookup(passwd), ProcFilter: filter}
+	for _, backend := range hndlr {
+		log.Info("Detected GPU vendor tool", "backend", backend.Name)
 	}
+	return hndlr
 }
 
-func (s *satellite) sendHeartBeat() error {
-	resp, err := femto.Post(
-		s.gsAddr+uplink.HeartbeatUrl,
-		uplink.HeartbeatReq{Hostname: s.hostname},
+func fakeGPUHandler(log *slog.Logger) gpustats.GPUDataSource {
+	// generate two random throwaway uuids for the fake gpu
+	fakeUuid1, err1 := uuid.NewRandom()
+	fakeUuid2, err2 := uuid.NewRandom()
+	err := errors.Join(err1, err2)
+	if err != nil {
+		log.Error("Could not generate random uuid, will use default constructed one", "err", err)
+		return gpustats.FakeGPU{}
+	}
+	return gpustats.FakeGPU{Uuids: [2]uuid.UUID{fakeUuid1, fakeUuid2}}
+}
+
+// Check the groundstation can understand us, and find out how it would like
+// us to run. Groundstations from before the handshake existed don't know it,
+// and only accept stats at the old URL.
+func (s *satellite) handshake() (uplink.Settings, error) {
+	resp, err := femto.PostJSON[uplink.HandshakeReq, uplink.HandshakeResp](
+		context.Background(),
+		s.client,
+		s.gsAddr+uplink.HandshakeUrl,
+		uplink.HandshakeReq{Hostname: s.hostname, Version: s.version},
 	)
 
-	if err != nil {
-		return err
+	var statusErr *femto.StatusError
+	if errors.As(err, &statusErr) && statusErr.Status == http.StatusNotFound {
+		slog.Warn("Groundstation is too old to handshake, using the legacy protocol")
+		s.statsUrl = uplink.LegacyGPUStatsUrl
+		return uplink.Settings{}, nil
+	} else if errors.As(err, &statusErr) && statusErr.Status == http.StatusUpgradeRequired {
+		return uplink.Settings{}, fmt.Errorf("this satellite is too old for the groundstation and must be updated: %w", err)
+	} else if err != nil {
+		return uplink.Settings{}, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return err // TODO: add errors for each status code
+	if s.version.Protocol > resp.MaxProtocol {
+		slog.Warn("Groundstation is older than this satellite, some of what we send may be ignored", "max_protocol", resp.MaxProtocol)
 	}
-	return nil
+	return resp.Settings, nil
 }
 
-func (s *satellite) sendGPUInfo(gpuhandler gpustats.GPUDataSource) error {
-	info, err := gpuhandler.GetGPUInformation()
-	if err != nil {
-		return err
+// Override our configuration with whatever the groundstation asked for
+func applySettings(conf config.Satellite, settings uplink.Settings) config.Satellite {
+	if settings.DataInterval > 0 {
+		conf.DataInterval = settings.DataInterval
+	}
+	if settings.SampleInterval > 0 {
+		conf.SampleInterval = settings.SampleInterval
 	}
+	if settings.HeartbeatInterval > 0 {
+		conf.HeartbeatInterval = settings.HeartbeatInterval
+	}
+	if len(settings.Collectors) > 0 {
+		conf.Collectors = settings.Collectors
+	}
+	if settings.ProcessFilter != nil {
+		conf.ProcessFilter = settings.ProcessFilter
+	}
+	return conf
+}
+
+func logSettings(log *slog.Logger, msg string, conf config.Satellite) {
+	log.Info(msg, "data_interval", conf.DataInterval, "sample_interval", conf.SampleEvery(), "heartbeat_interval", conf.HeartbeatInterval, "collectors", conf.Collectors, "process_filter", conf.ProcessFilter)
+}
+
+// Only collect from the named vendor tools, if any are named
+func selectCollectors(log *slog.Logger, source gpustats.GPUDataSource, names []string) gpustats.GPUDataSource {
+	composite, ok := source.(gpustats.Composite)
+	if !ok || len(names) == 0 {
+		return source
+	}
+
+	selected := composite.Only(names)
+	if len(selected) == 0 {
+		log.Warn("None of the requested collectors are installed, using all of them", "collectors", names)
+		return composite
+	}
+	return selected
+}
 
-	resp, err := femto.Post(
-		s.gsAddr+uplink.GPUStatsUrl,
-		uplink.GpuStatsUpload{Hostname: s.hostname, GPUInfos: info},
+// The version of this build, from the module version if installed with
+// `go install`, or the commit it was built from
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+
+	revision, modified := "devel", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+
+	if modified {
+		return revision + "-dirty"
+	}
+	return revision
+}
+
+func (s *satellite) sendHeartBeat() (uplink.HeartbeatResp, error) {
+	return femto.PostJSON[uplink.HeartbeatReq, uplink.HeartbeatResp](
+		context.Background(),
+		s.client,
+		s.gsAddr+uplink.HeartbeatUrl,
+		uplink.HeartbeatReq{Hostname: s.hostname, Version: s.version},
 	)
+}
 
-	if err != nil {
-		return err
+// Send stats to the groundstation, falling back to the legacy URL if it turns
+// out to be too old for the current one
+func (s *satellite) postStats(upload uplink.GpuStatsUpload) error {
+	upload.Version = s.version
+
+	_, err := femto.PostJSON[uplink.GpuStatsUpload, types.Unit](context.Background(), s.client, s.gsAddr+s.statsUrl, upload)
+
+	var statusErr *femto.StatusError
+	if errors.As(err, &statusErr) && statusErr.Status == http.StatusNotFound && s.statsUrl != uplink.LegacyGPUStatsUrl {
+		slog.Warn("Groundstation doesn't know the current stats URL, falling back to the legacy one")
+		s.statsUrl = uplink.LegacyGPUStatsUrl
+		_, err = femto.PostJSON[uplink.GpuStatsUpload, types.Unit](context.Background(), s.client, s.gsAddr+s.statsUrl, upload)
 	}
+	return err
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return err // TODO: Add errors for each code
+// Send the groundstation what our GPUs are. If only some of them could be
+// read, we still send those, as their stats would be refused otherwise
+func (s *satellite) sendGPUInfo(gpuhandler gpustats.GPUDataSource) error {
+	info, err := gpuhandler.GetGPUInformation()
+	if err != nil {
+		slog.Warn("Failed to get information about some GPUs", "err", err)
+		if info == nil {
+			return err
+		}
 	}
-	return nil
+
+	return s.postStats(uplink.GpuStatsUpload{Hostname: s.hostname, GPUInfos: info})
 }
 
 func (s *satellite) sendGPUStatusWithSource(gpuhandler gpustats.GPUDataSource) error {
@@ -266,20 +606,29 @@ func (s *satellite) sendGPUStatusWithSource(gpuhandler gpustats.GPUDataSource) e
 
 }
 
+// Any error means the samples weren't stored, so must stay in the backlog for
+// another go
 func (s *satellite) sendGPUStatus(stats []uplink.GPUStatSample) error {
-	resp, err := femto.Post(
-		s.gsAddr+uplink.GPUStatsUrl,
-		uplink.GpuStatsUpload{Hostname: s.hostname, Stats: stats},
-	)
-
-	if err != nil {
-		return err
-	}
+	err := s.postStats(uplink.GpuStatsUpload{Hostname: s.hostname, Stats: stats})
 
-	if resp.StatusCode == http.StatusBadRequest {
+	var statusErr *femto.StatusError
+	if errors.As(err, &statusErr) && statusErr.Status == http.StatusBadRequest {
 		// Could not send status, suspected cause is server does not have context.
 		return errSuspectedServerMissingInfo
+	}
+
+	return err
+}
 
+// the content type for an uplink_format in our configuration. Anything we
+// don't know is passed through, for the client to reject.
+func uplinkContentType(format string) string {
+	switch format {
+	case "", "json":
+		return femto.ContentTypeJSON
+	case "cbor":
+		return femto.ContentTypeCBOR
+	default:
+		return format
 	}
-	return err // TODO: Handle errors cases
 }
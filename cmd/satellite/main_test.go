@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gpuctl/gpuctl/internal/backlog"
+	"github.com/gpuctl/gpuctl/internal/database"
+	"github.com/gpuctl/gpuctl/internal/femto"
+	"github.com/gpuctl/gpuctl/internal/gpustats"
+	"github.com/gpuctl/gpuctl/internal/groundstation"
+	"github.com/gpuctl/gpuctl/internal/uplink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type brokenSource struct{}
+
+func (brokenSource) GetGPUStatus() ([]uplink.GPUStatSample, error) {
+	return nil, errors.New("vendor tool exploded")
+}
+
+func (brokenSource) GetGPUInformation() ([]uplink.GPUInfo, error) {
+	return nil, errors.New("vendor tool exploded")
+}
+
+// a satellite for ash01, talking to a groundstation backed by db
+func testSatellite(t *testing.T, db database.Database) *satellite {
+	t.Helper()
+
+	srv := httptest.NewServer(groundstation.NewServer(db, nil, uplink.Settings{}))
+	t.Cleanup(srv.Close)
+
+	return &satellite{
+		hostname: "ash01",
+		gsAddr:   srv.URL,
+		client:   &femto.Client{},
+		version:  uplink.Version{Protocol: uplink.ProtocolVersion},
+		statsUrl: uplink.GPUStatsUrl,
+	}
+}
+
+func TestGPUInfoIsSentIfABackendFails(t *testing.T) {
+	t.Parallel()
+
+	db := database.InMemory()
+	s := testSatellite(t, db)
+
+	working := gpustats.FakeGPU{Uuids: [2]uuid.UUID{uuid.New(), uuid.New()}}
+	src := gpustats.Composite{{Name: "broken", Source: brokenSource{}}, {Name: "working", Source: working}}
+
+	require.NoError(t, s.sendGPUInfo(src))
+
+	machines, err := db.GPUMachines(working.Uuids[:])
+	require.NoError(t, err)
+	assert.Equal(t, map[uuid.UUID]string{working.Uuids[0]: "ash01", working.Uuids[1]: "ash01"}, machines)
+
+	// so the working GPUs' stats are accepted
+	assert.NoError(t, s.sendGPUStatusWithSource(src))
+
+	// but if nothing could be read, there's nothing to send
+	assert.Error(t, s.sendGPUInfo(brokenSource{}))
+}
+
+func TestRejectedBatchesDontBlockTheBacklog(t *testing.T) {
+	t.Parallel()
+
+	db := database.InMemory()
+	s := testSatellite(t, db)
+	src := gpustats.FakeGPU{Uuids: [2]uuid.UUID{uuid.New(), uuid.New()}}
+
+	queue, err := backlog.Open(filepath.Join(t.TempDir(), "backlog"), 0)
+	require.NoError(t, err)
+
+	// the groundstation will never have heard of the gpu at the head
+	require.NoError(t, queue.Push(backlog.Batch{{Uuid: uuid.New(), Time: 1}}))
+	require.NoError(t, queue.Push(backlog.Batch{{Uuid: src.Uuids[0], Time: 2}}))
+	require.NoError(t, queue.Push(backlog.Batch{{Uuid: src.Uuids[1], Time: 3}}))
+
+	require.NoError(t, s.publish(queue, src))
+	assert.Zero(t, queue.Len())
+
+	history, err := db.HistoricalData("ash01", database.HistoryQuery{})
+	require.NoError(t, err)
+	assert.Len(t, history, 2)
+
+	// and ones after it are sent together again
+	require.NoError(t, queue.Push(backlog.Batch{{Uuid: src.Uuids[0], Time: 4}}))
+	require.NoError(t, s.publish(queue, src))
+	assert.Zero(t, queue.Len())
+}
@@ -19,12 +19,26 @@ import {
 
 const AXIS_MARGIN = { x: 20, y: 20 };
 
+// A point on a line, which can stand for `n` samples that ranged from `lo` to
+// `hi`, if they've been averaged
+export type GraphPoint = {
+  x: number;
+  y: number;
+  lo?: number;
+  hi?: number;
+  n?: number;
+};
+
+const lowest = ({ y, lo }: GraphPoint) => lo ?? y;
+const highest = ({ y, hi }: GraphPoint) => hi ?? y;
+const weight = ({ n }: GraphPoint) => n ?? 1;
+
 export const Graph = ({
   data,
   xlabel,
   maxPoints,
 }: {
-  data: ({ off: number; line: { x: number; y: number }[] } | null)[];
+  data: ({ off: number; line: GraphPoint[] } | null)[];
   xlabel: string;
   maxPoints: number;
 }) => {
@@ -35,10 +49,10 @@ export const Graph = ({
     ...catNulls(data.flatMap((d) => d?.line?.map(({ x }) => x))),
   );
   const minY = Math.min(
-    ...catNulls(data.flatMap((d) => d?.line?.map(({ y }) => y))),
+    ...catNulls(data.flatMap((d) => d?.line?.map(lowest))),
   );
   const maxY = Math.max(
-    ...catNulls(data.flatMap((d) => d?.line?.map(({ y }) => y))),
+    ...catNulls(data.flatMap((d) => d?.line?.map(highest))),
   );
 
   const ref = useRef<HTMLHeadingElement>(null);
@@ -68,13 +82,17 @@ export const Graph = ({
     const lineMaxX = Math.max(...line.map(({ x }) => x));
     const lineFrac = (lineMaxX - lineMinX) / (maxX - minX);
 
+    // averages are weighted by how many samples they stand for, and the
+    // range is kept so that peaks don't get smoothed away
     const chunkSize = Math.ceil(line.length / (maxPoints * lineFrac));
     return chunks(line, chunkSize, off).map((c) => {
-      const { x, y } = c.reduce(({ x: x1, y: y1 }, { x: x2, y: y2 }) => ({
-        x: x1,
-        y: y1 + y2,
-      }));
-      return { x, y: y / c.length };
+      const n = c.reduce((total, p) => total + weight(p), 0);
+      return {
+        x: c[0].x,
+        y: c.reduce((total, p) => total + p.y * weight(p), 0) / n,
+        lo: Math.min(...c.map(lowest)),
+        hi: Math.max(...c.map(highest)),
+      };
     });
   });
 
@@ -82,6 +100,14 @@ export const Graph = ({
     lineBuilder(d.map(({ x, y }) => [x, y])),
   );
 
+  const areaBuilder = d3
+    .area<{ x: number; lo: number; hi: number }>()
+    .x(({ x }) => xScale(x))
+    .y0(({ lo }) => yScale(lo))
+    .y1(({ hi }) => yScale(hi));
+
+  const rangePaths = mapNotNulls(downsampled, (d) => areaBuilder(d));
+
   return (
     <Box minWidth={200} minHeight={400} ref={ref}>
       <svg width={width} height={height}>
@@ -136,6 +162,15 @@ export const Graph = ({
             />
           </g>
 
+          {rangePaths.map((p, i) => (
+            <path
+              d={p ?? undefined}
+              fill={GRAPH_COLS[i % GRAPH_COLS.length]}
+              fillOpacity={0.2}
+              stroke="none"
+            />
+          ))}
+
           {linePaths.map((p, i) => (
             <path
               d={p ?? undefined}
@@ -174,14 +174,9 @@ export const GroupInfoManagement = ({
   const removeFile = useRemoveFile();
 
   const handleFileUpload = (name: string) => {
-    return async (event: React.ChangeEvent<HTMLInputElement>) => {
+    return (event: React.ChangeEvent<HTMLInputElement>) => {
       if (!event.target.files) return;
-      const file = event.target.files[0];
-
-      const arrayBuffer = await file.arrayBuffer();
-      const uint8Array = new Uint8Array(arrayBuffer);
-
-      uploadFile(name, file.type, file.name, uint8Array);
+      uploadFile(name, event.target.files[0]);
     };
   };
 
@@ -104,7 +104,7 @@ export const WorkstationCardMin = ({
                   <Heading size="md">{`${s.gpu_name} (${(
                     s.memory_total / 1000
                   ).toFixed(0)} GB)`}</Heading>
-                  <Text>{`${s.in_use ? `🔴 In-use (User: ${s.user})` : "🟢 Available"}`}</Text>
+                  <Text>{`${s.in_use ? `🔴 In-use (${s.users.length === 1 ? "User" : "Users"}: ${s.users.map((u) => u.name).join(", ")})` : "🟢 Available"}`}</Text>
                   <Text>{`${s.gpu_util < 10 ? "🐌" : "🏎️" + "☁️".repeat(Math.ceil(s.gpu_util / 40))} GPU Usage: ${Math.round(s.gpu_util)}%`}</Text>
                   <Text>{`${s.gpu_temp < 75 ? "❄️" : s.gpu_temp < 95 ? "🌡️" : "🔥"} ${Math.round(
                     s.gpu_temp,
@@ -33,7 +33,7 @@ import {
   Stack,
 } from "@chakra-ui/react";
 import { Navigate, useSearchParams } from "react-router-dom";
-import { Graph } from "./Graph";
+import { Graph, GraphPoint } from "./Graph";
 import { useHistoryStats } from "../Hooks/Hooks";
 import { useState } from "react";
 import { GraphField, WorkStationData } from "../Data";
@@ -217,18 +217,16 @@ const StatsGraphPanel = ({
 
   const historyStats = useHistoryStats(hostname);
 
-  const statsToDisplay: Validation<
-    {
-      x: number;
-      y: number;
-    }[][]
-  > = USE_FAKE_STATS
+  const statsToDisplay: Validation<GraphPoint[][]> = USE_FAKE_STATS
     ? success([FAKE_STATS])
     : mapSuccess(historyStats, (hist) => {
         return hist.map((h) =>
-          h.map(({ timestamp, sample }) => ({
+          h.map(({ timestamp, sample, min, max, samples }) => ({
             x: timestamp * 1000, // Typescript uses ms since epoch (not second)
             y: sample[GPU_FIELDS[field]],
+            lo: min?.[GPU_FIELDS[field]],
+            hi: max?.[GPU_FIELDS[field]],
+            n: samples,
           })),
         );
       });
@@ -348,7 +346,7 @@ const StatsGraph = ({
   stats,
   numGPUs,
 }: {
-  stats: { x: number; y: number }[][];
+  stats: GraphPoint[][];
   numGPUs: number;
 }) => {
   const minTS =
@@ -2,6 +2,8 @@
 
 export type WorkStationGroup<E = {}> = {
   name: string;
+  description: string;
+  order: number;
   workstations: (WorkStationData & E)[];
 };
 
@@ -13,6 +15,22 @@ export type WorkStationData = {
   owner: string;
   gpus: GPUStats[];
   last_seen: number;
+  tags: string[];
+  metadata: Metadata[];
+};
+
+// The value is written as its type is, like 12.5, 2026-01-31 or true
+export type Metadata = {
+  key: string;
+  type: "string" | "number" | "date" | "bool";
+  value: string;
+};
+
+// Someone running processes on a GPU, memory_used is in megabytes
+export type GPUUser = {
+  name: string;
+  memory_used: number;
+  processes: number;
 };
 
 // This needs to be kept in sync with `internal/uplink/
@@ -22,6 +40,7 @@ export type GPUStats = {
   gpu_brand: string;
   driver_ver: string;
   memory_total: number;
+  backend: string;
 
   memory_util: number;
   gpu_util: number;
@@ -36,7 +55,8 @@ export type GPUStats = {
   memory_clock: number;
   max_memory_clock: number;
   in_use: boolean;
-  user: string;
+  missing: boolean;
+  users: GPUUser[];
 };
 
 export enum GraphField {
@@ -57,9 +77,76 @@ export type DurationDeltas = {
   seconds_since: number;
 };
 
+// Durations are in nanoseconds, and unset fields are inherited
+// Each field given is a regex the process must match
+export type ProcessMatch = {
+  name?: string;
+  cmdline?: string;
+  owner?: string;
+};
+
+export type ProcessFilter = {
+  include?: ProcessMatch[];
+  exclude?: ProcessMatch[];
+  min_memory?: number;
+  ignore_desktop?: boolean;
+};
+
+export type GPUProcess = {
+  pid: number;
+  name: string;
+  used_memory: number;
+  owner: string;
+  cmdline?: string;
+};
+
+// Returned by /api/stats/usage, for a user, group or machine
+export type Usage = {
+  name: string;
+  gpu_hours: number;
+  average_utilisation: number;
+  energy: number; // Joules
+};
+
+// Returned by /api/stats/jobs, times are unix seconds
+export type Job = {
+  gpu: string;
+  pid: number;
+  name: string;
+  owner: string;
+  first_seen: number;
+  last_seen: number;
+  peak_memory: number;
+  running: boolean;
+};
+
+// Returned by /api/admin/processes
+export type GPUProcesses = {
+  uuid: string;
+  processes: GPUProcess[];
+};
+
+// Intervals are durations like "15s" or "1m30s", of at least a second
+export type SatelliteSettings = {
+  data_interval?: string;
+  sample_interval?: string;
+  heartbeat_interval?: string;
+  process_filter?: ProcessFilter;
+  collectors?: string[];
+};
+
+// Exactly one of group and hostname is set
+export type SettingsOverride = {
+  group?: string;
+  hostname?: string;
+  settings: SatelliteSettings;
+};
+
 export const EXAMPLE_DATA_1: WorkStationGroup[] = [
   {
     name: "Shared",
+    description: "",
+    order: 0,
     workstations: [
       {
         name: "Workstation 1",
@@ -68,6 +155,8 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
         notes: "noisy fan",
         owner: "",
         last_seen: 500,
+        tags: [],
+        metadata: [],
         gpus: [
           {
             uuid: "AAAAA",
@@ -75,6 +164,7 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
             gpu_brand: "GeForce",
             driver_ver: "535.146.02",
             memory_total: 2048,
+            backend: "nvidia",
             memory_util: 0,
             gpu_util: 0,
             memory_used: 82,
@@ -88,7 +178,8 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
             memory_clock: 6,
             max_memory_clock: 7,
             in_use: false,
-            user: "",
+            missing: false,
+            users: [],
           },
         ],
       },
@@ -99,6 +190,8 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
         notes: "",
         owner: "Bob Barker",
         last_seen: 260,
+        tags: [],
+        metadata: [],
         gpus: [
           {
             uuid: "BBBBB",
@@ -106,6 +199,7 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
             gpu_brand: "Titan",
             driver_ver: "535.146.02",
             memory_total: 12288,
+            backend: "nvidia",
             memory_util: 0,
             gpu_util: 0,
             memory_used: 83,
@@ -119,7 +213,8 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
             memory_clock: 13,
             max_memory_clock: 14,
             in_use: false,
-            user: "",
+            missing: false,
+            users: [],
           },
           {
             uuid: "CCCCC",
@@ -127,6 +222,7 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
             gpu_brand: "Titan",
             driver_ver: "535.146.02",
             memory_total: 12288,
+            backend: "nvidia",
             memory_util: 0,
             gpu_util: 0,
             memory_used: 83,
@@ -140,7 +236,8 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
             memory_clock: 20,
             max_memory_clock: 21,
             in_use: true,
-            user: "",
+            missing: false,
+            users: [],
           },
         ],
       },
@@ -151,6 +248,8 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
         notes: "scheduled for replacement 2024",
         owner: "Kermit",
         last_seen: 30000000000,
+        tags: [],
+        metadata: [],
         gpus: [
           {
             uuid: "DDDDD",
@@ -158,6 +257,7 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
             gpu_brand: "GeForce",
             driver_ver: "470.223.02",
             memory_total: 2001,
+            backend: "nvidia",
             memory_util: 0,
             gpu_util: 0,
             memory_used: 220,
@@ -171,7 +271,8 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
             memory_clock: 27,
             max_memory_clock: 28,
             in_use: true,
-            user: "",
+            missing: false,
+            users: [],
           },
         ],
       },
@@ -183,6 +284,8 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
           "We don't particularly like this one, but it always works and we can't really bin it",
         owner: "",
         last_seen: 90000000000,
+        tags: [],
+        metadata: [],
         gpus: [
           {
             uuid: "EEEEE",
@@ -190,6 +293,7 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
             gpu_brand: "Titan",
             driver_ver: "535.146.02",
             memory_total: 12288,
+            backend: "nvidia",
             memory_util: 0,
             gpu_util: 0,
             memory_used: 83,
@@ -203,7 +307,8 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
             memory_clock: 34,
             max_memory_clock: 35,
             in_use: false,
-            user: "",
+            missing: false,
+            users: [],
           },
           {
             uuid: "FFFFF",
@@ -211,6 +316,7 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
             gpu_brand: "Titan",
             driver_ver: "535.146.02",
             memory_total: 12288,
+            backend: "nvidia",
             memory_util: 0,
             gpu_util: 0,
             memory_used: 83,
@@ -224,7 +330,8 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
             memory_clock: 41,
             max_memory_clock: 42,
             in_use: false,
-            user: "",
+            missing: false,
+            users: [],
           },
         ],
       },
@@ -235,6 +342,8 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
         notes: "Please don't use this unless you absolutely have to",
         owner: "Mort",
         last_seen: 40000000000,
+        tags: [],
+        metadata: [],
         gpus: [
           {
             uuid: "GGGGG",
@@ -242,6 +351,7 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
             gpu_brand: "GeForce",
             driver_ver: "535.146.02",
             memory_total: 2048,
+            backend: "nvidia",
             memory_util: 0,
             gpu_util: 0,
             memory_used: 82,
@@ -255,7 +365,8 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
             memory_clock: 48,
             max_memory_clock: 49,
             in_use: false,
-            user: "",
+            missing: false,
+            users: [],
           },
         ],
       },
@@ -263,6 +374,8 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
   },
   {
     name: "Other",
+    description: "",
+    order: 0,
     workstations: [
       {
         name: "Workstation 6",
@@ -271,6 +384,8 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
         notes: "",
         owner: "",
         last_seen: 180000000123,
+        tags: [],
+        metadata: [],
         gpus: [
           {
             uuid: "HHHHH",
@@ -278,6 +393,7 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
             gpu_brand: "GeForce",
             driver_ver: "470.223.02",
             memory_total: 2001,
+            backend: "nvidia",
             memory_util: 0,
             gpu_util: 0,
             memory_used: 220,
@@ -291,7 +407,8 @@ export const EXAMPLE_DATA_1: WorkStationGroup[] = [
             memory_clock: 55,
             max_memory_clock: 56,
             in_use: true,
-            user: "",
+            missing: false,
+            users: [],
           },
         ],
       },
@@ -17,9 +17,9 @@ const ADD_MACHINE_URL = "/add_workstation";
 const REMOVE_MACHINE_URL = "/rm_workstation";
 
 const GET_ALL_FILES_URL = "/list_files";
-const UPLOAD_FILE_URL = "/attach_file";
+const UPLOAD_FILE_URL = "/upload_file";
 const REMOVE_FILE_URL = "/remove_file";
-const GET_SPECIFIC_FILE_URL = "/get_file";
+const GET_SPECIFIC_FILE_URL = "/download_file";
 
 export const useGetAllFiles = (
   hostname: string,
@@ -34,32 +34,21 @@ export const useGetAllFiles = (
   return () => getAllFilesAuth({ method: "GET" });
 };
 
-const encodeFile = (file: Uint8Array) => {
-  let binaryString = "";
-  const len = file.byteLength;
-  for (let i = 0; i < len; i++) {
-    binaryString += String.fromCharCode(file[i]);
-  }
-  return btoa(binaryString);
-};
-
 export const useUploadFile = (callback: (r: Validated<Response>) => void) => {
   const { useAuthFetch } = useAuth();
   const [, uploadFileAuth] = useAuthFetch(UPLOAD_FILE_URL, callback);
 
-  return (hostname: string, mime: string, filename: string, file: Uint8Array) =>
+  // the browser sets the multipart boundary itself. The hostname has to come
+  // before the file, which the server reads as it arrives
+  return (hostname: string, file: File) => {
+    const form = new FormData();
+    form.append("hostname", hostname);
+    form.append("file", file, file.name);
     uploadFileAuth({
       method: "POST",
-      headers: {
-        "Content-Type": "application/json",
-      },
-      body: JSON.stringify({
-        hostname,
-        mime,
-        filename,
-        file_enc: encodeFile(file),
-      }),
+      body: form,
     });
+  };
 };
 
 export const useRemoveFile = () => {
@@ -238,20 +227,31 @@ export const useHandleSubmit = (
   });
 };
 
+// min and max are only set when samples are bucketed with `step` or have been
+// rolled up, in which case `sample` is the average of `samples` of them
 export type HistorySample = {
   timestamp: number;
   sample: GPUStats;
+  min?: GPUStats;
+  max?: GPUStats;
+  samples: number;
 };
 
 const GRAPH_REFRESH_INTERVAL = 5000;
 
+// How far back the graphs go, in seconds
+const GRAPH_HISTORY = 24 * 60 * 60;
+
 export const useHistoryStats = (
   hostname: string,
 ): Validation<HistorySample[][]> => {
   const [stats, updateStats] = useJarJar<HistorySample[][]>(async () =>
     success(
       await (
-        await fetch(API_URL + `/stats/historical?hostname=${hostname}`)
+        await fetch(
+          API_URL +
+            `/stats/historical?hostname=${hostname}&from=${Math.floor(Date.now() / 1000) - GRAPH_HISTORY}`,
+        )
       ).json(),
     ),
   );
@@ -103,18 +103,21 @@ const preProcess = (
   gs: WorkStationGroup[],
 ): WorkStationGroup<{ free: boolean }>[] =>
   gs
-    .map(({ name, workstations }) => {
+    .map(({ workstations, ...rest }) => {
       const [used, free] = partition(sortData(workstations), inUse);
 
       return {
-        name,
+        ...rest,
         workstations: tagFree(free, true).concat(tagFree(used, false)),
       };
     })
-    .sort((g1, g2) =>
-      g1.name === "Shared"
-        ? -1
-        : g2.name === "Shared"
-          ? 1
-          : g1.name.localeCompare(g2.name),
+    // groups are put in order by admins, then Shared comes first
+    .sort(
+      (g1, g2) =>
+        g1.order - g2.order ||
+        (g1.name === "Shared"
+          ? -1
+          : g2.name === "Shared"
+            ? 1
+            : g1.name.localeCompare(g2.name)),
     );
@@ -0,0 +1,248 @@
+// Package alerting checks alerting rules against every sample from each gpu
+// as it arrives, and against when machines were last seen. Alerts are pending
+// while a rule holds, firing once it has for long enough, and resolved when it
+// stops holding after firing. Alerts that stop before they fire aren't kept
+package alerting
+
+import (
+	"cmp"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+	"github.com/gpuctl/gpuctl/internal/config"
+	"github.com/gpuctl/gpuctl/internal/database"
+	"github.com/gpuctl/gpuctl/internal/uplink"
+)
+
+func Monitor(db database.Database, rules []Rule, timeouts config.Timeouts, l *slog.Logger) error {
+	alertTicker := time.NewTicker(timeouts.MonitorInterval())
+
+	for t := range alertTicker.C {
+		err := Evaluate(db, rules, t, timeouts.DeathTimeout(), l)
+
+		if err != nil {
+			l.Error("Error evaluating alert rules:", "error", err)
+		}
+	}
+
+	return nil
+}
+
+// what an alert is about. The gpu is zero for rules about machines
+type alertKey struct {
+	rule     string
+	hostname string
+	gpu      uuid.UUID
+}
+
+func keyOf(alert broadcast.Alert) alertKey {
+	key := alertKey{rule: alert.Rule, hostname: alert.Hostname}
+	if alert.Gpu != nil {
+		key.gpu = *alert.Gpu
+	}
+	return key
+}
+
+type evaluation struct {
+	db      database.Database
+	now     time.Time
+	l       *slog.Logger
+	active  map[alertKey]broadcast.Alert
+	checked map[alertKey]bool
+}
+
+func newEvaluation(db database.Database, now time.Time, l *slog.Logger) (*evaluation, error) {
+	alerts, err := db.ActiveAlerts()
+	if err != nil {
+		return nil, err
+	}
+
+	e := &evaluation{
+		db:      db,
+		now:     now,
+		l:       l,
+		active:  make(map[alertKey]broadcast.Alert),
+		checked: make(map[alertKey]bool),
+	}
+	for _, alert := range alerts {
+		e.active[keyOf(alert)] = alert
+	}
+	return e, nil
+}
+
+// Check the rules about gpus against each of a batch of samples from a
+// machine, in the order they were taken, so that nothing between the ones the
+// machine sends is missed and durations are timed by the samples. Samples
+// without a time are taken as sampled at now
+func EvaluateSamples(db database.Database, rules []Rule, hostname string, samples []uplink.GPUStatSample, now time.Time, l *slog.Logger) error {
+	e, err := newEvaluation(db, now, l)
+	if err != nil {
+		return err
+	}
+
+	sorted := slices.Clone(samples)
+	slices.SortStableFunc(sorted, func(a, b uplink.GPUStatSample) int {
+		return cmp.Compare(sampledAt(a, now).Unix(), sampledAt(b, now).Unix())
+	})
+
+	for _, sample := range sorted {
+		e.now = sampledAt(sample, now)
+		gpu := database.GPUFromSample(sample)
+
+		for _, rule := range rules {
+			if rule.Machine {
+				continue
+			}
+
+			value, holds := rule.checkGpu(gpu)
+			err = e.update(rule, hostname, &sample.Uuid, value, holds)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func sampledAt(sample uplink.GPUStatSample, now time.Time) time.Time {
+	if sample.Time == 0 {
+		return now
+	}
+	return time.Unix(sample.Time, 0)
+}
+
+// Check the rules about machines as of now, recording the alerts that change
+// state. Rules about gpus are checked as samples arrive, so here their alerts
+// are only resolved if the rule, machine or gpu has gone, or the gpu's stopped
+// sending samples. That's taken to be when it's missing or its machine hasn't
+// been seen for longer than timeout, as its latest sample is out of date
+func Evaluate(db database.Database, rules []Rule, now time.Time, timeout time.Duration, l *slog.Logger) error {
+	data, err := db.LatestData()
+	if err != nil {
+		return err
+	}
+	seens, err := db.LastSeen()
+	if err != nil {
+		return err
+	}
+	e, err := newEvaluation(db, now, l)
+	if err != nil {
+		return err
+	}
+
+	lastSeen := make(map[string]time.Time)
+	for _, seen := range seens {
+		lastSeen[seen.Hostname] = seen.LastSeen
+	}
+
+	for _, rule := range rules {
+		if rule.Machine {
+			for _, seen := range seens {
+				// machines that have been added but never seen aren't offline
+				if seen.LastSeen.Unix() <= 0 {
+					continue
+				}
+
+				value, holds := rule.checkMachine(now.Sub(seen.LastSeen))
+				err = e.update(rule, seen.Hostname, nil, value, holds)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		for _, group := range data {
+			for _, machine := range group.Workstations {
+				if now.Sub(lastSeen[machine.Name]) > timeout {
+					continue
+				}
+
+				for _, gpu := range machine.Gpus {
+					if !gpu.Missing {
+						e.checked[alertKey{rule: rule.Name, hostname: machine.Name, gpu: gpu.Uuid}] = true
+					}
+				}
+			}
+		}
+	}
+
+	// the alert can't hold any more, or can't be checked
+	for key, alert := range e.active {
+		if !e.checked[key] {
+			err = e.stop(alert)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// move an alert on, given whether its rule holds now
+func (e *evaluation) update(rule Rule, hostname string, gpu *uuid.UUID, value float64, holds bool) error {
+	key := alertKey{rule: rule.Name, hostname: hostname}
+	if gpu != nil {
+		key.gpu = *gpu
+	}
+	e.checked[key] = true
+
+	alert, exists := e.active[key]
+	switch {
+	case holds && !exists:
+		alert = broadcast.Alert{
+			Rule:       rule.Name,
+			Expression: rule.Expression,
+			Hostname:   hostname,
+			Gpu:        gpu,
+			State:      broadcast.AlertPending,
+			Value:      value,
+			Since:      e.now.Unix(),
+		}
+		if rule.For == 0 {
+			return e.fire(alert, value)
+		}
+		id, err := e.db.RecordAlert(alert)
+		alert.Id = id
+		e.active[key] = alert
+		return err
+	case holds && alert.State == broadcast.AlertPending && e.now.Sub(time.Unix(alert.Since, 0)) >= rule.For:
+		return e.fire(alert, value)
+	case !holds && exists:
+		return e.stop(alert)
+	}
+	return nil
+}
+
+func (e *evaluation) fire(alert broadcast.Alert, value float64) error {
+	alert.State = broadcast.AlertFiring
+	alert.Value = value
+	alert.FiredAt = e.now.Unix()
+
+	e.l.Warn("Alert firing", "rule", alert.Rule, "hostname", alert.Hostname, "gpu", alert.Gpu, "value", value)
+	id, err := e.db.RecordAlert(alert)
+	if alert.Id == 0 {
+		alert.Id = id
+	}
+	e.active[keyOf(alert)] = alert
+	return err
+}
+
+func (e *evaluation) stop(alert broadcast.Alert) error {
+	delete(e.active, keyOf(alert))
+	if alert.State == broadcast.AlertPending {
+		return e.db.RemoveAlert(alert.Id)
+	}
+
+	alert.State = broadcast.AlertResolved
+	alert.ResolvedAt = e.now.Unix()
+
+	e.l.Info("Alert resolved", "rule", alert.Rule, "hostname", alert.Hostname, "gpu", alert.Gpu)
+	_, err := e.db.RecordAlert(alert)
+	return err
+}
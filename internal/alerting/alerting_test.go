@@ -0,0 +1,223 @@
+package alerting_test
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gpuctl/gpuctl/internal/alerting"
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+	"github.com/gpuctl/gpuctl/internal/database"
+	"github.com/gpuctl/gpuctl/internal/uplink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// a database with one machine, whose one gpu's latest sample was at temp
+func hotMachine(t *testing.T, temp float64) (database.Database, uuid.UUID) {
+	t.Helper()
+
+	db := database.InMemory()
+	gpu := uuid.New()
+	require.NoError(t, db.UpdateLastSeen("gpu01", time.Now()))
+	require.NoError(t, db.UpdateGPUContext("gpu01", uplink.GPUInfo{Uuid: gpu}))
+	require.NoError(t, db.AppendDataPoint(uplink.GPUStatSample{Uuid: gpu, Temp: temp}))
+	return db, gpu
+}
+
+func parse(t *testing.T, name string, expression string) []alerting.Rule {
+	t.Helper()
+
+	rule, err := alerting.ParseRule(name, expression)
+	require.NoError(t, err)
+	return []alerting.Rule{rule}
+}
+
+// a sample from the gpu at temp, taken at t
+func hot(gpu uuid.UUID, temp float64, t time.Time) uplink.GPUStatSample {
+	return uplink.GPUStatSample{Uuid: gpu, Temp: temp, Time: t.Unix()}
+}
+
+func TestAlertsFireAfterTheirDuration(t *testing.T) {
+	db, gpu := hotMachine(t, 95)
+	rules := parse(t, "overheating", "gpu_temp > 88 for 5m")
+	now := time.Now()
+
+	assert.NoError(t, alerting.EvaluateSamples(db, rules, "gpu01", []uplink.GPUStatSample{
+		hot(gpu, 95, now), hot(gpu, 95, now.Add(2*time.Minute)),
+	}, now, slog.Default()))
+
+	active, err := db.ActiveAlerts()
+	assert.NoError(t, err)
+	if assert.Len(t, active, 1) {
+		assert.Equal(t, broadcast.AlertPending, active[0].State)
+		assert.Equal(t, gpu, *active[0].Gpu)
+		assert.Equal(t, 95.0, active[0].Value)
+		assert.Equal(t, now.Unix(), active[0].Since)
+	}
+
+	// the timer leaves it to the samples
+	assert.NoError(t, alerting.Evaluate(db, rules, now.Add(5*time.Minute), time.Hour, slog.Default()))
+	active, err = db.ActiveAlerts()
+	assert.NoError(t, err)
+	if assert.Len(t, active, 1) {
+		assert.Equal(t, broadcast.AlertPending, active[0].State)
+	}
+
+	assert.NoError(t, alerting.EvaluateSamples(db, rules, "gpu01", []uplink.GPUStatSample{
+		hot(gpu, 96, now.Add(5*time.Minute)),
+	}, now, slog.Default()))
+
+	active, err = db.ActiveAlerts()
+	assert.NoError(t, err)
+	if assert.Len(t, active, 1) {
+		assert.Equal(t, broadcast.AlertFiring, active[0].State)
+		assert.Equal(t, 96.0, active[0].Value)
+		assert.Equal(t, now.Add(5*time.Minute).Unix(), active[0].FiredAt)
+	}
+
+	// it cools down
+	assert.NoError(t, alerting.EvaluateSamples(db, rules, "gpu01", []uplink.GPUStatSample{
+		hot(gpu, 70, now.Add(6*time.Minute)),
+	}, now, slog.Default()))
+
+	active, err = db.ActiveAlerts()
+	assert.NoError(t, err)
+	assert.Empty(t, active)
+
+	history, err := db.AlertHistory(now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, broadcast.AlertResolved, history[0].State)
+		assert.Equal(t, now.Add(6*time.Minute).Unix(), history[0].ResolvedAt)
+	}
+}
+
+func TestSpikesWithinABatchFire(t *testing.T) {
+	db, gpu := hotMachine(t, 70)
+	rules := parse(t, "overheating", "gpu_temp > 88")
+	now := time.Now()
+
+	// only the last sample of the batch is the latest
+	assert.NoError(t, alerting.EvaluateSamples(db, rules, "gpu01", []uplink.GPUStatSample{
+		hot(gpu, 70, now.Add(2*time.Minute)), hot(gpu, 70, now), hot(gpu, 95, now.Add(time.Minute)),
+	}, now, slog.Default()))
+
+	active, err := db.ActiveAlerts()
+	assert.NoError(t, err)
+	assert.Empty(t, active)
+
+	history, err := db.AlertHistory(now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, broadcast.AlertResolved, history[0].State)
+		assert.Equal(t, 95.0, history[0].Value)
+		assert.Equal(t, now.Add(time.Minute).Unix(), history[0].FiredAt)
+		assert.Equal(t, now.Add(2*time.Minute).Unix(), history[0].ResolvedAt)
+	}
+}
+
+func TestAlertsThatStopBeforeFiringAreForgotten(t *testing.T) {
+	db, gpu := hotMachine(t, 95)
+	rules := parse(t, "overheating", "gpu_temp > 88 for 5m")
+	now := time.Now()
+
+	assert.NoError(t, alerting.EvaluateSamples(db, rules, "gpu01", []uplink.GPUStatSample{
+		hot(gpu, 95, now), hot(gpu, 70, now.Add(time.Minute)),
+	}, now, slog.Default()))
+
+	active, err := db.ActiveAlerts()
+	assert.NoError(t, err)
+	assert.Empty(t, active)
+
+	history, err := db.AlertHistory(now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Empty(t, history)
+}
+
+func TestConditionsAllHaveToHold(t *testing.T) {
+	gpu := uuid.New()
+	rules := parse(t, "fan", "fan_speed == 0 while gpu_util > 50")
+	db := database.InMemory()
+	now := time.Now()
+
+	// an idle card can have its fan stopped
+	assert.NoError(t, alerting.EvaluateSamples(db, rules, "gpu01", []uplink.GPUStatSample{
+		{Uuid: gpu, FanSpeed: 0, GPUUtilisation: 20},
+	}, now, slog.Default()))
+	active, err := db.ActiveAlerts()
+	assert.NoError(t, err)
+	assert.Empty(t, active)
+
+	assert.NoError(t, alerting.EvaluateSamples(db, rules, "gpu01", []uplink.GPUStatSample{
+		{Uuid: gpu, FanSpeed: 0, GPUUtilisation: 90},
+	}, now, slog.Default()))
+	active, err = db.ActiveAlerts()
+	assert.NoError(t, err)
+	if assert.Len(t, active, 1) {
+		assert.Equal(t, broadcast.AlertFiring, active[0].State)
+		assert.Equal(t, now.Unix(), active[0].FiredAt)
+	}
+}
+
+func TestOfflineMachinesFire(t *testing.T) {
+	db := database.InMemory()
+	now := time.Now()
+	require.NoError(t, db.UpdateLastSeen("gpu01", now.Add(-time.Hour)))
+	require.NoError(t, db.UpdateLastSeen("gpu02", now))
+	group := "lab"
+	require.NoError(t, db.NewMachine(broadcast.NewMachine{Hostname: "gpu03", Group: &group}))
+
+	rules := parse(t, "offline", "offline > 30m")
+	assert.NoError(t, alerting.Evaluate(db, rules, now, time.Hour, slog.Default()))
+
+	active, err := db.ActiveAlerts()
+	assert.NoError(t, err)
+	if assert.Len(t, active, 1) {
+		assert.Equal(t, "gpu01", active[0].Hostname)
+		assert.Nil(t, active[0].Gpu)
+		assert.Equal(t, broadcast.AlertFiring, active[0].State)
+		assert.Equal(t, time.Hour.Seconds(), active[0].Value)
+	}
+}
+
+func TestAlertsOnOfflineMachinesResolve(t *testing.T) {
+	db, gpu := hotMachine(t, 95)
+	rules := parse(t, "overheating", "gpu_temp > 88")
+	now := time.Now()
+
+	assert.NoError(t, alerting.EvaluateSamples(db, rules, "gpu01", []uplink.GPUStatSample{hot(gpu, 95, now)}, now, slog.Default()))
+	assert.NoError(t, alerting.Evaluate(db, rules, now, 5*time.Minute, slog.Default()))
+	active, err := db.ActiveAlerts()
+	assert.NoError(t, err)
+	assert.Len(t, active, 1)
+
+	// the machine's stopped reporting, so its last sample is out of date
+	assert.NoError(t, alerting.Evaluate(db, rules, now.Add(10*time.Minute), 5*time.Minute, slog.Default()))
+	active, err = db.ActiveAlerts()
+	assert.NoError(t, err)
+	assert.Empty(t, active)
+
+	history, err := db.AlertHistory(now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, broadcast.AlertResolved, history[0].State)
+	}
+}
+
+func TestAlertsForRemovedRulesResolve(t *testing.T) {
+	db, gpu := hotMachine(t, 95)
+	now := time.Now()
+
+	assert.NoError(t, alerting.EvaluateSamples(db, parse(t, "overheating", "gpu_temp > 88"), "gpu01", []uplink.GPUStatSample{hot(gpu, 95, now)}, now, slog.Default()))
+	assert.NoError(t, alerting.Evaluate(db, nil, now.Add(time.Minute), time.Hour, slog.Default()))
+
+	active, err := db.ActiveAlerts()
+	assert.NoError(t, err)
+	assert.Empty(t, active)
+
+	history, err := db.AlertHistory(now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+}
@@ -0,0 +1,177 @@
+package alerting
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+	"github.com/gpuctl/gpuctl/internal/config"
+)
+
+var ErrInvalidRule = errors.New("invalid alert rule")
+
+// the metric rules about machines check, in seconds
+const offline = "offline"
+
+// gpu metrics rules can check, by their names in the web API
+var metrics = map[string]func(broadcast.GPU) float64{
+	"gpu_util":         func(gpu broadcast.GPU) float64 { return gpu.GPUUtilisation },
+	"memory_util":      func(gpu broadcast.GPU) float64 { return gpu.MemoryUtilisation },
+	"memory_used":      func(gpu broadcast.GPU) float64 { return gpu.MemoryUsed },
+	"fan_speed":        func(gpu broadcast.GPU) float64 { return gpu.FanSpeed },
+	"gpu_temp":         func(gpu broadcast.GPU) float64 { return gpu.Temp },
+	"memory_temp":      func(gpu broadcast.GPU) float64 { return gpu.MemoryTemp },
+	"graphics_voltage": func(gpu broadcast.GPU) float64 { return gpu.GraphicsVoltage },
+	"power_draw":       func(gpu broadcast.GPU) float64 { return gpu.PowerDraw },
+	"graphics_clock":   func(gpu broadcast.GPU) float64 { return gpu.GraphicsClock },
+	"memory_clock":     func(gpu broadcast.GPU) float64 { return gpu.MemoryClock },
+}
+
+var conditionPattern = regexp.MustCompile(`^([a-z_]+)(>=|<=|==|!=|>|<)(.+)$`)
+
+// A comparison of a metric against a threshold
+type Condition struct {
+	Metric    string
+	Operator  string
+	Threshold float64
+}
+
+func (c Condition) holds(value float64) bool {
+	switch c.Operator {
+	case ">":
+		return value > c.Threshold
+	case ">=":
+		return value >= c.Threshold
+	case "<":
+		return value < c.Threshold
+	case "<=":
+		return value <= c.Threshold
+	case "==":
+		return value == c.Threshold
+	default:
+		return value != c.Threshold
+	}
+}
+
+// A parsed alerting rule. Rules about machines only check how long they've
+// been offline, and rules about gpus check their latest sample
+type Rule struct {
+	Name       string
+	Expression string        // as it was written
+	Conditions []Condition   // all of which have to hold
+	For        time.Duration // how long they have to before the alert fires
+	Machine    bool          // whether it's about machines rather than gpus
+}
+
+// Parse a rule like "gpu_temp > 88 for 5m", "fan_speed == 0 while
+// gpu_util > 50" or "offline > 30m". Conditions are joined by "while" or
+// "and", and all have to hold
+func ParseRule(name string, expression string) (Rule, error) {
+	rule := Rule{Name: strings.TrimSpace(name), Expression: strings.TrimSpace(expression)}
+	if rule.Name == "" {
+		return rule, fmt.Errorf("%w: rules need a name", ErrInvalidRule)
+	}
+
+	words := strings.Fields(expression)
+	var condition []string
+	for i := 0; i <= len(words); i++ {
+		if i < len(words) && words[i] != "while" && words[i] != "and" && words[i] != "for" {
+			condition = append(condition, words[i])
+			continue
+		}
+
+		parsed, err := parseCondition(strings.Join(condition, ""))
+		if err != nil {
+			return rule, fmt.Errorf("%w: %s: %w", ErrInvalidRule, rule.Name, err)
+		}
+		rule.Conditions = append(rule.Conditions, parsed)
+		condition = nil
+
+		if i < len(words) && words[i] == "for" {
+			if i != len(words)-2 {
+				return rule, fmt.Errorf("%w: %s: for has to be followed by just a duration", ErrInvalidRule, rule.Name)
+			}
+			rule.For, err = time.ParseDuration(words[i+1])
+			if err != nil || rule.For < 0 {
+				return rule, fmt.Errorf("%w: %s: %q isn't a duration like 5m", ErrInvalidRule, rule.Name, words[i+1])
+			}
+			break
+		}
+	}
+
+	for _, condition := range rule.Conditions {
+		if condition.Metric == offline {
+			rule.Machine = true
+		}
+	}
+	if rule.Machine && (len(rule.Conditions) > 1 || rule.For != 0) {
+		return rule, fmt.Errorf("%w: %s: offline has to be a rule by itself", ErrInvalidRule, rule.Name)
+	}
+
+	return rule, nil
+}
+
+func parseCondition(condition string) (Condition, error) {
+	match := conditionPattern.FindStringSubmatch(condition)
+	if match == nil {
+		return Condition{}, fmt.Errorf("%q isn't a comparison like gpu_temp > 88", condition)
+	}
+	parsed := Condition{Metric: match[1], Operator: match[2]}
+
+	if parsed.Metric == offline {
+		after, err := time.ParseDuration(match[3])
+		if err != nil || after <= 0 || (parsed.Operator != ">" && parsed.Operator != ">=") {
+			return parsed, fmt.Errorf("%q isn't like offline > 30m", condition)
+		}
+		parsed.Threshold = after.Seconds()
+		return parsed, nil
+	}
+
+	if _, ok := metrics[parsed.Metric]; !ok {
+		return parsed, fmt.Errorf("unknown metric %q", parsed.Metric)
+	}
+	threshold, err := strconv.ParseFloat(match[3], 64)
+	if err != nil {
+		return parsed, fmt.Errorf("%q isn't a number", match[3])
+	}
+	parsed.Threshold = threshold
+	return parsed, nil
+}
+
+// Parse the rules from the config, which have to have different names
+func ParseRules(rules []config.AlertRule) ([]Rule, error) {
+	var parsed []Rule
+	names := make(map[string]bool)
+	for _, rule := range rules {
+		p, err := ParseRule(rule.Name, rule.Rule)
+		if err != nil {
+			return nil, err
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("%w: there's more than one rule called %s", ErrInvalidRule, p.Name)
+		}
+		names[p.Name] = true
+		parsed = append(parsed, p)
+	}
+	return parsed, nil
+}
+
+// Check a rule about gpus against one's latest sample, giving the value of
+// its first metric
+func (r Rule) checkGpu(gpu broadcast.GPU) (float64, bool) {
+	holds := true
+	for _, condition := range r.Conditions {
+		holds = holds && condition.holds(metrics[condition.Metric](gpu))
+	}
+	return metrics[r.Conditions[0].Metric](gpu), holds
+}
+
+// Check a rule about machines against how long one's been offline, giving
+// that in seconds
+func (r Rule) checkMachine(offlineFor time.Duration) (float64, bool) {
+	return offlineFor.Seconds(), r.Conditions[0].holds(offlineFor.Seconds())
+}
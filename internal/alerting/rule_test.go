@@ -0,0 +1,85 @@
+package alerting_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gpuctl/gpuctl/internal/alerting"
+	"github.com/gpuctl/gpuctl/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRule(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expression string
+		expected   alerting.Rule
+	}{
+		{"gpu_temp > 88 for 5m", alerting.Rule{
+			Conditions: []alerting.Condition{{Metric: "gpu_temp", Operator: ">", Threshold: 88}},
+			For:        5 * time.Minute,
+		}},
+		{"fan_speed == 0 while gpu_util > 50", alerting.Rule{
+			Conditions: []alerting.Condition{
+				{Metric: "fan_speed", Operator: "==", Threshold: 0},
+				{Metric: "gpu_util", Operator: ">", Threshold: 50},
+			},
+		}},
+		{"memory_used>=40000 and power_draw<100", alerting.Rule{
+			Conditions: []alerting.Condition{
+				{Metric: "memory_used", Operator: ">=", Threshold: 40000},
+				{Metric: "power_draw", Operator: "<", Threshold: 100},
+			},
+		}},
+		{"offline > 30m", alerting.Rule{
+			Conditions: []alerting.Condition{{Metric: "offline", Operator: ">", Threshold: 1800}},
+			Machine:    true,
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			rule, err := alerting.ParseRule("rule", test.expression)
+			assert.NoError(t, err)
+
+			test.expected.Name = "rule"
+			test.expected.Expression = test.expression
+			assert.Equal(t, test.expected, rule)
+		})
+	}
+}
+
+func TestParseInvalidRules(t *testing.T) {
+	t.Parallel()
+
+	for _, expression := range []string{
+		"",
+		"gpu_temp",
+		"gpu_temp > hot",
+		"gpu_temperature > 88",
+		"gpu_temp > 88 for",
+		"gpu_temp > 88 for five minutes",
+		"gpu_temp > 88 while",
+		"offline > 30",
+		"offline < 30m",
+		"offline > 30m for 5m",
+		"offline > 30m while gpu_util > 50",
+	} {
+		_, err := alerting.ParseRule("rule", expression)
+		assert.ErrorIs(t, err, alerting.ErrInvalidRule, expression)
+	}
+
+	_, err := alerting.ParseRule(" ", "gpu_temp > 88")
+	assert.ErrorIs(t, err, alerting.ErrInvalidRule)
+}
+
+func TestParseRulesNeedDifferentNames(t *testing.T) {
+	t.Parallel()
+
+	_, err := alerting.ParseRules([]config.AlertRule{
+		{Name: "hot", Rule: "gpu_temp > 88"},
+		{Name: "hot", Rule: "memory_temp > 95"},
+	})
+	assert.ErrorIs(t, err, alerting.ErrInvalidRule)
+}
@@ -1,6 +1,7 @@
 package authentication
 
 import (
+	"context"
 	"errors"
 	"log/slog"
 	"net/http"
@@ -31,6 +32,19 @@ type Authenticator[AuthCredientals any] interface {
 	CheckToken(AuthToken) (Username, error)
 }
 
+type userKey struct{}
+
+// UserOf gives the username of whoever made a request that's been through one
+// of the wrappers, or "" if it hasn't
+func UserOf(request *http.Request) Username {
+	user, _ := request.Context().Value(userKey{}).(Username)
+	return user
+}
+
+func withUser(request *http.Request, user Username) *http.Request {
+	return request.WithContext(context.WithValue(request.Context(), userKey{}, user))
+}
+
 func AuthWrapGet[A any, T any](auth Authenticator[A], handle femto.GetFunc[T]) femto.GetFunc[T] {
 	return func(request *http.Request, logger *slog.Logger) (*femto.Response[T], error) {
 		c, err := request.Cookie(TokenCookieName)
@@ -39,12 +53,12 @@ func AuthWrapGet[A any, T any](auth Authenticator[A], handle femto.GetFunc[T]) f
 		}
 
 		token := c.Value
-		_, err = auth.CheckToken(token)
+		user, err := auth.CheckToken(token)
 		if err != nil {
 			return &femto.Response[T]{Status: http.StatusUnauthorized}, NotAuthenticatedError
 		}
 
-		return handle(request, logger)
+		return handle(withUser(request, user), logger)
 	}
 }
 
@@ -56,10 +70,27 @@ func AuthWrapPost[A any, T any](auth Authenticator[A], handle femto.PostFunc[T])
 		}
 
 		token := c.Value
-		_, err = auth.CheckToken(token)
+		user, err := auth.CheckToken(token)
 		if err != nil {
 			return &femto.EmptyBodyResponse{Status: http.StatusUnauthorized}, NotAuthenticatedError
 		}
-		return handle(data, request, logger)
+		return handle(data, withUser(request, user), logger)
+	}
+}
+
+func AuthWrapStream[A any](auth Authenticator[A], handle femto.StreamFunc) femto.StreamFunc {
+	return func(w http.ResponseWriter, request *http.Request, logger *slog.Logger) error {
+		c, err := request.Cookie(TokenCookieName)
+		if err != nil {
+			http.Error(w, NotAuthenticatedError.Error(), http.StatusUnauthorized)
+			return NotAuthenticatedError
+		}
+
+		user, err := auth.CheckToken(c.Value)
+		if err != nil {
+			http.Error(w, NotAuthenticatedError.Error(), http.StatusUnauthorized)
+			return NotAuthenticatedError
+		}
+		return handle(w, withUser(request, user), logger)
 	}
 }
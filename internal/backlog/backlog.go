@@ -0,0 +1,168 @@
+// Store-and-forward queue for samples collected by the satellite, so that
+// nothing is lost while the groundstation is unreachable
+package backlog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/gpuctl/gpuctl/internal/uplink"
+)
+
+// A single collection's worth of samples
+type Batch = []uplink.GPUStatSample
+
+// Queue is a bounded, first-in-first-out queue of batches, mirrored to a file
+// so that it survives restarts.
+//
+// The file holds one JSON encoded batch per line. New batches are appended to
+// the end of the file, and it is only rewritten when batches are
+// acknowledged, or when it has grown to twice the limit. This means the file
+// can run ahead of the in-memory queue, so when reading it back we only keep
+// the newest limit batches.
+type Queue struct {
+	mu      sync.Mutex
+	path    string
+	limit   int // max number of batches kept, non-positive for no limit
+	batches []Batch
+	lines   int // number of batches in the file
+}
+
+// Open the queue stored at path, creating it if it does not exist. Lines in
+// the file that can't be decoded, such as one cut short by a crash, are
+// dropped.
+func Open(path string, limit int) (*Queue, error) {
+	q := &Queue{path: path, limit: limit}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return q, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	corrupt := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		q.lines++
+
+		var batch Batch
+		if json.Unmarshal(scanner.Bytes(), &batch) != nil {
+			corrupt = true
+			continue
+		}
+		q.batches = append(q.batches, batch)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	q.trim()
+
+	// rewrite now, otherwise the next push would be glued onto the bad line
+	if corrupt {
+		return q, q.rewrite()
+	}
+	return q, nil
+}
+
+// Push a batch onto the back of the queue, dropping the oldest batch if the
+// queue is full
+func (q *Queue) Push(batch Batch) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.batches = append(q.batches, batch)
+	q.trim()
+
+	if q.limit > 0 && q.lines >= 2*q.limit {
+		return q.rewrite()
+	}
+
+	line, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(q.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		return errors.Join(err, f.Close())
+	}
+
+	q.lines++
+	return f.Close()
+}
+
+// Get a copy of all unacknowledged batches, oldest first
+func (q *Queue) Pending() []Batch {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	pending := make([]Batch, len(q.batches))
+	copy(pending, q.batches)
+	return pending
+}
+
+// Ack removes the n oldest batches, once the groundstation has accepted them
+func (q *Queue) Ack(n int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n = min(n, len(q.batches))
+	if n <= 0 {
+		return nil
+	}
+
+	q.batches = q.batches[n:]
+	return q.rewrite()
+}
+
+// Number of unacknowledged batches
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.batches)
+}
+
+// drop the oldest batches until we're within the limit
+func (q *Queue) trim() {
+	if q.limit > 0 && len(q.batches) > q.limit {
+		q.batches = q.batches[len(q.batches)-q.limit:]
+	}
+}
+
+// replace the file with the current contents of the queue. We write to a
+// temporary file and rename it over the top so a crash can't leave us with
+// half a queue.
+func (q *Queue) rewrite() error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, batch := range q.batches {
+		if err := enc.Encode(batch); err != nil {
+			return err
+		}
+	}
+
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, q.path); err != nil {
+		return err
+	}
+
+	q.lines = len(q.batches)
+	return nil
+}
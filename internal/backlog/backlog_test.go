@@ -0,0 +1,119 @@
+package backlog_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gpuctl/gpuctl/internal/backlog"
+	"github.com/gpuctl/gpuctl/internal/uplink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/google/uuid"
+)
+
+var gpu = uuid.MustParse("6e1a4d4c-8b46-4b6a-a0b0-4a1f7c4e5b1e")
+
+func batch(time int64) backlog.Batch {
+	return backlog.Batch{{Uuid: gpu, Time: time, GPUUtilisation: float64(time)}}
+}
+
+func times(batches []backlog.Batch) []int64 {
+	res := make([]int64, len(batches))
+	for i, b := range batches {
+		res[i] = b[0].Time
+	}
+	return res
+}
+
+func TestEmptyQueue(t *testing.T) {
+	t.Parallel()
+
+	q, err := backlog.Open(filepath.Join(t.TempDir(), "cache"), 10)
+	require.NoError(t, err)
+
+	assert.Equal(t, 0, q.Len())
+	assert.Empty(t, q.Pending())
+	assert.NoError(t, q.Ack(1))
+}
+
+func TestPushAndAck(t *testing.T) {
+	t.Parallel()
+
+	q, err := backlog.Open(filepath.Join(t.TempDir(), "cache"), 10)
+	require.NoError(t, err)
+
+	for i := int64(1); i <= 3; i++ {
+		require.NoError(t, q.Push(batch(i)))
+	}
+	assert.Equal(t, []int64{1, 2, 3}, times(q.Pending()))
+
+	require.NoError(t, q.Ack(2))
+	assert.Equal(t, []int64{3}, times(q.Pending()))
+
+	require.NoError(t, q.Ack(5))
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestQueueIsBounded(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "cache")
+	q, err := backlog.Open(path, 3)
+	require.NoError(t, err)
+
+	for i := int64(1); i <= 10; i++ {
+		require.NoError(t, q.Push(batch(i)))
+	}
+	assert.Equal(t, []int64{8, 9, 10}, times(q.Pending()))
+
+	reopened, err := backlog.Open(path, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{8, 9, 10}, times(reopened.Pending()))
+}
+
+func TestQueueSurvivesRestart(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "cache")
+	q, err := backlog.Open(path, 10)
+	require.NoError(t, err)
+
+	for i := int64(1); i <= 4; i++ {
+		require.NoError(t, q.Push(batch(i)))
+	}
+	require.NoError(t, q.Ack(1))
+	require.NoError(t, q.Push(batch(5)))
+
+	reopened, err := backlog.Open(path, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{2, 3, 4, 5}, times(reopened.Pending()))
+	assert.Equal(t, []uplink.GPUStatSample(batch(5)), reopened.Pending()[3])
+}
+
+func TestTornLineIsSkipped(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "cache")
+	q, err := backlog.Open(path, 10)
+	require.NoError(t, err)
+	require.NoError(t, q.Push(batch(1)))
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`[{"uuid":"6e1a4d4c`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	reopened, err := backlog.Open(path, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1}, times(reopened.Pending()))
+
+	// pushing after recovering shouldn't lose anything
+	require.NoError(t, reopened.Push(batch(2)))
+
+	reopened, err = backlog.Open(path, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1, 2}, times(reopened.Pending()))
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/gpuctl/gpuctl/internal/uplink"
 )
 
 // frontend<->web-api types
@@ -28,6 +29,69 @@ type ModifyMachine struct {
 	Owner       *string `json:"owner"`       // nullable - means no change
 }
 
+// A group of machines, as created through the groups API. Machines can also be
+// put in a group that hasn't been created, which then has no description and
+// order 0 until it's updated
+type GroupInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Order       int    `json:"order"` // lower first, then by name
+}
+
+// Renaming a group takes its machines and settings override with it
+type ModifyGroup struct {
+	Name        string  `json:"name"`
+	NewName     *string `json:"new_name"`    // nullable - means no change
+	Description *string `json:"description"` // nullable - means no change
+	Order       *int    `json:"order"`       // nullable - means no change
+}
+
+// The group's machines are moved to MoveTo, or the default group if it's empty
+type RemoveGroup struct {
+	Name   string `json:"name"`
+	MoveTo string `json:"move_to,omitempty"`
+}
+
+type MoveMachines struct {
+	Hostnames []string `json:"hostnames"`
+	Group     string   `json:"group"`
+}
+
+// A tag on a machine, like "teaching" or "a100", which machines can be
+// filtered by
+type MachineTag struct {
+	Hostname string `json:"hostname"`
+	Tag      string `json:"tag"`
+}
+
+type MetadataType string
+
+const (
+	MetadataString MetadataType = "string"
+	MetadataNumber MetadataType = "number"
+	MetadataDate   MetadataType = "date" // like 2026-01-31
+	MetadataBool   MetadataType = "bool"
+)
+
+// Something recorded about a machine, like its room or when its warranty ends
+type Metadata struct {
+	Key   string       `json:"key"`
+	Type  MetadataType `json:"type"`
+	Value string       `json:"value"` // written as its type is, like 12.5, 2026-01-31 or true
+}
+
+type SetMetadata struct {
+	Hostname string `json:"hostname"`
+	Metadata
+}
+
+type RemoveMetadata struct {
+	Hostname string `json:"hostname"`
+	Key      string `json:"key"`
+}
+
+// A file attached to a machine as base64. Only for the JSON attach_file
+// endpoint, files are otherwise uploaded and downloaded as they are
 type AttachFile struct {
 	Hostname    string `json:"hostname"`
 	Mime        string `json:"mime"`
@@ -35,17 +99,112 @@ type AttachFile struct {
 	EncodedFile string `json:"file_enc"`
 }
 
+// What's known about an attached file. Its contents are kept in the file store,
+// not the database
+type FileInfo struct {
+	Hostname   string    `json:"hostname"`
+	Filename   string    `json:"filename"`
+	Mime       string    `json:"mime"`
+	Size       int64     `json:"size"`   // bytes
+	Checksum   string    `json:"sha256"` // hex
+	Uploader   string    `json:"uploader"`
+	UploadedAt time.Time `json:"uploaded_at"`
+	Key        string    `json:"-"` // where the file store keeps the contents
+}
+
 type RemoveFile struct {
 	Hostname string `json:"hostname"`
 	Filename string `json:"filename"`
 }
 
+// What a user, group or machine used over some period. Only time GPUs spent
+// in use counts, and a GPU shared between users is split by their memory use
+type Usage struct {
+	Name               string  `json:"name"`
+	GPUHours           float64 `json:"gpu_hours"`
+	AverageUtilisation float64 `json:"average_utilisation"` // Percentage, over the time in use
+	Energy             float64 `json:"energy"`              // Joules
+}
+
+// A process's lifetime on a GPU
+type Job struct {
+	Gpu        uuid.UUID `json:"gpu"`
+	Pid        uint64    `json:"pid"`
+	Name       string    `json:"name"`
+	Owner      string    `json:"owner"`
+	FirstSeen  int64     `json:"first_seen"`  // Unix time
+	LastSeen   int64     `json:"last_seen"`   // Unix time
+	PeakMemory float64   `json:"peak_memory"` // In megabytes
+	Running    bool      `json:"running"`     // was it in the GPU's latest sample
+}
+
+type InventoryEventKind string
+
+const (
+	GPUAppeared      InventoryEventKind = "appeared"       // first seen, on Hostname
+	GPUMoved         InventoryEventKind = "moved"          // from the machine in From to Hostname
+	GPUDriverChanged InventoryEventKind = "driver_changed" // from the version in From to the one in To
+	GPUMissing       InventoryEventKind = "missing"        // stopped reporting while its machine didn't
+	GPUReturned      InventoryEventKind = "returned"       // reporting again after going missing
+)
+
+// A change to the GPUs in a machine
+type InventoryEvent struct {
+	Time     int64              `json:"time"` // Unix time
+	Hostname string             `json:"hostname"`
+	Gpu      uuid.UUID          `json:"gpu"`
+	Name     string             `json:"gpu_name"`
+	Kind     InventoryEventKind `json:"kind"`
+	From     string             `json:"from,omitempty"`
+	To       string             `json:"to,omitempty"`
+}
+
+type AlertState string
+
+const (
+	AlertPending  AlertState = "pending"  // the rule holds, but hasn't for long enough
+	AlertFiring   AlertState = "firing"   // the rule has held for long enough
+	AlertResolved AlertState = "resolved" // the rule stopped holding after firing
+)
+
+// An alerting rule holding for a machine, or one of its gpus
+type Alert struct {
+	Id         int64      `json:"id"`
+	Rule       string     `json:"rule"`
+	Expression string     `json:"expression"` // the rule as it's configured
+	Hostname   string     `json:"hostname"`
+	Gpu        *uuid.UUID `json:"gpu,omitempty"` // nil for rules about machines
+	State      AlertState `json:"state"`
+	Value      float64    `json:"value"`                 // of the rule's first metric, or seconds offline, when it fired or started holding
+	Since      int64      `json:"since"`                 // Unix time the rule started holding
+	FiredAt    int64      `json:"fired_at,omitempty"`    // Unix time, if it has
+	ResolvedAt int64      `json:"resolved_at,omitempty"` // Unix time, if it has
+}
+
+// Every process running on a GPU, including those the satellite's process
+// filter doesn't count as using it
+type GPUProcesses struct {
+	Uuid      uuid.UUID        `json:"uuid"`
+	Processes uplink.Processes `json:"processes"`
+}
+
+// Settings sent to the satellites in a group, or on a single machine, in place
+// of those in the control server's configuration. Exactly one of Group and
+// Hostname is set. Machine overrides take priority over group ones.
+type SettingsOverride struct {
+	Group    string          `json:"group,omitempty"`
+	Hostname string          `json:"hostname,omitempty"`
+	Settings uplink.Settings `json:"settings"` // zero to remove the override
+}
+
 // data type representing struct returned on all workstations request
 type Workstations []Group
 
 type Group struct {
 	Name         string        `json:"name"` // group name
-	Workstations []Workstation `json:"workstations"`
+	Description  string        `json:"description"`
+	Order        int           `json:"order"`        // groups are sorted by this, then by name
+	Workstations []Workstation `json:"workstations"` // sorted by name
 }
 
 type Workstation struct {
@@ -55,29 +214,33 @@ type Workstation struct {
 	Notes       *string       `json:"notes"`       // general note (optional)
 	Owner       *string       `json:"owner"`       // person who "owns" this machine (optional)
 	LastSeen    time.Duration `json:"last_seen"`   // time since the machine was last seen
+	Tags        []string      `json:"tags"`        // in alphabetical order
+	Metadata    []Metadata    `json:"metadata"`    // in order of key
 	Gpus        []GPU         `json:"gpus"`
 }
 
 type GPU struct {
-	Uuid              uuid.UUID `json:"uuid"`
-	Name              string    `json:"gpu_name"`
-	Brand             string    `json:"gpu_brand"`
-	DriverVersion     string    `json:"driver_ver"`
-	MemoryTotal       uint64    `json:"memory_total"`
-	MemoryUtilisation float64   `json:"memory_util"`        // Percentage of memory used
-	GPUUtilisation    float64   `json:"gpu_util"`           // Percentage of memory used
-	MemoryUsed        float64   `json:"memory_used"`        // In megabytes
-	FanSpeed          float64   `json:"fan_speed"`          // Percentage of fan speed
-	Temp              float64   `json:"gpu_temp"`           // Celcius
-	MemoryTemp        float64   `json:"memory_temp"`        // Celcius
-	GraphicsVoltage   float64   `json:"graphics_voltage"`   // Volts
-	PowerDraw         float64   `json:"power_draw"`         // Watts
-	GraphicsClock     float64   `json:"graphics_clock"`     // Mhz
-	MaxGraphicsClock  float64   `json:"max_graphics_clock"` // Mhz
-	MemoryClock       float64   `json:"memory_clock"`       // Mhz
-	MaxMemoryClock    float64   `json:"max_memory_clock"`   // Mhz
-	InUse             bool      `json:"in_use"`             // is this gpu being used?
-	User              string    `json:"user"`               // iff it's being used, who is using this gpu
+	Uuid              uuid.UUID        `json:"uuid"`
+	Name              string           `json:"gpu_name"`
+	Brand             string           `json:"gpu_brand"`
+	DriverVersion     string           `json:"driver_ver"`
+	MemoryTotal       uint64           `json:"memory_total"`
+	Backend           string           `json:"backend"`            // vendor tool that reported this gpu
+	MemoryUtilisation float64          `json:"memory_util"`        // Percentage of memory used
+	GPUUtilisation    float64          `json:"gpu_util"`           // Percentage of memory used
+	MemoryUsed        float64          `json:"memory_used"`        // In megabytes
+	FanSpeed          float64          `json:"fan_speed"`          // Percentage of fan speed
+	Temp              float64          `json:"gpu_temp"`           // Celcius
+	MemoryTemp        float64          `json:"memory_temp"`        // Celcius
+	GraphicsVoltage   float64          `json:"graphics_voltage"`   // Volts
+	PowerDraw         float64          `json:"power_draw"`         // Watts
+	GraphicsClock     float64          `json:"graphics_clock"`     // Mhz
+	MaxGraphicsClock  float64          `json:"max_graphics_clock"` // Mhz
+	MemoryClock       float64          `json:"memory_clock"`       // Mhz
+	MaxMemoryClock    float64          `json:"max_memory_clock"`   // Mhz
+	InUse             bool             `json:"in_use"`             // is this gpu being used?
+	Missing           bool             `json:"missing"`            // has it stopped reporting while its machine hasn't?
+	Users             []uplink.GPUUser `json:"users"`              // everyone using this gpu, heaviest first
 }
 
 type OnboardReq struct {
@@ -98,7 +261,10 @@ type HistoricalData [][]HistoricalDataPoint
 
 type HistoricalDataPoint struct {
 	Timestamp int64 `json:"timestamp"`
-	Sample    GPU   `json:"sample"`
+	Sample    GPU   `json:"sample"`        // the average, when samples are bucketed or rolled up
+	Min       *GPU  `json:"min,omitempty"` // only when samples are bucketed or rolled up
+	Max       *GPU  `json:"max,omitempty"`
+	Samples   int   `json:"samples"` // how many samples were taken in this point's time
 }
 
 type AggregateData struct {
@@ -129,8 +129,15 @@ func TestToToml(t *testing.T) {
 	t.Parallel()
 
 	c := config.SatelliteConfiguration{
-		Groundstation: config.Groundstation{"https", "foo.bar", 80},
-		Satellite:     config.Satellite{"/tmp/sat", 15 * time.Second, 5 * time.Second, false},
+		Groundstation: config.Groundstation{Protocol: "https", Hostname: "foo.bar", Port: 80},
+		Satellite: config.Satellite{
+			Cache:             "/tmp/sat",
+			DataInterval:      15 * time.Second,
+			SampleInterval:    3 * time.Second,
+			HeartbeatInterval: 5 * time.Second,
+			FakeGPU:           false,
+			MaxBacklog:        100,
+		},
 	}
 
 	cToml, err := config.ToToml(c)
@@ -145,8 +152,10 @@ func TestToToml(t *testing.T) {
 [satellite]
   cache = "/tmp/sat"
   data_interval = "15s"
+  sample_interval = "3s"
   heartbeat_interval = "5s"
   fake_gpu = false
+  max_backlog = 100
 `, cToml)
 
 	c2 := config.ControlConfiguration{
@@ -165,8 +174,16 @@ func TestToToml(t *testing.T) {
 			DownsampleInterval: 2*time.Hour + 2*time.Minute,
 		},
 		Auth: config.AuthConfig{
-			Username: "joe",
-			Password: "mama",
+			Username:     "joe",
+			Password:     "mama",
+			UplinkSecret: "hunter2",
+		},
+		TLS: config.TLSConfig{
+			Enabled:    true,
+			CACert:     "ca.pem",
+			CAKey:      "ca.key",
+			ServerCert: "gs.pem",
+			ServerKey:  "gs.key",
 		},
 		SSH: config.SSHConf{
 			DataDir:    "datadir",
@@ -197,6 +214,14 @@ func TestToToml(t *testing.T) {
 [auth]
   username = "joe"
   password = "mama"
+  uplink_secret = "hunter2"
+
+[tls]
+  enabled = true
+  ca_cert = "ca.pem"
+  ca_key = "ca.key"
+  server_cert = "gs.pem"
+  server_key = "gs.key"
 
 [onboard]
   datadir = "datadir"
@@ -210,8 +235,10 @@ func TestToToml(t *testing.T) {
     [onboard.remote.satellite]
       cache = "/tmp/sat"
       data_interval = "15s"
+      sample_interval = "3s"
       heartbeat_interval = "5s"
       fake_gpu = false
+      max_backlog = 100
 `, c2Toml)
 
 }
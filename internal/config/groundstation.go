@@ -20,15 +20,62 @@ func (t Timeouts) MonitorInterval() time.Duration {
 }
 
 type Database struct {
-	InMemory           bool          `toml:"inmemory"`
-	Postgres           bool          `toml:"postgres"`
-	PostgresUrl        string        `toml:"url"`
-	DownsampleInterval time.Duration `toml:"downsample_interval"`
+	InMemory           bool            `toml:"inmemory"`
+	Postgres           bool            `toml:"postgres"`
+	PostgresUrl        string          `toml:"url"`
+	Sqlite             bool            `toml:"sqlite,omitempty"`
+	SqlitePath         string          `toml:"sqlite_path,omitempty"` // created if it doesn't exist
+	DownsampleInterval time.Duration   `toml:"downsample_interval"`
+	Retention          []RetentionTier `toml:"retention,omitempty"` // the default policy if empty
+}
+
+// Once samples are older than After, they're averaged over buckets of Step,
+// or deleted if there's no step
+type RetentionTier struct {
+	After time.Duration `toml:"after"`
+	Step  time.Duration `toml:"step,omitempty"`
 }
 
 type AuthConfig struct {
-	Username string `toml:"username"`
-	Password string `toml:"password"`
+	Username     string `toml:"username"`
+	Password     string `toml:"password"`
+	UplinkSecret string `toml:"uplink_secret"` // satellite tokens are derived from this, so keep it safe
+}
+
+// Mutual TLS between satellites and the groundstation
+type TLSConfig struct {
+	Enabled    bool   `toml:"enabled"`
+	CACert     string `toml:"ca_cert"`     // created if it doesn't exist
+	CAKey      string `toml:"ca_key"`      // created if it doesn't exist
+	ServerCert string `toml:"server_cert"` // issued from the CA if empty
+	ServerKey  string `toml:"server_key"`
+}
+
+// Where attached files are kept. They go to S3 if a bucket is set, and to Dir
+// otherwise
+type FileStore struct {
+	Dir     string  `toml:"dir,omitempty"`      // created if it doesn't exist
+	MaxSize int64   `toml:"max_size,omitempty"` // bytes
+	S3      S3Store `toml:"s3,omitempty"`
+}
+
+type S3Store struct {
+	Endpoint  string `toml:"endpoint"`
+	Bucket    string `toml:"bucket"`
+	Region    string `toml:"region,omitempty"`
+	AccessKey string `toml:"access_key"`
+	SecretKey string `toml:"secret_key"` // read from GPU_S3_SECRET_KEY if empty
+}
+
+// Rules checked against every sample from each gpu as it arrives, and
+// against when machines were last seen every monitor_interval
+type Alerting struct {
+	Rules []AlertRule `toml:"rules,omitempty"`
+}
+
+type AlertRule struct {
+	Name string `toml:"name"`
+	Rule string `toml:"rule"` // like "gpu_temp > 88 for 5m" or "offline > 30m"
 }
 
 type ControlConfiguration struct {
@@ -36,6 +83,9 @@ type ControlConfiguration struct {
 	Server   Server     `toml:"server"`
 	Database Database   `toml:"database"`
 	Auth     AuthConfig `toml:"auth"`
+	TLS      TLSConfig  `toml:"tls"`
+	Files    FileStore  `toml:"files,omitempty"`
+	Alerting Alerting   `toml:"alerting,omitempty"`
 	SSH      SSHConf    `toml:"onboard"` // TODO: Change name to ssh_configuration, deferred due to it being a breaking change
 }
 
@@ -56,11 +106,21 @@ func DefaultControlConfiguration() ControlConfiguration {
 			InMemory:    false,
 			Postgres:    false,
 			PostgresUrl: "postgres://postgres@postgres/postgres",
+			SqlitePath:  "control.db",
 		},
 		Auth: AuthConfig{
 			Username: "admin",
 			Password: "password",
 		},
+		TLS: TLSConfig{
+			Enabled: false,
+			CACert:  "ca.pem",
+			CAKey:   "ca.key",
+		},
+		Files: FileStore{
+			Dir:     "files",
+			MaxSize: 100 << 20,
+		},
 		SSH: SSHConf{
 			// We don't set any of the others.
 			RemoteConf: DefaultSatelliteConfiguration(),
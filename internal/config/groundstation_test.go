@@ -3,6 +3,7 @@ package config_test
 import (
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/gpuctl/gpuctl/internal/config"
 	"github.com/stretchr/testify/assert"
@@ -33,6 +34,76 @@ test_url = "postgres://postgres@localhost/gpuctl-tests"`
 	assert.Equal(t, "postgres://tony@ic.ac.uk/squares", config.Database.PostgresUrl)
 }
 
+func TestGetControl_Retention(t *testing.T) {
+	t.Parallel()
+	content := `
+[[database.retention]]
+after = "24h"
+step = "1m"
+
+[[database.retention]]
+after = "8760h"`
+	filename, cleanup := CreateTempConfigFile(content, t)
+	defer cleanup()
+
+	filename = filepath.Base(filename)
+
+	conf, err := config.GetControl(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, []config.RetentionTier{
+		{After: 24 * time.Hour, Step: time.Minute},
+		{After: 8760 * time.Hour},
+	}, conf.Database.Retention)
+}
+
+func TestGetControl_Files(t *testing.T) {
+	t.Parallel()
+	content := `
+[files]
+max_size = 1024
+
+[files.s3]
+endpoint = "http://localhost:9000"
+bucket = "gpuctl"`
+	filename, cleanup := CreateTempConfigFile(content, t)
+	defer cleanup()
+
+	filename = filepath.Base(filename)
+
+	conf, err := config.GetControl(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, config.FileStore{
+		Dir:     "files",
+		MaxSize: 1024,
+		S3:      config.S3Store{Endpoint: "http://localhost:9000", Bucket: "gpuctl"},
+	}, conf.Files)
+}
+
+func TestGetControl_Alerting(t *testing.T) {
+	t.Parallel()
+	content := `
+[[alerting.rules]]
+name = "overheating"
+rule = "gpu_temp > 88 for 5m"
+
+[[alerting.rules]]
+name = "offline"
+rule = "offline > 30m"`
+	filename, cleanup := CreateTempConfigFile(content, t)
+	defer cleanup()
+
+	filename = filepath.Base(filename)
+
+	conf, err := config.GetControl(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, config.Alerting{
+		Rules: []config.AlertRule{
+			{Name: "overheating", Rule: "gpu_temp > 88 for 5m"},
+			{Name: "offline", Rule: "offline > 30m"},
+		},
+	}, conf.Alerting)
+}
+
 func TestGetControl_DefaultConfig(t *testing.T) {
 	t.Parallel()
 	content := ``
@@ -2,19 +2,32 @@ package config
 
 import (
 	"time"
+
+	"github.com/gpuctl/gpuctl/internal/uplink"
 )
 
 type Groundstation struct {
 	Protocol string `toml:"protocol"`
 	Hostname string `toml:"hostname"`
 	Port     int    `toml:"port"`
+	CA       string `toml:"ca,omitempty"`   // path to the CA bundle to trust the groundstation with, the system's if empty
+	Cert     string `toml:"cert,omitempty"` // path to our client certificate, if the groundstation needs one
+	Key      string `toml:"key,omitempty"`  // path to our client certificate's key
 }
 
 type Satellite struct {
-	Cache             string        `toml:"cache"`
-	DataInterval      time.Duration `toml:"data_interval"`
-	HeartbeatInterval time.Duration `toml:"heartbeat_interval"`
-	FakeGPU           bool          `toml:"fake_gpu"`
+	Cache             string                `toml:"cache"`
+	DataInterval      time.Duration         `toml:"data_interval"`   // how often we upload samples
+	SampleInterval    time.Duration         `toml:"sample_interval"` // how often we take samples, zero to match data_interval
+	HeartbeatInterval time.Duration         `toml:"heartbeat_interval"`
+	FakeGPU           bool                  `toml:"fake_gpu"`
+	MaxBacklog        int                   `toml:"max_backlog"`                  // most collections to hold onto while the groundstation is unreachable
+	Hostname          string                `toml:"hostname,omitempty"`           // name to report as, and that the token was issued for. The machine's hostname if empty
+	Token             string                `toml:"token,omitempty"`              // for signing requests to the groundstation, installed when onboarding
+	UplinkFormat      string                `toml:"uplink_format,omitempty"`      // "json" or "cbor", json if empty
+	UplinkCompression string                `toml:"uplink_compression,omitempty"` // "gzip" or "zstd", uncompressed if empty
+	Collectors        []string              `toml:"collectors,omitempty"`         // GPU vendor tools to collect from, "nvidia" or "amd". All that are installed if empty
+	ProcessFilter     *uplink.ProcessFilter `toml:"process_filter,omitempty"`     // which processes count as using a GPU, everything but the desktop if empty
 }
 
 type SatelliteConfiguration struct {
@@ -34,6 +47,7 @@ func DefaultSatelliteConfiguration() SatelliteConfiguration {
 			DataInterval:      60 * time.Second,
 			HeartbeatInterval: 2 * time.Second,
 			FakeGPU:           false,
+			MaxBacklog:        10000,
 		},
 	}
 }
@@ -41,3 +55,11 @@ func DefaultSatelliteConfiguration() SatelliteConfiguration {
 func GetSatellite(filename string) (SatelliteConfiguration, error) {
 	return getConfiguration[SatelliteConfiguration](filename, DefaultSatelliteConfiguration)
 }
+
+// Time between taking samples, which defaults to the upload interval
+func (s Satellite) SampleEvery() time.Duration {
+	if s.SampleInterval <= 0 {
+		return s.DataInterval
+	}
+	return s.SampleInterval
+}
@@ -22,7 +22,13 @@ port = 8081
 [satellite]
 cache = "/tmp/satellite"
 data_interval = "1m"
-heartbeat_interval = "5s"`
+sample_interval = "2s"
+heartbeat_interval = "5s"
+hostname = "spoonbill"
+token = "abcdef"
+uplink_format = "cbor"
+uplink_compression = "zstd"
+collectors = ["amd"]`
 	filename, cleanup := CreateTempConfigFile(content, t)
 	defer cleanup()
 
@@ -35,7 +41,13 @@ heartbeat_interval = "5s"`
 	assert.Equal(t, 8081, conf.Groundstation.Port)
 	assert.Equal(t, "/tmp/satellite", conf.Satellite.Cache)
 	assert.Equal(t, time.Minute, conf.Satellite.DataInterval)
+	assert.Equal(t, 2*time.Second, conf.Satellite.SampleEvery())
 	assert.Equal(t, 5*time.Second, conf.Satellite.HeartbeatInterval)
+	assert.Equal(t, "spoonbill", conf.Satellite.Hostname)
+	assert.Equal(t, "abcdef", conf.Satellite.Token)
+	assert.Equal(t, "cbor", conf.Satellite.UplinkFormat)
+	assert.Equal(t, "zstd", conf.Satellite.UplinkCompression)
+	assert.Equal(t, []string{"amd"}, conf.Satellite.Collectors)
 }
 
 func TestGetSatellite_DefaultConfig(t *testing.T) {
@@ -56,6 +68,8 @@ func TestGetSatellite_DefaultConfig(t *testing.T) {
 	assert.Equal(t, "/tmp/satellite", conf.Satellite.Cache)
 	assert.Equal(t, 2*time.Second, conf.Satellite.HeartbeatInterval)
 	assert.Equal(t, time.Minute, conf.Satellite.DataInterval)
+	assert.Equal(t, time.Minute, conf.Satellite.SampleEvery())
+	assert.Equal(t, 10000, conf.Satellite.MaxBacklog)
 }
 
 func TestGetSatellite_InvalidConfig(t *testing.T) {
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"cmp"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+)
+
+// the group a machine is in, in SQL. Machines with an empty group are in the
+// default one
+const sqlGroupOf = `COALESCE(NULLIF(TRIM(GroupName), ''), '` + DefaultGroup + `')`
+
+// the group name as it's stored, or ErrInvalidGroup
+func normaliseGroupName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("%w: group names can't be blank", ErrInvalidGroup)
+	}
+	return name, nil
+}
+
+// The group to move a removed group's machines to
+func removedGroupTarget(remove broadcast.RemoveGroup) (name string, target string, err error) {
+	name = strings.TrimSpace(remove.Name)
+	target = cmp.Or(strings.TrimSpace(remove.MoveTo), DefaultGroup)
+	if name == target {
+		return name, target, fmt.Errorf("%w: can't move %s's machines to itself", ErrInvalidGroup, name)
+	}
+	return name, target, nil
+}
+
+func compareGroups(a, b broadcast.Group) int {
+	return cmp.Or(cmp.Compare(a.Order, b.Order), cmp.Compare(a.Name, b.Name))
+}
+
+// Put machines in their groups, with every created group whether or not it has
+// machines, all in order
+func arrangeGroups(created []broadcast.GroupInfo, machines map[string][]broadcast.Workstation) broadcast.Workstations {
+	groups := make(map[string]broadcast.Group)
+	for _, info := range created {
+		groups[info.Name] = broadcast.Group{
+			Name:         info.Name,
+			Description:  info.Description,
+			Order:        info.Order,
+			Workstations: []broadcast.Workstation{},
+		}
+	}
+	for name, workstations := range machines {
+		group := groups[name]
+		group.Name = name
+		group.Workstations = append(group.Workstations, workstations...)
+		groups[name] = group
+	}
+
+	var result broadcast.Workstations
+	for _, group := range groups {
+		slices.SortFunc(group.Workstations, func(a, b broadcast.Workstation) int {
+			return cmp.Compare(a.Name, b.Name)
+		})
+		result = append(result, group)
+	}
+	slices.SortFunc(result, compareGroups)
+	return result
+}
+
+// every group, without their machines
+func groupInfos(groups broadcast.Workstations) []broadcast.GroupInfo {
+	infos := []broadcast.GroupInfo{}
+	for _, group := range groups {
+		infos = append(infos, broadcast.GroupInfo{
+			Name:        group.Name,
+			Description: group.Description,
+			Order:       group.Order,
+		})
+	}
+	return infos
+}
+
+// The groups in the MachineGroups table, which postgres and sqlite share
+func createdGroups(tx *sql.Tx) ([]broadcast.GroupInfo, error) {
+	rows, err := tx.Query(`SELECT Name, Description, Position FROM MachineGroups`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []broadcast.GroupInfo
+	for rows.Next() {
+		var group broadcast.GroupInfo
+		err = rows.Scan(&group.Name, &group.Description, &group.Order)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+// Every group, created or not, for postgres and sqlite
+func sqlGroups(db *sql.DB) ([]broadcast.GroupInfo, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// only reading
+	defer tx.Rollback()
+
+	created, err := createdGroups(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT DISTINCT ` + sqlGroupOf + ` FROM Machines`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	named := make(map[string][]broadcast.Workstation)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		named[name] = nil
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return groupInfos(arrangeGroups(created, named)), nil
+}
@@ -0,0 +1,302 @@
+package database
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+	"github.com/gpuctl/gpuctl/internal/uplink"
+
+	"github.com/google/uuid"
+)
+
+// Which samples HistoricalData returns
+type HistoryQuery struct {
+	From time.Time     // zero for no lower bound
+	To   time.Time     // zero for no upper bound
+	Gpu  uuid.UUID     // uuid.Nil for every gpu on the machine
+	Step time.Duration // size of the buckets samples are aggregated into, zero for every sample
+}
+
+func (q HistoryQuery) Valid() bool {
+	return q.Step == 0 || q.Step >= time.Second
+}
+
+// whether a sample taken at the unix time t is wanted
+func (q HistoryQuery) includes(gpu uuid.UUID, t int64) bool {
+	return (q.Gpu == uuid.Nil || q.Gpu == gpu) &&
+		(q.From.IsZero() || t >= q.From.Unix()) &&
+		(q.To.IsZero() || t <= q.To.Unix())
+}
+
+// the numeric stats kept for every sample, by their column in the Stats table
+var historicalFields = []struct {
+	column string
+	field  func(*broadcast.GPU) *float64
+}{
+	{"MemoryUtilisation", func(g *broadcast.GPU) *float64 { return &g.MemoryUtilisation }},
+	{"GpuUtilisation", func(g *broadcast.GPU) *float64 { return &g.GPUUtilisation }},
+	{"MemoryUsed", func(g *broadcast.GPU) *float64 { return &g.MemoryUsed }},
+	{"FanSpeed", func(g *broadcast.GPU) *float64 { return &g.FanSpeed }},
+	{"Temp", func(g *broadcast.GPU) *float64 { return &g.Temp }},
+	{"MemoryTemp", func(g *broadcast.GPU) *float64 { return &g.MemoryTemp }},
+	{"GraphicsVoltage", func(g *broadcast.GPU) *float64 { return &g.GraphicsVoltage }},
+	{"PowerDraw", func(g *broadcast.GPU) *float64 { return &g.PowerDraw }},
+	{"GraphicsClock", func(g *broadcast.GPU) *float64 { return &g.GraphicsClock }},
+	{"MaxGraphicsClock", func(g *broadcast.GPU) *float64 { return &g.MaxGraphicsClock }},
+	{"MemoryClock", func(g *broadcast.GPU) *float64 { return &g.MemoryClock }},
+	{"MaxMemoryClock", func(g *broadcast.GPU) *float64 { return &g.MaxMemoryClock }},
+}
+
+// The unix time of the start of the bucket t falls in
+func bucketStart(t int64, step time.Duration) int64 {
+	secs := int64(step / time.Second)
+	return t - ((t%secs)+secs)%secs
+}
+
+// how many samples a point stands for
+func samplesIn(point broadcast.HistoricalDataPoint) int {
+	return max(point.Samples, 1)
+}
+
+// the lowest and highest a point's stats were, which are just the sample's
+// unless it's a rollup
+func lowest(point *broadcast.HistoricalDataPoint) *broadcast.GPU {
+	if point.Min != nil {
+		return point.Min
+	}
+	return &point.Sample
+}
+
+func highest(point *broadcast.HistoricalDataPoint) *broadcast.GPU {
+	if point.Max != nil {
+		return point.Max
+	}
+	return &point.Sample
+}
+
+// Aggregate the points in one bucket, each of which may be a sample or a
+// rollup of several. Averages are weighted by how many samples each point
+// stands for, and rollups' own min and max are kept. Min and max are only of
+// the numeric stats, and whether the gpu was in use all or some of the time
+func aggregateBucket(start int64, points []broadcast.HistoricalDataPoint) broadcast.HistoricalDataPoint {
+	gpu := points[0].Sample.Uuid
+	avg := broadcast.GPU{Uuid: gpu}
+	lo := broadcast.GPU{Uuid: gpu, InUse: true, Users: []uplink.GPUUser{}}
+	hi := broadcast.GPU{Uuid: gpu, Users: []uplink.GPUUser{}}
+
+	count := 0
+	for _, point := range points {
+		count += samplesIn(point)
+	}
+
+	for _, f := range historicalFields {
+		sum := 0.0
+		least, most := *f.field(lowest(&points[0])), *f.field(highest(&points[0]))
+		for _, point := range points {
+			sum += *f.field(&point.Sample) * float64(samplesIn(point))
+			least = min(least, *f.field(lowest(&point)))
+			most = max(most, *f.field(highest(&point)))
+		}
+		*f.field(&avg) = sum / float64(count)
+		*f.field(&lo) = least
+		*f.field(&hi) = most
+	}
+
+	for _, point := range points {
+		avg.InUse = avg.InUse || point.Sample.InUse
+		lo.InUse = lo.InUse && lowest(&point).InUse
+	}
+	hi.InUse = avg.InUse
+	avg.Users = mergeUsers(points)
+
+	return broadcast.HistoricalDataPoint{Timestamp: start, Sample: avg, Min: &lo, Max: &hi, Samples: count}
+}
+
+// Everyone who used a gpu across several points, with the memory they used
+// averaged over the samples they're in and the most processes they had, the
+// same as when postgres buckets history
+func mergeUsers(points []broadcast.HistoricalDataPoint) []uplink.GPUUser {
+	type total struct {
+		memory    float64
+		count     int
+		processes int
+	}
+
+	totals := make(map[string]*total)
+	for _, point := range points {
+		for _, user := range point.Sample.Users {
+			t := totals[user.Name]
+			if t == nil {
+				t = &total{}
+				totals[user.Name] = t
+			}
+			t.memory += user.MemoryUsed * float64(samplesIn(point))
+			t.count += samplesIn(point)
+			t.processes = max(t.processes, user.Processes)
+		}
+	}
+
+	merged := []uplink.GPUUser{}
+	for name, t := range totals {
+		merged = append(merged, uplink.GPUUser{
+			Name:       name,
+			MemoryUsed: t.memory / float64(t.count),
+			Processes:  t.processes,
+		})
+	}
+
+	slices.SortFunc(merged, func(a, b uplink.GPUUser) int {
+		return cmp.Or(cmp.Compare(b.MemoryUsed, a.MemoryUsed), cmp.Compare(a.Name, b.Name))
+	})
+	return merged
+}
+
+// One gpu's samples, in time order, as the points HistoricalData returns for
+// it: as they are, or aggregated into buckets of step
+func historyPoints(samples []broadcast.HistoricalDataPoint, step time.Duration) []broadcast.HistoricalDataPoint {
+	if step == 0 {
+		return samples
+	}
+
+	points := []broadcast.HistoricalDataPoint{}
+	var bucket []broadcast.HistoricalDataPoint
+	var start int64
+	for _, sample := range samples {
+		// samples are in time order, so each bucket's are together
+		if len(bucket) > 0 && bucketStart(sample.Timestamp, step) != start {
+			points = append(points, aggregateBucket(start, bucket))
+			bucket = nil
+		}
+		start = bucketStart(sample.Timestamp, step)
+		bucket = append(bucket, sample)
+	}
+	if len(bucket) > 0 {
+		points = append(points, aggregateBucket(start, bucket))
+	}
+
+	return points
+}
+
+// The columns of Stats only rolled up samples fill in, and are null for the
+// rest: whether the gpu was in use throughout, and the lowest and highest each
+// of historicalFields was
+func rollupColumns() []string {
+	columns := []string{"AlwaysInUse"}
+	for _, f := range historicalFields {
+		columns = append(columns, "Lowest"+f.column, "Highest"+f.column)
+	}
+	return columns
+}
+
+// An insert of a rolled up point into Stats at received, with the nth
+// argument written as param(n), and its arguments
+func rollupInsert(point broadcast.HistoricalDataPoint, received any, param func(n int) string) (string, []any, error) {
+	users, err := json.Marshal(point.Sample.Users)
+	if err != nil {
+		return "", nil, err
+	}
+
+	columns := []string{"Gpu", "Received"}
+	args := []any{point.Sample.Uuid, received}
+	for _, f := range historicalFields {
+		columns = append(columns, f.column)
+		args = append(args, *f.field(&point.Sample))
+	}
+	columns = append(columns, "InUse", "Users", "Samples")
+	args = append(args, point.Sample.InUse, string(users), samplesIn(point))
+
+	columns = append(columns, rollupColumns()...)
+	args = append(args, lowest(&point).InUse)
+	for _, f := range historicalFields {
+		args = append(args, *f.field(lowest(&point)), *f.field(highest(&point)))
+	}
+
+	params := make([]string, len(args))
+	for i := range params {
+		params[i] = param(i + 1)
+	}
+
+	query := fmt.Sprintf(`INSERT INTO Stats (%s) VALUES (%s)`,
+		strings.Join(columns, ", "), strings.Join(params, ", "))
+	return query, args, nil
+}
+
+// The columns scanHistory needs, from Stats s, after the gpu and unix time
+func historyColumns() string {
+	var columns strings.Builder
+	for _, f := range historicalFields {
+		fmt.Fprintf(&columns, ", s.%s", f.column)
+	}
+	columns.WriteString(", s.InUse, s.Users, s.Samples")
+	for _, column := range rollupColumns() {
+		fmt.Fprintf(&columns, ", s.%s", column)
+	}
+	return columns.String()
+}
+
+// Scan rows of each sample's gpu and unix time, then historyColumns, ordered
+// by gpu then time. Each gpu's samples go in their own list
+func scanHistory(rows *sql.Rows) ([][]broadcast.HistoricalDataPoint, error) {
+	defer rows.Close()
+
+	var samples [][]broadcast.HistoricalDataPoint
+	var points []broadcast.HistoricalDataPoint
+	for rows.Next() {
+		var point broadcast.HistoricalDataPoint
+		var users []byte
+		var alwaysInUse *bool
+		extremes := make([]*float64, 2*len(historicalFields))
+
+		targets := []any{&point.Sample.Uuid, &point.Timestamp}
+		for _, f := range historicalFields {
+			targets = append(targets, f.field(&point.Sample))
+		}
+		targets = append(targets, &point.Sample.InUse, &users, &point.Samples, &alwaysInUse)
+		for i := range extremes {
+			targets = append(targets, &extremes[i])
+		}
+
+		err := rows.Scan(targets...)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(users, &point.Sample.Users)
+		if err != nil {
+			return nil, err
+		}
+
+		// only rollups have a min and max
+		if alwaysInUse != nil {
+			point.Min = &broadcast.GPU{Uuid: point.Sample.Uuid, InUse: *alwaysInUse, Users: []uplink.GPUUser{}}
+			point.Max = &broadcast.GPU{Uuid: point.Sample.Uuid, InUse: point.Sample.InUse, Users: []uplink.GPUUser{}}
+			for i, f := range historicalFields {
+				*f.field(point.Min) = *f.field(&point.Sample)
+				*f.field(point.Max) = *f.field(&point.Sample)
+				if extremes[2*i] != nil {
+					*f.field(point.Min) = *extremes[2*i]
+				}
+				if extremes[2*i+1] != nil {
+					*f.field(point.Max) = *extremes[2*i+1]
+				}
+			}
+		}
+
+		if len(points) > 0 && points[0].Sample.Uuid != point.Sample.Uuid {
+			samples = append(samples, points)
+			points = nil
+		}
+		points = append(points, point)
+	}
+	if len(points) > 0 {
+		samples = append(samples, points)
+	}
+
+	return samples, rows.Err()
+}
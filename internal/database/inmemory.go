@@ -3,9 +3,9 @@ package database
 import (
 	"cmp"
 	"fmt"
+	"math"
 	"reflect"
 	"slices"
-	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -22,12 +22,23 @@ type gpuInfo struct {
 }
 
 type inMemory struct {
-	machines map[string]broadcast.ModifyMachine         // maps from hostname to machine info
-	infos    map[uuid.UUID]gpuInfo                      // maps from uuids to context info
-	stats    map[uuid.UUID][]uplink.GPUStatSample       // maps from uuids to slices of stats, allowing tracking of multiple datapoints
-	lastSeen map[string]time.Time                       // map from hostname to last seen time
-	files    map[string]map[string]broadcast.AttachFile // maps from hostname to attached files
-	mu       sync.Mutex                                 // mutex
+	machines        map[string]broadcast.ModifyMachine                    // maps from hostname to machine info
+	infos           map[uuid.UUID]gpuInfo                                 // maps from uuids to context info
+	stats           map[uuid.UUID][]uplink.GPUStatSample                  // maps from uuids to slices of stats, allowing tracking of multiple datapoints
+	lastSeen        map[string]time.Time                                  // map from hostname to last seen time
+	files           map[string]map[string]broadcast.FileInfo              // maps from hostname to attached files
+	tags            map[string]map[string]bool                            // maps from hostname to its set of tags
+	metadata        map[string]map[string]broadcast.Metadata              // maps from hostname to its metadata, by key
+	groups          map[string]broadcast.GroupInfo                        // maps from name to groups that have been created
+	groupSettings   map[string]uplink.Settings                            // maps from group name to settings overrides
+	machineSettings map[string]uplink.Settings                            // maps from hostname to settings overrides
+	jobs            map[uuid.UUID][]broadcast.Job                         // maps from uuids to the processes that have run on them
+	rollups         map[uuid.UUID]map[int64]broadcast.HistoricalDataPoint // maps from uuids to the rolled up samples among their stats, by time
+	inventory       []broadcast.InventoryEvent                            // every change to the gpus, oldest first
+	missing         map[uuid.UUID]bool                                    // set of uuids of gpus flagged as missing
+	alerts          map[int64]broadcast.Alert                             // maps from id to alerts, active or not
+	lastAlert       int64                                                 // the id of the newest alert
+	mu              sync.Mutex                                            // mutex
 }
 
 func InMemory() Database {
@@ -36,28 +47,95 @@ func InMemory() Database {
 		infos:    make(map[uuid.UUID]gpuInfo),
 		stats:    make(map[uuid.UUID][]uplink.GPUStatSample),
 		lastSeen: make(map[string]time.Time),
-		files:    make(map[string]map[string]broadcast.AttachFile),
+		files:    make(map[string]map[string]broadcast.FileInfo),
+		tags:     make(map[string]map[string]bool),
+		metadata: make(map[string]map[string]broadcast.Metadata),
+		groups:   make(map[string]broadcast.GroupInfo),
+
+		groupSettings:   make(map[string]uplink.Settings),
+		machineSettings: make(map[string]uplink.Settings),
+		jobs:            make(map[uuid.UUID][]broadcast.Job),
+		rollups:         make(map[uuid.UUID]map[int64]broadcast.HistoricalDataPoint),
+		missing:         make(map[uuid.UUID]bool),
+		alerts:          make(map[int64]broadcast.Alert),
 	}
 }
 
 func (m *inMemory) AppendDataPoint(sample uplink.GPUStatSample) error {
+	return m.AppendDataPoints([]uplink.GPUStatSample{sample})
+}
+
+func (m *inMemory) AppendDataPoints(samples []uplink.GPUStatSample) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if info, pres := m.infos[sample.Uuid]; !pres {
-		return ErrGpuNotPresent
-	} else {
-		m.stats[sample.Uuid] = append(m.stats[sample.Uuid], sample)
-		m.lastSeen[info.host] = time.Now()
+	// check them all first, so we either add everything or nothing
+	for _, sample := range samples {
+		if _, pres := m.infos[sample.Uuid]; !pres {
+			return ErrGpuNotPresent
+		}
 	}
 
+	now := time.Now()
+	var added []uplink.GPUStatSample
+	for _, sample := range samples {
+		if m.appendDataPoint(sample, now) {
+			added = append(added, sample)
+		}
+	}
+	m.recordJobs(jobRuns(added, now))
+
 	return nil
 }
 
+// must be called with the lock held, and with the sample's gpu present.
+// Reports whether it was added, rather than being one we already had
+func (m *inMemory) appendDataPoint(sample uplink.GPUStatSample, now time.Time) bool {
+	m.lastSeen[m.infos[sample.Uuid].host] = now
+
+	// samples without a time are stamped on arrival, same as postgres
+	if sample.Time == 0 {
+		sample.Time = now.Unix()
+		m.stats[sample.Uuid] = append(m.stats[sample.Uuid], sample)
+		return true
+	}
+
+	// satellites replaying their backlog can send samples out of order, or
+	// send ones we've already got
+	stats := m.stats[sample.Uuid]
+	i, found := slices.BinarySearchFunc(stats, sample.Time, func(s uplink.GPUStatSample, t int64) int {
+		return cmp.Compare(s.Time, t)
+	})
+	if !found {
+		m.stats[sample.Uuid] = slices.Insert(stats, i, sample)
+	}
+	return !found
+}
+
+// must be called with the lock held
+func (m *inMemory) recordJobs(runs []broadcast.Job) {
+	for _, run := range runs {
+		jobs := m.jobs[run.Gpu]
+
+		i := jobContinued(jobs, run)
+		if i == -1 {
+			m.jobs[run.Gpu] = append(jobs, run)
+			continue
+		}
+
+		job := &jobs[i]
+		job.FirstSeen = min(job.FirstSeen, run.FirstSeen)
+		job.LastSeen = max(job.LastSeen, run.LastSeen)
+		job.PeakMemory = max(job.PeakMemory, run.PeakMemory)
+	}
+}
+
 func (m *inMemory) UpdateGPUContext(host string, packet uplink.GPUInfo) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	previous, known := m.infos[packet.Uuid]
+	m.inventory = append(m.inventory, inventoryChanges(previous, known, host, packet, time.Now())...)
 	m.infos[packet.Uuid] = gpuInfo{host: host, context: packet}
 
 	// Initialize stats slice if it doesn't exist
@@ -69,6 +147,20 @@ func (m *inMemory) UpdateGPUContext(host string, packet uplink.GPUInfo) error {
 	return nil
 }
 
+func (m *inMemory) GPUMachines(uuids []uuid.UUID) (map[uuid.UUID]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	machines := make(map[uuid.UUID]string)
+	for _, gpu := range uuids {
+		if info, known := m.infos[gpu]; known {
+			machines[gpu] = info.host
+		}
+	}
+
+	return machines, nil
+}
+
 func (m *inMemory) LatestData() (broadcast.Workstations, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -81,40 +173,30 @@ func (m *inMemory) LatestData() (broadcast.Workstations, error) {
 			continue
 		}
 
-		stat := stats[len(stats)-1]
-
-		inUse, user := stat.RunningProcesses.Summarise()
-
-		gpu := broadcast.GPU{
-			Uuid:          uuid,
-			Name:          info.context.Name,
-			Brand:         info.context.Brand,
-			DriverVersion: info.context.DriverVersion,
-			MemoryTotal:   info.context.MemoryTotal,
-			InUse:         inUse,
-			User:          user,
-		}
-
-		for _, field := range reflect.VisibleFields(reflect.TypeOf(stat)) {
-			if field.Name == "Uuid" {
-				continue
-			}
-			target := reflect.ValueOf(&gpu).Elem().FieldByName(field.Name)
-			if target.CanSet() {
-				target.Set(reflect.ValueOf(stat).FieldByIndex(field.Index))
-			}
-		}
+		gpu := GPUFromSample(stats[len(stats)-1])
+		gpu.Name = info.context.Name
+		gpu.Brand = info.context.Brand
+		gpu.DriverVersion = info.context.DriverVersion
+		gpu.MemoryTotal = info.context.MemoryTotal
+		gpu.Backend = info.context.Backend
+		gpu.Missing = m.missing[uuid]
 
 		gpus[info.host] = append(gpus[info.host], gpu)
 	}
 
 	var groups = make(map[string][]broadcast.Workstation)
 	for machine, info := range m.machines {
-		group := info.Group
-		if group == nil || strings.TrimSpace(*group) == "" {
-			fallback := DefaultGroup
-			group = &fallback
+		group := groupOf(info)
+
+		tags := []string{}
+		for tag := range m.tags[machine] {
+			tags = append(tags, tag)
 		}
+		metadata := []broadcast.Metadata{}
+		for _, meta := range m.metadata[machine] {
+			metadata = append(metadata, meta)
+		}
+		sortLabels(tags, metadata)
 
 		workstation := broadcast.Workstation{
 			Name:        machine,
@@ -123,18 +205,178 @@ func (m *inMemory) LatestData() (broadcast.Workstations, error) {
 			Notes:       info.Notes,
 			Owner:       info.Owner,
 			LastSeen:    time.Since(m.lastSeen[machine]),
+			Tags:        tags,
+			Metadata:    metadata,
 			Gpus:        gpus[machine],
 		}
 
-		groups[*group] = append(groups[*group], workstation)
+		groups[group] = append(groups[group], workstation)
 	}
 
-	var result broadcast.Workstations
-	for group, machines := range groups {
-		result = append(result, broadcast.Group{Name: group, Workstations: machines})
+	return arrangeGroups(m.createdGroups(), groups), nil
+}
+
+// the group a machine is in, with an empty group being the default one
+func groupOf(machine broadcast.ModifyMachine) string {
+	if machine.Group == nil || strings.TrimSpace(*machine.Group) == "" {
+		return DefaultGroup
 	}
+	return *machine.Group
+}
 
-	return result, nil
+func (m *inMemory) createdGroups() []broadcast.GroupInfo {
+	var created []broadcast.GroupInfo
+	for _, group := range m.groups {
+		created = append(created, group)
+	}
+	return created
+}
+
+func (m *inMemory) groupExists(name string) bool {
+	if _, exists := m.groups[name]; exists {
+		return true
+	}
+	for _, machine := range m.machines {
+		if groupOf(machine) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// put every machine in from into to
+func (m *inMemory) moveGroup(from string, to string) {
+	for hostname, machine := range m.machines {
+		if groupOf(machine) == from {
+			machine.Group = &to
+			m.machines[hostname] = machine
+		}
+	}
+}
+
+func (m *inMemory) Groups() ([]broadcast.GroupInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	named := make(map[string][]broadcast.Workstation)
+	for _, machine := range m.machines {
+		named[groupOf(machine)] = nil
+	}
+	return groupInfos(arrangeGroups(m.createdGroups(), named)), nil
+}
+
+func (m *inMemory) CreateGroup(group broadcast.GroupInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name, err := normaliseGroupName(group.Name)
+	if err != nil {
+		return err
+	}
+	if m.groupExists(name) {
+		return fmt.Errorf("%s: %w", name, ErrGroupExists)
+	}
+
+	group.Name = name
+	m.groups[name] = group
+	return nil
+}
+
+func (m *inMemory) UpdateGroup(changes broadcast.ModifyGroup) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.groupExists(changes.Name) {
+		return fmt.Errorf("%s: %w", changes.Name, ErrNoSuchGroup)
+	}
+
+	group := m.groups[changes.Name]
+	group.Name = changes.Name
+	if changes.Description != nil {
+		group.Description = *changes.Description
+	}
+	if changes.Order != nil {
+		group.Order = *changes.Order
+	}
+
+	if changes.NewName != nil && *changes.NewName != changes.Name {
+		newName, err := normaliseGroupName(*changes.NewName)
+		if err != nil {
+			return err
+		}
+		if m.groupExists(newName) {
+			return fmt.Errorf("%s: %w", newName, ErrGroupExists)
+		}
+
+		m.moveGroup(changes.Name, newName)
+		if settings, ok := m.groupSettings[changes.Name]; ok {
+			m.groupSettings[newName] = settings
+			delete(m.groupSettings, changes.Name)
+		}
+		delete(m.groups, changes.Name)
+		group.Name = newName
+	}
+
+	m.groups[group.Name] = group
+	return nil
+}
+
+func (m *inMemory) RemoveGroup(remove broadcast.RemoveGroup) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name, target, err := removedGroupTarget(remove)
+	if err != nil {
+		return err
+	}
+	if !m.groupExists(name) {
+		return fmt.Errorf("%s: %w", name, ErrNoSuchGroup)
+	}
+
+	m.moveGroup(name, target)
+	delete(m.groupSettings, name)
+	delete(m.groups, name)
+	return nil
+}
+
+func (m *inMemory) MoveMachines(move broadcast.MoveMachines) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, err := normaliseGroupName(move.Group)
+	if err != nil {
+		return err
+	}
+	for _, hostname := range move.Hostnames {
+		if _, exists := m.machines[hostname]; !exists {
+			return fmt.Errorf("%s: %w", hostname, ErrNoSuchMachine)
+		}
+	}
+
+	for _, hostname := range move.Hostnames {
+		machine := m.machines[hostname]
+		machine.Group = &group
+		m.machines[hostname] = machine
+	}
+	return nil
+}
+
+// GPUFromSample gives the stats in a sample as they're sent to the frontend
+func GPUFromSample(stat uplink.GPUStatSample) broadcast.GPU {
+	inUse, users := stat.RunningProcesses.Summarise()
+	gpu := broadcast.GPU{Uuid: stat.Uuid, InUse: inUse, Users: users}
+
+	for _, field := range reflect.VisibleFields(reflect.TypeOf(stat)) {
+		if field.Name == "Uuid" {
+			continue
+		}
+		target := reflect.ValueOf(&gpu).Elem().FieldByName(field.Name)
+		if target.CanSet() {
+			target.Set(reflect.ValueOf(stat).FieldByIndex(field.Index))
+		}
+	}
+
+	return gpu
 }
 
 func (m *inMemory) UpdateLastSeen(host string, whenSeen time.Time) error {
@@ -161,42 +403,98 @@ func (m *inMemory) LastSeen() ([]broadcast.WorkstationSeen, error) {
 	return seen, nil
 }
 
-func (m *inMemory) Downsample(cutoffTime time.Time) error {
+func (m *inMemory) Downsample(now time.Time, policy RetentionPolicy) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	windows, cutoff, deletes := policy.plan(now)
 	for uuid, samples := range m.stats {
-		var oldSamples, newSamples []uplink.GPUStatSample
-		for _, sample := range samples {
-			if sample.Time < cutoffTime.Unix() {
-				oldSamples = append(oldSamples, sample)
-			} else {
-				newSamples = append(newSamples, sample)
+		rollups := m.rollups[uuid]
+		if rollups == nil {
+			rollups = make(map[int64]broadcast.HistoricalDataPoint)
+			m.rollups[uuid] = rollups
+		}
+
+		if deletes {
+			samples = slices.DeleteFunc(samples, func(s uplink.GPUStatSample) bool {
+				return s.Time < cutoff.Unix()
+			})
+			for t := range rollups {
+				if t < cutoff.Unix() {
+					delete(rollups, t)
+				}
 			}
 		}
+		for _, window := range windows {
+			samples = rollUpSamples(samples, window, rollups)
+		}
+		m.stats[uuid] = samples
+	}
 
-		sort.Slice(oldSamples, func(i, j int) bool {
-			return oldSamples[i].Time < oldSamples[j].Time
-		})
+	return nil
+}
 
-		var downsampled []uplink.GPUStatSample
+// Replace the samples in each of the window's buckets with their average,
+// at the start of the bucket, and record each bucket's rollup among rollups.
+// Samples must be in time order
+func rollUpSamples(samples []uplink.GPUStatSample, window rollupWindow, rollups map[int64]broadcast.HistoricalDataPoint) []uplink.GPUStatSample {
+	var result []uplink.GPUStatSample
+	for start := 0; start < len(samples); {
+		if !window.includes(samples[start].Time) {
+			result = append(result, samples[start])
+			start++
+			continue
+		}
 
-		for len(oldSamples) > 0 {
-			batchEnd := 100
-			if len(oldSamples) < 100 {
-				batchEnd = len(oldSamples)
-			}
-			batch := oldSamples[:batchEnd]
-			oldSamples = oldSamples[batchEnd:]
+		bucket := bucketStart(samples[start].Time, window.step)
+		end := start + 1
+		for end < len(samples) && window.includes(samples[end].Time) &&
+			bucketStart(samples[end].Time, window.step) == bucket {
+			end++
+		}
 
-			averagedSample := CalculateAverage(batch)
-			downsampled = append(downsampled, averagedSample)
+		// a single sample at the start has already been rolled up
+		if end-start == 1 && samples[start].Time == bucket {
+			result = append(result, samples[start])
+			start = end
+			continue
 		}
 
-		m.stats[uuid] = append(downsampled, newSamples...)
+		// earlier rollups count for all the samples they stand for
+		points := make([]broadcast.HistoricalDataPoint, end-start)
+		for i, sample := range samples[start:end] {
+			point, ok := rollups[sample.Time]
+			if !ok {
+				point = broadcast.HistoricalDataPoint{Timestamp: sample.Time, Sample: GPUFromSample(sample), Samples: 1}
+			}
+			points[i] = point
+			delete(rollups, sample.Time)
+		}
+		rollup := aggregateBucket(bucket, points)
+		rollups[bucket] = rollup
+
+		// keep the processes, but take the stats from the rollup so they're
+		// weighted the same way
+		average := CalculateAverage(samples[start:end])
+		average.Time = bucket
+		setStats(&average, rollup.Sample)
+		result = append(result, average)
+		start = end
 	}
+	return result
+}
 
-	return nil
+// the inverse of GPUFromSample, for just the numeric stats
+func setStats(stat *uplink.GPUStatSample, gpu broadcast.GPU) {
+	for _, field := range reflect.VisibleFields(reflect.TypeOf(gpu)) {
+		if field.Type.Kind() != reflect.Float64 {
+			continue
+		}
+		target := reflect.ValueOf(stat).Elem().FieldByName(field.Name)
+		if target.CanSet() {
+			target.Set(reflect.ValueOf(gpu).FieldByIndex(field.Index))
+		}
+	}
 }
 
 func CalculateAverage(samples []uplink.GPUStatSample) uplink.GPUStatSample {
@@ -311,13 +609,28 @@ func (m *inMemory) RemoveMachine(machine broadcast.RemoveMachine) error {
 	}
 
 	delete(m.files, machine.Hostname)
+	delete(m.tags, machine.Hostname)
+	delete(m.metadata, machine.Hostname)
+	delete(m.machineSettings, machine.Hostname)
 	delete(m.lastSeen, machine.Hostname)
 	delete(m.machines, machine.Hostname)
 
+	m.inventory = slices.DeleteFunc(m.inventory, func(event broadcast.InventoryEvent) bool {
+		return event.Hostname == machine.Hostname
+	})
+	for id, alert := range m.alerts {
+		if alert.Hostname == machine.Hostname {
+			delete(m.alerts, id)
+		}
+	}
+
 	for i := range uuidsToRemove {
 		uuidToRemove := uuidsToRemove[i]
 		delete(m.infos, uuidToRemove)
 		delete(m.stats, uuidToRemove)
+		delete(m.jobs, uuidToRemove)
+		delete(m.rollups, uuidToRemove)
+		delete(m.missing, uuidToRemove)
 	}
 
 	return nil
@@ -358,75 +671,454 @@ func (m *inMemory) UpdateMachine(changes broadcast.ModifyMachine) error {
 	return nil
 }
 
-func (m *inMemory) AttachFile(file broadcast.AttachFile) error {
+func (m *inMemory) AddTag(tag broadcast.MachineTag) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name, err := normaliseTag(tag.Tag)
+	if err != nil {
+		return err
+	}
+	if _, exists := m.machines[tag.Hostname]; !exists {
+		return fmt.Errorf("%s: %w", tag.Hostname, ErrNoSuchMachine)
+	}
+
+	if m.tags[tag.Hostname] == nil {
+		m.tags[tag.Hostname] = make(map[string]bool)
+	}
+	m.tags[tag.Hostname][name] = true
+	return nil
+}
+
+func (m *inMemory) RemoveTag(tag broadcast.MachineTag) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.tags[tag.Hostname], strings.TrimSpace(tag.Tag))
+	return nil
+}
+
+func (m *inMemory) SetMetadata(meta broadcast.SetMetadata) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metadata, err := normaliseMetadata(meta.Metadata)
+	if err != nil {
+		return err
+	}
+	if _, exists := m.machines[meta.Hostname]; !exists {
+		return fmt.Errorf("%s: %w", meta.Hostname, ErrNoSuchMachine)
+	}
+
+	if m.metadata[meta.Hostname] == nil {
+		m.metadata[meta.Hostname] = make(map[string]broadcast.Metadata)
+	}
+	m.metadata[meta.Hostname][metadata.Key] = metadata
+	return nil
+}
+
+func (m *inMemory) RemoveMetadata(remove broadcast.RemoveMetadata) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.metadata[remove.Hostname], strings.TrimSpace(remove.Key))
+	return nil
+}
+
+func (m *inMemory) AttachFile(file broadcast.FileInfo) (string, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	var zero broadcast.ModifyMachine
 	if m.machines[file.Hostname] == zero {
-		return fmt.Errorf("%s: %w", file.Hostname, ErrNoSuchMachine)
+		return "", fmt.Errorf("%s: %w", file.Hostname, ErrNoSuchMachine)
 	}
 
 	if m.files[file.Hostname] == nil {
-		m.files[file.Hostname] = make(map[string]broadcast.AttachFile)
+		m.files[file.Hostname] = make(map[string]broadcast.FileInfo)
 	}
+	replaced := m.files[file.Hostname][file.Filename].Key
 	m.files[file.Hostname][file.Filename] = file
-	return nil
+	return replaced, nil
 }
 
-func (m *inMemory) GetFile(hostname string, filename string) (broadcast.AttachFile, error) {
+func (m *inMemory) GetFile(hostname string, filename string) (broadcast.FileInfo, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	var zerofile broadcast.AttachFile
-	filedir := m.files[hostname]
-	if filedir == nil {
-		return zerofile, fmt.Errorf("%s: %w", hostname, ErrFileNotPresent)
-	}
-
-	file := filedir[filename]
-	if file == zerofile {
-		return zerofile, fmt.Errorf("%s: %w", hostname, ErrFileNotPresent)
+	file, ok := m.files[hostname][filename]
+	if !ok {
+		return broadcast.FileInfo{}, fmt.Errorf("%s: %w", hostname, ErrFileNotPresent)
 	}
 
 	return file, nil
 }
 
-func (m *inMemory) ListFiles(hostname string) ([]string, error) {
+func (m *inMemory) ListFiles(hostname string) ([]broadcast.FileInfo, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	var zero broadcast.ModifyMachine
 	if m.machines[hostname] == zero {
-		return []string{}, ErrNoSuchMachine
+		return []broadcast.FileInfo{}, ErrNoSuchMachine
 	}
 
-	if m.files[hostname] == nil {
-		return []string{}, nil
-	}
-	res := []string{}
-	for k := range m.files[hostname] {
-		res = append(res, k)
+	res := []broadcast.FileInfo{}
+	for _, file := range m.files[hostname] {
+		res = append(res, file)
 	}
+	// in the same order as postgres
+	slices.SortFunc(res, func(a, b broadcast.FileInfo) int {
+		return cmp.Compare(a.Filename, b.Filename)
+	})
 	return res, nil
 }
 
-func (m *inMemory) RemoveFile(remove broadcast.RemoveFile) error {
+func (m *inMemory) RemoveFile(remove broadcast.RemoveFile) (string, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	var zero broadcast.ModifyMachine
-	if m.machines[remove.Hostname] == zero || m.files[remove.Hostname] == nil {
-		return ErrFileNotPresent
+	file, ok := m.files[remove.Hostname][remove.Filename]
+	if !ok {
+		return "", ErrFileNotPresent
 	}
 	delete(m.files[remove.Hostname], remove.Filename)
-	return nil
+	return file.Key, nil
 }
 
-// TODO: add implementations for the functions
-func (m *inMemory) HistoricalData(hostname string) (broadcast.HistoricalData, error) {
-	return broadcast.HistoricalData{}, ErrNotImplemented
+func (m *inMemory) HistoricalData(hostname string, query HistoryQuery) (broadcast.HistoricalData, error) {
+	if !query.Valid() {
+		return nil, ErrInvalidStep
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// in the same order as postgres
+	var gpus []uuid.UUID
+	for uuid, info := range m.infos {
+		if info.host == hostname {
+			gpus = append(gpus, uuid)
+		}
+	}
+	slices.SortFunc(gpus, func(a, b uuid.UUID) int {
+		return cmp.Compare(a.String(), b.String())
+	})
+
+	data := broadcast.HistoricalData{}
+	for _, gpu := range gpus {
+		var samples []broadcast.HistoricalDataPoint
+		for _, stat := range m.stats[gpu] {
+			if query.includes(gpu, stat.Time) {
+				point, ok := m.rollups[gpu][stat.Time]
+				if !ok {
+					point = broadcast.HistoricalDataPoint{Timestamp: stat.Time, Sample: GPUFromSample(stat), Samples: 1}
+				}
+				samples = append(samples, point)
+			}
+		}
+
+		if points := historyPoints(samples, query.Step); len(points) > 0 {
+			data = append(data, points)
+		}
+	}
+
+	return data, nil
 }
+
+// Like postgres, each sample's power draw counts for the time since the
+// previous one from its gpu
 func (m *inMemory) AggregateData() (broadcast.AggregateData, error) {
-	return broadcast.AggregateData{}, ErrNotImplemented
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var energy float64
+	for _, stats := range m.stats {
+		for i := 1; i < len(stats); i++ {
+			energy += stats[i].PowerDraw * float64(stats[i].Time-stats[i-1].Time)
+		}
+	}
+
+	return broadcast.AggregateData{TotalEnergy: uint64(math.Round(energy))}, nil
+}
+
+func (m *inMemory) SettingsOverrides() ([]broadcast.SettingsOverride, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	overrides := []broadcast.SettingsOverride{}
+	for group, settings := range m.groupSettings {
+		overrides = append(overrides, broadcast.SettingsOverride{Group: group, Settings: settings})
+	}
+	for hostname, settings := range m.machineSettings {
+		overrides = append(overrides, broadcast.SettingsOverride{Hostname: hostname, Settings: settings})
+	}
+	return overrides, nil
+}
+
+func (m *inMemory) SetSettingsOverride(override broadcast.SettingsOverride) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var overrides map[string]uplink.Settings
+	var key string
+	switch {
+	case override.Group != "" && override.Hostname == "":
+		overrides, key = m.groupSettings, override.Group
+	case override.Hostname != "" && override.Group == "":
+		if _, exists := m.lastSeen[override.Hostname]; !exists {
+			return fmt.Errorf("%s: %w", override.Hostname, ErrNoSuchMachine)
+		}
+		overrides, key = m.machineSettings, override.Hostname
+	default:
+		return ErrInvalidOverride
+	}
+
+	if override.Settings.IsZero() {
+		delete(overrides, key)
+	} else {
+		overrides[key] = override.Settings
+	}
+	return nil
+}
+
+func (m *inMemory) SettingsFor(hostname string) (uplink.Settings, uplink.Settings, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group := DefaultGroup
+	if machine := m.machines[hostname]; machine.Group != nil && *machine.Group != "" {
+		group = *machine.Group
+	}
+	return m.groupSettings[group], m.machineSettings[hostname], nil
+}
+
+func (m *inMemory) LatestProcesses(hostname string) ([]broadcast.GPUProcesses, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.lastSeen[hostname]; !exists {
+		return nil, fmt.Errorf("%s: %w", hostname, ErrNoSuchMachine)
+	}
+
+	result := []broadcast.GPUProcesses{}
+	for uuid, info := range m.infos {
+		if info.host != hostname {
+			continue
+		}
+
+		procs := uplink.Processes{}
+		if stats := m.stats[uuid]; len(stats) > 0 {
+			procs = stats[len(stats)-1].Unfiltered()
+		}
+		result = append(result, broadcast.GPUProcesses{Uuid: uuid, Processes: procs})
+	}
+	return result, nil
+}
+
+func (m *inMemory) Jobs(hostname string, from time.Time, to time.Time) ([]broadcast.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.lastSeen[hostname]; !exists {
+		return nil, fmt.Errorf("%s: %w", hostname, ErrNoSuchMachine)
+	}
+
+	result := []broadcast.Job{}
+	for uuid, info := range m.infos {
+		if info.host != hostname {
+			continue
+		}
+
+		var latest int64
+		if stats := m.stats[uuid]; len(stats) > 0 {
+			latest = stats[len(stats)-1].Time
+		}
+
+		for _, job := range m.jobs[uuid] {
+			if job.FirstSeen > to.Unix() || job.LastSeen < from.Unix() {
+				continue
+			}
+			job.Running = job.LastSeen >= latest
+			result = append(result, job)
+		}
+	}
+
+	slices.SortFunc(result, func(a, b broadcast.Job) int {
+		return cmp.Or(
+			cmp.Compare(b.LastSeen, a.LastSeen),
+			cmp.Compare(b.FirstSeen, a.FirstSeen),
+			cmp.Compare(a.Gpu.String(), b.Gpu.String()),
+			cmp.Compare(a.Pid, b.Pid),
+		)
+	})
+	return result, nil
+}
+
+// Each sample accounts for the time since the previous one from its gpu, up
+// to maxGap for each sample it stands for
+func (m *inMemory) Usage(from time.Time, to time.Time, by UsageBy, maxGap time.Duration) ([]broadcast.Usage, error) {
+	if !by.Valid() {
+		return nil, ErrInvalidUsageBy
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	totals := make(map[string]*usageTotal)
+	for uuid, stats := range m.stats {
+		info, exists := m.infos[uuid]
+		if !exists {
+			continue
+		}
+
+		for i := 1; i < len(stats); i++ {
+			sample := stats[i]
+			if sample.Time <= from.Unix() || sample.Time > to.Unix() {
+				continue
+			}
+
+			owner := info.host
+			if by == UsageByGroup {
+				owner = DefaultGroup
+				if group := m.machines[info.host].Group; group != nil && *group != "" {
+					owner = *group
+				}
+			}
+
+			samples := 1
+			if point, ok := m.rollups[uuid][sample.Time]; ok {
+				samples = point.Samples
+			}
+
+			seconds := usageSeconds(sample.Time-stats[i-1].Time, samples, maxGap)
+			addUsage(totals, by, owner, seconds, GPUFromSample(sample))
+		}
+	}
+
+	return usageFromTotals(totals), nil
+}
+
+func (m *inMemory) InventoryEvents(hostname string, from time.Time, to time.Time) ([]broadcast.InventoryEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.lastSeen[hostname]; !exists {
+		return nil, fmt.Errorf("%s: %w", hostname, ErrNoSuchMachine)
+	}
+
+	result := []broadcast.InventoryEvent{}
+	for _, event := range m.inventory {
+		if inventoryEventOf(event, hostname) && event.Time >= from.Unix() && event.Time <= to.Unix() {
+			result = append(result, event)
+		}
+	}
+
+	sortInventoryEvents(result)
+	return result, nil
+}
+
+func (m *inMemory) FlagMissingGPUs(now time.Time, timeout time.Duration, slower map[string]time.Duration) ([]broadcast.InventoryEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := now.Add(-timeout)
+
+	var events []broadcast.InventoryEvent
+	for uuid, info := range m.infos {
+		stats := m.stats[uuid]
+		if len(stats) == 0 {
+			continue
+		}
+		gpuCutoff := cutoff
+		if timeout, ok := slower[info.host]; ok {
+			gpuCutoff = now.Add(-timeout)
+		}
+		reporting := stats[len(stats)-1].Time >= gpuCutoff.Unix()
+
+		var kind broadcast.InventoryEventKind
+		if m.missing[uuid] && reporting {
+			kind = broadcast.GPUReturned
+			delete(m.missing, uuid)
+		} else if !m.missing[uuid] && !reporting && !m.lastSeen[info.host].Before(cutoff) {
+			kind = broadcast.GPUMissing
+			m.missing[uuid] = true
+		} else {
+			continue
+		}
+
+		events = append(events, broadcast.InventoryEvent{
+			Time:     now.Unix(),
+			Hostname: info.host,
+			Gpu:      uuid,
+			Name:     info.context.Name,
+			Kind:     kind,
+		})
+	}
+
+	m.inventory = append(m.inventory, events...)
+	return events, nil
+}
+
+func (m *inMemory) RecordAlert(alert broadcast.Alert) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if alert.Id == 0 {
+		m.lastAlert++
+		alert.Id = m.lastAlert
+	} else if _, exists := m.alerts[alert.Id]; !exists {
+		return alert.Id, nil
+	}
+
+	m.alerts[alert.Id] = alert
+	return alert.Id, nil
+}
+
+func (m *inMemory) RemoveAlert(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.alerts, id)
+	return nil
+}
+
+func (m *inMemory) ActiveAlerts() ([]broadcast.Alert, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := []broadcast.Alert{}
+	for _, alert := range m.alerts {
+		if alert.State != broadcast.AlertResolved {
+			result = append(result, alert)
+		}
+	}
+
+	slices.SortFunc(result, func(a, b broadcast.Alert) int {
+		return cmp.Or(cmp.Compare(a.Since, b.Since), cmp.Compare(a.Id, b.Id))
+	})
+	return result, nil
+}
+
+func (m *inMemory) AlertHistory(from time.Time, to time.Time) ([]broadcast.Alert, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := []broadcast.Alert{}
+	for _, alert := range m.alerts {
+		if alertFiredBetween(alert, from, to) {
+			result = append(result, alert)
+		}
+	}
+
+	slices.SortFunc(result, func(a, b broadcast.Alert) int {
+		return cmp.Or(cmp.Compare(b.FiredAt, a.FiredAt), cmp.Compare(b.Id, a.Id))
+	})
+	return result, nil
+}
+
+// whether an alert was firing at some point between from and to
+func alertFiredBetween(alert broadcast.Alert, from time.Time, to time.Time) bool {
+	return alert.FiredAt != 0 && alert.FiredAt <= to.Unix() &&
+		(alert.ResolvedAt == 0 || alert.ResolvedAt >= from.Unix())
 }
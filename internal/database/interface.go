@@ -6,6 +6,8 @@ import (
 
 	"github.com/gpuctl/gpuctl/internal/broadcast"
 	"github.com/gpuctl/gpuctl/internal/uplink"
+
+	"github.com/google/uuid"
 )
 
 // Constant errors for failures in DB
@@ -16,6 +18,15 @@ var (
 	ErrNoSuchMachine     = errors.New("could not find given machine")
 	ErrFileNotPresent    = errors.New("no file found")
 	ErrNotImplemented    = errors.New("method not implemented")
+	ErrInvalidOverride   = errors.New("settings override must be for exactly one group or machine")
+	ErrInvalidUsageBy    = errors.New("usage can only be totalled by user, group or machine")
+	ErrInvalidStep       = errors.New("history can't be bucketed more finely than a second")
+	ErrInvalidRetention  = errors.New("invalid retention policy")
+	ErrInvalidTag        = errors.New("tags can't be blank")
+	ErrInvalidMetadata   = errors.New("invalid metadata")
+	ErrInvalidGroup      = errors.New("invalid group")
+	ErrNoSuchGroup       = errors.New("could not find given group")
+	ErrGroupExists       = errors.New("group already exists")
 )
 
 // default group to give to machines with a null or empty group
@@ -30,9 +41,16 @@ type Database interface {
 	// will error if this gpu hasn't sent a context packet yet
 	AppendDataPoint(sample uplink.GPUStatSample) error
 
+	// record several data points at once, either all are saved or none are
+	AppendDataPoints(samples []uplink.GPUStatSample) error
+
 	// Update the information for the GPU contained in uplink.GPUInfo
 	UpdateGPUContext(host string, info uplink.GPUInfo) error
 
+	// the machine each of the given gpus is in, leaving out any we haven't
+	// had the context of
+	GPUMachines(uuids []uuid.UUID) (map[uuid.UUID]string, error)
+
 	// get the latest metrics for all approved machines
 	LatestData() (broadcast.Workstations, error)
 
@@ -44,16 +62,87 @@ type Database interface {
 	RemoveMachine(machine broadcast.RemoveMachine) error
 	UpdateMachine(changes broadcast.ModifyMachine) error
 
-	// downsample since certain time
-	Downsample(time.Time) error
+	// groups of machines, which exist once they've been created or have a
+	// machine in them. Groups are listed by their order and then name, and
+	// machines in a group that hasn't been created are in one with no
+	// description and order 0. Removing a group moves its machines to
+	// another, and moving machines moves every one of them, or none if any
+	// don't exist
+	Groups() ([]broadcast.GroupInfo, error)
+	CreateGroup(group broadcast.GroupInfo) error
+	UpdateGroup(changes broadcast.ModifyGroup) error
+	RemoveGroup(remove broadcast.RemoveGroup) error
+	MoveMachines(move broadcast.MoveMachines) error
+
+	// tags and typed metadata on machines, which LatestData includes. Adding a
+	// tag twice or removing one that isn't there does nothing, as does
+	// removing missing metadata. Setting metadata replaces any with its key
+	AddTag(tag broadcast.MachineTag) error
+	RemoveTag(tag broadcast.MachineTag) error
+	SetMetadata(meta broadcast.SetMetadata) error
+	RemoveMetadata(remove broadcast.RemoveMetadata) error
 
-	// methods for interacting with files
-	AttachFile(broadcast.AttachFile) error
-	GetFile(hostname string, filename string) (broadcast.AttachFile, error)
-	RemoveFile(broadcast.RemoveFile) error
-	ListFiles(hostname string) ([]string, error)
+	// roll up and delete old samples, as the policy says they should be at now
+	Downsample(now time.Time, policy RetentionPolicy) error
+
+	// methods for interacting with files, whose contents are kept in a file
+	// store under their Key. Attaching and removing give the key of any
+	// contents that were replaced or removed, so they can be deleted too
+	AttachFile(file broadcast.FileInfo) (replaced string, err error)
+	GetFile(hostname string, filename string) (broadcast.FileInfo, error)
+	RemoveFile(remove broadcast.RemoveFile) (removed string, err error)
+	ListFiles(hostname string) ([]broadcast.FileInfo, error)
 
 	// Historical and aggregate data for graphs
-	HistoricalData(hostname string) (broadcast.HistoricalData, error)
+	HistoricalData(hostname string, query HistoryQuery) (broadcast.HistoricalData, error)
 	AggregateData() (broadcast.AggregateData, error)
+
+	// per-group and per-machine overrides of the settings sent to satellites.
+	// Setting an override with zero settings removes it
+	SettingsOverrides() ([]broadcast.SettingsOverride, error)
+	SetSettingsOverride(override broadcast.SettingsOverride) error
+	// the overrides for a machine and the group it's in, zero if there are none
+	SettingsFor(hostname string) (group uplink.Settings, machine uplink.Settings, err error)
+
+	// everything running on each of a machine's gpus when they were last
+	// sampled, whether or not the satellite's filter counts it as using them
+	LatestProcesses(hostname string) ([]broadcast.GPUProcesses, error)
+
+	// the processes that ran on a machine's gpus at some point between from
+	// and to, most recently seen first
+	Jobs(hostname string, from time.Time, to time.Time) ([]broadcast.Job, error)
+
+	// what each user, group or machine used between from and to, heaviest
+	// first. Each sample accounts for the time since the one before it from
+	// its gpu, but no more than maxGap for each sample it stands for
+	Usage(from time.Time, to time.Time, by UsageBy, maxGap time.Duration) ([]broadcast.Usage, error)
+
+	// when gpus appeared on, moved to or from, or went missing from a machine
+	// between from and to, and when their drivers changed, newest first.
+	// UpdateGPUContext records all but missing gpus
+	InventoryEvents(hostname string, from time.Time, to time.Time) ([]broadcast.InventoryEvent, error)
+
+	// flag the gpus that haven't sent a sample within timeout of now, though
+	// their machine has, and unflag any flagged ones that have since. Gives
+	// the missing and returned events it recorded. Machines in slower, by
+	// hostname, send samples less often, so their gpus get that long instead
+	FlagMissingGPUs(now time.Time, timeout time.Duration, slower map[string]time.Duration) ([]broadcast.InventoryEvent, error)
+
+	// alerts raised by alerting rules. Recording an alert without an Id adds
+	// it and gives its new Id, otherwise it replaces the one with that Id, if
+	// there still is one. Alerts that stop before they fire are removed
+	RecordAlert(alert broadcast.Alert) (int64, error)
+	RemoveAlert(id int64) error
+	// the alerts that are pending or firing, oldest first
+	ActiveAlerts() ([]broadcast.Alert, error)
+	// the alerts that were firing at some point between from and to, most
+	// recently fired first
+	AlertHistory(from time.Time, to time.Time) ([]broadcast.Alert, error)
+}
+
+// Databases that can have files from before they were kept in a file store,
+// with their contents in the database itself
+type LegacyFiles interface {
+	// give each such file's contents to save, and record where it put them
+	MoveLegacyFiles(save func(file broadcast.FileInfo, contents []byte) (broadcast.FileInfo, error)) (int, error)
 }
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+	"github.com/gpuctl/gpuctl/internal/uplink"
+)
+
+// What changed about a gpu when its context arrived from host, given what we
+// had for it before, if we'd seen it at all
+func inventoryChanges(previous gpuInfo, known bool, host string, packet uplink.GPUInfo, now time.Time) []broadcast.InventoryEvent {
+	event := broadcast.InventoryEvent{
+		Time:     now.Unix(),
+		Hostname: host,
+		Gpu:      packet.Uuid,
+		Name:     packet.Name,
+	}
+
+	if !known {
+		event.Kind = broadcast.GPUAppeared
+		return []broadcast.InventoryEvent{event}
+	}
+
+	var events []broadcast.InventoryEvent
+	if previous.host != host {
+		moved := event
+		moved.Kind = broadcast.GPUMoved
+		moved.From, moved.To = previous.host, host
+		events = append(events, moved)
+	}
+	if previous.context.DriverVersion != packet.DriverVersion {
+		changed := event
+		changed.Kind = broadcast.GPUDriverChanged
+		changed.From, changed.To = previous.context.DriverVersion, packet.DriverVersion
+		events = append(events, changed)
+	}
+	return events
+}
+
+// whether an event is part of a machine's timeline. Gpus moving away from it
+// are, as well as everything that happened on it
+func inventoryEventOf(event broadcast.InventoryEvent, hostname string) bool {
+	return event.Hostname == hostname ||
+		(event.Kind == broadcast.GPUMoved && event.From == hostname)
+}
+
+// newest first, and the most recently recorded first among those at the same
+// time, given them oldest first
+func sortInventoryEvents(events []broadcast.InventoryEvent) {
+	slices.Reverse(events)
+	slices.SortStableFunc(events, func(a, b broadcast.InventoryEvent) int {
+		return cmp.Compare(b.Time, a.Time)
+	})
+}
+
+// how long each machine in slower has to send samples, in seconds, as JSON
+// for a query to look hostnames up in
+func slowerJSON(slower map[string]time.Duration) (string, error) {
+	seconds := make(map[string]float64)
+	for hostname, timeout := range slower {
+		seconds[hostname] = timeout.Seconds()
+	}
+	encoded, err := json.Marshal(seconds)
+	return string(encoded), err
+}
+
+// Run an update giving the uuid, machine and name of the gpus it changed, and
+// make an event of kind for each. Shared by postgres and sqlite
+func flagGpus(tx *sql.Tx, kind broadcast.InventoryEventKind, now time.Time, update string, args ...any) ([]broadcast.InventoryEvent, error) {
+	rows, err := tx.Query(update, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []broadcast.InventoryEvent
+	for rows.Next() {
+		event := broadcast.InventoryEvent{Time: now.Unix(), Kind: kind}
+		err = rows.Scan(&event.Gpu, &event.Hostname, &event.Name)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
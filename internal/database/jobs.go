@@ -0,0 +1,100 @@
+package database
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+	"github.com/gpuctl/gpuctl/internal/uplink"
+
+	"github.com/google/uuid"
+)
+
+// A process that goes this long without being sampled is taken to have
+// ended, so the same program starting again under its pid is a new job
+const jobGap = 10 * time.Minute
+
+// The processes in some samples, as one job for each stretch of time a pid
+// ran the same program without a gap, in the order they started. Samples
+// without a time are taken as sampled at now
+func jobRuns(samples []uplink.GPUStatSample, now time.Time) []broadcast.Job {
+	sorted := slices.Clone(samples)
+	for i := range sorted {
+		if sorted[i].Time == 0 {
+			sorted[i].Time = now.Unix()
+		}
+	}
+	slices.SortStableFunc(sorted, func(a, b uplink.GPUStatSample) int {
+		return cmp.Compare(a.Time, b.Time)
+	})
+
+	type process struct {
+		gpu uuid.UUID
+		pid uint64
+	}
+	current := make(map[process]int) // the run each process is on
+	var runs []broadcast.Job
+
+	for _, sample := range sorted {
+		for _, proc := range sample.RunningProcesses {
+			key := process{sample.Uuid, proc.Pid}
+			i, ok := current[key]
+			if ok && runs[i].Name == proc.Name && runs[i].Owner == proc.Owner &&
+				sample.Time-runs[i].LastSeen <= int64(jobGap.Seconds()) {
+				runs[i].LastSeen = sample.Time
+				runs[i].PeakMemory = max(runs[i].PeakMemory, proc.MemUsed)
+				continue
+			}
+
+			current[key] = len(runs)
+			runs = append(runs, broadcast.Job{
+				Gpu:        sample.Uuid,
+				Pid:        proc.Pid,
+				Name:       proc.Name,
+				Owner:      proc.Owner,
+				FirstSeen:  sample.Time,
+				LastSeen:   sample.Time,
+				PeakMemory: proc.MemUsed,
+			})
+		}
+	}
+
+	return runs
+}
+
+// Whether a run of a process carries on a job we already have, which must
+// be the one its pid was running when the run started
+func continuesJob(job broadcast.Job, run broadcast.Job) bool {
+	gap := int64(jobGap.Seconds())
+	return job.Name == run.Name && job.Owner == run.Owner &&
+		run.FirstSeen <= job.LastSeen+gap && run.LastSeen >= job.FirstSeen-gap
+}
+
+// The job a run carries on, out of those for its gpu, or -1 if it's new
+func jobContinued(jobs []broadcast.Job, run broadcast.Job) int {
+	latest := -1
+	for i, job := range jobs {
+		if job.Pid == run.Pid && (latest == -1 || startedCloser(job, jobs[latest], run.FirstSeen)) {
+			latest = i
+		}
+	}
+
+	if latest == -1 || !continuesJob(jobs[latest], run) {
+		return -1
+	}
+	return latest
+}
+
+// Whether job a is more likely than b to be what their pid was running at t:
+// the newest that had started by then is, or failing that the first after
+func startedCloser(a broadcast.Job, b broadcast.Job, t int64) bool {
+	aStarted, bStarted := a.FirstSeen <= t, b.FirstSeen <= t
+	if aStarted != bStarted {
+		return aStarted
+	}
+	if aStarted {
+		return a.FirstSeen > b.FirstSeen
+	}
+	return a.FirstSeen < b.FirstSeen
+}
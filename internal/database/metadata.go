@@ -0,0 +1,117 @@
+package database
+
+import (
+	"cmp"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+)
+
+// the tag as it's stored, or ErrInvalidTag
+func normaliseTag(tag string) (string, error) {
+	tag = strings.TrimSpace(tag)
+	if tag == "" {
+		return "", ErrInvalidTag
+	}
+	return tag, nil
+}
+
+// Check the value is of the metadata's type, and write it the same way
+// whichever way it was given, so it can be compared and sorted as text
+func normaliseMetadata(meta broadcast.Metadata) (broadcast.Metadata, error) {
+	meta.Key = strings.TrimSpace(meta.Key)
+	if meta.Key == "" {
+		return meta, fmt.Errorf("%w: missing key", ErrInvalidMetadata)
+	}
+
+	value := strings.TrimSpace(meta.Value)
+	switch meta.Type {
+	case broadcast.MetadataString:
+		return meta, nil
+	case broadcast.MetadataNumber:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return meta, fmt.Errorf("%w: %s isn't a number", ErrInvalidMetadata, meta.Key)
+		}
+		meta.Value = strconv.FormatFloat(number, 'f', -1, 64)
+	case broadcast.MetadataDate:
+		date, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return meta, fmt.Errorf("%w: %s isn't a date like 2026-01-31", ErrInvalidMetadata, meta.Key)
+		}
+		meta.Value = date.Format(time.DateOnly)
+	case broadcast.MetadataBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return meta, fmt.Errorf("%w: %s isn't true or false", ErrInvalidMetadata, meta.Key)
+		}
+		meta.Value = strconv.FormatBool(b)
+	default:
+		return meta, fmt.Errorf("%w: unknown type %q", ErrInvalidMetadata, meta.Type)
+	}
+	return meta, nil
+}
+
+// sorted here rather than in SQL, where the order depends on the collation
+func sortLabels(tags []string, metadata []broadcast.Metadata) {
+	slices.Sort(tags)
+	slices.SortFunc(metadata, func(a, b broadcast.Metadata) int {
+		return cmp.Compare(a.Key, b.Key)
+	})
+}
+
+// Every machine's tags and metadata, from the MachineTags and MachineMetadata
+// tables, which postgres and sqlite share
+func machineLabels(tx *sql.Tx) (map[string][]string, map[string][]broadcast.Metadata, error) {
+	tags := make(map[string][]string)
+	rows, err := tx.Query(`SELECT Hostname, Tag FROM MachineTags`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hostname, tag string
+		err = rows.Scan(&hostname, &tag)
+		if err != nil {
+			return nil, nil, err
+		}
+		tags[hostname] = append(tags[hostname], tag)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	metadata := make(map[string][]broadcast.Metadata)
+	rows, err = tx.Query(`SELECT Hostname, Name, Type, Value FROM MachineMetadata`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hostname string
+		var meta broadcast.Metadata
+		err = rows.Scan(&hostname, &meta.Key, &meta.Type, &meta.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+		metadata[hostname] = append(metadata[hostname], meta)
+	}
+	return tags, metadata, rows.Err()
+}
+
+// a machine's labels for LatestData, empty rather than nil so clients don't
+// have to check
+func labelsFor(hostname string, tags map[string][]string, metadata map[string][]broadcast.Metadata) ([]string, []broadcast.Metadata) {
+	machineTags := append([]string{}, tags[hostname]...)
+	machineMetadata := append([]broadcast.Metadata{}, metadata[hostname]...)
+	sortLabels(machineTags, machineMetadata)
+	return machineTags, machineMetadata
+}
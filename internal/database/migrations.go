@@ -0,0 +1,229 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Changes to the postgres schema, applied in order. Each is named
+// NNNN_description.sql and is never edited once released: change the schema
+// by adding a new one
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// arbitrary, but the same for every groundstation, so only one migrates a
+// database at a time
+const migrationLockKey = 0x67707563746c
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// The state of one migration in a database
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time // nil if it hasn't been applied yet
+}
+
+func loadMigrations() ([]migration, error) {
+	files, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	// the glob is sorted, and the numbers are padded
+	var migrations []migration
+	for i, file := range files {
+		base := strings.TrimSuffix(strings.TrimPrefix(file, "migrations/"), ".sql")
+		number, name, found := strings.Cut(base, "_")
+		version, err := strconv.Atoi(number)
+		if !found || err != nil {
+			return nil, fmt.Errorf("migration %s isn't named NNNN_description.sql", file)
+		}
+		if version != i+1 {
+			return nil, fmt.Errorf("migration %s should be number %d", file, i+1)
+		}
+
+		contents, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration{version: version, name: name, sql: string(contents)})
+	}
+
+	return migrations, nil
+}
+
+// open a postgres database without touching its schema
+func openPostgres(databaseUrl string) (*sql.DB, error) {
+	db, err := sql.Open("pgx", databaseUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(maxOpenCons)
+
+	// sql.Open won't make a connection til use
+	// so try pinging database to verify connection
+	err = db.Ping()
+	if err != nil {
+		return nil, errors.Join(err, db.Close())
+	}
+
+	return db, nil
+}
+
+// MigratePostgres brings the database at databaseUrl up to date, returning
+// how many migrations were applied
+func MigratePostgres(databaseUrl string) (int, error) {
+	db, err := openPostgres(databaseUrl)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	return migrate(db, migrations)
+}
+
+// PostgresMigrationStatus lists every migration, and when it was applied to
+// the database at databaseUrl
+func PostgresMigrationStatus(databaseUrl string) ([]MigrationStatus, error) {
+	db, err := openPostgres(databaseUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		statuses[i] = MigrationStatus{Version: m.version, Name: m.name}
+		if at, ok := applied[m.version]; ok {
+			statuses[i].AppliedAt = &at
+		}
+	}
+	return statuses, nil
+}
+
+// when each applied migration was, by version. Empty for databases that
+// haven't been migrated yet
+func appliedMigrations(db *sql.DB) (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+
+	var table sql.NullString
+	err := db.QueryRow(`SELECT to_regclass('schema_version')::text`).Scan(&table)
+	if err != nil || !table.Valid {
+		return applied, err
+	}
+
+	rows, err := db.Query(`SELECT Version, AppliedAt FROM schema_version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var at time.Time
+		err = rows.Scan(&version, &at)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+
+	return applied, rows.Err()
+}
+
+// Apply the migrations the database doesn't have yet, each in its own
+// transaction. Holds an advisory lock throughout, so groundstations starting
+// at the same time don't both try
+func migrate(db *sql.DB, migrations []migration) (int, error) {
+	ctx := context.Background()
+
+	// advisory locks belong to a session, so everything has to happen on the
+	// one connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version (
+		Version integer NOT NULL,
+		Name text NOT NULL,
+		AppliedAt timestamp NOT NULL DEFAULT now(),
+		PRIMARY KEY (Version)
+	);`)
+	if err != nil {
+		return 0, err
+	}
+
+	var current int
+	err = conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(Version), 0) FROM schema_version`).Scan(&current)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return applied, err
+		}
+
+		_, err = tx.Exec(m.sql)
+		if err != nil {
+			return applied, errors.Join(fmt.Errorf("migration %d (%s): %w", m.version, m.name, err), tx.Rollback())
+		}
+
+		_, err = tx.Exec(`INSERT INTO schema_version (Version, Name) VALUES ($1, $2)`, m.version, m.name)
+		if err != nil {
+			return applied, errors.Join(err, tx.Rollback())
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return applied, err
+		}
+		applied++
+	}
+
+	return applied, nil
+}
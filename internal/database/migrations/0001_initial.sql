@@ -0,0 +1,56 @@
+-- The schema from before migrations were tracked. Databases from then
+-- already have these tables, so nothing here can fail if they exist.
+--
+-- We have to make all rows non-null, because we can't scan a null value
+-- into a Go variable
+
+-- the default group must match DefaultGroup
+CREATE TABLE IF NOT EXISTS Machines (
+	Hostname text NOT NULL,
+	GroupName text NOT NULL DEFAULT 'Shared',
+	CPU text,
+	Motherboard text,
+	Notes text,
+	Owner text,
+	LastSeen timestamp,
+	PRIMARY KEY (Hostname)
+);
+
+CREATE TABLE IF NOT EXISTS GPUs (
+	Uuid uuid NOT NULL,
+	Machine text NOT NULL REFERENCES Machines (Hostname),
+	Name text NOT NULL,
+	Brand text NOT NULL,
+	DriverVersion text NOT NULL,
+	MemoryTotal integer NOT NULL,
+	PRIMARY KEY (Uuid)
+);
+
+CREATE TABLE IF NOT EXISTS Files (
+	Hostname text NOT NULL REFERENCES Machines (Hostname),
+	Filename text NOT NULL,
+	Mime text NOT NULL,
+	File text NOT NULL,
+	PRIMARY KEY (Hostname, Filename)
+);
+
+CREATE TABLE IF NOT EXISTS Stats (
+	Gpu uuid REFERENCES GPUs (Uuid) NOT NULL,
+	Received timestamp NOT NULL,
+	MemoryUtilisation real NOT NULL,
+	GpuUtilisation real NOT NULL,
+	MemoryUsed real NOT NULL,
+	FanSpeed real NOT NULL,
+	Temp real NOT NULL,
+	MemoryTemp real NOT NULL,
+	GraphicsVoltage real NOT NULL,
+	PowerDraw real NOT NULL,
+	GraphicsClock real NOT NULL,
+	MaxGraphicsClock real NOT NULL,
+	MemoryClock real NOT NULL,
+	MaxMemoryClock real NOT NULL,
+	InUse boolean NOT NULL,
+	UserName text NOT NULL DEFAULT '',
+	IsDownSampled boolean DEFAULT FALSE,
+	PRIMARY KEY (Gpu, Received)
+);
@@ -0,0 +1,3 @@
+-- which vendor tool each gpu's stats come from
+ALTER TABLE GPUs
+	ADD COLUMN IF NOT EXISTS Backend text NOT NULL DEFAULT '';
@@ -0,0 +1,13 @@
+-- settings overrides are stored as the JSON sent to satellites, so that new
+-- settings don't need new columns
+CREATE TABLE IF NOT EXISTS GroupSettings (
+	GroupName text NOT NULL,
+	Settings jsonb NOT NULL,
+	PRIMARY KEY (GroupName)
+);
+
+CREATE TABLE IF NOT EXISTS MachineSettings (
+	Hostname text NOT NULL REFERENCES Machines (Hostname),
+	Settings jsonb NOT NULL,
+	PRIMARY KEY (Hostname)
+);
@@ -0,0 +1,8 @@
+-- only the processes in the newest sample from each gpu are kept, in full,
+-- as Stats only records who was using it
+CREATE TABLE IF NOT EXISTS LatestProcesses (
+	Gpu uuid NOT NULL REFERENCES GPUs (Uuid),
+	Received timestamp NOT NULL,
+	Processes jsonb NOT NULL,
+	PRIMARY KEY (Gpu)
+);
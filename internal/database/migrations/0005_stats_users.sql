@@ -0,0 +1,15 @@
+-- everyone using a gpu, rather than just the first user's name
+ALTER TABLE Stats
+	ADD COLUMN IF NOT EXISTS Users jsonb NOT NULL DEFAULT '[]';
+
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_name = 'stats' AND column_name = 'username') THEN
+		UPDATE Stats
+		SET Users = jsonb_build_array(jsonb_build_object(
+			'name', UserName, 'memory_used', 0, 'processes', 1))
+		WHERE InUse;
+		ALTER TABLE Stats DROP COLUMN UserName;
+	END IF;
+END $$;
@@ -0,0 +1,11 @@
+-- pids get reused, so a process is identified by when it started too
+CREATE TABLE IF NOT EXISTS Jobs (
+	Gpu uuid NOT NULL REFERENCES GPUs (Uuid),
+	Pid bigint NOT NULL,
+	Name text NOT NULL,
+	Owner text NOT NULL,
+	FirstSeen timestamp NOT NULL,
+	LastSeen timestamp NOT NULL,
+	PeakMemory real NOT NULL,
+	PRIMARY KEY (Gpu, Pid, FirstSeen)
+);
@@ -0,0 +1,4 @@
+-- rollups are told apart by being the only sample at the start of their
+-- bucket, rather than by a flag
+ALTER TABLE Stats
+	DROP COLUMN IF EXISTS IsDownSampled;
@@ -0,0 +1,29 @@
+-- rollups keep how many samples they average, and the lowest and highest
+-- each stat was, so peaks survive downsampling. Null for raw samples
+ALTER TABLE Stats
+	ADD COLUMN IF NOT EXISTS Samples integer NOT NULL DEFAULT 1,
+	ADD COLUMN IF NOT EXISTS AlwaysInUse boolean,
+	ADD COLUMN IF NOT EXISTS LowestMemoryUtilisation real,
+	ADD COLUMN IF NOT EXISTS HighestMemoryUtilisation real,
+	ADD COLUMN IF NOT EXISTS LowestGpuUtilisation real,
+	ADD COLUMN IF NOT EXISTS HighestGpuUtilisation real,
+	ADD COLUMN IF NOT EXISTS LowestMemoryUsed real,
+	ADD COLUMN IF NOT EXISTS HighestMemoryUsed real,
+	ADD COLUMN IF NOT EXISTS LowestFanSpeed real,
+	ADD COLUMN IF NOT EXISTS HighestFanSpeed real,
+	ADD COLUMN IF NOT EXISTS LowestTemp real,
+	ADD COLUMN IF NOT EXISTS HighestTemp real,
+	ADD COLUMN IF NOT EXISTS LowestMemoryTemp real,
+	ADD COLUMN IF NOT EXISTS HighestMemoryTemp real,
+	ADD COLUMN IF NOT EXISTS LowestGraphicsVoltage real,
+	ADD COLUMN IF NOT EXISTS HighestGraphicsVoltage real,
+	ADD COLUMN IF NOT EXISTS LowestPowerDraw real,
+	ADD COLUMN IF NOT EXISTS HighestPowerDraw real,
+	ADD COLUMN IF NOT EXISTS LowestGraphicsClock real,
+	ADD COLUMN IF NOT EXISTS HighestGraphicsClock real,
+	ADD COLUMN IF NOT EXISTS LowestMaxGraphicsClock real,
+	ADD COLUMN IF NOT EXISTS HighestMaxGraphicsClock real,
+	ADD COLUMN IF NOT EXISTS LowestMemoryClock real,
+	ADD COLUMN IF NOT EXISTS HighestMemoryClock real,
+	ADD COLUMN IF NOT EXISTS LowestMaxMemoryClock real,
+	ADD COLUMN IF NOT EXISTS HighestMaxMemoryClock real;
@@ -0,0 +1,9 @@
+-- files' contents are kept in a file store, under StoreKey. Files from before
+-- then still have their contents in File, until they're moved on startup
+ALTER TABLE Files
+	ALTER COLUMN File DROP NOT NULL,
+	ADD COLUMN IF NOT EXISTS StoreKey text,
+	ADD COLUMN IF NOT EXISTS Size bigint NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS Checksum text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS Uploader text NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS UploadedAt timestamp NOT NULL DEFAULT now();
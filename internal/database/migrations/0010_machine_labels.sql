@@ -0,0 +1,15 @@
+-- tags and typed metadata on machines. Metadata values are stored as text in
+-- a fixed format for their type, so any type fits in one column
+CREATE TABLE IF NOT EXISTS MachineTags (
+	Hostname text NOT NULL REFERENCES Machines (Hostname),
+	Tag text NOT NULL,
+	PRIMARY KEY (Hostname, Tag)
+);
+
+CREATE TABLE IF NOT EXISTS MachineMetadata (
+	Hostname text NOT NULL REFERENCES Machines (Hostname),
+	Name text NOT NULL,
+	Type text NOT NULL,
+	Value text NOT NULL,
+	PRIMARY KEY (Hostname, Name)
+);
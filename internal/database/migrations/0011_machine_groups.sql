@@ -0,0 +1,8 @@
+-- groups that have been created through the groups API. Machines still name
+-- their group in Machines.GroupName, and can be in one that isn't here
+CREATE TABLE IF NOT EXISTS MachineGroups (
+	Name text NOT NULL,
+	Description text NOT NULL DEFAULT '',
+	Position integer NOT NULL DEFAULT 0,
+	PRIMARY KEY (Name)
+);
@@ -0,0 +1,17 @@
+-- changes to which gpus are in which machines, and to their drivers. Events
+-- outlive the gpus they're about, so they don't reference them
+CREATE TABLE IF NOT EXISTS InventoryEvents (
+	Id bigserial NOT NULL,
+	Time timestamp NOT NULL,
+	Hostname text NOT NULL,
+	Gpu uuid NOT NULL,
+	Name text NOT NULL,
+	Kind text NOT NULL,
+	FromValue text NOT NULL DEFAULT '',
+	ToValue text NOT NULL DEFAULT '',
+	PRIMARY KEY (Id)
+);
+
+-- gpus that stopped sending samples while their machine didn't
+ALTER TABLE GPUs
+	ADD COLUMN IF NOT EXISTS Missing boolean NOT NULL DEFAULT false;
@@ -0,0 +1,15 @@
+-- alerts raised by the alerting rules, kept after they resolve as a history.
+-- Gpu is null for rules about whole machines
+CREATE TABLE IF NOT EXISTS Alerts (
+	Id bigserial NOT NULL,
+	Rule text NOT NULL,
+	Expression text NOT NULL,
+	Hostname text NOT NULL,
+	Gpu uuid,
+	State text NOT NULL,
+	Value real NOT NULL,
+	Since timestamp NOT NULL,
+	FiredAt timestamp,
+	ResolvedAt timestamp,
+	PRIMARY KEY (Id)
+);
@@ -0,0 +1,154 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrationsAreInOrder(t *testing.T) {
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.version)
+		assert.NotEmpty(t, m.name)
+		assert.NotEmpty(t, m.sql)
+	}
+}
+
+func openTestPostgres(t *testing.T) PostgresConn {
+	if testing.Short() {
+		t.Skip("not connecting to postgres in short tests")
+	}
+
+	// set default value that matches github workflow
+	url := os.Getenv("TEST_URL")
+	if url == "" {
+		url = "postgres://postgres@localhost/postgres"
+	}
+
+	db, err := openPostgres(url)
+	require.NoError(t, err)
+
+	// Drop fails if any of the tables are missing, so checks every
+	// migration was applied
+	conn := PostgresConn{db}
+	t.Cleanup(func() {
+		if err := conn.Drop(); err != nil {
+			t.Fatal("Failed to drop database", err)
+		}
+	})
+	return conn
+}
+
+// an empty database can be taken through every version in turn
+func TestPostgresMigrations(t *testing.T) {
+	conn := openTestPostgres(t)
+
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+
+	for i := range migrations {
+		applied, err := migrate(conn.db, migrations[:i+1])
+		require.NoError(t, err, "migrating to version %d", i+1)
+		assert.Equal(t, 1, applied)
+
+		versions, err := appliedMigrations(conn.db)
+		require.NoError(t, err)
+		assert.Len(t, versions, i+1)
+	}
+
+	// migrating an up to date database does nothing
+	applied, err := migrate(conn.db, migrations)
+	require.NoError(t, err)
+	assert.Zero(t, applied)
+}
+
+// databases from before migrations were tracked already have the initial
+// schema, and maybe some of the later changes
+func TestPostgresMigrationsFromUntracked(t *testing.T) {
+	conn := openTestPostgres(t)
+
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+
+	for _, m := range migrations[:2] {
+		_, err = conn.db.Exec(m.sql)
+		require.NoError(t, err)
+	}
+
+	applied, err := migrate(conn.db, migrations)
+	require.NoError(t, err)
+	assert.Equal(t, len(migrations), applied)
+}
+
+// files from before the file store have their contents in the database, and
+// are moved out once, however many times the control server starts
+func TestPostgresMoveLegacyFiles(t *testing.T) {
+	conn := openTestPostgres(t)
+
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+
+	// store the file the way it was before 0009_file_store
+	var beforeFileStore []migration
+	for _, m := range migrations {
+		if m.version < 9 {
+			beforeFileStore = append(beforeFileStore, m)
+		}
+	}
+	_, err = migrate(conn.db, beforeFileStore)
+	require.NoError(t, err)
+
+	contents := []byte("hello, world")
+	require.NoError(t, conn.UpdateLastSeen("gpu01", time.Now()))
+	_, err = conn.db.Exec(`INSERT INTO Files (Hostname, Filename, Mime, File)
+		VALUES ($1, $2, $3, $4)`,
+		"gpu01", "notes.txt", "text/plain", base64.StdEncoding.EncodeToString(contents))
+	require.NoError(t, err)
+
+	_, err = migrate(conn.db, migrations)
+	require.NoError(t, err)
+
+	checksum := sha256.Sum256(contents)
+	saved := 0
+	save := func(file broadcast.FileInfo, got []byte) (broadcast.FileInfo, error) {
+		saved++
+		assert.Equal(t, contents, got)
+		file.Key = "moved/" + file.Filename
+		file.Size = int64(len(got))
+		file.Checksum = hex.EncodeToString(checksum[:])
+		return file, nil
+	}
+
+	for run := range 2 {
+		moved, err := conn.MoveLegacyFiles(save)
+		require.NoError(t, err)
+		assert.Equal(t, 1-run, moved, "run %d", run+1)
+		assert.Equal(t, 1, saved, "run %d", run+1)
+
+		var file sql.NullString
+		var key string
+		var size int64
+		var sum string
+		err = conn.db.QueryRow(`SELECT File, StoreKey, Size, Checksum
+			FROM Files
+			WHERE Hostname=$1 AND Filename=$2`,
+			"gpu01", "notes.txt").Scan(&file, &key, &size, &sum)
+		require.NoError(t, err)
+		assert.False(t, file.Valid, "run %d", run+1)
+		assert.Equal(t, "moved/notes.txt", key)
+		assert.Equal(t, int64(len(contents)), size)
+		assert.Equal(t, hex.EncodeToString(checksum[:]), sum)
+	}
+}
@@ -2,6 +2,8 @@ package database
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -14,6 +16,7 @@ import (
 	"github.com/gpuctl/gpuctl/internal/broadcast"
 	"github.com/gpuctl/gpuctl/internal/uplink"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
@@ -24,101 +27,27 @@ type PostgresConn struct {
 	db *sql.DB
 }
 
+// Postgres connects to the database at databaseUrl, bringing its schema up
+// to date first
 func Postgres(databaseUrl string) (Database, error) {
-	db, err := sql.Open("pgx", databaseUrl)
+	db, err := openPostgres(databaseUrl)
 	if err != nil {
 		return nil, err
 	}
 
-	db.SetMaxOpenConns(maxOpenCons)
-
-	// sql.Open won't make a connection til use
-	// so try pinging database to verify connection
-	err = db.Ping()
+	migrations, err := loadMigrations()
 	if err != nil {
-		return nil, err
+		return nil, errors.Join(err, db.Close())
 	}
 
-	err = createTables(db)
+	_, err = migrate(db, migrations)
 	if err != nil {
-		return nil, err
+		return nil, errors.Join(err, db.Close())
 	}
 
 	return PostgresConn{db}, nil
 }
 
-func createTables(db *sql.DB) error {
-	// TODO: Find a way to generate this from gpustats.go?
-
-	// We have to make all rows non-null, because we can't scan a null value
-	// into a Go variable
-
-	var err error
-	_, err = db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS Machines (
-		Hostname text NOT NULL,
-		GroupName text NOT NULL DEFAULT '%s',
-		CPU text,
-		Motherboard text,
-		Notes text,
-		Owner text,
-		LastSeen timestamp,
-		PRIMARY KEY (Hostname)
-	);`, DefaultGroup))
-
-	if err != nil {
-		return err
-	}
-
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS GPUs (
-		Uuid uuid NOT NULL,
-		Machine text NOT NULL REFERENCES Machines (Hostname),
-		Name text NOT NULL,
-		Brand text NOT NULL,
-		DriverVersion text NOT NULL,
-		MemoryTotal integer NOT NULL,
-		PRIMARY KEY (Uuid)
-	);`)
-
-	if err != nil {
-		return err
-	}
-
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS Files (
-		Hostname text NOT NULL REFERENCES Machines (Hostname),
-		Filename text NOT NULL,
-		Mime text NOT NULL,
-		File text NOT NULL,
-		PRIMARY KEY (Hostname, Filename)
-	);`)
-
-	if err != nil {
-		return err
-	}
-
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS Stats (
-		Gpu uuid REFERENCES GPUs (Uuid) NOT NULL,
-		Received timestamp NOT NULL,
-		MemoryUtilisation real NOT NULL,
-		GpuUtilisation real NOT NULL,
-		MemoryUsed real NOT NULL,
-		FanSpeed real NOT NULL,
-		Temp real NOT NULL,
-		MemoryTemp real NOT NULL,
-		GraphicsVoltage real NOT NULL,
-		PowerDraw real NOT NULL,
-		GraphicsClock real NOT NULL,
-		MaxGraphicsClock real NOT NULL,
-		MemoryClock real NOT NULL,
-		MaxMemoryClock real NOT NULL,
-		InUse boolean NOT NULL,
-		UserName text NOT NULL DEFAULT '',
-		IsDownSampled boolean DEFAULT FALSE,
-		PRIMARY KEY (Gpu, Received)
-	);`)
-
-	return err
-}
-
 // implement interface
 func (conn PostgresConn) UpdateLastSeen(host string, now time.Time) error {
 	var err error
@@ -183,178 +112,333 @@ func updateLastSeen(host string, now time.Time, tx *sql.Tx) (err error) {
 }
 
 func (conn PostgresConn) AppendDataPoint(sample uplink.GPUStatSample) error {
+	return conn.AppendDataPoints([]uplink.GPUStatSample{sample})
+}
+
+// columns of the Stats table we fill in for each sample
+const statsColumns = 16
+
+// postgres allows at most 65535 parameters in a single statement
+const maxStatsRowsPerInsert = 65535 / statsColumns
+
+func (conn PostgresConn) AppendDataPoints(samples []uplink.GPUStatSample) error {
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
+	}
+
 	now := time.Now()
+	for start := 0; start < len(samples); start += maxStatsRowsPerInsert {
+		end := min(start+maxStatsRowsPerInsert, len(samples))
+		err = insertStats(samples[start:end], now, tx)
+		if isForeignKeyViolation(err) {
+			return errors.Join(ErrGpuNotPresent, tx.Rollback())
+		} else if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+	}
+
+	err = updateLatestProcesses(samples, now, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	err = recordJobs(samples, now, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	return tx.Commit()
+}
+
+// whether postgres refused something for referencing a row that isn't there,
+// like a sample for a gpu we don't know
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503" // foreign_key_violation
+}
+
+// Record the processes in the newest of the samples for each gpu, unless we
+// already have some from a newer one
+func updateLatestProcesses(samples []uplink.GPUStatSample, now time.Time, tx *sql.Tx) error {
+	newest := make(map[uuid.UUID]uplink.GPUStatSample)
+	for _, sample := range samples {
+		if sample.Time == 0 {
+			sample.Time = now.Unix()
+		}
+		if prev, ok := newest[sample.Uuid]; !ok || sample.Time >= prev.Time {
+			newest[sample.Uuid] = sample
+		}
+	}
+
+	for gpu, sample := range newest {
+		procs := sample.Unfiltered()
+		if procs == nil {
+			procs = uplink.Processes{}
+		}
+		procsJSON, err := json.Marshal(procs)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO LatestProcesses (Gpu, Received, Processes)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (Gpu) DO UPDATE
+			SET (Received, Processes) = (EXCLUDED.Received, EXCLUDED.Processes)
+			WHERE LatestProcesses.Received <= EXCLUDED.Received`,
+			gpu, time.Unix(sample.Time, 0), string(procsJSON))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	inUse, user := sample.RunningProcesses.Summarise()
-	_, err := conn.db.Exec(`INSERT INTO Stats
+// Extend the job each run of a process in the samples carries on, or start a
+// new one, with one statement per run rather than per sample. pids get
+// reused, so a run can only carry on the job its pid was running when the
+// run started, if it's the same program and hasn't stopped for longer than
+// jobGap, the same as jobContinued
+func recordJobs(samples []uplink.GPUStatSample, now time.Time, tx *sql.Tx) error {
+	for _, run := range jobRuns(samples, now) {
+		first, last := time.Unix(run.FirstSeen, 0), time.Unix(run.LastSeen, 0)
+
+		_, err := tx.Exec(`WITH Latest AS (
+				SELECT Gpu, Pid, Name, Owner, FirstSeen, LastSeen
+				FROM Jobs
+				WHERE Gpu=$1 AND Pid=$2
+				ORDER BY FirstSeen > $5, ABS(EXTRACT(EPOCH FROM FirstSeen - $5))
+				LIMIT 1
+			), Extended AS (
+				UPDATE Jobs j
+				SET FirstSeen=LEAST(j.FirstSeen, $5),
+					LastSeen=GREATEST(j.LastSeen, $6),
+					PeakMemory=GREATEST(j.PeakMemory, $7)
+				FROM Latest l
+				WHERE j.Gpu=l.Gpu AND j.Pid=l.Pid AND j.FirstSeen=l.FirstSeen
+					AND l.Name=$3 AND l.Owner=$4
+					AND l.LastSeen >= $8 AND l.FirstSeen <= $9
+				RETURNING j.Gpu
+			)
+			INSERT INTO Jobs (Gpu, Pid, Name, Owner, FirstSeen, LastSeen, PeakMemory)
+			SELECT $1, $2, $3, $4, $5, $6, $7
+			WHERE NOT EXISTS (SELECT 1 FROM Extended)
+			ON CONFLICT (Gpu, Pid, FirstSeen) DO NOTHING`,
+			run.Gpu, run.Pid, run.Name, run.Owner, first, last, run.PeakMemory,
+			first.Add(-jobGap), last.Add(jobGap))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insert several samples in one statement
+func insertStats(samples []uplink.GPUStatSample, now time.Time, tx *sql.Tx) error {
+	var query strings.Builder
+	query.WriteString(`INSERT INTO Stats
 		(Gpu, Received, MemoryUtilisation, GpuUtilisation, MemoryUsed,
 		FanSpeed, Temp, MemoryTemp, GraphicsVoltage, PowerDraw,
 		GraphicsClock, MaxGraphicsClock, MemoryClock, MaxMemoryClock,
-		InUse, UserName)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13,
-		$14, $15, $16)`,
-		sample.Uuid, now,
-		sample.MemoryUtilisation, sample.GPUUtilisation,
-		sample.MemoryUsed, sample.FanSpeed, sample.Temp,
-		sample.MemoryTemp, sample.GraphicsVoltage, sample.PowerDraw,
-		sample.GraphicsClock, sample.MaxGraphicsClock,
-		sample.MemoryClock, sample.MaxMemoryClock,
-		inUse, user)
+		InUse, Users)
+		VALUES `)
+
+	args := make([]any, 0, len(samples)*statsColumns)
+	for i, sample := range samples {
+		// use the time the satellite took the sample, if it told us
+		received := now
+		if sample.Time != 0 {
+			received = time.Unix(sample.Time, 0)
+		}
 
-	// TODO: hacky, untested. Might not work
-	if err != nil {
-		return ErrGpuNotPresent
+		inUse, users := sample.RunningProcesses.Summarise()
+		usersJSON, err := json.Marshal(users)
+		if err != nil {
+			return err
+		}
+
+		args = append(args, sample.Uuid, received,
+			sample.MemoryUtilisation, sample.GPUUtilisation,
+			sample.MemoryUsed, sample.FanSpeed, sample.Temp,
+			sample.MemoryTemp, sample.GraphicsVoltage, sample.PowerDraw,
+			sample.GraphicsClock, sample.MaxGraphicsClock,
+			sample.MemoryClock, sample.MaxMemoryClock,
+			inUse, string(usersJSON))
+
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("(")
+		for col := range statsColumns {
+			if col > 0 {
+				query.WriteString(", ")
+			}
+			fmt.Fprintf(&query, "$%d", i*statsColumns+col+1)
+		}
+		query.WriteString(")")
 	}
 
+	query.WriteString(" ON CONFLICT (Gpu, Received) DO NOTHING")
+
+	_, err := tx.Exec(query.String(), args...)
 	return err
 }
 
 func (conn PostgresConn) UpdateGPUContext(host string, packet uplink.GPUInfo) error {
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// what we had before, to see what's changed
+	var previous gpuInfo
+	err = tx.QueryRow(`SELECT Machine, DriverVersion
+		FROM GPUs
+		WHERE Uuid=$1
+		FOR UPDATE`,
+		packet.Uuid).Scan(&previous.host, &previous.context.DriverVersion)
+	known := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return errors.Join(err, tx.Rollback())
+	}
+
 	// Insert the new context we've received into the db, overwriting the
 	// existing info
-	_, err := conn.db.Exec(`INSERT INTO GPUs
-		(Uuid, Machine, Name, Brand, DriverVersion, MemoryTotal)
-		VALUES ($1, $2, $3, $4, $5, $6)
+	_, err = tx.Exec(`INSERT INTO GPUs
+		(Uuid, Machine, Name, Brand, DriverVersion, MemoryTotal, Backend)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		ON CONFLICT (Uuid) DO UPDATE
-		SET (Uuid, Machine, Name, Brand, DriverVersion, MemoryTotal)
+		SET (Uuid, Machine, Name, Brand, DriverVersion, MemoryTotal, Backend)
 		= (EXCLUDED.Uuid, EXCLUDED.Machine, EXCLUDED.Name,
-		EXCLUDED.Brand, EXCLUDED.DriverVersion, EXCLUDED.MemoryTotal)`,
+		EXCLUDED.Brand, EXCLUDED.DriverVersion, EXCLUDED.MemoryTotal,
+		EXCLUDED.Backend)`,
 		packet.Uuid, host, packet.Name, packet.Brand,
-		packet.DriverVersion, packet.MemoryTotal)
+		packet.DriverVersion, packet.MemoryTotal, packet.Backend)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
 
-	return err
+	err = recordInventoryEvents(inventoryChanges(previous, known, host, packet, time.Now()), tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	return tx.Commit()
 }
 
-func (conn PostgresConn) Downsample(int_now time.Time) error {
-	downsample_query := `CREATE TEMPORARY TABLE TempDownsampled AS
-WITH OrderedStats AS (
-  SELECT
-    Gpu,
-    Received,
-    MemoryUtilisation,
-    GpuUtilisation,
-    MemoryUsed,
-    FanSpeed,
-    Temp,
-    MemoryTemp,
-    GraphicsVoltage,
-    PowerDraw,
-    GraphicsClock,
-    MaxGraphicsClock,
-    MemoryClock,
-    MaxMemoryClock,
-    InUse,
-    UserName,
-    CASE 
-      WHEN COUNT(*) OVER (PARTITION BY Gpu) < 100 THEN 0 
-      ELSE ROW_NUMBER() OVER (PARTITION BY Gpu ORDER BY Received ASC) - 1 
-    END AS RowNum
-  FROM Stats
-  WHERE Received > $1 AND IsDownsampled = FALSE
-),
-GroupedStats AS (
-  SELECT
-    Gpu,
-    AVG(MemoryUtilisation) AS AvgMemoryUtilisation,
-    AVG(GpuUtilisation) AS AvgGpuUtilisation,
-    AVG(MemoryUsed) AS AvgMemoryUsed,
-    AVG(FanSpeed) AS AvgFanSpeed,
-    AVG(Temp) AS AvgTemp,
-    AVG(MemoryTemp) AS AvgMemoryTemp,
-    AVG(GraphicsVoltage) AS AvgGraphicsVoltage,
-    AVG(PowerDraw) AS AvgPowerDraw,
-    AVG(GraphicsClock) AS AvgGraphicsClock,
-    AVG(MaxGraphicsClock) AS AvgMaxGraphicsClock,
-    AVG(MemoryClock) AS AvgMemoryClock,
-    AVG(MaxMemoryClock) AS AvgMaxMemoryClock,
-    MIN(Received) AS SampleStartTime,
-    MAX(Received) AS SampleEndTime,
-    (RowNum / 100) AS GroupId,
-    bool_or(InUse) AS OrInUse,
-    mode() WITHIN GROUP (ORDER BY UserName) as ModeUserName
-  FROM OrderedStats
-  GROUP BY Gpu, GroupId
-)
-SELECT * FROM GroupedStats;`
-
-	insert_query := `INSERT INTO Stats (Gpu, Received, MemoryUtilisation, GpuUtilisation, MemoryUsed, FanSpeed, Temp, MemoryTemp, GraphicsVoltage, PowerDraw, GraphicsClock, MaxGraphicsClock, MemoryClock, MaxMemoryClock, InUse, UserName, IsDownsampled)
-	SELECT
-		Gpu,
-		SampleStartTime,
-		AvgMemoryUtilisation,
-		AvgGpuUtilisation,
-		AvgMemoryUsed,
-		AvgFanSpeed,
-		AvgTemp,
-		AvgMemoryTemp,
-		AvgGraphicsVoltage,
-		AvgPowerDraw,
-		AvgGraphicsClock,
-		AvgMaxGraphicsClock,
-		AvgMemoryClock,
-		AvgMaxMemoryClock,
-		OrInUse,
-		ModeUserName,
-		TRUE
-	FROM TempDownsampled
-	ON CONFLICT (Gpu, Received) DO UPDATE
-	SET MemoryUtilisation = EXCLUDED.MemoryUtilisation,
-			GpuUtilisation = EXCLUDED.GpuUtilisation,
-			MemoryUsed = EXCLUDED.MemoryUsed,
-			FanSpeed = EXCLUDED.FanSpeed,
-			Temp = EXCLUDED.Temp,
-			MemoryTemp = EXCLUDED.MemoryTemp,
-			GraphicsVoltage = EXCLUDED.GraphicsVoltage,
-			PowerDraw = EXCLUDED.PowerDraw,
-			GraphicsClock = EXCLUDED.GraphicsClock,
-			MaxGraphicsClock = EXCLUDED.MaxGraphicsClock,
-			MemoryClock = EXCLUDED.MemoryClock,
-			MaxMemoryClock = EXCLUDED.MaxMemoryClock,
-			InUse = EXCLUDED.InUse,
-			UserName = EXCLUDED.UserName,
-			IsDownsampled = EXCLUDED.IsDownsampled;`
-
-	delete_query := `DELETE FROM Stats WHERE Received <= $1 AND IsDownsampled = FALSE;`
-	cleanup_query := `DROP TABLE TempDownsampled;`
-
-	now := int_now
-	downsampleThresh := now.Add(-time.Hour)
-	downsampleThreshFormatted := downsampleThresh.Format("2006-01-02 15:04:05")
+func (conn PostgresConn) GPUMachines(uuids []uuid.UUID) (map[uuid.UUID]string, error) {
+	machines := make(map[uuid.UUID]string)
+	for _, gpu := range uuids {
+		var machine string
+		err := conn.db.QueryRow(`SELECT Machine FROM GPUs WHERE Uuid=$1`, gpu).Scan(&machine)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		machines[gpu] = machine
+	}
+
+	return machines, nil
+}
+
+func recordInventoryEvents(events []broadcast.InventoryEvent, tx *sql.Tx) error {
+	for _, event := range events {
+		_, err := tx.Exec(`INSERT INTO InventoryEvents
+			(Time, Hostname, Gpu, Name, Kind, FromValue, ToValue)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			time.Unix(event.Time, 0), event.Hostname, event.Gpu, event.Name,
+			event.Kind, event.From, event.To)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Buckets are averaged the same way as when bucketing history, and only
+// those that haven't been rolled up already are touched
+func (conn PostgresConn) Downsample(now time.Time, policy RetentionPolicy) error {
+	windows, cutoff, deletes := policy.plan(now)
 
 	tx, err := conn.db.Begin()
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.Exec(downsample_query, downsampleThreshFormatted)
-	if err != nil {
-		return errors.Join(err, tx.Rollback())
+	if deletes {
+		_, err = tx.Exec(`DELETE FROM Stats WHERE Received < $1`, cutoff)
+		if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
 	}
 
-	_, err = tx.Exec(insert_query)
-	if err != nil {
-		return errors.Join(err, tx.Rollback())
+	for _, window := range windows {
+		err = rollUp(window, tx)
+		if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
 	}
 
-	_, err = tx.Exec(delete_query, downsampleThreshFormatted)
+	return tx.Commit()
+}
+
+// Roll a window up within postgres, so however many samples it has, none
+// are read out: the buckets are aggregated into a temporary table, their
+// samples deleted, and the buckets put back in their place
+func rollUp(window rollupWindow, tx *sql.Tx) error {
+	args := []any{window.to, int64(window.step / time.Second)}
+	inWindow := "s.Received < $1"
+	if !window.from.IsZero() {
+		args = append(args, window.from)
+		inWindow += " AND s.Received >= $3"
+	}
+	bucket := "(FLOOR(EXTRACT(EPOCH FROM s.Received) / $2) * $2)::bigint"
 
+	// in the order historicalBucketsQuery gives them
+	columns := []string{}
+	for _, f := range historicalFields {
+		columns = append(columns, f.column, "Lowest"+f.column, "Highest"+f.column)
+	}
+	columns = append(columns, "InUse", "AlwaysInUse", "Samples", "Users")
+	stats := strings.Join(columns, ", ")
+
+	_, err := tx.Exec(`CREATE TEMPORARY TABLE Rollups (LIKE Stats INCLUDING DEFAULTS) ON COMMIT DROP`)
 	if err != nil {
-		return errors.Join(err, tx.Rollback())
+		return err
 	}
 
-	_, err = tx.Exec(cleanup_query)
+	_, err = tx.Exec(fmt.Sprintf(`INSERT INTO Rollups (Gpu, Received, %[1]s)
+		SELECT Gpu, to_timestamp(Bucket) AT TIME ZONE 'UTC', %[1]s
+		FROM (%[2]s) AS b(Gpu, Bucket, %[1]s)`, stats,
+		historicalBucketsQuery(fmt.Sprintf(`%[1]s
+			AND (s.Gpu, %[2]s) IN (
+				SELECT s.Gpu, %[2]s
+				FROM Stats s
+				WHERE %[1]s
+				GROUP BY 1, 2
+				HAVING COUNT(*) > 1
+					OR MIN(FLOOR(EXTRACT(EPOCH FROM s.Received)))::bigint <> MIN(%[2]s)
+			)`, inWindow, bucket), 2)), args...)
 	if err != nil {
-		return errors.Join(err, tx.Rollback())
+		return err
 	}
 
-	err = tx.Commit()
-	return err
-}
+	_, err = tx.Exec(fmt.Sprintf(`DELETE FROM Stats s
+		WHERE %s AND (s.Gpu, %s) IN (
+			SELECT Gpu, EXTRACT(EPOCH FROM Received)::bigint FROM Rollups
+		)`, inWindow, bucket), args...)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(`INSERT INTO Stats (Gpu, Received, %[1]s)
+		SELECT Gpu, Received, %[1]s FROM Rollups`, stats))
+	if err != nil {
+		return err
+	}
 
-func (conn PostgresConn) DownsampleOld(cut time.Time) error {
-	// TODO: decide what to do with the old downsampling code (i.e. fix bugs)
-	_, err := conn.db.Exec(`DELETE FROM Stats
-				WHERE Received < $1`, cut)
+	_, err = tx.Exec(`DROP TABLE Rollups`)
 	return err
 }
 
@@ -403,6 +487,16 @@ func (conn PostgresConn) LatestData() (broadcast.Workstations, error) {
 		return nil, errors.Join(err, tx.Rollback())
 	}
 
+	tags, metadata, err := machineLabels(tx)
+	if err != nil {
+		return nil, errors.Join(err, tx.Rollback())
+	}
+
+	created, err := createdGroups(tx)
+	if err != nil {
+		return nil, errors.Join(err, tx.Rollback())
+	}
+
 	// attach gpus to all machines
 	// can't be done in the previous loop because we can't be iterating
 	// through two queries at once
@@ -412,20 +506,12 @@ func (conn PostgresConn) LatestData() (broadcast.Workstations, error) {
 			if err != nil {
 				return nil, errors.Join(err, tx.Rollback())
 			}
+			machine.Tags, machine.Metadata = labelsFor(machine.Name, tags, metadata)
 			groups[group][i] = machine
 		}
 	}
 
-	// flatten map
-	var result broadcast.Workstations
-	for groupName, machines := range groups {
-		result = append(result, broadcast.Group{
-			Name:         groupName,
-			Workstations: machines,
-		})
-	}
-
-	return result, tx.Commit()
+	return arrangeGroups(created, groups), tx.Commit()
 }
 
 // get the latest stat for all the gpus on a machine
@@ -433,12 +519,12 @@ func getGpus(host string, tx *sql.Tx) ([]broadcast.GPU, error) {
 	result := make([]broadcast.GPU, 0)
 
 	gpus, err := tx.Query(`SELECT g.Uuid, g.Name, g.Brand,
-		g.DriverVersion, g.MemoryTotal,
+		g.DriverVersion, g.MemoryTotal, g.Backend,
 		s.MemoryUtilisation, s.GpuUtilisation,
 		s.MemoryUsed, s.FanSpeed, s.Temp, s.MemoryTemp,
 		s.GraphicsVoltage, s.PowerDraw, s.GraphicsClock,
 		s.MaxGraphicsClock, s.MemoryClock,
-		s.MaxMemoryClock, s.InUse, s.UserName
+		s.MaxMemoryClock, s.InUse, s.Users, g.Missing
 		FROM GPUs g INNER JOIN Stats s ON g.Uuid = s.Gpu
 		INNER JOIN (
 			SELECT Gpu, Max(Received) Received
@@ -455,15 +541,21 @@ func getGpus(host string, tx *sql.Tx) ([]broadcast.GPU, error) {
 
 	for gpus.Next() {
 		var gpu broadcast.GPU
+		var users []byte
 		err = gpus.Scan(&gpu.Uuid, &gpu.Name, &gpu.Brand,
-			&gpu.DriverVersion, &gpu.MemoryTotal,
+			&gpu.DriverVersion, &gpu.MemoryTotal, &gpu.Backend,
 			&gpu.MemoryUtilisation,
 			&gpu.GPUUtilisation, &gpu.MemoryUsed,
 			&gpu.FanSpeed, &gpu.Temp,
 			&gpu.MemoryTemp, &gpu.GraphicsVoltage,
 			&gpu.PowerDraw, &gpu.GraphicsClock,
 			&gpu.MaxGraphicsClock, &gpu.MemoryClock,
-			&gpu.MaxMemoryClock, &gpu.InUse, &gpu.User)
+			&gpu.MaxMemoryClock, &gpu.InUse, &users, &gpu.Missing)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(users, &gpu.Users)
 		if err != nil {
 			return nil, err
 		}
@@ -511,6 +603,26 @@ func (conn PostgresConn) RemoveMachine(machine broadcast.RemoveMachine) error {
 		return errors.Join(err, tx.Rollback())
 	}
 
+	_, err = tx.Exec(`DELETE FROM Jobs
+		WHERE Gpu=ANY(SELECT Uuid
+			FROM Gpus
+			WHERE Machine=$1)`,
+		machine.Hostname,
+	)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	_, err = tx.Exec(`DELETE FROM LatestProcesses
+		WHERE Gpu=ANY(SELECT Uuid
+			FROM Gpus
+			WHERE Machine=$1)`,
+		machine.Hostname,
+	)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
 	_, err = tx.Exec(`DELETE FROM GPUs
 		WHERE Machine=$1`,
 		machine.Hostname,
@@ -526,6 +638,41 @@ func (conn PostgresConn) RemoveMachine(machine broadcast.RemoveMachine) error {
 		return errors.Join(err, tx.Rollback())
 	}
 
+	_, err = tx.Exec(`DELETE FROM MachineSettings
+		WHERE Hostname=$1`,
+		machine.Hostname)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	_, err = tx.Exec(`DELETE FROM MachineTags
+		WHERE Hostname=$1`,
+		machine.Hostname)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	_, err = tx.Exec(`DELETE FROM MachineMetadata
+		WHERE Hostname=$1`,
+		machine.Hostname)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	_, err = tx.Exec(`DELETE FROM InventoryEvents
+		WHERE Hostname=$1`,
+		machine.Hostname)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	_, err = tx.Exec(`DELETE FROM Alerts
+		WHERE Hostname=$1`,
+		machine.Hostname)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
 	_, err = tx.Exec(`DELETE FROM Machines
 		WHERE Hostname=$1`,
 		machine.Hostname,
@@ -632,10 +779,21 @@ func (conn PostgresConn) UpdateMachine(machine broadcast.ModifyMachine) error {
 //
 // This should only be used for testing purposes
 func (conn PostgresConn) Drop() error {
+	// tables referencing gpus or machines have to go before them
 	_, err := conn.db.Exec(`DROP TABLE stats;
+		DROP TABLE jobs;
+		DROP TABLE latestprocesses;
+		DROP TABLE inventoryevents;
+		DROP TABLE alerts;
 		DROP TABLE gpus;
 		DROP TABLE files;
-		DROP TABLE machines`)
+		DROP TABLE groupsettings;
+		DROP TABLE machinesettings;
+		DROP TABLE machinetags;
+		DROP TABLE machinemetadata;
+		DROP TABLE machinegroups;
+		DROP TABLE machines;
+		DROP TABLE schema_version`)
 	if err != nil {
 		return err
 	}
@@ -643,173 +801,539 @@ func (conn PostgresConn) Drop() error {
 	return conn.db.Close()
 }
 
-func (conn PostgresConn) LastSeen() ([]broadcast.WorkstationSeen, error) {
-	rows, err := conn.db.Query(`SELECT Hostname, LastSeen FROM Machines`)
+func (conn PostgresConn) Groups() ([]broadcast.GroupInfo, error) {
+	return sqlGroups(conn.db)
+}
 
+// whether the group has been created or has machines in it
+func groupExists(name string, tx *sql.Tx) (bool, error) {
+	var exists bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM MachineGroups WHERE Name=$1)
+		OR EXISTS (SELECT 1 FROM Machines WHERE `+sqlGroupOf+`=$1)`,
+		name).Scan(&exists)
+	return exists, err
+}
+
+func (conn PostgresConn) CreateGroup(group broadcast.GroupInfo) error {
+	name, err := normaliseGroupName(group.Name)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	var seens []broadcast.WorkstationSeen
-
-	for rows.Next() {
-		var seen_instance broadcast.WorkstationSeen
-
-		err := rows.Scan(&seen_instance.Hostname, &seen_instance.LastSeen)
-		if err != nil {
-			return nil, err
-		}
-
-		slog.Debug("Fetched last seen instance from Machine table", "Hostname", seen_instance.Hostname, "LastSeen", seen_instance.LastSeen)
-		seens = append(seens, seen_instance)
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
 	}
 
-	return seens, nil
-}
-
-func (conn PostgresConn) AttachFile(attach broadcast.AttachFile) error {
-	// Insert into db
-	_, err := conn.db.Exec(`INSERT INTO Files (Hostname, Mime, Filename, File)
-		VALUES ($1, $2, $3, $4)
-		ON CONFLICT (Hostname, Filename) DO UPDATE
-		SET (Mime, File) = (EXCLUDED.Mime, Excluded.File);`,
-		attach.Hostname, attach.Mime, attach.Filename, attach.EncodedFile,
-	)
+	exists, err := groupExists(name, tx)
 	if err != nil {
-		return ErrNoSuchMachine
+		return errors.Join(err, tx.Rollback())
+	} else if exists {
+		return errors.Join(fmt.Errorf("%s: %w", name, ErrGroupExists), tx.Rollback())
 	}
-	return nil
-}
 
-func (conn PostgresConn) GetFile(hostname string, filename string) (broadcast.AttachFile, error) {
-	file := broadcast.AttachFile{Hostname: hostname, Filename: filename}
-
-	row := conn.db.QueryRow(`SELECT Mime, File
-		FROM Files
-		WHERE Hostname=$1 AND Filename=$2`,
-		hostname, filename)
-	err := row.Scan(&file.Mime, &file.EncodedFile)
-	if errors.Is(err, sql.ErrNoRows) {
-		return file, ErrFileNotPresent
+	_, err = tx.Exec(`INSERT INTO MachineGroups (Name, Description, Position)
+		VALUES ($1, $2, $3)`,
+		name, group.Description, group.Order)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
 	}
 
-	return file, err
+	return tx.Commit()
 }
 
-func (conn PostgresConn) ListFiles(hostname string) ([]string, error) {
-	rows, err := conn.db.Query(`SELECT Filename
-		FROM Files
-		WHERE Hostname=$1`,
-		hostname)
+func (conn PostgresConn) UpdateGroup(changes broadcast.ModifyGroup) error {
+	tx, err := conn.db.Begin()
 	if err != nil {
-		return []string{}, err
+		return err
 	}
 
-	res := []string{}
-	for rows.Next() {
-		val := ""
-		err = rows.Scan(&val)
-		if err != nil {
-			return res, err
-		}
-		res = append(res, val)
+	exists, err := groupExists(changes.Name, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	} else if !exists {
+		return errors.Join(fmt.Errorf("%s: %w", changes.Name, ErrNoSuchGroup), tx.Rollback())
 	}
-	return res, nil
-}
 
-func (conn PostgresConn) RemoveFile(remove broadcast.RemoveFile) error {
-	rows, err := conn.db.Query(`DELETE FROM Files
-		WHERE Hostname=$1 AND Filename=$2
-		RETURNING Filename;`,
-		remove.Hostname, remove.Filename)
+	// groups that only have machines get created, to keep the changes
+	_, err = tx.Exec(`INSERT INTO MachineGroups (Name, Description, Position)
+		VALUES ($1, COALESCE($2, ''), COALESCE($3, 0))
+		ON CONFLICT (Name) DO UPDATE
+		SET Description = COALESCE($2, MachineGroups.Description),
+			Position = COALESCE($3, MachineGroups.Position)`,
+		changes.Name, changes.Description, changes.Order)
 	if err != nil {
-		return err
+		return errors.Join(err, tx.Rollback())
 	}
 
-	found := false
-	for rows.Next() {
-		found = true
-		rows.Scan()
+	if changes.NewName == nil || *changes.NewName == changes.Name {
+		return tx.Commit()
+	}
+
+	newName, err := normaliseGroupName(*changes.NewName)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	exists, err = groupExists(newName, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	} else if exists {
+		return errors.Join(fmt.Errorf("%s: %w", newName, ErrGroupExists), tx.Rollback())
+	}
+
+	renames := []string{
+		`UPDATE MachineGroups SET Name=$2 WHERE Name=$1`,
+		`UPDATE Machines SET GroupName=$2 WHERE ` + sqlGroupOf + `=$1`,
+		`UPDATE GroupSettings SET GroupName=$2 WHERE GroupName=$1`,
+	}
+	for _, query := range renames {
+		_, err = tx.Exec(query, changes.Name, newName)
+		if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (conn PostgresConn) RemoveGroup(remove broadcast.RemoveGroup) error {
+	name, target, err := removedGroupTarget(remove)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	exists, err := groupExists(name, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	} else if !exists {
+		return errors.Join(fmt.Errorf("%s: %w", name, ErrNoSuchGroup), tx.Rollback())
+	}
+
+	_, err = tx.Exec(`UPDATE Machines SET GroupName=$2 WHERE `+sqlGroupOf+`=$1`, name, target)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	for _, query := range []string{
+		`DELETE FROM GroupSettings WHERE GroupName=$1`,
+		`DELETE FROM MachineGroups WHERE Name=$1`,
+	} {
+		_, err = tx.Exec(query, name)
+		if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (conn PostgresConn) MoveMachines(move broadcast.MoveMachines) error {
+	group, err := normaliseGroupName(move.Group)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, hostname := range move.Hostnames {
+		result, err := tx.Exec(`UPDATE Machines
+			SET GroupName=$2
+			WHERE Hostname=$1`,
+			hostname, group)
+		if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+
+		moved, err := result.RowsAffected()
+		if err != nil {
+			return errors.Join(err, tx.Rollback())
+		} else if moved == 0 {
+			return errors.Join(fmt.Errorf("%s: %w", hostname, ErrNoSuchMachine), tx.Rollback())
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (conn PostgresConn) AddTag(tag broadcast.MachineTag) error {
+	name, err := normaliseTag(tag.Tag)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = getLastSeen(tag.Hostname, tx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.Join(fmt.Errorf("%s: %w", tag.Hostname, ErrNoSuchMachine), tx.Rollback())
+	} else if err != nil {
+		return errors.Join(err, tx.Rollback())
 	}
 
-	if !found {
-		return ErrFileNotPresent
+	_, err = tx.Exec(`INSERT INTO MachineTags (Hostname, Tag)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`,
+		tag.Hostname, name)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
 	}
+
+	return tx.Commit()
+}
+
+func (conn PostgresConn) RemoveTag(tag broadcast.MachineTag) error {
+	_, err := conn.db.Exec(`DELETE FROM MachineTags
+		WHERE Hostname=$1 AND Tag=$2`,
+		tag.Hostname, strings.TrimSpace(tag.Tag))
 	return err
 }
 
-func (conn PostgresConn) HistoricalData(hostname string) (broadcast.HistoricalData, error) {
-	samples, err := conn.db.Query(`SELECT
-		s.Gpu,
-		s.Received,
-		s.MemoryUtilisation,
-		s.GpuUtilisation,
-		s.MemoryUsed,
-		s.FanSpeed,
-		s.Temp,
-		s.MemoryTemp,
-		s.GraphicsVoltage,
-		s.PowerDraw,
-		s.GraphicsClock,
-		s.MaxGraphicsClock,
-		s.MemoryClock,
-		s.MaxMemoryClock,
-		s.InUse,
-		s.UserName
-		FROM Stats s
-		INNER JOIN GPUs g ON g.Uuid = s.Gpu
-		WHERE g.Machine=$1
-		ORDER BY s.Gpu, s.Received`,
-		hostname,
-	)
+func (conn PostgresConn) SetMetadata(meta broadcast.SetMetadata) error {
+	metadata, err := normaliseMetadata(meta.Metadata)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = getLastSeen(meta.Hostname, tx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.Join(fmt.Errorf("%s: %w", meta.Hostname, ErrNoSuchMachine), tx.Rollback())
+	} else if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	_, err = tx.Exec(`INSERT INTO MachineMetadata (Hostname, Name, Type, Value)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (Hostname, Name) DO UPDATE
+		SET Type = EXCLUDED.Type, Value = EXCLUDED.Value`,
+		meta.Hostname, metadata.Key, metadata.Type, metadata.Value)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	return tx.Commit()
+}
+
+func (conn PostgresConn) RemoveMetadata(remove broadcast.RemoveMetadata) error {
+	_, err := conn.db.Exec(`DELETE FROM MachineMetadata
+		WHERE Hostname=$1 AND Name=$2`,
+		remove.Hostname, strings.TrimSpace(remove.Key))
+	return err
+}
+
+func (conn PostgresConn) LastSeen() ([]broadcast.WorkstationSeen, error) {
+	rows, err := conn.db.Query(`SELECT Hostname, LastSeen FROM Machines`)
+
 	if err != nil {
 		return nil, err
 	}
 
-	// XXX: I hate, HATE what is below this comment, but it has to be done because we
-	//  	need to pass each gpu in its own list to the front-end
-	// NOTE: what we do here is that we accumulate over the stats and package all samples by their insert time
+	var seens []broadcast.WorkstationSeen
+
+	for rows.Next() {
+		var seen_instance broadcast.WorkstationSeen
+
+		err := rows.Scan(&seen_instance.Hostname, &seen_instance.LastSeen)
+		if err != nil {
+			return nil, err
+		}
+
+		slog.Debug("Fetched last seen instance from Machine table", "Hostname", seen_instance.Hostname, "LastSeen", seen_instance.LastSeen)
+		seens = append(seens, seen_instance)
+	}
+
+	return seens, nil
+}
+
+func (conn PostgresConn) AttachFile(file broadcast.FileInfo) (string, error) {
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return "", err
+	}
+
+	var replaced sql.NullString
+	err = tx.QueryRow(`SELECT StoreKey FROM Files
+		WHERE Hostname=$1 AND Filename=$2
+		FOR UPDATE`,
+		file.Hostname, file.Filename).Scan(&replaced)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", errors.Join(err, tx.Rollback())
+	}
 
+	_, err = tx.Exec(`INSERT INTO Files (Hostname, Filename, Mime, StoreKey,
+		Size, Checksum, Uploader, UploadedAt)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (Hostname, Filename) DO UPDATE
+		SET (Mime, File, StoreKey, Size, Checksum, Uploader, UploadedAt)
+		= (EXCLUDED.Mime, NULL, EXCLUDED.StoreKey, EXCLUDED.Size,
+		EXCLUDED.Checksum, EXCLUDED.Uploader, EXCLUDED.UploadedAt);`,
+		file.Hostname, file.Filename, file.Mime, file.Key,
+		file.Size, file.Checksum, file.Uploader, file.UploadedAt.UTC(),
+	)
+	if err != nil {
+		return "", errors.Join(ErrNoSuchMachine, tx.Rollback())
+	}
+
+	return replaced.String, tx.Commit()
+}
+
+const fileColumns = `Hostname, Filename, Mime, COALESCE(StoreKey, ''), Size,
+	Checksum, Uploader, UploadedAt`
+
+func scanFile(row interface{ Scan(...any) error }) (broadcast.FileInfo, error) {
+	var file broadcast.FileInfo
+	err := row.Scan(&file.Hostname, &file.Filename, &file.Mime, &file.Key,
+		&file.Size, &file.Checksum, &file.Uploader, &file.UploadedAt)
+	file.UploadedAt = file.UploadedAt.UTC()
+	return file, err
+}
+
+func (conn PostgresConn) GetFile(hostname string, filename string) (broadcast.FileInfo, error) {
+	file, err := scanFile(conn.db.QueryRow(`SELECT `+fileColumns+`
+		FROM Files
+		WHERE Hostname=$1 AND Filename=$2`,
+		hostname, filename))
+	if errors.Is(err, sql.ErrNoRows) {
+		return file, ErrFileNotPresent
+	}
+
+	return file, err
+}
+
+func (conn PostgresConn) ListFiles(hostname string) ([]broadcast.FileInfo, error) {
+	rows, err := conn.db.Query(`SELECT `+fileColumns+`
+		FROM Files
+		WHERE Hostname=$1
+		ORDER BY Filename`,
+		hostname)
+	if err != nil {
+		return []broadcast.FileInfo{}, err
+	}
+	defer rows.Close()
+
+	res := []broadcast.FileInfo{}
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, file)
+	}
+	return res, rows.Err()
+}
+
+func (conn PostgresConn) RemoveFile(remove broadcast.RemoveFile) (string, error) {
+	var removed sql.NullString
+	err := conn.db.QueryRow(`DELETE FROM Files
+		WHERE Hostname=$1 AND Filename=$2
+		RETURNING StoreKey;`,
+		remove.Hostname, remove.Filename).Scan(&removed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrFileNotPresent
+	}
+	return removed.String, err
+}
+
+// Files attached before there was a file store have their base64 contents in
+// the File column, and no StoreKey
+func (conn PostgresConn) MoveLegacyFiles(save func(file broadcast.FileInfo, contents []byte) (broadcast.FileInfo, error)) (int, error) {
+	rows, err := conn.db.Query(`SELECT Hostname, Filename, Mime, File
+		FROM Files
+		WHERE StoreKey IS NULL AND File IS NOT NULL`)
+	if err != nil {
+		return 0, err
+	}
+
+	type legacy struct {
+		file    broadcast.FileInfo
+		encoded string
+	}
+	var files []legacy
+	for rows.Next() {
+		var l legacy
+		err = rows.Scan(&l.file.Hostname, &l.file.Filename, &l.file.Mime, &l.encoded)
+		if err != nil {
+			return 0, errors.Join(err, rows.Close())
+		}
+		files = append(files, l)
+	}
+	err = errors.Join(rows.Err(), rows.Close())
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, l := range files {
+		contents, err := base64.StdEncoding.DecodeString(l.encoded)
+		if err != nil {
+			return moved, fmt.Errorf("%s/%s: %w", l.file.Hostname, l.file.Filename, err)
+		}
+
+		file, err := save(l.file, contents)
+		if err != nil {
+			return moved, err
+		}
+
+		_, err = conn.db.Exec(`UPDATE Files
+			SET (File, StoreKey, Size, Checksum) = (NULL, $3, $4, $5)
+			WHERE Hostname=$1 AND Filename=$2 AND StoreKey IS NULL`,
+			file.Hostname, file.Filename, file.Key, file.Size, file.Checksum)
+		if err != nil {
+			return moved, err
+		}
+		moved++
+	}
+
+	return moved, nil
+}
+
+func (conn PostgresConn) HistoricalData(hostname string, query HistoryQuery) (broadcast.HistoricalData, error) {
+	if !query.Valid() {
+		return nil, ErrInvalidStep
+	}
+
+	args := []any{hostname}
+	conditions := []string{"g.Machine=$1"}
+	if !query.From.IsZero() {
+		args = append(args, query.From)
+		conditions = append(conditions, fmt.Sprintf("s.Received >= $%d", len(args)))
+	}
+	if !query.To.IsZero() {
+		args = append(args, query.To)
+		conditions = append(conditions, fmt.Sprintf("s.Received <= $%d", len(args)))
+	}
+	if query.Gpu != uuid.Nil {
+		args = append(args, query.Gpu)
+		conditions = append(conditions, fmt.Sprintf("s.Gpu = $%d", len(args)))
+	}
+	where := strings.Join(conditions, " AND ")
+
+	if query.Step == 0 {
+		rows, err := conn.db.Query(historicalSamplesQuery(where), args...)
+		if err != nil {
+			return nil, err
+		}
+
+		samples, err := scanHistory(rows)
+		if err != nil {
+			return nil, err
+		}
+		return append(broadcast.HistoricalData{}, samples...), nil
+	}
+
+	args = append(args, int64(query.Step/time.Second))
+	rows, err := conn.db.Query(historicalBucketsQuery(where, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// each gpu's buckets go in their own list for the frontend, and they come
+	// out ordered by gpu
 	data := broadcast.HistoricalData{}
-	bucket := []broadcast.HistoricalDataPoint{}
-	curruuid, _ := uuid.NewRandom()
-
-	for samples.Next() {
-		var sample broadcast.GPU
-		var timestamp time.Time
-
-		err = samples.Scan(
-			&sample.Uuid,
-			&timestamp,
-			&sample.MemoryUtilisation,
-			&sample.GPUUtilisation,
-			&sample.MemoryUsed,
-			&sample.FanSpeed,
-			&sample.Temp,
-			&sample.MemoryTemp,
-			&sample.GraphicsVoltage,
-			&sample.PowerDraw,
-			&sample.GraphicsClock,
-			&sample.MaxGraphicsClock,
-			&sample.MemoryClock,
-			&sample.MaxMemoryClock,
-			&sample.InUse,
-			&sample.User,
-		)
+	var points []broadcast.HistoricalDataPoint
+	for rows.Next() {
+		var point broadcast.HistoricalDataPoint
+		var users []byte
+
+		point.Min = &broadcast.GPU{Users: []uplink.GPUUser{}}
+		point.Max = &broadcast.GPU{Users: []uplink.GPUUser{}}
+		targets := []any{&point.Sample.Uuid, &point.Timestamp}
+		for _, f := range historicalFields {
+			targets = append(targets, f.field(&point.Sample), f.field(point.Min), f.field(point.Max))
+		}
+		targets = append(targets, &point.Max.InUse, &point.Min.InUse, &point.Samples, &users)
+
+		err = rows.Scan(targets...)
 		if err != nil {
 			return nil, err
 		}
-		if curruuid != sample.Uuid {
-			curruuid = sample.Uuid
-			data = append(data, bucket)
-			bucket = make([]broadcast.HistoricalDataPoint, 0)
+
+		err = json.Unmarshal(users, &point.Sample.Users)
+		if err != nil {
+			return nil, err
 		}
 
-		bucket = append(bucket, broadcast.HistoricalDataPoint{Timestamp: timestamp.Unix(), Sample: sample})
+		point.Sample.InUse = point.Max.InUse
+		point.Min.Uuid = point.Sample.Uuid
+		point.Max.Uuid = point.Sample.Uuid
+
+		if len(points) > 0 && points[0].Sample.Uuid != point.Sample.Uuid {
+			data = append(data, points)
+			points = nil
+		}
+		points = append(points, point)
 	}
-	data = append(data, bucket)
+	if len(points) > 0 {
+		data = append(data, points)
+	}
+
+	return data, rows.Err()
+}
+
+// every sample matching where
+func historicalSamplesQuery(where string) string {
+	return fmt.Sprintf(`SELECT s.Gpu, FLOOR(EXTRACT(EPOCH FROM s.Received))::bigint%s
+		FROM Stats s
+		INNER JOIN GPUs g ON g.Uuid = s.Gpu
+		WHERE %s
+		ORDER BY s.Gpu, s.Received`, historyColumns(), where)
+}
 
-	return data[1:], nil
+// the samples matching where, aggregated into buckets of the number of
+// seconds in parameter step the same way as aggregateBucket, with users merged
+// the same way as mergeUsers
+func historicalBucketsQuery(where string, step int) string {
+	var query strings.Builder
+	fmt.Fprintf(&query, `WITH Bucketed AS (
+			SELECT s.*,
+				(FLOOR(EXTRACT(EPOCH FROM s.Received) / $%[2]d) * $%[2]d)::bigint AS Bucket
+			FROM Stats s
+			INNER JOIN GPUs g ON g.Uuid = s.Gpu
+			WHERE %[1]s
+		), BucketUsers AS (
+			SELECT Gpu, Bucket, u.Entry->>'name' AS Name,
+				SUM((u.Entry->>'memory_used')::double precision * Samples)
+					/ SUM(Samples) AS MemoryUsed,
+				MAX((u.Entry->>'processes')::integer) AS Processes
+			FROM Bucketed, jsonb_array_elements(Users) AS u(Entry)
+			GROUP BY Gpu, Bucket, Name
+		), GroupedUsers AS (
+			SELECT Gpu, Bucket, jsonb_agg(jsonb_build_object(
+				'name', Name,
+				'memory_used', MemoryUsed,
+				'processes', Processes
+			) ORDER BY MemoryUsed DESC, Name) AS Users
+			FROM BucketUsers
+			GROUP BY Gpu, Bucket
+		)
+		SELECT s.Gpu, s.Bucket`, where, step)
+	for _, f := range historicalFields {
+		fmt.Fprintf(&query, `, SUM(s.%[1]s * s.Samples) / SUM(s.Samples),
+			MIN(COALESCE(s.Lowest%[1]s, s.%[1]s)), MAX(COALESCE(s.Highest%[1]s, s.%[1]s))`, f.column)
+	}
+	query.WriteString(`, bool_or(s.InUse), bool_and(COALESCE(s.AlwaysInUse, s.InUse)),
+		SUM(s.Samples), COALESCE(u.Users, '[]')
+		FROM Bucketed s
+		LEFT JOIN GroupedUsers u ON u.Gpu = s.Gpu AND u.Bucket = s.Bucket
+		GROUP BY s.Gpu, s.Bucket, u.Users
+		ORDER BY s.Gpu, s.Bucket`)
+	return query.String()
 }
 
 // calculating the aggregate data
@@ -847,3 +1371,454 @@ func (conn PostgresConn) AggregateData() (broadcast.AggregateData, error) {
 
 	return broadcast.AggregateData{TotalEnergy: *result}, nil
 }
+
+func (conn PostgresConn) SettingsOverrides() ([]broadcast.SettingsOverride, error) {
+	rows, err := conn.db.Query(`SELECT GroupName, '', Settings FROM GroupSettings
+		UNION ALL
+		SELECT '', Hostname, Settings FROM MachineSettings`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := []broadcast.SettingsOverride{}
+	for rows.Next() {
+		var override broadcast.SettingsOverride
+		var settings []byte
+
+		err = rows.Scan(&override.Group, &override.Hostname, &settings)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(settings, &override.Settings)
+		if err != nil {
+			return nil, err
+		}
+
+		overrides = append(overrides, override)
+	}
+
+	return overrides, rows.Err()
+}
+
+func (conn PostgresConn) SetSettingsOverride(override broadcast.SettingsOverride) error {
+	var table, column, key string
+	switch {
+	case override.Group != "" && override.Hostname == "":
+		table, column, key = "GroupSettings", "GroupName", override.Group
+	case override.Hostname != "" && override.Group == "":
+		table, column, key = "MachineSettings", "Hostname", override.Hostname
+	default:
+		return ErrInvalidOverride
+	}
+
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if override.Hostname != "" {
+		_, err = getLastSeen(override.Hostname, tx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.Join(fmt.Errorf("%s: %w", override.Hostname, ErrNoSuchMachine), tx.Rollback())
+		} else if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+	}
+
+	if override.Settings.IsZero() {
+		_, err = tx.Exec(`DELETE FROM `+table+` WHERE `+column+`=$1`, key)
+		if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+		return tx.Commit()
+	}
+
+	settings, err := json.Marshal(override.Settings)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	_, err = tx.Exec(`INSERT INTO `+table+` (`+column+`, Settings)
+		VALUES ($1, $2)
+		ON CONFLICT (`+column+`) DO UPDATE
+		SET Settings = EXCLUDED.Settings`,
+		key, string(settings))
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	return tx.Commit()
+}
+
+func (conn PostgresConn) SettingsFor(hostname string) (uplink.Settings, uplink.Settings, error) {
+	var group, machine uplink.Settings
+	var groupJSON, machineJSON []byte
+
+	row := conn.db.QueryRow(`SELECT g.Settings, s.Settings
+		FROM Machines m
+		LEFT JOIN GroupSettings g ON g.GroupName = COALESCE(NULLIF(m.GroupName, ''), $2)
+		LEFT JOIN MachineSettings s ON s.Hostname = m.Hostname
+		WHERE m.Hostname=$1`,
+		hostname, DefaultGroup)
+	err := row.Scan(&groupJSON, &machineJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		return group, machine, nil
+	} else if err != nil {
+		return group, machine, err
+	}
+
+	if groupJSON != nil {
+		err = json.Unmarshal(groupJSON, &group)
+		if err != nil {
+			return group, machine, err
+		}
+	}
+	if machineJSON != nil {
+		err = json.Unmarshal(machineJSON, &machine)
+	}
+	return group, machine, err
+}
+
+func (conn PostgresConn) LatestProcesses(hostname string) ([]broadcast.GPUProcesses, error) {
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// only reading
+	defer tx.Rollback()
+
+	_, err = getLastSeen(hostname, tx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", hostname, ErrNoSuchMachine)
+	} else if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT g.Uuid, COALESCE(p.Processes, '[]')
+		FROM GPUs g
+		LEFT JOIN LatestProcesses p ON p.Gpu = g.Uuid
+		WHERE g.Machine=$1`,
+		hostname)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []broadcast.GPUProcesses{}
+	for rows.Next() {
+		var gpu broadcast.GPUProcesses
+		var procs []byte
+
+		err = rows.Scan(&gpu.Uuid, &procs)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(procs, &gpu.Processes)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, gpu)
+	}
+
+	return result, rows.Err()
+}
+
+func (conn PostgresConn) Jobs(hostname string, from time.Time, to time.Time) ([]broadcast.Job, error) {
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// only reading
+	defer tx.Rollback()
+
+	_, err = getLastSeen(hostname, tx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", hostname, ErrNoSuchMachine)
+	} else if err != nil {
+		return nil, err
+	}
+
+	// a job is still running if it was in the gpu's latest sample
+	rows, err := tx.Query(`SELECT j.Gpu, j.Pid, j.Name, j.Owner,
+		j.FirstSeen, j.LastSeen, j.PeakMemory,
+		COALESCE(j.LastSeen >= p.Received, FALSE)
+		FROM Jobs j
+		INNER JOIN GPUs g ON g.Uuid = j.Gpu
+		LEFT JOIN LatestProcesses p ON p.Gpu = j.Gpu
+		WHERE g.Machine=$1 AND j.FirstSeen <= $3 AND j.LastSeen >= $2
+		ORDER BY j.LastSeen DESC, j.FirstSeen DESC, j.Gpu, j.Pid`,
+		hostname, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []broadcast.Job{}
+	for rows.Next() {
+		var job broadcast.Job
+		var firstSeen, lastSeen time.Time
+
+		err = rows.Scan(&job.Gpu, &job.Pid, &job.Name, &job.Owner,
+			&firstSeen, &lastSeen, &job.PeakMemory, &job.Running)
+		if err != nil {
+			return nil, err
+		}
+
+		job.FirstSeen = firstSeen.Unix()
+		job.LastSeen = lastSeen.Unix()
+		result = append(result, job)
+	}
+
+	return result, rows.Err()
+}
+
+// Each sample accounts for the time since the previous one from its gpu, up
+// to maxGap for each sample it stands for, split between its users the same
+// way as usageShares. Only the samples in the window are read, and the one
+// before it from each gpu
+func (conn PostgresConn) Usage(from time.Time, to time.Time, by UsageBy, maxGap time.Duration) ([]broadcast.Usage, error) {
+	args := []any{from, to, maxGap.Seconds()}
+	var name string
+	switch by {
+	case UsageByUser:
+		name = "sh.UserName"
+	case UsageByMachine:
+		name = "g.Machine"
+	case UsageByGroup:
+		name = "COALESCE(NULLIF(m.GroupName, ''), $4)"
+		args = append(args, DefaultGroup)
+	default:
+		return nil, ErrInvalidUsageBy
+	}
+
+	rows, err := conn.db.Query(fmt.Sprintf(`WITH Previous AS (
+			SELECT g.Uuid AS Gpu, COALESCE((
+				SELECT MAX(Received) FROM Stats
+				WHERE Gpu = g.Uuid AND Received <= $1
+			), $1) AS Since
+			FROM GPUs g
+		), Intervals AS (
+			SELECT s.Gpu, s.Received, s.Users, s.GpuUtilisation, s.PowerDraw, s.Samples,
+				EXTRACT(EPOCH FROM s.Received - LAG(s.Received)
+					OVER (PARTITION BY s.Gpu ORDER BY s.Received))::double precision AS Gap
+			FROM Previous p
+			INNER JOIN Stats s ON s.Gpu = p.Gpu
+				AND s.Received >= p.Since AND s.Received <= $2
+		), Shares AS (
+			SELECT i.Gpu, u.Entry->>'name' AS UserName,
+				i.GpuUtilisation, i.PowerDraw,
+				LEAST(i.Gap, i.Samples * $3::double precision) * CASE
+					WHEN t.Memory > 0 THEN (u.Entry->>'memory_used')::double precision / t.Memory
+					ELSE 1.0 / jsonb_array_length(i.Users)
+				END AS Seconds
+			FROM Intervals i
+			CROSS JOIN LATERAL (
+				SELECT COALESCE(SUM((e.Entry->>'memory_used')::double precision), 0) AS Memory
+				FROM jsonb_array_elements(i.Users) AS e(Entry)
+			) t
+			CROSS JOIN LATERAL jsonb_array_elements(i.Users) AS u(Entry)
+			WHERE i.Received > $1 AND i.Gap IS NOT NULL
+		)
+		SELECT %s AS Name,
+			SUM(sh.Seconds) / 3600,
+			COALESCE(SUM(sh.Seconds * sh.GpuUtilisation) / NULLIF(SUM(sh.Seconds), 0), 0),
+			SUM(sh.Seconds * sh.PowerDraw)
+		FROM Shares sh
+		INNER JOIN GPUs g ON g.Uuid = sh.Gpu
+		INNER JOIN Machines m ON m.Hostname = g.Machine
+		GROUP BY 1
+		ORDER BY 2 DESC, 1`, name),
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []broadcast.Usage{}
+	for rows.Next() {
+		var usage broadcast.Usage
+		err = rows.Scan(&usage.Name, &usage.GPUHours, &usage.AverageUtilisation, &usage.Energy)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, usage)
+	}
+
+	return result, rows.Err()
+}
+
+func (conn PostgresConn) InventoryEvents(hostname string, from time.Time, to time.Time) ([]broadcast.InventoryEvent, error) {
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// only reading
+	defer tx.Rollback()
+
+	_, err = getLastSeen(hostname, tx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", hostname, ErrNoSuchMachine)
+	} else if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT Time, Hostname, Gpu, Name, Kind,
+		FromValue, ToValue
+		FROM InventoryEvents
+		WHERE (Hostname=$1 OR (Kind=$4 AND FromValue=$1))
+			AND Time >= $2 AND Time <= $3
+		ORDER BY Time DESC, Id DESC`,
+		hostname, from, to, broadcast.GPUMoved)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []broadcast.InventoryEvent{}
+	for rows.Next() {
+		var event broadcast.InventoryEvent
+		var when time.Time
+
+		err = rows.Scan(&when, &event.Hostname, &event.Gpu, &event.Name,
+			&event.Kind, &event.From, &event.To)
+		if err != nil {
+			return nil, err
+		}
+
+		event.Time = when.Unix()
+		result = append(result, event)
+	}
+
+	return result, rows.Err()
+}
+
+func (conn PostgresConn) FlagMissingGPUs(now time.Time, timeout time.Duration, slower map[string]time.Duration) ([]broadcast.InventoryEvent, error) {
+	seconds, err := slowerJSON(slower)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := now.Add(-timeout)
+
+	// how long ago a gpu's machine has to have sent its last sample by
+	const gpuCutoff = `COALESCE(
+		$2::timestamp - make_interval(secs => ($3::jsonb ->> g.Machine)::float8),
+		$1)`
+
+	// gpus without any samples haven't started reporting, so can't stop
+	missing, err := flagGpus(tx, broadcast.GPUMissing, now, `UPDATE GPUs g
+		SET Missing = TRUE
+		WHERE NOT g.Missing
+			AND (SELECT MAX(Received) FROM Stats WHERE Gpu = g.Uuid) < `+gpuCutoff+`
+			AND g.Machine IN (SELECT Hostname FROM Machines WHERE LastSeen >= $1)
+		RETURNING g.Uuid, g.Machine, g.Name`, cutoff, now, seconds)
+	if err != nil {
+		return nil, errors.Join(err, tx.Rollback())
+	}
+
+	returned, err := flagGpus(tx, broadcast.GPUReturned, now, `UPDATE GPUs g
+		SET Missing = FALSE
+		WHERE g.Missing
+			AND (SELECT MAX(Received) FROM Stats WHERE Gpu = g.Uuid) >= `+gpuCutoff+`
+		RETURNING g.Uuid, g.Machine, g.Name`, cutoff, now, seconds)
+	if err != nil {
+		return nil, errors.Join(err, tx.Rollback())
+	}
+
+	events := append(missing, returned...)
+	err = recordInventoryEvents(events, tx)
+	if err != nil {
+		return nil, errors.Join(err, tx.Rollback())
+	}
+
+	return events, tx.Commit()
+}
+
+// an alert's time for a nullable column, null if it's unset
+func alertTime(unix int64) sql.NullTime {
+	return sql.NullTime{Time: time.Unix(unix, 0), Valid: unix != 0}
+}
+
+func (conn PostgresConn) RecordAlert(alert broadcast.Alert) (int64, error) {
+	if alert.Id == 0 {
+		err := conn.db.QueryRow(`INSERT INTO Alerts
+			(Rule, Expression, Hostname, Gpu, State, Value, Since, FiredAt, ResolvedAt)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING Id`,
+			alert.Rule, alert.Expression, alert.Hostname, alert.Gpu,
+			alert.State, alert.Value, time.Unix(alert.Since, 0),
+			alertTime(alert.FiredAt), alertTime(alert.ResolvedAt)).Scan(&alert.Id)
+		return alert.Id, err
+	}
+
+	_, err := conn.db.Exec(`UPDATE Alerts
+		SET (Rule, Expression, Hostname, Gpu, State, Value, Since, FiredAt, ResolvedAt)
+		= ($2, $3, $4, $5, $6, $7, $8, $9, $10)
+		WHERE Id=$1`,
+		alert.Id, alert.Rule, alert.Expression, alert.Hostname, alert.Gpu,
+		alert.State, alert.Value, time.Unix(alert.Since, 0),
+		alertTime(alert.FiredAt), alertTime(alert.ResolvedAt))
+	return alert.Id, err
+}
+
+func (conn PostgresConn) RemoveAlert(id int64) error {
+	_, err := conn.db.Exec(`DELETE FROM Alerts WHERE Id=$1`, id)
+	return err
+}
+
+func (conn PostgresConn) ActiveAlerts() ([]broadcast.Alert, error) {
+	return conn.queryAlerts(`WHERE State <> $1
+		ORDER BY Since, Id`,
+		broadcast.AlertResolved)
+}
+
+func (conn PostgresConn) AlertHistory(from time.Time, to time.Time) ([]broadcast.Alert, error) {
+	return conn.queryAlerts(`WHERE FiredAt <= $2
+			AND (ResolvedAt IS NULL OR ResolvedAt >= $1)
+		ORDER BY FiredAt DESC, Id DESC`,
+		from, to)
+}
+
+func (conn PostgresConn) queryAlerts(where string, args ...any) ([]broadcast.Alert, error) {
+	rows, err := conn.db.Query(`SELECT Id, Rule, Expression, Hostname, Gpu,
+		State, Value, Since, FiredAt, ResolvedAt
+		FROM Alerts `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []broadcast.Alert{}
+	for rows.Next() {
+		var alert broadcast.Alert
+		var since time.Time
+		var firedAt, resolvedAt sql.NullTime
+
+		err = rows.Scan(&alert.Id, &alert.Rule, &alert.Expression,
+			&alert.Hostname, &alert.Gpu, &alert.State, &alert.Value,
+			&since, &firedAt, &resolvedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		alert.Since = since.Unix()
+		if firedAt.Valid {
+			alert.FiredAt = firedAt.Time.Unix()
+		}
+		if resolvedAt.Valid {
+			alert.ResolvedAt = resolvedAt.Time.Unix()
+		}
+		result = append(result, alert)
+	}
+
+	return result, rows.Err()
+}
@@ -5,11 +5,11 @@ import (
 	"time"
 )
 
-func DownsampleOverTime(interval time.Duration, database Database) error {
+func DownsampleOverTime(interval time.Duration, policy RetentionPolicy, database Database) error {
 	downsampleTicker := time.NewTicker(time.Duration(interval))
 
 	for range downsampleTicker.C {
-		err := database.Downsample(time.Now())
+		err := database.Downsample(time.Now(), policy)
 
 		if err != nil {
 			slog.Error("Got error whilst downsampling", "err", err)
@@ -19,6 +19,6 @@ func DownsampleOverTime(interval time.Duration, database Database) error {
 	return nil
 }
 
-func downsampleDatabase(database Database, t time.Time) error {
-	return database.Downsample(t)
+func downsampleDatabase(database Database, t time.Time, policy RetentionPolicy) error {
+	return database.Downsample(t, policy)
 }
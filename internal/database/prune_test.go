@@ -1,7 +1,9 @@
 package database
 
 import (
+	"errors"
 	"reflect"
+	"slices"
 	"testing"
 	"time"
 
@@ -134,100 +136,135 @@ func TestAverageProcess(t *testing.T) {
 
 }
 
-// specify the time we test at
-// test hasn't been passing since March started?
-var now = time.Date(2024, time.February, 1, 0, 0, 0, 0, time.Local)
+// everyone who used the gpu during a downsampled period is still listed
+func TestAverageKeepsEveryUser(t *testing.T) {
+	t.Parallel()
 
-func TestDownsample(t *testing.T) {
-	db := InMemory().(*inMemory)
+	samples := []uplink.GPUStatSample{
+		{RunningProcesses: uplink.Processes{{Pid: 1, Name: "python", MemUsed: 100, Owner: "clive"}}},
+		{RunningProcesses: uplink.Processes{{Pid: 2, Name: "julia", MemUsed: 200, Owner: "brenda"}}},
+		{RunningProcesses: uplink.Processes{}},
+	}
 
-	cutoffTime := now.AddDate(0, -6, 0)
+	inUse, users := CalculateAverage(samples).RunningProcesses.Summarise()
 
-	gpuUUID := uuid.MustParse("96cd8554-161d-4865-9767-60c1779c57b9")
-	hostName := "test-host"
+	expected := []uplink.GPUUser{
+		{Name: "brenda", MemoryUsed: 200, Processes: 1},
+		{Name: "clive", MemoryUsed: 100, Processes: 1},
+	}
+	if !inUse || !reflect.DeepEqual(users, expected) {
+		t.Errorf("Summarise() = %v, %v, want true, %v", inUse, users, expected)
+	}
+}
 
-	db.infos[gpuUUID] = gpuInfo{host: hostName, context: uplink.GPUInfo{Uuid: gpuUUID}}
-	db.UpdateLastSeen(hostName, now)
+// specify the time we test at, on a bucket boundary
+var now = time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)
 
-	for i := 1; i <= 250; i++ {
-		sampleTime := now.AddDate(0, 0, -i*2).Unix() // Ensuring a spread over the year
+// raw for 30 minutes, 10 minute averages for an hour, then 30 minute
+// averages, then nothing after 2 hours
+var testPolicy = RetentionPolicy{
+	{After: 30 * time.Minute, Step: 10 * time.Minute},
+	{After: time.Hour, Step: 30 * time.Minute},
+	{After: 2 * time.Hour},
+}
+
+// a sample a minute for the last 3 hours, with the temperature as how many
+// minutes ago it was, in time order
+func addMinuteSamples(db *inMemory, gpuUUID uuid.UUID) {
+	db.infos[gpuUUID] = gpuInfo{host: "test-host", context: uplink.GPUInfo{Uuid: gpuUUID}}
+	db.UpdateLastSeen("test-host", now)
+
+	for i := 180; i >= 0; i-- {
 		db.stats[gpuUUID] = append(db.stats[gpuUUID], uplink.GPUStatSample{
-			Uuid:              gpuUUID,
-			MemoryUtilisation: float64(i % 100), // Example values, vary as needed
-			GPUUtilisation:    float64(i % 100),
-			MemoryUsed:        1024 + float64(i),         // Example incremental value
-			FanSpeed:          50 + float64(i%50),        // Example variation
-			Temp:              60 + float64(i%40),        // Example variation
-			MemoryTemp:        30 + float64(i%20),        // Example variation
-			GraphicsVoltage:   1.0 + float64(i%100)/1000, // Incremental variation
-			PowerDraw:         200 + float64(i%50),       // Example variation
-			GraphicsClock:     1000 + float64(i%500),     // Variation
-			MaxGraphicsClock:  1500 + float64(i%500),     // Variation
-			MemoryClock:       500 + float64(i%250),      // Variation
-			MaxMemoryClock:    750 + float64(i%250),      // Variation
-			Time:              sampleTime,
+			Uuid:      gpuUUID,
+			Temp:      float64(i),
+			PowerDraw: 200,
+			Time:      now.Add(-time.Duration(i) * time.Minute).Unix(),
 			RunningProcesses: []uplink.GPUProcInfo{
 				{Pid: 1234, Name: "ProcessA", MemUsed: 250.0},
-				{Pid: 1235, Name: "ProcessB", MemUsed: 300.0},
 			},
 		})
 	}
+}
 
-	// TODO: how did we determine this value?
-	// since March we've been getting 93 rather than 94
-	expectedNumSamples := 94
+func TestDownsample(t *testing.T) {
+	db := InMemory().(*inMemory)
+	gpuUUID := uuid.MustParse("96cd8554-161d-4865-9767-60c1779c57b9")
+	addMinuteSamples(db, gpuUUID)
 
-	if err := db.Downsample(cutoffTime); err != nil {
+	if err := db.Downsample(now, testPolicy); err != nil {
 		t.Fatalf("Downsample failed: %v", err)
 	}
-	if gotNumSamples := len(db.stats[gpuUUID]); gotNumSamples != expectedNumSamples {
-		t.Errorf("Downsample() resulted in %d samples for %s; want %d", gotNumSamples, gpuUUID, expectedNumSamples)
+
+	// 2 half hours, 3 ten minutes, then the 31 samples from the last 30
+	// minutes
+	stats := db.stats[gpuUUID]
+	expectedNumSamples := 2 + 3 + 31
+	if gotNumSamples := len(stats); gotNumSamples != expectedNumSamples {
+		t.Fatalf("Downsample() resulted in %d samples for %s; want %d", gotNumSamples, gpuUUID, expectedNumSamples)
 	}
 
+	expected := []struct {
+		time time.Time
+		temp float64
+	}{
+		{now.Add(-2 * time.Hour), 105.5},
+		{now.Add(-90 * time.Minute), 75.5},
+		{now.Add(-time.Hour), 55.5},
+		{now.Add(-50 * time.Minute), 45.5},
+		{now.Add(-40 * time.Minute), 35.5},
+		{now.Add(-30 * time.Minute), 30},
+	}
+	for i, want := range expected {
+		if stats[i].Time != want.time.Unix() || stats[i].Temp != want.temp {
+			t.Errorf("sample %d was %.1f at %d; want %.1f at %d", i, stats[i].Temp, stats[i].Time, want.temp, want.time.Unix())
+		}
+	}
 }
 
+// applying the same policy again changes nothing
 func TestDownsamplePruneMethod(t *testing.T) {
-
 	db := InMemory().(*inMemory)
-
-	cutoffTime := now.AddDate(0, -6, 0)
-	// use all 1s uuid for test
 	gpuUUID := uuid.MustParse("95a6f0b2-634c-41ab-91e2-1b9782cf8cbd")
-	hostName := "test-host"
+	addMinuteSamples(db, gpuUUID)
 
-	db.infos[gpuUUID] = gpuInfo{host: hostName, context: uplink.GPUInfo{Uuid: gpuUUID}}
-	db.UpdateLastSeen(hostName, now)
-
-	for i := 1; i <= 250; i++ {
-		sampleTime := now.AddDate(0, 0, -i*2).Unix() // Ensuring a spread over the year
-		db.stats[gpuUUID] = append(db.stats[gpuUUID], uplink.GPUStatSample{
-			Uuid:              gpuUUID,
-			MemoryUtilisation: float64(i % 100), // Example values, vary as needed
-			GPUUtilisation:    float64(i % 100),
-			MemoryUsed:        1024 + float64(i),         // Example incremental value
-			FanSpeed:          50 + float64(i%50),        // Example variation
-			Temp:              60 + float64(i%40),        // Example variation
-			MemoryTemp:        30 + float64(i%20),        // Example variation
-			GraphicsVoltage:   1.0 + float64(i%100)/1000, // Incremental variation
-			PowerDraw:         200 + float64(i%50),       // Example variation
-			GraphicsClock:     1000 + float64(i%500),     // Variation
-			MaxGraphicsClock:  1500 + float64(i%500),     // Variation
-			MemoryClock:       500 + float64(i%250),      // Variation
-			MaxMemoryClock:    750 + float64(i%250),      // Variation
-			Time:              sampleTime,
-			RunningProcesses: []uplink.GPUProcInfo{
-				{Pid: 1234, Name: "ProcessA", MemUsed: 250.0},
-				{Pid: 1235, Name: "ProcessB", MemUsed: 300.0},
-			},
-		})
+	if err := downsampleDatabase(db, now, testPolicy); err != nil {
+		t.Fatalf("Downsample failed: %v", err)
 	}
+	once := slices.Clone(db.stats[gpuUUID])
 
-	expectedNumSamples := 94
-
-	if err := downsampleDatabase(db, cutoffTime); err != nil {
+	if err := downsampleDatabase(db, now, testPolicy); err != nil {
 		t.Fatalf("Downsample failed: %v", err)
 	}
-	if gotNumSamples := len(db.stats[gpuUUID]); gotNumSamples != expectedNumSamples {
-		t.Errorf("Downsample() resulted in %d samples for %s; want %d", gotNumSamples, gpuUUID, expectedNumSamples)
+	if !reflect.DeepEqual(once, db.stats[gpuUUID]) {
+		t.Errorf("Downsampling again changed the samples from %v to %v", once, db.stats[gpuUUID])
+	}
+}
+
+func TestRetentionPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		valid  bool
+	}{
+		{"Default", DefaultRetention, true},
+		{"Empty", RetentionPolicy{}, true},
+		{"OnlyDeletes", RetentionPolicy{{After: time.Hour}}, true},
+		{"DeletesBeforeTheEnd", RetentionPolicy{{After: time.Hour}, {After: 2 * time.Hour, Step: time.Minute}}, false},
+		{"OutOfOrder", RetentionPolicy{{After: 2 * time.Hour, Step: time.Minute}, {After: time.Hour, Step: time.Hour}}, false},
+		{"NotMultiples", RetentionPolicy{{After: time.Hour, Step: 2 * time.Minute}, {After: 2 * time.Hour, Step: 3 * time.Minute}}, false},
+		{"FractionalSeconds", RetentionPolicy{{After: time.Hour, Step: 1500 * time.Millisecond}}, false},
+		{"NoAge", RetentionPolicy{{Step: time.Minute}}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.policy.Validate()
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if !test.valid && !errors.Is(err, ErrInvalidRetention) {
+				t.Errorf("expected ErrInvalidRetention, got %v", err)
+			}
+		})
 	}
 }
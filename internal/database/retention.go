@@ -0,0 +1,108 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+)
+
+// Once samples are older than After, they're replaced by their averages over
+// buckets of Step, or deleted if Step is zero
+type RetentionTier struct {
+	After time.Duration
+	Step  time.Duration
+}
+
+// How long samples are kept, and at what resolution. Tiers are in order of
+// age, each coarser than the last, and only the last can delete samples.
+// Samples younger than the first tier are kept as they are
+type RetentionPolicy []RetentionTier
+
+// raw samples for a day, minute averages for a week, hourly averages for a
+// year, then nothing
+var DefaultRetention = RetentionPolicy{
+	{After: 24 * time.Hour, Step: time.Minute},
+	{After: 7 * 24 * time.Hour, Step: time.Hour},
+	{After: 365 * 24 * time.Hour},
+}
+
+func (p RetentionPolicy) Validate() error {
+	for i, tier := range p {
+		if tier.After <= 0 {
+			return fmt.Errorf("%w: tier %d must be for samples of some age", ErrInvalidRetention, i+1)
+		}
+		if i > 0 && tier.After <= p[i-1].After {
+			return fmt.Errorf("%w: tier %d must be for older samples than tier %d", ErrInvalidRetention, i+1, i)
+		}
+
+		if tier.Step == 0 {
+			if i != len(p)-1 {
+				return fmt.Errorf("%w: only the last tier can delete samples", ErrInvalidRetention)
+			}
+			continue
+		}
+		if tier.Step < time.Second || tier.Step%time.Second != 0 {
+			return fmt.Errorf("%w: tier %d must average over whole seconds", ErrInvalidRetention, i+1)
+		}
+		// so each tier's buckets are made of whole ones from the last
+		if i > 0 && tier.Step%p[i-1].Step != 0 {
+			return fmt.Errorf("%w: tier %d's step must be a multiple of tier %d's", ErrInvalidRetention, i+1, i)
+		}
+	}
+	return nil
+}
+
+// The samples one tier averages, from the start of its first bucket up to
+// the start of the first bucket that isn't old enough yet
+type rollupWindow struct {
+	from time.Time // zero if every older sample is included
+	to   time.Time
+	step time.Duration
+}
+
+func (w rollupWindow) includes(t int64) bool {
+	return (w.from.IsZero() || t >= w.from.Unix()) && t < w.to.Unix()
+}
+
+// What applying the policy at now involves: averaging the samples in each of
+// the windows, and deleting everything before cutoff if deletes is set.
+// Windows don't overlap, so samples are only averaged by one tier at a time
+func (p RetentionPolicy) plan(now time.Time) (windows []rollupWindow, cutoff time.Time, deletes bool) {
+	// oldest first, as each tier stops where the next one starts
+	var from time.Time
+	for i := len(p) - 1; i >= 0; i-- {
+		tier := p[i]
+		if tier.Step == 0 {
+			cutoff, deletes = now.Add(-tier.After), true
+			from = cutoff
+			continue
+		}
+
+		to := time.Unix(bucketStart(now.Add(-tier.After).Unix(), tier.Step), 0)
+		windows = append(windows, rollupWindow{from: from, to: to, step: tier.Step})
+		from = to
+	}
+
+	return windows, cutoff, deletes
+}
+
+// Average each bucket of one gpu's samples, in time order, that isn't
+// already a single sample at its start. Those that are have been rolled up
+// before, so are left alone
+func rollupPoints(samples []broadcast.HistoricalDataPoint, step time.Duration) []broadcast.HistoricalDataPoint {
+	var points []broadcast.HistoricalDataPoint
+	for start := 0; start < len(samples); {
+		bucket := bucketStart(samples[start].Timestamp, step)
+		end := start + 1
+		for end < len(samples) && bucketStart(samples[end].Timestamp, step) == bucket {
+			end++
+		}
+
+		if end-start > 1 || samples[start].Timestamp != bucket {
+			points = append(points, historyPoints(samples[start:end], step)...)
+		}
+		start = end
+	}
+	return points
+}
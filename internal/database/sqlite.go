@@ -0,0 +1,1531 @@
+package database
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+	"github.com/gpuctl/gpuctl/internal/uplink"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SqliteConn represents an open control database kept in a local SQLite file.
+//
+// It has the same tables as postgres, but times are stored as unix seconds
+// (unix microseconds for when machines were last seen) and JSON as text
+type SqliteConn struct {
+	db *sql.DB
+}
+
+func Sqlite(path string) (Database, error) {
+	// foreign keys are off by default in sqlite, and we rely on them to
+	// reject samples for gpus we don't know
+	db, err := sql.Open("sqlite", "file:"+path+
+		"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite only allows one writer at a time, so share one connection
+	// rather than have transactions fail with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	err = db.Ping()
+	if err != nil {
+		return nil, err
+	}
+
+	err = createSqliteTables(db)
+	if err != nil {
+		return nil, errors.Join(err, db.Close())
+	}
+
+	return SqliteConn{db}, nil
+}
+
+func createSqliteTables(db *sql.DB) error {
+	tables := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS Machines (
+			Hostname text NOT NULL,
+			GroupName text NOT NULL DEFAULT '%s',
+			CPU text,
+			Motherboard text,
+			Notes text,
+			Owner text,
+			LastSeen integer NOT NULL,
+			PRIMARY KEY (Hostname)
+		);`, DefaultGroup),
+		`CREATE TABLE IF NOT EXISTS GPUs (
+			Uuid text NOT NULL,
+			Machine text NOT NULL REFERENCES Machines (Hostname),
+			Name text NOT NULL,
+			Brand text NOT NULL,
+			DriverVersion text NOT NULL,
+			MemoryTotal integer NOT NULL,
+			Backend text NOT NULL DEFAULT '',
+			Missing boolean NOT NULL DEFAULT false,
+			PRIMARY KEY (Uuid)
+		);`,
+		`CREATE TABLE IF NOT EXISTS Files (
+			Hostname text NOT NULL REFERENCES Machines (Hostname),
+			Filename text NOT NULL,
+			Mime text NOT NULL,
+			StoreKey text NOT NULL,
+			Size integer NOT NULL,
+			Checksum text NOT NULL,
+			Uploader text NOT NULL,
+			UploadedAt integer NOT NULL,
+			PRIMARY KEY (Hostname, Filename)
+		);`,
+		`CREATE TABLE IF NOT EXISTS Stats (
+			Gpu text NOT NULL REFERENCES GPUs (Uuid),
+			Received integer NOT NULL,
+			MemoryUtilisation real NOT NULL,
+			GpuUtilisation real NOT NULL,
+			MemoryUsed real NOT NULL,
+			FanSpeed real NOT NULL,
+			Temp real NOT NULL,
+			MemoryTemp real NOT NULL,
+			GraphicsVoltage real NOT NULL,
+			PowerDraw real NOT NULL,
+			GraphicsClock real NOT NULL,
+			MaxGraphicsClock real NOT NULL,
+			MemoryClock real NOT NULL,
+			MaxMemoryClock real NOT NULL,
+			InUse boolean NOT NULL,
+			Users text NOT NULL DEFAULT '[]',
+			Samples integer NOT NULL DEFAULT 1,
+			AlwaysInUse boolean,
+			LowestMemoryUtilisation real,
+			HighestMemoryUtilisation real,
+			LowestGpuUtilisation real,
+			HighestGpuUtilisation real,
+			LowestMemoryUsed real,
+			HighestMemoryUsed real,
+			LowestFanSpeed real,
+			HighestFanSpeed real,
+			LowestTemp real,
+			HighestTemp real,
+			LowestMemoryTemp real,
+			HighestMemoryTemp real,
+			LowestGraphicsVoltage real,
+			HighestGraphicsVoltage real,
+			LowestPowerDraw real,
+			HighestPowerDraw real,
+			LowestGraphicsClock real,
+			HighestGraphicsClock real,
+			LowestMaxGraphicsClock real,
+			HighestMaxGraphicsClock real,
+			LowestMemoryClock real,
+			HighestMemoryClock real,
+			LowestMaxMemoryClock real,
+			HighestMaxMemoryClock real,
+			PRIMARY KEY (Gpu, Received)
+		);`,
+		`CREATE TABLE IF NOT EXISTS GroupSettings (
+			GroupName text NOT NULL,
+			Settings text NOT NULL,
+			PRIMARY KEY (GroupName)
+		);`,
+		`CREATE TABLE IF NOT EXISTS MachineSettings (
+			Hostname text NOT NULL REFERENCES Machines (Hostname),
+			Settings text NOT NULL,
+			PRIMARY KEY (Hostname)
+		);`,
+		`CREATE TABLE IF NOT EXISTS MachineGroups (
+			Name text NOT NULL,
+			Description text NOT NULL DEFAULT '',
+			Position integer NOT NULL DEFAULT 0,
+			PRIMARY KEY (Name)
+		);`,
+		`CREATE TABLE IF NOT EXISTS MachineTags (
+			Hostname text NOT NULL REFERENCES Machines (Hostname),
+			Tag text NOT NULL,
+			PRIMARY KEY (Hostname, Tag)
+		);`,
+		`CREATE TABLE IF NOT EXISTS MachineMetadata (
+			Hostname text NOT NULL REFERENCES Machines (Hostname),
+			Name text NOT NULL,
+			Type text NOT NULL,
+			Value text NOT NULL,
+			PRIMARY KEY (Hostname, Name)
+		);`,
+		`CREATE TABLE IF NOT EXISTS LatestProcesses (
+			Gpu text NOT NULL REFERENCES GPUs (Uuid),
+			Received integer NOT NULL,
+			Processes text NOT NULL,
+			PRIMARY KEY (Gpu)
+		);`,
+		`CREATE TABLE IF NOT EXISTS Jobs (
+			Gpu text NOT NULL REFERENCES GPUs (Uuid),
+			Pid integer NOT NULL,
+			Name text NOT NULL,
+			Owner text NOT NULL,
+			FirstSeen integer NOT NULL,
+			LastSeen integer NOT NULL,
+			PeakMemory real NOT NULL,
+			PRIMARY KEY (Gpu, Pid, FirstSeen)
+		);`,
+		`CREATE TABLE IF NOT EXISTS InventoryEvents (
+			Id integer NOT NULL,
+			Time integer NOT NULL,
+			Hostname text NOT NULL,
+			Gpu text NOT NULL,
+			Name text NOT NULL,
+			Kind text NOT NULL,
+			FromValue text NOT NULL DEFAULT '',
+			ToValue text NOT NULL DEFAULT '',
+			PRIMARY KEY (Id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS Alerts (
+			Id integer NOT NULL,
+			Rule text NOT NULL,
+			Expression text NOT NULL,
+			Hostname text NOT NULL,
+			Gpu text,
+			State text NOT NULL,
+			Value real NOT NULL,
+			Since integer NOT NULL,
+			FiredAt integer,
+			ResolvedAt integer,
+			PRIMARY KEY (Id)
+		);`,
+	}
+
+	for _, table := range tables {
+		_, err := db.Exec(table)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (conn SqliteConn) UpdateLastSeen(host string, now time.Time) error {
+	_, err := conn.db.Exec(`INSERT INTO Machines (Hostname, LastSeen)
+		VALUES (?1, ?2)
+		ON CONFLICT (Hostname) DO UPDATE
+		SET LastSeen = MAX(LastSeen, excluded.LastSeen)`,
+		host, now.UnixMicro())
+	return err
+}
+
+func (conn SqliteConn) AppendDataPoint(sample uplink.GPUStatSample) error {
+	return conn.AppendDataPoints([]uplink.GPUStatSample{sample})
+}
+
+func (conn SqliteConn) AppendDataPoints(samples []uplink.GPUStatSample) error {
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, sample := range samples {
+		// samples without a time are stamped on arrival, and replace any
+		// we got earlier in the same second
+		stamped := sample.Time == 0
+		if stamped {
+			sample.Time = now.Unix()
+		}
+
+		err = insertSqliteStats(sample.Time, GPUFromSample(sample), stamped, tx)
+		if isSqliteForeignKeyViolation(err) {
+			return errors.Join(ErrGpuNotPresent, tx.Rollback())
+		} else if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+
+		err = updateSqliteLatestProcesses(sample, tx)
+		if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+	}
+
+	err = recordSqliteJobs(jobRuns(samples, now), tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	return tx.Commit()
+}
+
+// whether sqlite refused something for referencing a row that isn't there,
+// like a sample for a gpu we don't know
+func isSqliteForeignKeyViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY
+}
+
+// Samples we already have are ignored, unless replace is set
+func insertSqliteStats(received int64, gpu broadcast.GPU, replace bool, tx *sql.Tx) error {
+	usersJSON, err := json.Marshal(gpu.Users)
+	if err != nil {
+		return err
+	}
+
+	conflict := "DO NOTHING"
+	if replace {
+		conflict = `DO UPDATE
+		SET (MemoryUtilisation, GpuUtilisation, MemoryUsed, FanSpeed, Temp,
+		MemoryTemp, GraphicsVoltage, PowerDraw, GraphicsClock,
+		MaxGraphicsClock, MemoryClock, MaxMemoryClock, InUse, Users)
+		= (excluded.MemoryUtilisation, excluded.GpuUtilisation,
+		excluded.MemoryUsed, excluded.FanSpeed, excluded.Temp,
+		excluded.MemoryTemp, excluded.GraphicsVoltage, excluded.PowerDraw,
+		excluded.GraphicsClock, excluded.MaxGraphicsClock,
+		excluded.MemoryClock, excluded.MaxMemoryClock,
+		excluded.InUse, excluded.Users)`
+	}
+
+	_, err = tx.Exec(`INSERT INTO Stats
+		(Gpu, Received, MemoryUtilisation, GpuUtilisation, MemoryUsed,
+		FanSpeed, Temp, MemoryTemp, GraphicsVoltage, PowerDraw,
+		GraphicsClock, MaxGraphicsClock, MemoryClock, MaxMemoryClock,
+		InUse, Users)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12, ?13, ?14, ?15, ?16)
+		ON CONFLICT (Gpu, Received) `+conflict,
+		gpu.Uuid, received,
+		gpu.MemoryUtilisation, gpu.GPUUtilisation,
+		gpu.MemoryUsed, gpu.FanSpeed, gpu.Temp,
+		gpu.MemoryTemp, gpu.GraphicsVoltage, gpu.PowerDraw,
+		gpu.GraphicsClock, gpu.MaxGraphicsClock,
+		gpu.MemoryClock, gpu.MaxMemoryClock,
+		gpu.InUse, string(usersJSON))
+	return err
+}
+
+// Record the processes in the sample, unless we already have some from a
+// newer one
+func updateSqliteLatestProcesses(sample uplink.GPUStatSample, tx *sql.Tx) error {
+	procs := sample.Unfiltered()
+	if procs == nil {
+		procs = uplink.Processes{}
+	}
+	procsJSON, err := json.Marshal(procs)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO LatestProcesses (Gpu, Received, Processes)
+		VALUES (?1, ?2, ?3)
+		ON CONFLICT (Gpu) DO UPDATE
+		SET Received = excluded.Received, Processes = excluded.Processes
+		WHERE LatestProcesses.Received <= excluded.Received`,
+		sample.Uuid, sample.Time, string(procsJSON))
+	return err
+}
+
+// Extend the job each run of a process carries on, or start a new one, the
+// same way as postgres
+func recordSqliteJobs(runs []broadcast.Job, tx *sql.Tx) error {
+	for _, run := range runs {
+		var job broadcast.Job
+		row := tx.QueryRow(`SELECT Name, Owner, FirstSeen, LastSeen
+			FROM Jobs
+			WHERE Gpu=?1 AND Pid=?2
+			ORDER BY FirstSeen > ?3, ABS(FirstSeen - ?3)
+			LIMIT 1`,
+			run.Gpu, run.Pid, run.FirstSeen)
+		err := row.Scan(&job.Name, &job.Owner, &job.FirstSeen, &job.LastSeen)
+
+		if err == nil && continuesJob(job, run) {
+			_, err = tx.Exec(`UPDATE Jobs
+				SET FirstSeen=MIN(FirstSeen, ?4),
+					LastSeen=MAX(LastSeen, ?5),
+					PeakMemory=MAX(PeakMemory, ?6)
+				WHERE Gpu=?1 AND Pid=?2 AND FirstSeen=?3`,
+				run.Gpu, run.Pid, job.FirstSeen, run.FirstSeen, run.LastSeen, run.PeakMemory)
+		} else if err == nil || errors.Is(err, sql.ErrNoRows) {
+			_, err = tx.Exec(`INSERT INTO Jobs (Gpu, Pid, Name, Owner, FirstSeen, LastSeen, PeakMemory)
+				VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)
+				ON CONFLICT (Gpu, Pid, FirstSeen) DO NOTHING`,
+				run.Gpu, run.Pid, run.Name, run.Owner, run.FirstSeen, run.LastSeen, run.PeakMemory)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (conn SqliteConn) UpdateGPUContext(host string, packet uplink.GPUInfo) error {
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// what we had before, to see what's changed
+	var previous gpuInfo
+	err = tx.QueryRow(`SELECT Machine, DriverVersion FROM GPUs WHERE Uuid=?1`,
+		packet.Uuid).Scan(&previous.host, &previous.context.DriverVersion)
+	known := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	_, err = tx.Exec(`INSERT INTO GPUs
+		(Uuid, Machine, Name, Brand, DriverVersion, MemoryTotal, Backend)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)
+		ON CONFLICT (Uuid) DO UPDATE
+		SET Machine = excluded.Machine, Name = excluded.Name,
+			Brand = excluded.Brand, DriverVersion = excluded.DriverVersion,
+			MemoryTotal = excluded.MemoryTotal, Backend = excluded.Backend`,
+		packet.Uuid, host, packet.Name, packet.Brand,
+		packet.DriverVersion, packet.MemoryTotal, packet.Backend)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	err = recordSqliteInventoryEvents(inventoryChanges(previous, known, host, packet, time.Now()), tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	return tx.Commit()
+}
+
+func (conn SqliteConn) GPUMachines(uuids []uuid.UUID) (map[uuid.UUID]string, error) {
+	machines := make(map[uuid.UUID]string)
+	for _, gpu := range uuids {
+		var machine string
+		err := conn.db.QueryRow(`SELECT Machine FROM GPUs WHERE Uuid=?1`, gpu).Scan(&machine)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		machines[gpu] = machine
+	}
+
+	return machines, nil
+}
+
+func recordSqliteInventoryEvents(events []broadcast.InventoryEvent, tx *sql.Tx) error {
+	for _, event := range events {
+		_, err := tx.Exec(`INSERT INTO InventoryEvents
+			(Time, Hostname, Gpu, Name, Kind, FromValue, ToValue)
+			VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)`,
+			event.Time, event.Hostname, event.Gpu, event.Name,
+			event.Kind, event.From, event.To)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Buckets are averaged the same way as when bucketing history, and only
+// those that haven't been rolled up already are read
+func (conn SqliteConn) Downsample(now time.Time, policy RetentionPolicy) error {
+	windows, cutoff, deletes := policy.plan(now)
+
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if deletes {
+		_, err = tx.Exec(`DELETE FROM Stats WHERE Received < ?1`, cutoff.Unix())
+		if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+	}
+
+	for _, window := range windows {
+		err = rollUpSqlite(window, tx)
+		if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+	}
+
+	return tx.Commit()
+}
+
+func rollUpSqlite(window rollupWindow, tx *sql.Tx) error {
+	args := []any{window.to.Unix(), int64(window.step / time.Second)}
+	inWindow := "s.Received < ?1"
+	if !window.from.IsZero() {
+		args = append(args, window.from.Unix())
+		inWindow += " AND s.Received >= ?3"
+	}
+	bucket := "(s.Received - ((s.Received % ?2) + ?2) % ?2)"
+
+	rows, err := tx.Query(historicalSqliteQuery(fmt.Sprintf(`%[1]s
+		AND (s.Gpu, %[2]s) IN (
+			SELECT s.Gpu, %[2]s
+			FROM Stats s
+			WHERE %[1]s
+			GROUP BY 1, 2
+			HAVING COUNT(*) > 1 OR MIN(s.Received) <> MIN(%[2]s)
+		)`, inWindow, bucket)), args...)
+	if err != nil {
+		return err
+	}
+
+	samples, err := scanHistory(rows)
+	if err != nil {
+		return err
+	}
+
+	for _, gpu := range samples {
+		for _, point := range rollupPoints(gpu, window.step) {
+			_, err = tx.Exec(`DELETE FROM Stats
+				WHERE Gpu=?1 AND Received >= ?2 AND Received < ?3`,
+				point.Sample.Uuid, point.Timestamp, point.Timestamp+int64(window.step/time.Second))
+			if err != nil {
+				return err
+			}
+
+			insert, args, err := rollupInsert(point, point.Timestamp, func(n int) string {
+				return fmt.Sprintf("?%d", n)
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(insert, args...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (conn SqliteConn) LatestData() (broadcast.Workstations, error) {
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// only reading
+	defer tx.Rollback()
+
+	gpus, err := latestSqliteGpus(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, metadata, err := machineLabels(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := createdGroups(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT GroupName, Hostname, CPU, Motherboard,
+		Notes, Owner, LastSeen
+		FROM Machines`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make(map[string][]broadcast.Workstation)
+	for rows.Next() {
+		var groupName string
+		var machine broadcast.Workstation
+		var lastSeen int64
+
+		err = rows.Scan(&groupName, &machine.Name, &machine.CPU,
+			&machine.Motherboard, &machine.Notes, &machine.Owner, &lastSeen)
+		if err != nil {
+			return nil, err
+		}
+
+		// coalesce empty group names to default
+		if strings.TrimSpace(groupName) == "" {
+			groupName = DefaultGroup
+		}
+
+		machine.LastSeen = time.Since(time.UnixMicro(lastSeen))
+		machine.Tags, machine.Metadata = labelsFor(machine.Name, tags, metadata)
+		machine.Gpus = gpus[machine.Name]
+
+		groups[groupName] = append(groups[groupName], machine)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return arrangeGroups(created, groups), nil
+}
+
+// the latest stat for every gpu, by the machine they're in
+func latestSqliteGpus(tx *sql.Tx) (map[string][]broadcast.GPU, error) {
+	rows, err := tx.Query(`SELECT g.Machine, g.Uuid, g.Name, g.Brand,
+		g.DriverVersion, g.MemoryTotal, g.Backend,
+		s.MemoryUtilisation, s.GpuUtilisation,
+		s.MemoryUsed, s.FanSpeed, s.Temp, s.MemoryTemp,
+		s.GraphicsVoltage, s.PowerDraw, s.GraphicsClock,
+		s.MaxGraphicsClock, s.MemoryClock,
+		s.MaxMemoryClock, s.InUse, s.Users, g.Missing
+		FROM GPUs g INNER JOIN Stats s ON g.Uuid = s.Gpu
+		INNER JOIN (
+			SELECT Gpu, MAX(Received) Received
+			FROM Stats
+			GROUP BY Gpu
+		) latest ON s.Gpu = latest.Gpu
+			AND s.Received = latest.Received`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	gpus := make(map[string][]broadcast.GPU)
+	for rows.Next() {
+		var host string
+		var gpu broadcast.GPU
+		var users []byte
+		err = rows.Scan(&host, &gpu.Uuid, &gpu.Name, &gpu.Brand,
+			&gpu.DriverVersion, &gpu.MemoryTotal, &gpu.Backend,
+			&gpu.MemoryUtilisation,
+			&gpu.GPUUtilisation, &gpu.MemoryUsed,
+			&gpu.FanSpeed, &gpu.Temp,
+			&gpu.MemoryTemp, &gpu.GraphicsVoltage,
+			&gpu.PowerDraw, &gpu.GraphicsClock,
+			&gpu.MaxGraphicsClock, &gpu.MemoryClock,
+			&gpu.MaxMemoryClock, &gpu.InUse, &users, &gpu.Missing)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(users, &gpu.Users)
+		if err != nil {
+			return nil, err
+		}
+
+		gpus[host] = append(gpus[host], gpu)
+	}
+
+	return gpus, rows.Err()
+}
+
+func (conn SqliteConn) LastSeen() ([]broadcast.WorkstationSeen, error) {
+	rows, err := conn.db.Query(`SELECT Hostname, LastSeen FROM Machines`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var seens []broadcast.WorkstationSeen
+	for rows.Next() {
+		var seen broadcast.WorkstationSeen
+		var lastSeen int64
+
+		err = rows.Scan(&seen.Hostname, &lastSeen)
+		if err != nil {
+			return nil, err
+		}
+
+		seen.LastSeen = time.UnixMicro(lastSeen)
+		seens = append(seens, seen)
+	}
+
+	return seens, rows.Err()
+}
+
+func (conn SqliteConn) NewMachine(machine broadcast.NewMachine) error {
+	group := DefaultGroup
+	if machine.Group != nil {
+		group = *machine.Group
+	}
+
+	// we have not seen this machine yet, so give it 0 timestamp
+	_, err := conn.db.Exec(`INSERT INTO Machines (Hostname, GroupName, LastSeen)
+		VALUES (?1, ?2, ?3)
+		ON CONFLICT (Hostname) DO UPDATE
+		SET GroupName = excluded.GroupName`,
+		machine.Hostname, group, time.Unix(0, 0).UnixMicro())
+	return err
+}
+
+func (conn SqliteConn) RemoveMachine(machine broadcast.RemoveMachine) error {
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// everything referencing the machine or its gpus has to go first
+	deletes := []string{
+		`DELETE FROM Stats WHERE Gpu IN (SELECT Uuid FROM GPUs WHERE Machine=?1)`,
+		`DELETE FROM Jobs WHERE Gpu IN (SELECT Uuid FROM GPUs WHERE Machine=?1)`,
+		`DELETE FROM LatestProcesses WHERE Gpu IN (SELECT Uuid FROM GPUs WHERE Machine=?1)`,
+		`DELETE FROM GPUs WHERE Machine=?1`,
+		`DELETE FROM Files WHERE Hostname=?1`,
+		`DELETE FROM MachineSettings WHERE Hostname=?1`,
+		`DELETE FROM MachineTags WHERE Hostname=?1`,
+		`DELETE FROM MachineMetadata WHERE Hostname=?1`,
+		`DELETE FROM InventoryEvents WHERE Hostname=?1`,
+		`DELETE FROM Alerts WHERE Hostname=?1`,
+		`DELETE FROM Machines WHERE Hostname=?1`,
+	}
+
+	for _, query := range deletes {
+		_, err = tx.Exec(query, machine.Hostname)
+		if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Only the fields that are set are changed
+func (conn SqliteConn) UpdateMachine(machine broadcast.ModifyMachine) error {
+	_, err := conn.db.Exec(`UPDATE Machines
+		SET CPU=COALESCE(?2, CPU),
+			Motherboard=COALESCE(?3, Motherboard),
+			Notes=COALESCE(?4, Notes),
+			GroupName=COALESCE(?5, GroupName),
+			Owner=COALESCE(?6, Owner)
+		WHERE Hostname=?1`,
+		machine.Hostname, machine.CPU, machine.Motherboard,
+		machine.Notes, machine.Group, machine.Owner)
+	return err
+}
+
+func (conn SqliteConn) Groups() ([]broadcast.GroupInfo, error) {
+	return sqlGroups(conn.db)
+}
+
+// whether the group has been created or has machines in it
+func sqliteGroupExists(name string, tx *sql.Tx) (bool, error) {
+	var exists bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM MachineGroups WHERE Name=?1)
+		OR EXISTS (SELECT 1 FROM Machines WHERE `+sqlGroupOf+`=?1)`,
+		name).Scan(&exists)
+	return exists, err
+}
+
+func (conn SqliteConn) CreateGroup(group broadcast.GroupInfo) error {
+	name, err := normaliseGroupName(group.Name)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	exists, err := sqliteGroupExists(name, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	} else if exists {
+		return errors.Join(fmt.Errorf("%s: %w", name, ErrGroupExists), tx.Rollback())
+	}
+
+	_, err = tx.Exec(`INSERT INTO MachineGroups (Name, Description, Position)
+		VALUES (?1, ?2, ?3)`,
+		name, group.Description, group.Order)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	return tx.Commit()
+}
+
+func (conn SqliteConn) UpdateGroup(changes broadcast.ModifyGroup) error {
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	exists, err := sqliteGroupExists(changes.Name, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	} else if !exists {
+		return errors.Join(fmt.Errorf("%s: %w", changes.Name, ErrNoSuchGroup), tx.Rollback())
+	}
+
+	// groups that only have machines get created, to keep the changes
+	_, err = tx.Exec(`INSERT INTO MachineGroups (Name, Description, Position)
+		VALUES (?1, COALESCE(?2, ''), COALESCE(?3, 0))
+		ON CONFLICT (Name) DO UPDATE
+		SET Description = COALESCE(?2, Description),
+			Position = COALESCE(?3, Position)`,
+		changes.Name, changes.Description, changes.Order)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	if changes.NewName == nil || *changes.NewName == changes.Name {
+		return tx.Commit()
+	}
+
+	newName, err := normaliseGroupName(*changes.NewName)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	exists, err = sqliteGroupExists(newName, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	} else if exists {
+		return errors.Join(fmt.Errorf("%s: %w", newName, ErrGroupExists), tx.Rollback())
+	}
+
+	renames := []string{
+		`UPDATE MachineGroups SET Name=?2 WHERE Name=?1`,
+		`UPDATE Machines SET GroupName=?2 WHERE ` + sqlGroupOf + `=?1`,
+		`UPDATE GroupSettings SET GroupName=?2 WHERE GroupName=?1`,
+	}
+	for _, query := range renames {
+		_, err = tx.Exec(query, changes.Name, newName)
+		if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (conn SqliteConn) RemoveGroup(remove broadcast.RemoveGroup) error {
+	name, target, err := removedGroupTarget(remove)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	exists, err := sqliteGroupExists(name, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	} else if !exists {
+		return errors.Join(fmt.Errorf("%s: %w", name, ErrNoSuchGroup), tx.Rollback())
+	}
+
+	_, err = tx.Exec(`UPDATE Machines SET GroupName=?2 WHERE `+sqlGroupOf+`=?1`, name, target)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	for _, query := range []string{
+		`DELETE FROM GroupSettings WHERE GroupName=?1`,
+		`DELETE FROM MachineGroups WHERE Name=?1`,
+	} {
+		_, err = tx.Exec(query, name)
+		if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (conn SqliteConn) MoveMachines(move broadcast.MoveMachines) error {
+	group, err := normaliseGroupName(move.Group)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, hostname := range move.Hostnames {
+		err = sqliteMachineExists(hostname, tx)
+		if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+
+		_, err = tx.Exec(`UPDATE Machines SET GroupName=?2 WHERE Hostname=?1`, hostname, group)
+		if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (conn SqliteConn) AddTag(tag broadcast.MachineTag) error {
+	name, err := normaliseTag(tag.Tag)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = sqliteMachineExists(tag.Hostname, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	_, err = tx.Exec(`INSERT INTO MachineTags (Hostname, Tag)
+		VALUES (?1, ?2)
+		ON CONFLICT DO NOTHING`,
+		tag.Hostname, name)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	return tx.Commit()
+}
+
+func (conn SqliteConn) RemoveTag(tag broadcast.MachineTag) error {
+	_, err := conn.db.Exec(`DELETE FROM MachineTags
+		WHERE Hostname=?1 AND Tag=?2`,
+		tag.Hostname, strings.TrimSpace(tag.Tag))
+	return err
+}
+
+func (conn SqliteConn) SetMetadata(meta broadcast.SetMetadata) error {
+	metadata, err := normaliseMetadata(meta.Metadata)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = sqliteMachineExists(meta.Hostname, tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	_, err = tx.Exec(`INSERT INTO MachineMetadata (Hostname, Name, Type, Value)
+		VALUES (?1, ?2, ?3, ?4)
+		ON CONFLICT (Hostname, Name) DO UPDATE
+		SET Type = excluded.Type, Value = excluded.Value`,
+		meta.Hostname, metadata.Key, metadata.Type, metadata.Value)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	return tx.Commit()
+}
+
+func (conn SqliteConn) RemoveMetadata(remove broadcast.RemoveMetadata) error {
+	_, err := conn.db.Exec(`DELETE FROM MachineMetadata
+		WHERE Hostname=?1 AND Name=?2`,
+		remove.Hostname, strings.TrimSpace(remove.Key))
+	return err
+}
+
+// Drop drops all tables in the database, then closes it.
+//
+// This should only be used for testing purposes
+func (conn SqliteConn) Drop() error {
+	_, err := conn.db.Exec(`DROP TABLE Stats;
+		DROP TABLE Jobs;
+		DROP TABLE LatestProcesses;
+		DROP TABLE GPUs;
+		DROP TABLE Files;
+		DROP TABLE GroupSettings;
+		DROP TABLE MachineSettings;
+		DROP TABLE MachineTags;
+		DROP TABLE MachineMetadata;
+		DROP TABLE MachineGroups;
+		DROP TABLE InventoryEvents;
+		DROP TABLE Alerts;
+		DROP TABLE Machines`)
+	if err != nil {
+		return errors.Join(err, conn.db.Close())
+	}
+
+	return conn.db.Close()
+}
+
+func (conn SqliteConn) AttachFile(file broadcast.FileInfo) (string, error) {
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return "", err
+	}
+
+	var replaced string
+	err = tx.QueryRow(`SELECT StoreKey FROM Files
+		WHERE Hostname=?1 AND Filename=?2`,
+		file.Hostname, file.Filename).Scan(&replaced)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", errors.Join(err, tx.Rollback())
+	}
+
+	_, err = tx.Exec(`INSERT INTO Files (Hostname, Filename, Mime, StoreKey,
+		Size, Checksum, Uploader, UploadedAt)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8)
+		ON CONFLICT (Hostname, Filename) DO UPDATE
+		SET Mime = excluded.Mime, StoreKey = excluded.StoreKey,
+		Size = excluded.Size, Checksum = excluded.Checksum,
+		Uploader = excluded.Uploader, UploadedAt = excluded.UploadedAt`,
+		file.Hostname, file.Filename, file.Mime, file.Key,
+		file.Size, file.Checksum, file.Uploader, file.UploadedAt.UnixMicro(),
+	)
+	if err != nil {
+		return "", errors.Join(fmt.Errorf("%s: %w", file.Hostname, ErrNoSuchMachine), tx.Rollback())
+	}
+
+	return replaced, tx.Commit()
+}
+
+func scanSqliteFile(row interface{ Scan(...any) error }) (broadcast.FileInfo, error) {
+	var file broadcast.FileInfo
+	var uploadedAt int64
+	err := row.Scan(&file.Hostname, &file.Filename, &file.Mime, &file.Key,
+		&file.Size, &file.Checksum, &file.Uploader, &uploadedAt)
+	file.UploadedAt = time.UnixMicro(uploadedAt).UTC()
+	return file, err
+}
+
+func (conn SqliteConn) GetFile(hostname string, filename string) (broadcast.FileInfo, error) {
+	file, err := scanSqliteFile(conn.db.QueryRow(`SELECT Hostname, Filename, Mime,
+		StoreKey, Size, Checksum, Uploader, UploadedAt
+		FROM Files
+		WHERE Hostname=?1 AND Filename=?2`,
+		hostname, filename))
+	if errors.Is(err, sql.ErrNoRows) {
+		return file, fmt.Errorf("%s: %w", hostname, ErrFileNotPresent)
+	}
+
+	return file, err
+}
+
+func (conn SqliteConn) ListFiles(hostname string) ([]broadcast.FileInfo, error) {
+	rows, err := conn.db.Query(`SELECT Hostname, Filename, Mime,
+		StoreKey, Size, Checksum, Uploader, UploadedAt
+		FROM Files
+		WHERE Hostname=?1
+		ORDER BY Filename`,
+		hostname)
+	if err != nil {
+		return []broadcast.FileInfo{}, err
+	}
+	defer rows.Close()
+
+	res := []broadcast.FileInfo{}
+	for rows.Next() {
+		file, err := scanSqliteFile(rows)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, file)
+	}
+	return res, rows.Err()
+}
+
+func (conn SqliteConn) RemoveFile(remove broadcast.RemoveFile) (string, error) {
+	var removed string
+	err := conn.db.QueryRow(`DELETE FROM Files
+		WHERE Hostname=?1 AND Filename=?2
+		RETURNING StoreKey`,
+		remove.Hostname, remove.Filename).Scan(&removed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("%s: %w", remove.Hostname, ErrFileNotPresent)
+	}
+	return removed, err
+}
+
+// Samples are bucketed here rather than in SQL, so that they're aggregated
+// exactly the same way as in memory
+func (conn SqliteConn) HistoricalData(hostname string, query HistoryQuery) (broadcast.HistoricalData, error) {
+	if !query.Valid() {
+		return nil, ErrInvalidStep
+	}
+
+	args := []any{hostname}
+	conditions := []string{"g.Machine=?1"}
+	if !query.From.IsZero() {
+		args = append(args, query.From.Unix())
+		conditions = append(conditions, fmt.Sprintf("s.Received >= ?%d", len(args)))
+	}
+	if !query.To.IsZero() {
+		args = append(args, query.To.Unix())
+		conditions = append(conditions, fmt.Sprintf("s.Received <= ?%d", len(args)))
+	}
+	if query.Gpu != uuid.Nil {
+		args = append(args, query.Gpu)
+		conditions = append(conditions, fmt.Sprintf("s.Gpu = ?%d", len(args)))
+	}
+
+	rows, err := conn.db.Query(historicalSqliteQuery(strings.Join(conditions, " AND ")), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	samples, err := scanHistory(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	data := broadcast.HistoricalData{}
+	for _, gpu := range samples {
+		data = append(data, historyPoints(gpu, query.Step))
+	}
+	return data, nil
+}
+
+// every sample matching where, ordered by gpu then time
+func historicalSqliteQuery(where string) string {
+	return fmt.Sprintf(`SELECT s.Gpu, s.Received%s
+		FROM Stats s
+		INNER JOIN GPUs g ON g.Uuid = s.Gpu
+		WHERE %s
+		ORDER BY s.Gpu, s.Received`, historyColumns(), where)
+}
+
+// Like postgres, each sample's power draw counts for the time since the
+// previous one from its gpu
+func (conn SqliteConn) AggregateData() (broadcast.AggregateData, error) {
+	row := conn.db.QueryRow(`SELECT COALESCE(ROUND(SUM(PowerDraw * Seconds)), 0)
+		FROM (
+			SELECT PowerDraw,
+				Received - LAG(Received) OVER (PARTITION BY Gpu ORDER BY Received) AS Seconds
+			FROM Stats
+		)`)
+
+	var energy float64
+	err := row.Scan(&energy)
+	if err != nil {
+		return broadcast.AggregateData{}, err
+	}
+
+	return broadcast.AggregateData{TotalEnergy: uint64(energy)}, nil
+}
+
+func (conn SqliteConn) SettingsOverrides() ([]broadcast.SettingsOverride, error) {
+	rows, err := conn.db.Query(`SELECT GroupName, '', Settings FROM GroupSettings
+		UNION ALL
+		SELECT '', Hostname, Settings FROM MachineSettings`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := []broadcast.SettingsOverride{}
+	for rows.Next() {
+		var override broadcast.SettingsOverride
+		var settings []byte
+
+		err = rows.Scan(&override.Group, &override.Hostname, &settings)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(settings, &override.Settings)
+		if err != nil {
+			return nil, err
+		}
+
+		overrides = append(overrides, override)
+	}
+
+	return overrides, rows.Err()
+}
+
+func (conn SqliteConn) SetSettingsOverride(override broadcast.SettingsOverride) error {
+	var table, column, key string
+	switch {
+	case override.Group != "" && override.Hostname == "":
+		table, column, key = "GroupSettings", "GroupName", override.Group
+	case override.Hostname != "" && override.Group == "":
+		table, column, key = "MachineSettings", "Hostname", override.Hostname
+	default:
+		return ErrInvalidOverride
+	}
+
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if override.Hostname != "" {
+		err = sqliteMachineExists(override.Hostname, tx)
+		if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+	}
+
+	if override.Settings.IsZero() {
+		_, err = tx.Exec(`DELETE FROM `+table+` WHERE `+column+`=?1`, key)
+		if err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+		return tx.Commit()
+	}
+
+	settings, err := json.Marshal(override.Settings)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	_, err = tx.Exec(`INSERT INTO `+table+` (`+column+`, Settings)
+		VALUES (?1, ?2)
+		ON CONFLICT (`+column+`) DO UPDATE
+		SET Settings = excluded.Settings`,
+		key, string(settings))
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	return tx.Commit()
+}
+
+// ErrNoSuchMachine if there's no machine called hostname
+func sqliteMachineExists(hostname string, tx *sql.Tx) error {
+	var found string
+	err := tx.QueryRow(`SELECT Hostname FROM Machines WHERE Hostname=?1`, hostname).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s: %w", hostname, ErrNoSuchMachine)
+	}
+	return err
+}
+
+func (conn SqliteConn) SettingsFor(hostname string) (uplink.Settings, uplink.Settings, error) {
+	var group, machine uplink.Settings
+	var groupJSON, machineJSON []byte
+
+	row := conn.db.QueryRow(`SELECT g.Settings, s.Settings
+		FROM Machines m
+		LEFT JOIN GroupSettings g ON g.GroupName = COALESCE(NULLIF(m.GroupName, ''), ?2)
+		LEFT JOIN MachineSettings s ON s.Hostname = m.Hostname
+		WHERE m.Hostname=?1`,
+		hostname, DefaultGroup)
+	err := row.Scan(&groupJSON, &machineJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		return group, machine, nil
+	} else if err != nil {
+		return group, machine, err
+	}
+
+	if groupJSON != nil {
+		err = json.Unmarshal(groupJSON, &group)
+		if err != nil {
+			return group, machine, err
+		}
+	}
+	if machineJSON != nil {
+		err = json.Unmarshal(machineJSON, &machine)
+	}
+	return group, machine, err
+}
+
+func (conn SqliteConn) LatestProcesses(hostname string) ([]broadcast.GPUProcesses, error) {
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// only reading
+	defer tx.Rollback()
+
+	err = sqliteMachineExists(hostname, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT g.Uuid, COALESCE(p.Processes, '[]')
+		FROM GPUs g
+		LEFT JOIN LatestProcesses p ON p.Gpu = g.Uuid
+		WHERE g.Machine=?1`,
+		hostname)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []broadcast.GPUProcesses{}
+	for rows.Next() {
+		var gpu broadcast.GPUProcesses
+		var procs []byte
+
+		err = rows.Scan(&gpu.Uuid, &procs)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(procs, &gpu.Processes)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, gpu)
+	}
+
+	return result, rows.Err()
+}
+
+func (conn SqliteConn) Jobs(hostname string, from time.Time, to time.Time) ([]broadcast.Job, error) {
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// only reading
+	defer tx.Rollback()
+
+	err = sqliteMachineExists(hostname, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	// a job is still running if it was in the gpu's latest sample
+	rows, err := tx.Query(`SELECT j.Gpu, j.Pid, j.Name, j.Owner,
+		j.FirstSeen, j.LastSeen, j.PeakMemory,
+		COALESCE(j.LastSeen >= p.Received, FALSE)
+		FROM Jobs j
+		INNER JOIN GPUs g ON g.Uuid = j.Gpu
+		LEFT JOIN LatestProcesses p ON p.Gpu = j.Gpu
+		WHERE g.Machine=?1 AND j.FirstSeen <= ?3 AND j.LastSeen >= ?2
+		ORDER BY j.LastSeen DESC, j.FirstSeen DESC, j.Gpu, j.Pid`,
+		hostname, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []broadcast.Job{}
+	for rows.Next() {
+		var job broadcast.Job
+		err = rows.Scan(&job.Gpu, &job.Pid, &job.Name, &job.Owner,
+			&job.FirstSeen, &job.LastSeen, &job.PeakMemory, &job.Running)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, job)
+	}
+
+	return result, rows.Err()
+}
+
+// Each sample accounts for the time since the previous one from its gpu, up
+// to maxGap for each sample it stands for, split between its users by
+// addUsage, the same as in memory. Only the samples in the window are read,
+// and the one before it from each gpu
+func (conn SqliteConn) Usage(from time.Time, to time.Time, by UsageBy, maxGap time.Duration) ([]broadcast.Usage, error) {
+	if !by.Valid() {
+		return nil, ErrInvalidUsageBy
+	}
+
+	rows, err := conn.db.Query(`SELECT i.Gap, i.Samples, i.GpuUtilisation, i.PowerDraw,
+			i.Users, g.Machine, m.GroupName
+		FROM (
+			SELECT s.Gpu, s.Received, s.Samples, s.GpuUtilisation, s.PowerDraw, s.Users,
+				s.Received - LAG(s.Received) OVER (PARTITION BY s.Gpu ORDER BY s.Received) AS Gap
+			FROM (
+				SELECT Uuid, COALESCE((
+					SELECT MAX(Received) FROM Stats
+					WHERE Gpu = Uuid AND Received <= ?1
+				), ?1) AS Since
+				FROM GPUs
+			) p
+			INNER JOIN Stats s ON s.Gpu = p.Uuid
+				AND s.Received >= p.Since AND s.Received <= ?2
+		) i
+		INNER JOIN GPUs g ON g.Uuid = i.Gpu
+		INNER JOIN Machines m ON m.Hostname = g.Machine
+		WHERE i.Received > ?1 AND i.Gap IS NOT NULL`,
+		from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make(map[string]*usageTotal)
+	for rows.Next() {
+		var gap int64
+		var samples int
+		var sample broadcast.GPU
+		var users []byte
+		var machine, group string
+
+		err = rows.Scan(&gap, &samples, &sample.GPUUtilisation, &sample.PowerDraw,
+			&users, &machine, &group)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(users, &sample.Users)
+		if err != nil {
+			return nil, err
+		}
+
+		owner := machine
+		if by == UsageByGroup {
+			owner = cmp.Or(group, DefaultGroup)
+		}
+		addUsage(totals, by, owner, usageSeconds(gap, samples, maxGap), sample)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return usageFromTotals(totals), nil
+}
+
+func (conn SqliteConn) InventoryEvents(hostname string, from time.Time, to time.Time) ([]broadcast.InventoryEvent, error) {
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// only reading
+	defer tx.Rollback()
+
+	err = sqliteMachineExists(hostname, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT Time, Hostname, Gpu, Name, Kind,
+		FromValue, ToValue
+		FROM InventoryEvents
+		WHERE (Hostname=?1 OR (Kind=?4 AND FromValue=?1))
+			AND Time >= ?2 AND Time <= ?3
+		ORDER BY Time DESC, Id DESC`,
+		hostname, from.Unix(), to.Unix(), broadcast.GPUMoved)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []broadcast.InventoryEvent{}
+	for rows.Next() {
+		var event broadcast.InventoryEvent
+		err = rows.Scan(&event.Time, &event.Hostname, &event.Gpu, &event.Name,
+			&event.Kind, &event.From, &event.To)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, event)
+	}
+
+	return result, rows.Err()
+}
+
+func (conn SqliteConn) FlagMissingGPUs(now time.Time, timeout time.Duration, slower map[string]time.Duration) ([]broadcast.InventoryEvent, error) {
+	seconds, err := slowerJSON(slower)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := conn.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := now.Add(-timeout)
+
+	// how long ago a gpu's machine has to have sent its last sample by
+	const gpuCutoff = `COALESCE(
+		?3 - (SELECT value FROM json_each(?4) WHERE key = GPUs.Machine),
+		?1)`
+
+	// gpus without any samples haven't started reporting, so can't stop
+	missing, err := flagGpus(tx, broadcast.GPUMissing, now, `UPDATE GPUs
+		SET Missing = TRUE
+		WHERE NOT Missing
+			AND (SELECT MAX(Received) FROM Stats WHERE Gpu = GPUs.Uuid) < `+gpuCutoff+`
+			AND Machine IN (SELECT Hostname FROM Machines WHERE LastSeen >= ?2)
+		RETURNING Uuid, Machine, Name`, cutoff.Unix(), cutoff.UnixMicro(), now.Unix(), seconds)
+	if err != nil {
+		return nil, errors.Join(err, tx.Rollback())
+	}
+
+	returned, err := flagGpus(tx, broadcast.GPUReturned, now, `UPDATE GPUs
+		SET Missing = FALSE
+		WHERE Missing
+			AND (SELECT MAX(Received) FROM Stats WHERE Gpu = GPUs.Uuid) >= `+gpuCutoff+`
+		RETURNING Uuid, Machine, Name`, cutoff.Unix(), cutoff.UnixMicro(), now.Unix(), seconds)
+	if err != nil {
+		return nil, errors.Join(err, tx.Rollback())
+	}
+
+	events := append(missing, returned...)
+	err = recordSqliteInventoryEvents(events, tx)
+	if err != nil {
+		return nil, errors.Join(err, tx.Rollback())
+	}
+
+	return events, tx.Commit()
+}
+
+// an alert's time for a nullable column, null if it's unset
+func sqliteAlertTime(unix int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: unix, Valid: unix != 0}
+}
+
+func (conn SqliteConn) RecordAlert(alert broadcast.Alert) (int64, error) {
+	if alert.Id == 0 {
+		err := conn.db.QueryRow(`INSERT INTO Alerts
+			(Rule, Expression, Hostname, Gpu, State, Value, Since, FiredAt, ResolvedAt)
+			VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9)
+			RETURNING Id`,
+			alert.Rule, alert.Expression, alert.Hostname, alert.Gpu,
+			alert.State, alert.Value, alert.Since,
+			sqliteAlertTime(alert.FiredAt), sqliteAlertTime(alert.ResolvedAt)).Scan(&alert.Id)
+		return alert.Id, err
+	}
+
+	_, err := conn.db.Exec(`UPDATE Alerts
+		SET Rule = ?2, Expression = ?3, Hostname = ?4, Gpu = ?5, State = ?6,
+			Value = ?7, Since = ?8, FiredAt = ?9, ResolvedAt = ?10
+		WHERE Id=?1`,
+		alert.Id, alert.Rule, alert.Expression, alert.Hostname, alert.Gpu,
+		alert.State, alert.Value, alert.Since,
+		sqliteAlertTime(alert.FiredAt), sqliteAlertTime(alert.ResolvedAt))
+	return alert.Id, err
+}
+
+func (conn SqliteConn) RemoveAlert(id int64) error {
+	_, err := conn.db.Exec(`DELETE FROM Alerts WHERE Id=?1`, id)
+	return err
+}
+
+func (conn SqliteConn) ActiveAlerts() ([]broadcast.Alert, error) {
+	return conn.queryAlerts(`WHERE State <> ?1
+		ORDER BY Since, Id`,
+		broadcast.AlertResolved)
+}
+
+func (conn SqliteConn) AlertHistory(from time.Time, to time.Time) ([]broadcast.Alert, error) {
+	return conn.queryAlerts(`WHERE FiredAt <= ?2
+			AND (ResolvedAt IS NULL OR ResolvedAt >= ?1)
+		ORDER BY FiredAt DESC, Id DESC`,
+		from.Unix(), to.Unix())
+}
+
+func (conn SqliteConn) queryAlerts(where string, args ...any) ([]broadcast.Alert, error) {
+	rows, err := conn.db.Query(`SELECT Id, Rule, Expression, Hostname, Gpu,
+		State, Value, Since, FiredAt, ResolvedAt
+		FROM Alerts `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []broadcast.Alert{}
+	for rows.Next() {
+		var alert broadcast.Alert
+		var firedAt, resolvedAt sql.NullInt64
+
+		err = rows.Scan(&alert.Id, &alert.Rule, &alert.Expression,
+			&alert.Hostname, &alert.Gpu, &alert.State, &alert.Value,
+			&alert.Since, &firedAt, &resolvedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		alert.FiredAt = firedAt.Int64
+		alert.ResolvedAt = resolvedAt.Int64
+		result = append(result, alert)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,35 @@
+package database_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gpuctl/gpuctl/internal/database"
+)
+
+// run all the database unit tests on the sqlite implementation
+func TestSqlite(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range UnitTests {
+		t.Run(test.Name, func(t *testing.T) {
+			dbi, err := database.Sqlite(filepath.Join(t.TempDir(), "control.db"))
+			if err != nil {
+				t.Fatalf("Failed to open database: %v", err)
+			}
+
+			db, ok := dbi.(database.SqliteConn)
+			if !ok {
+				t.Fatal("database.Sqlite didn't return database.SqliteConn")
+			}
+
+			t.Cleanup(func() {
+				if err := db.Drop(); err != nil {
+					t.Fatal("Failed to drop database", err)
+				}
+			})
+
+			test.F(t, db)
+		})
+	}
+}
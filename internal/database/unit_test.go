@@ -6,8 +6,6 @@
 package database_test
 
 import (
-	_ "embed"
-	"encoding/base64"
 	"log/slog"
 	"math"
 	"reflect"
@@ -22,13 +20,19 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-//go:embed testdata/uploadtest.pdf
-var uploadPdfBytes []byte
-var uploadPdfEnc = base64.StdEncoding.EncodeToString(uploadPdfBytes)
-
-//go:embed testdata/more.txt
-var uploadTxtBytes []byte
-var uploadTxtEnc = base64.StdEncoding.EncodeToString(uploadTxtBytes)
+// an attached file, whose contents would be in the file store under key
+func fakeFile(hostname string, filename string, mime string, key string) broadcast.FileInfo {
+	return broadcast.FileInfo{
+		Hostname:   hostname,
+		Filename:   filename,
+		Mime:       mime,
+		Size:       1234,
+		Checksum:   "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		Uploader:   "admin",
+		UploadedAt: time.Date(2024, time.March, 1, 12, 30, 0, 0, time.UTC),
+		Key:        key,
+	}
+}
 
 type unitTest struct {
 	Name string
@@ -46,6 +50,7 @@ var UnitTests = [...]unitTest{
 	{"LastSeen1", testLastSeen1},
 	{"LastSeen2", testLastSeen2},
 	{"OneGpu", oneGpu},
+	{"GPUMachinesAreKnown", gpuMachinesAreKnown},
 	{"MachineInfoStartsEmpty", machineInfoStartsEmpty},
 	{"MachineInfoUpdatesWork", machineInfoUpdatesWork},
 	{"AttachingFiles", attachAndGetFile},
@@ -58,8 +63,48 @@ var UnitTests = [...]unitTest{
 	{"MachinesWithSamplesCanBeRemoved", removingMachineAndSamples},
 	{"InUseInformation", inUseInformation},
 	{"RemovingMachineRemovesFiles", removingMachineRemoveFiles},
+	{"TagsAndMetadataAreInLatestData", tagsAndMetadataAreInLatestData},
+	{"MetadataIsTyped", metadataIsTyped},
+	{"LabellingUnknownMachine", labellingUnknownMachine},
+	{"RemovingMachineRemovesLabels", removingMachineRemovesLabels},
+	{"GroupsCanBeCreated", groupsCanBeCreated},
+	{"RenamingGroupsTakesTheirMachines", renamingGroupsTakesTheirMachines},
+	{"RemovingGroupsMovesTheirMachines", removingGroupsMovesTheirMachines},
+	{"MovingMachinesIsAllOrNothing", movingMachinesIsAllOrNothing},
 	{"AddMachineAddsMachines", addingMachines},
 	{"DoesNotUpdateNonexistentMachines", doesNotUpdateNonexistentMachines},
+	{"BackfilledSamplesKeepTheirTime", backfilledSamplesKeepTheirTime},
+	{"AppendingManyDataPoints", appendingManyDataPoints},
+	{"AppendingManyFailsIfAnyContextMissing", appendingManyFailsIfAnyContextMissing},
+	{"AppendingBatchesBiggerThanOneInsert", appendingBatchesBiggerThanOneInsert},
+	{"SettingsOverridesStartEmpty", settingsOverridesStartEmpty},
+	{"SettingsOverridesApplyToGroupsAndMachines", settingsOverridesApplyToGroupsAndMachines},
+	{"SettingsOverridesCanBeRemoved", settingsOverridesCanBeRemoved},
+	{"SettingsOverridesNeedOneTarget", settingsOverridesNeedOneTarget},
+	{"LatestProcessesIncludeFilteredOnes", latestProcessesIncludeFilteredOnes},
+	{"LatestProcessesOfUnknownMachine", latestProcessesOfUnknownMachine},
+	{"JobsTrackProcessLifetimes", jobsTrackProcessLifetimes},
+	{"JobsEndAfterAGap", jobsEndAfterAGap},
+	{"JobsOutsideTheWindowAreHidden", jobsOutsideTheWindowAreHidden},
+	{"JobsOfUnknownMachine", jobsOfUnknownMachine},
+	{"InventoryTracksGpuChanges", inventoryTracksGpuChanges},
+	{"MissingGpusAreFlagged", missingGpusAreFlagged},
+	{"SlowerMachinesGpusAreFlaggedLater", slowerMachinesGpusAreFlaggedLater},
+	{"InventoryOfUnknownMachine", inventoryOfUnknownMachine},
+	{"AlertsMoveThroughTheirStates", alertsMoveThroughTheirStates},
+	{"PendingAlertsCanBeRemoved", pendingAlertsCanBeRemoved},
+	{"RemovingMachineRemovesAlerts", removingMachineRemovesAlerts},
+	{"UsageIsSplitBetweenUsers", usageIsSplitBetweenUsers},
+	{"UsageOnlyCountsTheWindow", usageOnlyCountsTheWindow},
+	{"UsageByUnknownGrouping", usageByUnknownGrouping},
+	{"UsageIsCappedOverGaps", usageIsCappedOverGaps},
+	{"HistoricalDataCanBeLimited", historicalDataCanBeLimited},
+	{"HistoricalDataCanBeBucketed", historicalDataCanBeBucketed},
+	{"HistoricalDataOfUnknownMachine", historicalDataOfUnknownMachine},
+	{"AggregateDataStartsEmpty", aggregateDataStartsEmpty},
+	{"AggregateDataTotalsEnergy", aggregateDataTotalsEnergy},
+	{"DownsamplingFollowsRetention", downsamplingFollowsRetention},
+	{"RollupsKeepTheirExtremes", rollupsKeepTheirExtremes},
 }
 
 // fake data for adding during tests
@@ -137,13 +182,13 @@ func statsNear(target broadcast.GPU, stat uplink.GPUStatSample, context uplink.G
 	}
 
 	// compare running processes
-	inUse, user := stat.RunningProcesses.Summarise()
+	inUse, users := stat.RunningProcesses.Summarise()
 	if target.InUse != inUse {
 		slog.Error("InUse didn't match", "was", target.InUse, "wanted", inUse)
 		return false
 	}
-	if target.User != user {
-		slog.Error("User didn't match", "was", target.User, "wanted", user)
+	if !reflect.DeepEqual(target.Users, users) {
+		slog.Error("Users didn't match", "was", target.Users, "wanted", users)
 		return false
 	}
 
@@ -160,8 +205,9 @@ func statsNear(target broadcast.GPU, stat uplink.GPUStatSample, context uplink.G
 			if field.Name == "Time" {
 				continue
 			}
-			// we've already checked running processes
-			if field.Name == "RunningProcesses" {
+			// we've already checked running processes, and the
+			// unfiltered ones aren't part of the latest data
+			if field.Name == "RunningProcesses" || field.Name == "AllProcesses" {
 				continue
 			}
 
@@ -380,6 +426,24 @@ func oneGpu(t *testing.T, db database.Database) {
 	assert.Len(t, data, 1)
 }
 
+// the machine each gpu is in, once it's sent its context
+func gpuMachinesAreKnown(t *testing.T, db database.Database) {
+	unknown := uuid.MustParse("00bb654e-1823-46ae-a26c-e884e2f00ff4")
+
+	machines, err := db.GPUMachines([]uuid.UUID{fakeDataInfo.Uuid, unknown})
+	assert.NoError(t, err)
+	assert.Empty(t, machines)
+
+	err = db.UpdateLastSeen("foo", time.Now())
+	assert.NoError(t, err)
+	err = db.UpdateGPUContext("foo", fakeDataInfo)
+	assert.NoError(t, err)
+
+	machines, err = db.GPUMachines([]uuid.UUID{fakeDataInfo.Uuid, unknown})
+	assert.NoError(t, err)
+	assert.Equal(t, map[uuid.UUID]string{fakeDataInfo.Uuid: "foo"}, machines)
+}
+
 // a machines info starts empty
 func machineInfoStartsEmpty(t *testing.T, db database.Database) {
 	fakeHost := "porcupine"
@@ -584,23 +648,17 @@ func attachAndGetFile(t *testing.T, db database.Database) {
 	err := db.NewMachine(broadcast.NewMachine{Hostname: fakeHost, Group: &fakeGroup})
 	assert.NoError(t, err)
 
-	payload := broadcast.AttachFile{
-		Hostname:    fakeHost,
-		Mime:        "application/pdf",
-		Filename:    "test",
-		EncodedFile: uploadPdfEnc,
-	}
+	payload := fakeFile(fakeHost, "test", "application/pdf", "key-1")
 
 	// Put file in db
-	err = db.AttachFile(payload)
+	replaced, err := db.AttachFile(payload)
 	assert.NoError(t, err)
+	assert.Empty(t, replaced)
 
 	// Now get file
 	resp, err := db.GetFile(fakeHost, payload.Filename)
 	assert.NoError(t, err)
-	assert.Equal(t, uploadPdfEnc, resp.EncodedFile)
-	assert.Equal(t, "application/pdf", resp.Mime)
-	assert.Equal(t, fakeHost, resp.Hostname)
+	assert.Equal(t, payload, resp)
 }
 
 func gettingNonExistentFile(t *testing.T, db database.Database) {
@@ -618,12 +676,8 @@ func gettingNonExistentFile(t *testing.T, db database.Database) {
 }
 
 func attachFileToNonExistentHost(t *testing.T, db database.Database) {
-	payload := broadcast.AttachFile{
-		Hostname:    "mystery",
-		Mime:        "application/pdf",
-		EncodedFile: uploadPdfEnc,
-	}
-	err := db.AttachFile(payload)
+	payload := fakeFile("mystery", "test", "application/pdf", "key-1")
+	_, err := db.AttachFile(payload)
 	assert.ErrorIs(t, err, database.ErrNoSuchMachine)
 }
 
@@ -632,30 +686,18 @@ func listFiles(t *testing.T, db database.Database) {
 	fakeGroup := "Shared"
 	err := db.NewMachine(broadcast.NewMachine{Hostname: fakeHost, Group: &fakeGroup})
 	assert.NoError(t, err)
-	pdf := broadcast.AttachFile{
-		Hostname:    fakeHost,
-		Mime:        "application/pdf",
-		Filename:    "testpdf",
-		EncodedFile: uploadPdfEnc,
-	}
 
-	txt := broadcast.AttachFile{
-		Hostname:    fakeHost,
-		Mime:        "text/plain",
-		Filename:    "testtxt",
-		EncodedFile: uploadTxtEnc,
-	}
+	pdf := fakeFile(fakeHost, "testpdf", "application/pdf", "key-1")
+	txt := fakeFile(fakeHost, "testtxt", "text/plain", "key-2")
 
-	err = db.AttachFile(pdf)
+	_, err = db.AttachFile(txt)
 	assert.NoError(t, err)
-	err = db.AttachFile(txt)
+	_, err = db.AttachFile(pdf)
 	assert.NoError(t, err)
 
 	files, err := db.ListFiles(fakeHost)
 	assert.NoError(t, err)
-	assert.Equal(t, 2, len(files))
-
-	assert.ElementsMatch(t, files, []string{pdf.Filename, txt.Filename})
+	assert.Equal(t, []broadcast.FileInfo{pdf, txt}, files)
 }
 
 func removeFile(t *testing.T, db database.Database) {
@@ -664,18 +706,14 @@ func removeFile(t *testing.T, db database.Database) {
 	err := db.NewMachine(broadcast.NewMachine{Hostname: fakeHost, Group: &fakeGroup})
 	assert.NoError(t, err)
 
-	pdf := broadcast.AttachFile{
-		Hostname:    fakeHost,
-		Mime:        "application/pdf",
-		Filename:    "filename",
-		EncodedFile: uploadPdfEnc,
-	}
+	pdf := fakeFile(fakeHost, "filename", "application/pdf", "key-1")
 
-	err = db.AttachFile(pdf)
+	_, err = db.AttachFile(pdf)
 	assert.NoError(t, err)
 
-	err = db.RemoveFile(broadcast.RemoveFile{Hostname: fakeHost, Filename: pdf.Filename})
+	removed, err := db.RemoveFile(broadcast.RemoveFile{Hostname: fakeHost, Filename: pdf.Filename})
 	assert.NoError(t, err)
+	assert.Equal(t, pdf.Key, removed)
 
 	_, err = db.GetFile(fakeHost, pdf.Filename)
 	assert.ErrorIs(t, err, database.ErrFileNotPresent)
@@ -687,10 +725,10 @@ func removeWrongFile(t *testing.T, db database.Database) {
 	err := db.NewMachine(broadcast.NewMachine{Hostname: fakeHost, Group: &fakeGroup})
 	assert.NoError(t, err)
 
-	err = db.RemoveFile(broadcast.RemoveFile{Hostname: "mystery", Filename: "doesnt exist"})
+	_, err = db.RemoveFile(broadcast.RemoveFile{Hostname: "mystery", Filename: "doesnt exist"})
 	assert.ErrorIs(t, err, database.ErrFileNotPresent)
 
-	err = db.RemoveFile(broadcast.RemoveFile{Hostname: "real", Filename: "doesnt exist"})
+	_, err = db.RemoveFile(broadcast.RemoveFile{Hostname: "real", Filename: "doesnt exist"})
 	assert.ErrorIs(t, err, database.ErrFileNotPresent)
 }
 
@@ -700,28 +738,24 @@ func additionRemoveOldFile(t *testing.T, db database.Database) {
 	err := db.NewMachine(broadcast.NewMachine{Hostname: fakeHost, Group: &fakeGroup})
 	assert.NoError(t, err)
 
-	pdf1 := broadcast.AttachFile{
-		Hostname:    fakeHost,
-		Mime:        "application/pdf",
-		Filename:    "filename",
-		EncodedFile: uploadPdfEnc,
-	}
-
-	text := broadcast.AttachFile{
-		Hostname:    fakeHost,
-		Mime:        "plain/text",
-		Filename:    "filename",
-		EncodedFile: uploadTxtEnc,
-	}
+	pdf := fakeFile(fakeHost, "filename", "application/pdf", "key-1")
+	text := fakeFile(fakeHost, "filename", "plain/text", "key-2")
+	text.Uploader = "someone else"
 
-	err = db.AttachFile(pdf1)
+	_, err = db.AttachFile(pdf)
 	assert.NoError(t, err)
-	err = db.AttachFile(text)
+	replaced, err := db.AttachFile(text)
 	assert.NoError(t, err)
+	assert.Equal(t, pdf.Key, replaced)
+
 	files, err := db.ListFiles(fakeHost)
-	assert.Equal(t, 1, len(files))
+	assert.NoError(t, err)
+	if !assert.Equal(t, 1, len(files)) {
+		return
+	}
 
-	file, err := db.GetFile(fakeHost, files[0])
+	file, err := db.GetFile(fakeHost, files[0].Filename)
+	assert.NoError(t, err)
 	assert.Equal(t, text, file)
 }
 
@@ -731,14 +765,9 @@ func removingMachineRemoveFiles(t *testing.T, db database.Database) {
 	err := db.NewMachine(broadcast.NewMachine{Hostname: fakeHost, Group: &fakeGroup})
 	assert.NoError(t, err)
 
-	pdf := broadcast.AttachFile{
-		Hostname:    fakeHost,
-		Mime:        "application/pdf",
-		Filename:    "filename",
-		EncodedFile: uploadPdfEnc,
-	}
+	pdf := fakeFile(fakeHost, "filename", "application/pdf", "key-1")
 
-	err = db.AttachFile(pdf)
+	_, err = db.AttachFile(pdf)
 	assert.NoError(t, err)
 
 	err = db.RemoveMachine(broadcast.RemoveMachine{Hostname: fakeHost})
@@ -748,6 +777,241 @@ func removingMachineRemoveFiles(t *testing.T, db database.Database) {
 	assert.ErrorIs(t, err, database.ErrFileNotPresent)
 }
 
+func tagsAndMetadataAreInLatestData(t *testing.T, db database.Database) {
+	fakeHost := "kittiwake"
+	assert.NoError(t, db.UpdateLastSeen(fakeHost, time.Now()))
+
+	data, err := db.LatestData()
+	assert.NoError(t, err)
+	_, _, machine := getMachine(data, fakeHost)
+	assert.Equal(t, []string{}, machine.Tags)
+	assert.Equal(t, []broadcast.Metadata{}, machine.Metadata)
+
+	for _, tag := range []string{"teaching", " a100 ", "teaching", "old"} {
+		assert.NoError(t, db.AddTag(broadcast.MachineTag{Hostname: fakeHost, Tag: tag}))
+	}
+	assert.NoError(t, db.RemoveTag(broadcast.MachineTag{Hostname: fakeHost, Tag: "old"}))
+	assert.NoError(t, db.RemoveTag(broadcast.MachineTag{Hostname: fakeHost, Tag: "never added"}))
+
+	assert.NoError(t, db.SetMetadata(broadcast.SetMetadata{Hostname: fakeHost, Metadata: broadcast.Metadata{
+		Key: "room", Type: broadcast.MetadataString, Value: "Huxley 219",
+	}}))
+	assert.NoError(t, db.SetMetadata(broadcast.SetMetadata{Hostname: fakeHost, Metadata: broadcast.Metadata{
+		Key: "asset", Type: broadcast.MetadataNumber, Value: "1234",
+	}}))
+	assert.NoError(t, db.SetMetadata(broadcast.SetMetadata{Hostname: fakeHost, Metadata: broadcast.Metadata{
+		Key: "warranty", Type: broadcast.MetadataDate, Value: "2027-03-31",
+	}}))
+	// replaced, type and all
+	assert.NoError(t, db.SetMetadata(broadcast.SetMetadata{Hostname: fakeHost, Metadata: broadcast.Metadata{
+		Key: "asset", Type: broadcast.MetadataString, Value: "DOC-1234",
+	}}))
+	assert.NoError(t, db.RemoveMetadata(broadcast.RemoveMetadata{Hostname: fakeHost, Key: "warranty"}))
+
+	data, err = db.LatestData()
+	assert.NoError(t, err)
+	_, _, machine = getMachine(data, fakeHost)
+	assert.Equal(t, []string{"a100", "teaching"}, machine.Tags)
+	assert.Equal(t, []broadcast.Metadata{
+		{Key: "asset", Type: broadcast.MetadataString, Value: "DOC-1234"},
+		{Key: "room", Type: broadcast.MetadataString, Value: "Huxley 219"},
+	}, machine.Metadata)
+}
+
+// values are checked against their type, and always written the same way
+func metadataIsTyped(t *testing.T, db database.Database) {
+	fakeHost := "gannet"
+	assert.NoError(t, db.UpdateLastSeen(fakeHost, time.Now()))
+
+	set := func(key string, kind broadcast.MetadataType, value string) error {
+		return db.SetMetadata(broadcast.SetMetadata{Hostname: fakeHost, Metadata: broadcast.Metadata{
+			Key: key, Type: kind, Value: value,
+		}})
+	}
+
+	assert.NoError(t, set("grant", broadcast.MetadataNumber, " 2.50 "))
+	assert.NoError(t, set("bought", broadcast.MetadataDate, "2024-09-01"))
+	assert.NoError(t, set("loaned", broadcast.MetadataBool, "TRUE"))
+
+	assert.ErrorIs(t, set("grant", broadcast.MetadataNumber, "lots"), database.ErrInvalidMetadata)
+	assert.ErrorIs(t, set("bought", broadcast.MetadataDate, "01/09/2024"), database.ErrInvalidMetadata)
+	assert.ErrorIs(t, set("loaned", broadcast.MetadataBool, "maybe"), database.ErrInvalidMetadata)
+	assert.ErrorIs(t, set("colour", "colour", "red"), database.ErrInvalidMetadata)
+	assert.ErrorIs(t, set(" ", broadcast.MetadataString, "no key"), database.ErrInvalidMetadata)
+
+	data, err := db.LatestData()
+	assert.NoError(t, err)
+	_, _, machine := getMachine(data, fakeHost)
+	assert.Equal(t, []broadcast.Metadata{
+		{Key: "bought", Type: broadcast.MetadataDate, Value: "2024-09-01"},
+		{Key: "grant", Type: broadcast.MetadataNumber, Value: "2.5"},
+		{Key: "loaned", Type: broadcast.MetadataBool, Value: "true"},
+	}, machine.Metadata)
+}
+
+func labellingUnknownMachine(t *testing.T, db database.Database) {
+	err := db.AddTag(broadcast.MachineTag{Hostname: "moa", Tag: "extinct"})
+	assert.ErrorIs(t, err, database.ErrNoSuchMachine)
+
+	err = db.SetMetadata(broadcast.SetMetadata{Hostname: "moa", Metadata: broadcast.Metadata{
+		Key: "room", Type: broadcast.MetadataString, Value: "museum",
+	}})
+	assert.ErrorIs(t, err, database.ErrNoSuchMachine)
+
+	assert.NoError(t, db.UpdateLastSeen("moa", time.Now()))
+	err = db.AddTag(broadcast.MachineTag{Hostname: "moa", Tag: "  "})
+	assert.ErrorIs(t, err, database.ErrInvalidTag)
+}
+
+func removingMachineRemovesLabels(t *testing.T, db database.Database) {
+	fakeHost := "fulmar"
+	assert.NoError(t, db.UpdateLastSeen(fakeHost, time.Now()))
+	assert.NoError(t, db.AddTag(broadcast.MachineTag{Hostname: fakeHost, Tag: "teaching"}))
+	assert.NoError(t, db.SetMetadata(broadcast.SetMetadata{Hostname: fakeHost, Metadata: broadcast.Metadata{
+		Key: "room", Type: broadcast.MetadataString, Value: "Huxley 219",
+	}}))
+
+	assert.NoError(t, db.RemoveMachine(broadcast.RemoveMachine{Hostname: fakeHost}))
+	assert.NoError(t, db.UpdateLastSeen(fakeHost, time.Now()))
+
+	data, err := db.LatestData()
+	assert.NoError(t, err)
+	_, _, machine := getMachine(data, fakeHost)
+	assert.Empty(t, machine.Tags)
+	assert.Empty(t, machine.Metadata)
+}
+
+// the names of the groups in LatestData, and the machines in each
+func groupMembers(t *testing.T, db database.Database) ([]string, map[string][]string) {
+	t.Helper()
+
+	data, err := db.LatestData()
+	assert.NoError(t, err)
+
+	names := []string{}
+	members := make(map[string][]string)
+	for _, group := range data {
+		names = append(names, group.Name)
+		members[group.Name] = []string{}
+		for _, machine := range group.Workstations {
+			members[group.Name] = append(members[group.Name], machine.Name)
+		}
+	}
+	return names, members
+}
+
+func groupsCanBeCreated(t *testing.T, db database.Database) {
+	assert.NoError(t, db.UpdateLastSeen("razorbill", time.Now()))
+	assert.NoError(t, db.UpdateLastSeen("auk", time.Now()))
+
+	assert.NoError(t, db.CreateGroup(broadcast.GroupInfo{Name: " lab ", Description: "Huxley 219", Order: 1}))
+	assert.NoError(t, db.CreateGroup(broadcast.GroupInfo{Name: "Teaching"}))
+
+	assert.ErrorIs(t, db.CreateGroup(broadcast.GroupInfo{Name: "lab"}), database.ErrGroupExists)
+	// machines are already in the default group, so it exists too
+	assert.ErrorIs(t, db.CreateGroup(broadcast.GroupInfo{Name: database.DefaultGroup}), database.ErrGroupExists)
+	assert.ErrorIs(t, db.CreateGroup(broadcast.GroupInfo{Name: " "}), database.ErrInvalidGroup)
+
+	groups, err := db.Groups()
+	assert.NoError(t, err)
+	assert.Equal(t, []broadcast.GroupInfo{
+		{Name: database.DefaultGroup},
+		{Name: "Teaching"},
+		{Name: "lab", Description: "Huxley 219", Order: 1},
+	}, groups)
+
+	// empty groups are there too, and everything is in order
+	names, members := groupMembers(t, db)
+	assert.Equal(t, []string{database.DefaultGroup, "Teaching", "lab"}, names)
+	assert.Equal(t, []string{"auk", "razorbill"}, members[database.DefaultGroup])
+	assert.Equal(t, []string{}, members["lab"])
+
+	data, err := db.LatestData()
+	assert.NoError(t, err)
+	assert.Equal(t, "Huxley 219", data[2].Description)
+	assert.Equal(t, 1, data[2].Order)
+}
+
+func renamingGroupsTakesTheirMachines(t *testing.T, db database.Database) {
+	lab := "lab"
+	assert.NoError(t, db.NewMachine(broadcast.NewMachine{Hostname: "puffin", Group: &lab}))
+	assert.NoError(t, db.NewMachine(broadcast.NewMachine{Hostname: "tern", Group: &lab}))
+	settings := uplink.Settings{DataInterval: 15 * time.Second}
+	assert.NoError(t, db.SetSettingsOverride(broadcast.SettingsOverride{Group: lab, Settings: settings}))
+	assert.NoError(t, db.CreateGroup(broadcast.GroupInfo{Name: "office"}))
+
+	// the group was never created, but has machines
+	newName, description, order := "ml lab", "Huxley 219", -1
+	assert.NoError(t, db.UpdateGroup(broadcast.ModifyGroup{Name: lab, NewName: &newName, Description: &description, Order: &order}))
+
+	names, members := groupMembers(t, db)
+	assert.Equal(t, []string{"ml lab", "office"}, names)
+	assert.Equal(t, []string{"puffin", "tern"}, members["ml lab"])
+
+	group, _, err := db.SettingsFor("tern")
+	assert.NoError(t, err)
+	assert.Equal(t, settings, group)
+
+	// only what's given is changed
+	order = 5
+	assert.NoError(t, db.UpdateGroup(broadcast.ModifyGroup{Name: "ml lab", Order: &order}))
+	groups, err := db.Groups()
+	assert.NoError(t, err)
+	assert.Equal(t, []broadcast.GroupInfo{
+		{Name: "office"},
+		{Name: "ml lab", Description: "Huxley 219", Order: 5},
+	}, groups)
+
+	office := "office"
+	assert.ErrorIs(t, db.UpdateGroup(broadcast.ModifyGroup{Name: "ml lab", NewName: &office}), database.ErrGroupExists)
+	assert.ErrorIs(t, db.UpdateGroup(broadcast.ModifyGroup{Name: lab, Order: &order}), database.ErrNoSuchGroup)
+}
+
+func removingGroupsMovesTheirMachines(t *testing.T, db database.Database) {
+	lab, office := "lab", "office"
+	assert.NoError(t, db.NewMachine(broadcast.NewMachine{Hostname: "puffin", Group: &lab}))
+	assert.NoError(t, db.NewMachine(broadcast.NewMachine{Hostname: "tern", Group: &office}))
+	description := "going soon"
+	assert.NoError(t, db.UpdateGroup(broadcast.ModifyGroup{Name: lab, Description: &description}))
+	assert.NoError(t, db.SetSettingsOverride(broadcast.SettingsOverride{Group: lab, Settings: uplink.Settings{DataInterval: time.Second}}))
+
+	assert.NoError(t, db.RemoveGroup(broadcast.RemoveGroup{Name: lab}))
+	names, members := groupMembers(t, db)
+	assert.Equal(t, []string{database.DefaultGroup, office}, names)
+	assert.Equal(t, []string{"puffin"}, members[database.DefaultGroup])
+
+	overrides, err := db.SettingsOverrides()
+	assert.NoError(t, err)
+	assert.Empty(t, overrides)
+
+	assert.NoError(t, db.RemoveGroup(broadcast.RemoveGroup{Name: database.DefaultGroup, MoveTo: office}))
+	names, members = groupMembers(t, db)
+	assert.Equal(t, []string{office}, names)
+	assert.Equal(t, []string{"puffin", "tern"}, members[office])
+
+	assert.ErrorIs(t, db.RemoveGroup(broadcast.RemoveGroup{Name: lab}), database.ErrNoSuchGroup)
+	assert.ErrorIs(t, db.RemoveGroup(broadcast.RemoveGroup{Name: office, MoveTo: office}), database.ErrInvalidGroup)
+}
+
+func movingMachinesIsAllOrNothing(t *testing.T, db database.Database) {
+	for _, host := range []string{"puffin", "tern", "skua"} {
+		assert.NoError(t, db.UpdateLastSeen(host, time.Now()))
+	}
+
+	err := db.MoveMachines(broadcast.MoveMachines{Hostnames: []string{"puffin", "dodo"}, Group: "lab"})
+	assert.ErrorIs(t, err, database.ErrNoSuchMachine)
+	_, members := groupMembers(t, db)
+	assert.Equal(t, []string{"puffin", "skua", "tern"}, members[database.DefaultGroup])
+
+	assert.NoError(t, db.MoveMachines(broadcast.MoveMachines{Hostnames: []string{"puffin", "tern"}, Group: "lab"}))
+	names, members := groupMembers(t, db)
+	assert.Equal(t, []string{database.DefaultGroup, "lab"}, names)
+	assert.Equal(t, []string{"puffin", "tern"}, members["lab"])
+
+	err = db.MoveMachines(broadcast.MoveMachines{Hostnames: []string{"skua"}, Group: ""})
+	assert.ErrorIs(t, err, database.ErrInvalidGroup)
+}
+
 func addingMachines(t *testing.T, db database.Database) {
 	fakeHost := "chestnut"
 	fakeGroup := "someGroup"
@@ -775,3 +1039,1005 @@ func doesNotUpdateNonexistentMachines(t *testing.T, db database.Database) {
 	assert.NoError(t, err)
 	assert.Equal(t, 0, len(seen))
 }
+
+// samples replayed from a satellite's backlog can arrive late, out of order or
+// more than once, and shouldn't replace newer data
+func backfilledSamplesKeepTheirTime(t *testing.T, db database.Database) {
+	fakeHost := "heron"
+
+	err := db.UpdateLastSeen(fakeHost, time.Now())
+	assert.NoError(t, err)
+	err = db.UpdateGPUContext(fakeHost, fakeDataInfo)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	newer := fakeDataSample
+	newer.Time = now.Add(-time.Minute).Unix()
+	older := fakeDataSample2
+	older.Time = now.Add(-time.Hour).Unix()
+
+	assert.NoError(t, db.AppendDataPoint(newer))
+	assert.NoError(t, db.AppendDataPoint(older))
+	assert.NoError(t, db.AppendDataPoint(newer))
+
+	data, err := db.LatestData()
+	assert.NoError(t, err)
+
+	found, _, machine := getMachine(data, fakeHost)
+	assert.True(t, found)
+	if assert.Len(t, machine.Gpus, 1) {
+		assert.True(t, statsNear(machine.Gpus[0], newer, fakeDataInfo))
+	}
+}
+
+// a whole upload's worth of samples can be added in one go
+func appendingManyDataPoints(t *testing.T, db database.Database) {
+	fakeHost := "puffin"
+
+	err := db.UpdateLastSeen(fakeHost, time.Now())
+	assert.NoError(t, err)
+	err = db.UpdateGPUContext(fakeHost, fakeDataInfo)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	first := fakeDataSample2
+	first.Time = now.Add(-2 * time.Minute).Unix()
+	second := fakeDataSample
+	second.Time = now.Add(-time.Minute).Unix()
+
+	err = db.AppendDataPoints([]uplink.GPUStatSample{first, second})
+	assert.NoError(t, err)
+
+	data, err := db.LatestData()
+	assert.NoError(t, err)
+
+	found, _, machine := getMachine(data, fakeHost)
+	assert.True(t, found)
+	if assert.Len(t, machine.Gpus, 1) {
+		assert.True(t, statsNear(machine.Gpus[0], second, fakeDataInfo))
+	}
+
+	// an empty upload is fine too
+	assert.NoError(t, db.AppendDataPoints(nil))
+}
+
+// postgres has to split batches this big over several inserts
+func appendingBatchesBiggerThanOneInsert(t *testing.T, db database.Database) {
+	fakeHost := "shearwater"
+
+	err := db.UpdateLastSeen(fakeHost, time.Now())
+	assert.NoError(t, err)
+	err = db.UpdateGPUContext(fakeHost, fakeDataInfo)
+	assert.NoError(t, err)
+
+	start := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+	samples := make([]uplink.GPUStatSample, 5000)
+	for i := range samples {
+		samples[i] = fakeDataSample
+		samples[i].Time = start.Add(time.Duration(i) * time.Second).Unix()
+		samples[i].RunningProcesses = nil
+	}
+
+	// an unknown gpu in a later insert still means nothing is saved
+	unknown := samples[len(samples)-1]
+	unknown.Uuid = uuid.MustParse("9e4c2b7a-1d3f-4a8e-b6c5-0f2d7e9a1b38")
+	err = db.AppendDataPoints(append(samples[:len(samples):len(samples)], unknown))
+	assert.ErrorIs(t, err, database.ErrGpuNotPresent)
+
+	history, err := db.HistoricalData(fakeHost, database.HistoryQuery{})
+	assert.NoError(t, err)
+	for _, gpu := range history {
+		assert.Empty(t, gpu)
+	}
+
+	err = db.AppendDataPoints(samples)
+	assert.NoError(t, err)
+
+	history, err = db.HistoricalData(fakeHost, database.HistoryQuery{})
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Len(t, history[0], len(samples))
+	}
+}
+
+// if any sample is for a gpu we don't know, none of them are saved
+func appendingManyFailsIfAnyContextMissing(t *testing.T, db database.Database) {
+	fakeHost := "gannet"
+
+	err := db.UpdateLastSeen(fakeHost, time.Now())
+	assert.NoError(t, err)
+	err = db.UpdateGPUContext(fakeHost, fakeDataInfo)
+	assert.NoError(t, err)
+
+	unknown := fakeDataSample2
+	unknown.Uuid = uuid.MustParse("3b1d1a4e-4ab7-49a6-9a3e-2c4f1f6a0c1d")
+
+	err = db.AppendDataPoints([]uplink.GPUStatSample{fakeDataSample, unknown})
+	assert.ErrorIs(t, err, database.ErrGpuNotPresent)
+
+	data, err := db.LatestData()
+	assert.NoError(t, err)
+
+	found, _, machine := getMachine(data, fakeHost)
+	assert.True(t, found)
+	assert.Empty(t, machine.Gpus)
+}
+
+func settingsOverridesStartEmpty(t *testing.T, db database.Database) {
+	overrides, err := db.SettingsOverrides()
+	assert.NoError(t, err)
+	assert.Empty(t, overrides)
+
+	group, machine, err := db.SettingsFor("nobody")
+	assert.NoError(t, err)
+	assert.Zero(t, group)
+	assert.Zero(t, machine)
+}
+
+func settingsOverridesApplyToGroupsAndMachines(t *testing.T, db database.Database) {
+	lab := "lab"
+	assert.NoError(t, db.NewMachine(broadcast.NewMachine{Hostname: "puffin", Group: &lab}))
+	assert.NoError(t, db.NewMachine(broadcast.NewMachine{Hostname: "tern", Group: &lab}))
+
+	labSettings := uplink.Settings{DataInterval: 15 * time.Second, ProcessFilter: &uplink.ProcessFilter{Include: []uplink.ProcessMatch{{Name: "julia"}}}}
+	puffinSettings := uplink.Settings{Collectors: []string{"amd"}}
+
+	assert.NoError(t, db.SetSettingsOverride(broadcast.SettingsOverride{Group: lab, Settings: labSettings}))
+	assert.NoError(t, db.SetSettingsOverride(broadcast.SettingsOverride{Hostname: "puffin", Settings: puffinSettings}))
+
+	group, machine, err := db.SettingsFor("puffin")
+	assert.NoError(t, err)
+	assert.Equal(t, labSettings, group)
+	assert.Equal(t, puffinSettings, machine)
+
+	group, machine, err = db.SettingsFor("tern")
+	assert.NoError(t, err)
+	assert.Equal(t, labSettings, group)
+	assert.Zero(t, machine)
+
+	overrides, err := db.SettingsOverrides()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []broadcast.SettingsOverride{
+		{Group: lab, Settings: labSettings},
+		{Hostname: "puffin", Settings: puffinSettings},
+	}, overrides)
+
+	// overrides follow a machine when it moves group
+	shared := database.DefaultGroup
+	assert.NoError(t, db.UpdateMachine(broadcast.ModifyMachine{Hostname: "tern", Group: &shared}))
+	group, _, err = db.SettingsFor("tern")
+	assert.NoError(t, err)
+	assert.Zero(t, group)
+}
+
+func settingsOverridesCanBeRemoved(t *testing.T, db database.Database) {
+	assert.NoError(t, db.UpdateLastSeen("guillemot", time.Now()))
+
+	settings := uplink.Settings{HeartbeatInterval: time.Second}
+	assert.NoError(t, db.SetSettingsOverride(broadcast.SettingsOverride{Hostname: "guillemot", Settings: settings}))
+	assert.NoError(t, db.SetSettingsOverride(broadcast.SettingsOverride{Group: database.DefaultGroup, Settings: settings}))
+
+	group, machine, err := db.SettingsFor("guillemot")
+	assert.NoError(t, err)
+	assert.Equal(t, settings, group)
+	assert.Equal(t, settings, machine)
+
+	// with zero settings
+	assert.NoError(t, db.SetSettingsOverride(broadcast.SettingsOverride{Group: database.DefaultGroup}))
+	group, _, err = db.SettingsFor("guillemot")
+	assert.NoError(t, err)
+	assert.Zero(t, group)
+
+	// or by removing the machine
+	assert.NoError(t, db.RemoveMachine(broadcast.RemoveMachine{Hostname: "guillemot"}))
+	overrides, err := db.SettingsOverrides()
+	assert.NoError(t, err)
+	assert.Empty(t, overrides)
+}
+
+func settingsOverridesNeedOneTarget(t *testing.T, db database.Database) {
+	settings := uplink.Settings{HeartbeatInterval: time.Second}
+
+	err := db.SetSettingsOverride(broadcast.SettingsOverride{Settings: settings})
+	assert.ErrorIs(t, err, database.ErrInvalidOverride)
+
+	err = db.SetSettingsOverride(broadcast.SettingsOverride{Group: "lab", Hostname: "puffin", Settings: settings})
+	assert.ErrorIs(t, err, database.ErrInvalidOverride)
+
+	err = db.SetSettingsOverride(broadcast.SettingsOverride{Hostname: "nobody", Settings: settings})
+	assert.ErrorIs(t, err, database.ErrNoSuchMachine)
+}
+
+// admins can see processes even when the satellite's filter dropped them
+func latestProcessesIncludeFilteredOnes(t *testing.T, db database.Database) {
+	fakeHost := "gannet"
+
+	assert.NoError(t, db.UpdateLastSeen(fakeHost, time.Now()))
+	assert.NoError(t, db.UpdateGPUContext(fakeHost, fakeDataInfo))
+
+	procs, err := db.LatestProcesses(fakeHost)
+	assert.NoError(t, err)
+	assert.Equal(t, []broadcast.GPUProcesses{{Uuid: fakeDataInfo.Uuid, Processes: uplink.Processes{}}}, procs)
+
+	xorg := uplink.GPUProcInfo{Pid: 1, Name: "Xorg", MemUsed: 120, Owner: "root"}
+	python := uplink.GPUProcInfo{Pid: 2, Name: "python3", MemUsed: 2048, Owner: "alice", Cmdline: "python3 train.py"}
+
+	older := fakeDataSample
+	older.Time = time.Now().Add(-time.Hour).Unix()
+	older.RunningProcesses = uplink.Processes{xorg}
+
+	newer := fakeDataSample2
+	newer.Time = time.Now().Add(-time.Minute).Unix()
+	newer.RunningProcesses = uplink.Processes{python}
+	newer.AllProcesses = uplink.Processes{xorg, python}
+
+	assert.NoError(t, db.AppendDataPoints([]uplink.GPUStatSample{newer, older}))
+
+	procs, err = db.LatestProcesses(fakeHost)
+	assert.NoError(t, err)
+	assert.Equal(t, []broadcast.GPUProcesses{{Uuid: fakeDataInfo.Uuid, Processes: uplink.Processes{xorg, python}}}, procs)
+}
+
+func latestProcessesOfUnknownMachine(t *testing.T, db database.Database) {
+	_, err := db.LatestProcesses("nobody")
+	assert.ErrorIs(t, err, database.ErrNoSuchMachine)
+}
+
+// a process is one job for as long as it runs, even when its pid is reused
+func jobsTrackProcessLifetimes(t *testing.T, db database.Database) {
+	fakeHost := "kittiwake"
+
+	assert.NoError(t, db.UpdateLastSeen(fakeHost, time.Now()))
+	assert.NoError(t, db.UpdateGPUContext(fakeHost, fakeDataInfo))
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	sampleAt := func(offset time.Duration, procs ...uplink.GPUProcInfo) uplink.GPUStatSample {
+		sample := fakeDataSample
+		sample.Time = start.Add(offset).Unix()
+		sample.RunningProcesses = procs
+		return sample
+	}
+
+	train := uplink.GPUProcInfo{Pid: 100, Name: "python3", MemUsed: 1000, Owner: "alice"}
+	peak := train
+	peak.MemUsed = 3000
+	render := uplink.GPUProcInfo{Pid: 100, Name: "blender", MemUsed: 500, Owner: "bob"}
+
+	assert.NoError(t, db.AppendDataPoints([]uplink.GPUStatSample{
+		sampleAt(0, train),
+		sampleAt(time.Minute, peak),
+		sampleAt(2*time.Minute, train),
+		sampleAt(3 * time.Minute),
+		sampleAt(4*time.Minute, render),
+	}))
+
+	jobs, err := db.Jobs(fakeHost, start.Add(-time.Minute), time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []broadcast.Job{
+		{
+			Gpu:        fakeDataInfo.Uuid,
+			Pid:        100,
+			Name:       "blender",
+			Owner:      "bob",
+			FirstSeen:  start.Add(4 * time.Minute).Unix(),
+			LastSeen:   start.Add(4 * time.Minute).Unix(),
+			PeakMemory: 500,
+			Running:    true,
+		},
+		{
+			Gpu:        fakeDataInfo.Uuid,
+			Pid:        100,
+			Name:       "python3",
+			Owner:      "alice",
+			FirstSeen:  start.Unix(),
+			LastSeen:   start.Add(2 * time.Minute).Unix(),
+			PeakMemory: 3000,
+			Running:    false,
+		},
+	}, jobs)
+}
+
+// a pid reused by the same program after a long gap is a new job, whether
+// the gap is within one upload or between them, and late samples only join
+// the job that was running at the time
+func jobsEndAfterAGap(t *testing.T, db database.Database) {
+	fakeHost := "gannet"
+
+	assert.NoError(t, db.UpdateLastSeen(fakeHost, time.Now()))
+	assert.NoError(t, db.UpdateGPUContext(fakeHost, fakeDataInfo))
+
+	start := time.Now().Add(-5 * time.Hour).Truncate(time.Second)
+	sampleAt := func(offset time.Duration) uplink.GPUStatSample {
+		sample := fakeDataSample
+		sample.Time = start.Add(offset).Unix()
+		sample.RunningProcesses = uplink.Processes{{Pid: 100, Name: "python3", MemUsed: 1000, Owner: "alice"}}
+		return sample
+	}
+
+	assert.NoError(t, db.AppendDataPoints([]uplink.GPUStatSample{
+		sampleAt(0),
+		sampleAt(time.Minute),
+		sampleAt(3 * time.Hour),
+	}))
+	assert.NoError(t, db.AppendDataPoint(sampleAt(3*time.Hour+2*time.Minute)))
+	assert.NoError(t, db.AppendDataPoint(sampleAt(-2*time.Hour)))
+	assert.NoError(t, db.AppendDataPoint(sampleAt(5*time.Minute)))
+
+	job := func(first time.Duration, last time.Duration, running bool) broadcast.Job {
+		return broadcast.Job{
+			Gpu:        fakeDataInfo.Uuid,
+			Pid:        100,
+			Name:       "python3",
+			Owner:      "alice",
+			FirstSeen:  start.Add(first).Unix(),
+			LastSeen:   start.Add(last).Unix(),
+			PeakMemory: 1000,
+			Running:    running,
+		}
+	}
+
+	jobs, err := db.Jobs(fakeHost, start.Add(-3*time.Hour), time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []broadcast.Job{
+		job(3*time.Hour, 3*time.Hour+2*time.Minute, true),
+		job(0, 5*time.Minute, false),
+		job(-2*time.Hour, -2*time.Hour, false),
+	}, jobs)
+}
+
+func jobsOutsideTheWindowAreHidden(t *testing.T, db database.Database) {
+	fakeHost := "fulmar"
+
+	assert.NoError(t, db.UpdateLastSeen(fakeHost, time.Now()))
+	assert.NoError(t, db.UpdateGPUContext(fakeHost, fakeDataInfo))
+
+	lastNight := time.Now().Add(-12 * time.Hour).Truncate(time.Second)
+	sample := fakeDataSample
+	sample.Time = lastNight.Unix()
+	sample.RunningProcesses = uplink.Processes{{Pid: 7, Name: "julia", MemUsed: 100, Owner: "carol"}}
+	assert.NoError(t, db.AppendDataPoint(sample))
+
+	jobs, err := db.Jobs(fakeHost, lastNight.Add(-time.Hour), lastNight.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+
+	jobs, err = db.Jobs(fakeHost, time.Now().Add(-time.Hour), time.Now())
+	assert.NoError(t, err)
+	assert.Empty(t, jobs)
+}
+
+func jobsOfUnknownMachine(t *testing.T, db database.Database) {
+	_, err := db.Jobs("nobody", time.Now().Add(-time.Hour), time.Now())
+	assert.ErrorIs(t, err, database.ErrNoSuchMachine)
+}
+
+// the events without their times, which tests can't know exactly
+func withoutTimes(events []broadcast.InventoryEvent) []broadcast.InventoryEvent {
+	for i := range events {
+		events[i].Time = 0
+	}
+	return events
+}
+
+func inventoryTracksGpuChanges(t *testing.T, db database.Database) {
+	from, to := "gannet", "puffin"
+
+	assert.NoError(t, db.UpdateLastSeen(from, time.Now()))
+	assert.NoError(t, db.UpdateLastSeen(to, time.Now()))
+	start := time.Now().Add(-time.Minute)
+
+	// resending the same context changes nothing
+	assert.NoError(t, db.UpdateGPUContext(from, fakeDataInfo))
+	assert.NoError(t, db.UpdateGPUContext(from, fakeDataInfo))
+
+	upgraded := fakeDataInfo
+	upgraded.DriverVersion = "v1.5.0"
+	assert.NoError(t, db.UpdateGPUContext(from, upgraded))
+	assert.NoError(t, db.UpdateGPUContext(to, upgraded))
+
+	moved := broadcast.InventoryEvent{
+		Hostname: to, Gpu: fakeDataInfo.Uuid, Name: fakeDataInfo.Name,
+		Kind: broadcast.GPUMoved, From: from, To: to,
+	}
+
+	events, err := db.InventoryEvents(from, start, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, []broadcast.InventoryEvent{
+		moved,
+		{
+			Hostname: from, Gpu: fakeDataInfo.Uuid, Name: fakeDataInfo.Name,
+			Kind: broadcast.GPUDriverChanged, From: "v1.4.5", To: "v1.5.0",
+		},
+		{
+			Hostname: from, Gpu: fakeDataInfo.Uuid, Name: fakeDataInfo.Name,
+			Kind: broadcast.GPUAppeared,
+		},
+	}, withoutTimes(events))
+
+	events, err = db.InventoryEvents(to, start, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, []broadcast.InventoryEvent{moved}, withoutTimes(events))
+
+	events, err = db.InventoryEvents(to, start.Add(-time.Hour), start)
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func missingGpusAreFlagged(t *testing.T, db database.Database) {
+	alive, dead := "guillemot", "skua"
+	silent := fakeDataInfo
+	gone := fakeDataInfo
+	gone.Uuid = uuid.MustParse("2b1e0c7a-55d4-4f0e-9a63-4c8e1d3f9a10")
+	unsampled := fakeDataInfo
+	unsampled.Uuid = uuid.MustParse("c0d5e2f4-8a7b-4e91-b3c6-1f2a9d8e7b54")
+
+	assert.NoError(t, db.UpdateLastSeen(alive, time.Now()))
+	assert.NoError(t, db.UpdateLastSeen(dead, time.Now()))
+	assert.NoError(t, db.UpdateGPUContext(alive, silent))
+	assert.NoError(t, db.UpdateGPUContext(alive, unsampled))
+	assert.NoError(t, db.UpdateGPUContext(dead, gone))
+
+	sample := fakeDataSample
+	sample.Time = time.Now().Unix()
+	assert.NoError(t, db.AppendDataPoint(sample))
+	sample.Uuid = gone.Uuid
+	assert.NoError(t, db.AppendDataPoint(sample))
+
+	// an hour on, only the machine that's still alive has been seen
+	later := time.Now().Add(time.Hour)
+	assert.NoError(t, db.UpdateLastSeen(alive, later))
+
+	events, err := db.FlagMissingGPUs(later, 5*time.Minute, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []broadcast.InventoryEvent{{
+		Time: later.Unix(), Hostname: alive, Gpu: silent.Uuid,
+		Name: silent.Name, Kind: broadcast.GPUMissing,
+	}}, events)
+
+	// it's only flagged once
+	events, err = db.FlagMissingGPUs(later, 5*time.Minute, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+
+	data, err := db.LatestData()
+	assert.NoError(t, err)
+	for _, machine := range data[0].Workstations {
+		for _, gpu := range machine.Gpus {
+			assert.Equal(t, gpu.Uuid == silent.Uuid, gpu.Missing, gpu.Uuid)
+		}
+	}
+
+	sample.Uuid = silent.Uuid
+	sample.Time = later.Unix()
+	assert.NoError(t, db.AppendDataPoint(sample))
+
+	events, err = db.FlagMissingGPUs(later, 5*time.Minute, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []broadcast.InventoryEvent{{
+		Time: later.Unix(), Hostname: alive, Gpu: silent.Uuid,
+		Name: silent.Name, Kind: broadcast.GPUReturned,
+	}}, events)
+
+	events, err = db.InventoryEvents(alive, time.Now().Add(-time.Minute), later)
+	assert.NoError(t, err)
+	kinds := []broadcast.InventoryEventKind{}
+	for _, event := range events {
+		kinds = append(kinds, event.Kind)
+	}
+	assert.Equal(t, []broadcast.InventoryEventKind{
+		broadcast.GPUReturned, broadcast.GPUMissing,
+		broadcast.GPUAppeared, broadcast.GPUAppeared,
+	}, kinds)
+}
+
+func inventoryOfUnknownMachine(t *testing.T, db database.Database) {
+	_, err := db.InventoryEvents("nobody", time.Now().Add(-time.Hour), time.Now())
+	assert.ErrorIs(t, err, database.ErrNoSuchMachine)
+}
+
+func alertsMoveThroughTheirStates(t *testing.T, db database.Database) {
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	gpu := fakeDataInfo.Uuid
+
+	hot := broadcast.Alert{
+		Rule:       "overheating",
+		Expression: "gpu_temp > 88 for 5m",
+		Hostname:   "petrel",
+		Gpu:        &gpu,
+		State:      broadcast.AlertPending,
+		Value:      91,
+		Since:      start.Unix(),
+	}
+	offline := broadcast.Alert{
+		Rule:       "offline",
+		Expression: "offline > 30m",
+		Hostname:   "storm",
+		State:      broadcast.AlertFiring,
+		Value:      1800,
+		Since:      start.Add(time.Minute).Unix(),
+		FiredAt:    start.Add(time.Minute).Unix(),
+	}
+
+	var err error
+	hot.Id, err = db.RecordAlert(hot)
+	assert.NoError(t, err)
+	offline.Id, err = db.RecordAlert(offline)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hot.Id, offline.Id)
+
+	active, err := db.ActiveAlerts()
+	assert.NoError(t, err)
+	assert.Equal(t, []broadcast.Alert{hot, offline}, active)
+
+	// only alerts that fired are history
+	history, err := db.AlertHistory(start, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []broadcast.Alert{offline}, history)
+
+	hot.State = broadcast.AlertFiring
+	hot.FiredAt = start.Add(5 * time.Minute).Unix()
+	_, err = db.RecordAlert(hot)
+	assert.NoError(t, err)
+
+	offline.State = broadcast.AlertResolved
+	offline.ResolvedAt = start.Add(10 * time.Minute).Unix()
+	_, err = db.RecordAlert(offline)
+	assert.NoError(t, err)
+
+	active, err = db.ActiveAlerts()
+	assert.NoError(t, err)
+	assert.Equal(t, []broadcast.Alert{hot}, active)
+
+	history, err = db.AlertHistory(start, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []broadcast.Alert{hot, offline}, history)
+
+	// the offline alert resolved before this
+	history, err = db.AlertHistory(start.Add(30*time.Minute), time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []broadcast.Alert{hot}, history)
+}
+
+func pendingAlertsCanBeRemoved(t *testing.T, db database.Database) {
+	id, err := db.RecordAlert(broadcast.Alert{
+		Rule:       "fan",
+		Expression: "fan_speed == 0 while gpu_util > 50",
+		Hostname:   "fulmar",
+		State:      broadcast.AlertPending,
+		Since:      time.Now().Unix(),
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, db.RemoveAlert(id))
+
+	active, err := db.ActiveAlerts()
+	assert.NoError(t, err)
+	assert.Empty(t, active)
+
+	// recording it again doesn't bring it back
+	_, err = db.RecordAlert(broadcast.Alert{Id: id, State: broadcast.AlertPending})
+	assert.NoError(t, err)
+
+	active, err = db.ActiveAlerts()
+	assert.NoError(t, err)
+	assert.Empty(t, active)
+}
+
+func removingMachineRemovesAlerts(t *testing.T, db database.Database) {
+	hostname := "shag"
+	assert.NoError(t, db.UpdateLastSeen(hostname, time.Now()))
+
+	_, err := db.RecordAlert(broadcast.Alert{
+		Rule:       "offline",
+		Expression: "offline > 30m",
+		Hostname:   hostname,
+		State:      broadcast.AlertFiring,
+		Since:      time.Now().Unix(),
+		FiredAt:    time.Now().Unix(),
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.RemoveMachine(broadcast.RemoveMachine{Hostname: hostname}))
+
+	active, err := db.ActiveAlerts()
+	assert.NoError(t, err)
+	assert.Empty(t, active)
+}
+
+// adds a gpu to "shearwater" in "lab", shared by alice and bob for a minute
+// then used by alice alone for another, returning when it was first sampled
+func addSharedUsage(t *testing.T, db database.Database) time.Time {
+	t.Helper()
+
+	lab := "lab"
+	assert.NoError(t, db.NewMachine(broadcast.NewMachine{Hostname: "shearwater", Group: &lab}))
+	assert.NoError(t, db.UpdateGPUContext("shearwater", fakeDataInfo))
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	sampleAt := func(offset time.Duration, util float64, power float64, procs ...uplink.GPUProcInfo) uplink.GPUStatSample {
+		sample := fakeDataSample
+		sample.Time = start.Add(offset).Unix()
+		sample.GPUUtilisation = util
+		sample.PowerDraw = power
+		sample.RunningProcesses = procs
+		return sample
+	}
+
+	alice := uplink.GPUProcInfo{Pid: 1, Name: "python3", MemUsed: 300, Owner: "alice"}
+	bob := uplink.GPUProcInfo{Pid: 2, Name: "julia", MemUsed: 100, Owner: "bob"}
+
+	assert.NoError(t, db.AppendDataPoints([]uplink.GPUStatSample{
+		sampleAt(0, 0, 50),
+		sampleAt(time.Minute, 50, 100, alice, bob),
+		sampleAt(2*time.Minute, 100, 200, alice),
+		sampleAt(3*time.Minute, 0, 50),
+	}))
+	return start
+}
+
+// the longest a sample accounts for in usage tests
+const usageGap = 5 * time.Minute
+
+func usageIsSplitBetweenUsers(t *testing.T, db database.Database) {
+	start := addSharedUsage(t, db)
+	from, to := start.Add(-time.Second), time.Now()
+
+	usage, err := db.Usage(from, to, database.UsageByUser, usageGap)
+	assert.NoError(t, err)
+	if assert.Len(t, usage, 2) {
+		assert.Equal(t, "alice", usage[0].Name)
+		assert.InDelta(t, 105.0/3600, usage[0].GPUHours, 1e-6)
+		assert.InDelta(t, (45*50+60*100)/105.0, usage[0].AverageUtilisation, 1e-3)
+		assert.InDelta(t, 45*100+60*200, usage[0].Energy, 1e-3)
+
+		assert.Equal(t, "bob", usage[1].Name)
+		assert.InDelta(t, 15.0/3600, usage[1].GPUHours, 1e-6)
+		assert.InDelta(t, 50, usage[1].AverageUtilisation, 1e-3)
+		assert.InDelta(t, 15*100, usage[1].Energy, 1e-3)
+	}
+
+	for by, name := range map[database.UsageBy]string{
+		database.UsageByMachine: "shearwater",
+		database.UsageByGroup:   "lab",
+	} {
+		usage, err = db.Usage(from, to, by, usageGap)
+		assert.NoError(t, err)
+		if assert.Len(t, usage, 1, by) {
+			assert.Equal(t, name, usage[0].Name)
+			assert.InDelta(t, 120.0/3600, usage[0].GPUHours, 1e-6)
+			assert.InDelta(t, 75, usage[0].AverageUtilisation, 1e-3)
+			assert.InDelta(t, 60*100+60*200, usage[0].Energy, 1e-3)
+		}
+	}
+}
+
+func usageOnlyCountsTheWindow(t *testing.T, db database.Database) {
+	start := addSharedUsage(t, db)
+
+	// only the minute alice was on her own
+	usage, err := db.Usage(start.Add(time.Minute), start.Add(2*time.Minute), database.UsageByUser, usageGap)
+	assert.NoError(t, err)
+	if assert.Len(t, usage, 1) {
+		assert.Equal(t, "alice", usage[0].Name)
+		assert.InDelta(t, 60.0/3600, usage[0].GPUHours, 1e-6)
+	}
+
+	usage, err = db.Usage(start.Add(-2*time.Hour), start.Add(-time.Hour), database.UsageByUser, usageGap)
+	assert.NoError(t, err)
+	assert.Empty(t, usage)
+}
+
+func usageByUnknownGrouping(t *testing.T, db database.Database) {
+	_, err := db.Usage(time.Now().Add(-time.Hour), time.Now(), "colour", usageGap)
+	assert.ErrorIs(t, err, database.ErrInvalidUsageBy)
+}
+
+// a sample after the gpu went unreported only accounts for usageGap, even
+// when the one before it is outside the window
+func usageIsCappedOverGaps(t *testing.T, db database.Database) {
+	start := addSharedUsage(t, db)
+
+	late := fakeDataSample
+	late.Time = start.Add(50 * time.Minute).Unix()
+	late.GPUUtilisation = 100
+	late.PowerDraw = 100
+	late.RunningProcesses = uplink.Processes{{Pid: 1, Name: "python3", MemUsed: 300, Owner: "alice"}}
+	assert.NoError(t, db.AppendDataPoint(late))
+
+	usage, err := db.Usage(start.Add(-time.Second), time.Now(), database.UsageByUser, usageGap)
+	assert.NoError(t, err)
+	if assert.Len(t, usage, 2) {
+		assert.Equal(t, "alice", usage[0].Name)
+		assert.InDelta(t, (105.0+300)/3600, usage[0].GPUHours, 1e-6)
+		assert.InDelta(t, 45*100+60*200+300*100, usage[0].Energy, 1e-3)
+	}
+
+	usage, err = db.Usage(start.Add(10*time.Minute), time.Now(), database.UsageByUser, usageGap)
+	assert.NoError(t, err)
+	if assert.Len(t, usage, 1) {
+		assert.Equal(t, "alice", usage[0].Name)
+		assert.InDelta(t, 300.0/3600, usage[0].GPUHours, 1e-6)
+	}
+}
+
+// adds two gpus to "petrel", the first sampled every minute for four minutes
+// and the second once, returning when the first sample was taken
+func addHistory(t *testing.T, db database.Database) (time.Time, uuid.UUID) {
+	t.Helper()
+
+	other := fakeDataInfo
+	other.Uuid = uuid.MustParse("0b0c5d1e-6f7a-4b8c-9d0e-1f2a3b4c5d6e")
+
+	assert.NoError(t, db.UpdateLastSeen("petrel", time.Now()))
+	assert.NoError(t, db.UpdateGPUContext("petrel", fakeDataInfo))
+	assert.NoError(t, db.UpdateGPUContext("petrel", other))
+
+	start := time.Now().Add(-time.Hour).Truncate(10 * time.Minute)
+	sampleAt := func(offset time.Duration, temp float64, procs ...uplink.GPUProcInfo) uplink.GPUStatSample {
+		sample := fakeDataSample
+		sample.Time = start.Add(offset).Unix()
+		sample.Temp = temp
+		sample.RunningProcesses = procs
+		return sample
+	}
+
+	alice := uplink.GPUProcInfo{Pid: 1, Name: "python3", MemUsed: 100, Owner: "alice"}
+	busier := alice
+	busier.MemUsed = 300
+	bob := uplink.GPUProcInfo{Pid: 2, Name: "julia", MemUsed: 50, Owner: "bob"}
+
+	otherSample := fakeDataSample
+	otherSample.Uuid = other.Uuid
+	otherSample.Time = start.Add(time.Minute).Unix()
+
+	assert.NoError(t, db.AppendDataPoints([]uplink.GPUStatSample{
+		sampleAt(0, 40, alice),
+		sampleAt(time.Minute, 50, busier, bob),
+		sampleAt(2*time.Minute, 60),
+		sampleAt(3*time.Minute, 70),
+		otherSample,
+	}))
+	return start, other.Uuid
+}
+
+func historicalDataCanBeLimited(t *testing.T, db database.Database) {
+	start, other := addHistory(t, db)
+
+	data, err := db.HistoricalData("petrel", database.HistoryQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, data, 2)
+
+	data, err = db.HistoricalData("petrel", database.HistoryQuery{
+		From: start.Add(time.Minute),
+		To:   start.Add(2 * time.Minute),
+		Gpu:  fakeDataInfo.Uuid,
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, data, 1) && assert.Len(t, data[0], 2) {
+		assert.Equal(t, start.Add(time.Minute).Unix(), data[0][0].Timestamp)
+		assert.Equal(t, fakeDataInfo.Uuid, data[0][0].Sample.Uuid)
+		assert.InDelta(t, 50, data[0][0].Sample.Temp, 1e-3)
+		assert.Equal(t, []uplink.GPUUser{
+			{Name: "alice", MemoryUsed: 300, Processes: 1},
+			{Name: "bob", MemoryUsed: 50, Processes: 1},
+		}, data[0][0].Sample.Users)
+		assert.Nil(t, data[0][0].Min)
+		assert.Equal(t, start.Add(2*time.Minute).Unix(), data[0][1].Timestamp)
+	}
+
+	data, err = db.HistoricalData("petrel", database.HistoryQuery{Gpu: other})
+	assert.NoError(t, err)
+	if assert.Len(t, data, 1) {
+		assert.Len(t, data[0], 1)
+	}
+
+	_, err = db.HistoricalData("petrel", database.HistoryQuery{Step: time.Millisecond})
+	assert.ErrorIs(t, err, database.ErrInvalidStep)
+}
+
+func historicalDataCanBeBucketed(t *testing.T, db database.Database) {
+	start, _ := addHistory(t, db)
+
+	data, err := db.HistoricalData("petrel", database.HistoryQuery{
+		Gpu:  fakeDataInfo.Uuid,
+		Step: 2 * time.Minute,
+	})
+	assert.NoError(t, err)
+	if !assert.Len(t, data, 1) || !assert.Len(t, data[0], 2) {
+		return
+	}
+
+	first, second := data[0][0], data[0][1]
+	assert.Equal(t, start.Unix(), first.Timestamp)
+	assert.Equal(t, start.Add(2*time.Minute).Unix(), second.Timestamp)
+
+	if assert.NotNil(t, first.Min) && assert.NotNil(t, first.Max) {
+		assert.InDelta(t, 45, first.Sample.Temp, 1e-3)
+		assert.InDelta(t, 40, first.Min.Temp, 1e-3)
+		assert.InDelta(t, 50, first.Max.Temp, 1e-3)
+		assert.True(t, first.Sample.InUse)
+		assert.True(t, first.Min.InUse)
+	}
+	assert.Equal(t, []uplink.GPUUser{
+		{Name: "alice", MemoryUsed: 200, Processes: 1},
+		{Name: "bob", MemoryUsed: 50, Processes: 1},
+	}, first.Sample.Users)
+
+	assert.InDelta(t, 65, second.Sample.Temp, 1e-3)
+	assert.False(t, second.Sample.InUse)
+	assert.Empty(t, second.Sample.Users)
+}
+
+func historicalDataOfUnknownMachine(t *testing.T, db database.Database) {
+	data, err := db.HistoricalData("nobody", database.HistoryQuery{})
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func aggregateDataStartsEmpty(t *testing.T, db database.Database) {
+	data, err := db.AggregateData()
+	assert.NoError(t, err)
+	assert.Zero(t, data.TotalEnergy)
+}
+
+// each sample's power draw counts for the time since the previous one on the
+// same gpu, so the first sample from each gpu doesn't count
+func aggregateDataTotalsEnergy(t *testing.T, db database.Database) {
+	addSharedUsage(t, db)
+
+	// the shared gpu drew 100W then 200W then 50W for a minute each
+	data, err := db.AggregateData()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(60*100+60*200+60*50), data.TotalEnergy)
+}
+
+// old samples are rolled up into averages over buckets of time, then deleted,
+// and doing it again changes nothing
+func downsamplingFollowsRetention(t *testing.T, db database.Database) {
+	fakeHost := "tern"
+	now := time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.NoError(t, db.UpdateLastSeen(fakeHost, now))
+	assert.NoError(t, db.UpdateGPUContext(fakeHost, fakeDataInfo))
+
+	// a sample a minute for 3 hours, with the temperature as how many
+	// minutes ago it was
+	var samples []uplink.GPUStatSample
+	for i := 180; i >= 0; i-- {
+		sample := fakeDataSample
+		sample.Temp = float64(i)
+		sample.Time = now.Add(-time.Duration(i) * time.Minute).Unix()
+		samples = append(samples, sample)
+	}
+	assert.NoError(t, db.AppendDataPoints(samples))
+
+	policy := database.RetentionPolicy{
+		{After: 30 * time.Minute, Step: 10 * time.Minute},
+		{After: time.Hour, Step: 30 * time.Minute},
+		{After: 2 * time.Hour},
+	}
+	for range 2 {
+		assert.NoError(t, db.Downsample(now, policy))
+
+		data, err := db.HistoricalData(fakeHost, database.HistoryQuery{})
+		assert.NoError(t, err)
+		if !assert.Len(t, data, 1) || !assert.Len(t, data[0], 2+3+31) {
+			return
+		}
+
+		expected := []struct {
+			ago  time.Duration
+			temp float64
+		}{
+			{2 * time.Hour, 105.5},
+			{90 * time.Minute, 75.5},
+			{time.Hour, 55.5},
+			{50 * time.Minute, 45.5},
+			{40 * time.Minute, 35.5},
+			{30 * time.Minute, 30},
+		}
+		for i, want := range expected {
+			assert.Equal(t, now.Add(-want.ago).Unix(), data[0][i].Timestamp)
+			assert.InDelta(t, want.temp, data[0][i].Sample.Temp, 1e-3)
+		}
+	}
+}
+
+func rollupsKeepTheirExtremes(t *testing.T, db database.Database) {
+	fakeHost := "puffin"
+	now := time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.NoError(t, db.UpdateLastSeen(fakeHost, now))
+	assert.NoError(t, db.UpdateGPUContext(fakeHost, fakeDataInfo))
+
+	// a sample a minute for 2 hours, steady apart from one spike and one dip
+	var samples []uplink.GPUStatSample
+	for i := 120; i >= 0; i-- {
+		sample := fakeDataSample
+		sample.Temp = 40
+		sample.Time = now.Add(-time.Duration(i) * time.Minute).Unix()
+		samples = append(samples, sample)
+	}
+	samples[120-100].Temp = 90
+	samples[120-70].Temp = 10
+	assert.NoError(t, db.AppendDataPoints(samples))
+
+	policy := database.RetentionPolicy{
+		{After: 30 * time.Minute, Step: 30 * time.Minute},
+		{After: time.Hour, Step: time.Hour},
+	}
+	// half hour rollups first, which then get rolled up into the hour
+	assert.NoError(t, db.Downsample(now.Add(-30*time.Minute), policy))
+	assert.NoError(t, db.Downsample(now, policy))
+
+	data, err := db.HistoricalData(fakeHost, database.HistoryQuery{})
+	assert.NoError(t, err)
+	if !assert.Len(t, data, 1) || !assert.Len(t, data[0], 1+1+31) {
+		return
+	}
+
+	hour := data[0][0]
+	assert.Equal(t, now.Add(-2*time.Hour).Unix(), hour.Timestamp)
+	assert.Equal(t, 60, hour.Samples)
+	assert.InDelta(t, (40*58+90+10)/60.0, hour.Sample.Temp, 1e-3)
+	if assert.NotNil(t, hour.Min) && assert.NotNil(t, hour.Max) {
+		assert.InDelta(t, 10, hour.Min.Temp, 1e-3)
+		assert.InDelta(t, 90, hour.Max.Temp, 1e-3)
+	}
+
+	halfHour := data[0][1]
+	assert.Equal(t, now.Add(-time.Hour).Unix(), halfHour.Timestamp)
+	assert.Equal(t, 30, halfHour.Samples)
+
+	raw := data[0][2]
+	assert.Equal(t, 1, raw.Samples)
+	assert.Nil(t, raw.Min)
+	assert.Nil(t, raw.Max)
+
+	// and they still count for every sample when bucketed again
+	data, err = db.HistoricalData(fakeHost, database.HistoryQuery{Step: 2 * time.Hour})
+	assert.NoError(t, err)
+	if !assert.Len(t, data, 1) || !assert.Len(t, data[0], 2) {
+		return
+	}
+	assert.Equal(t, 60+30+30, data[0][0].Samples)
+	assert.InDelta(t, (40*118+90+10)/120.0, data[0][0].Sample.Temp, 1e-3)
+	if assert.NotNil(t, data[0][0].Min) && assert.NotNil(t, data[0][0].Max) {
+		assert.InDelta(t, 10, data[0][0].Min.Temp, 1e-3)
+		assert.InDelta(t, 90, data[0][0].Max.Temp, 1e-3)
+	}
+}
+
+func slowerMachinesGpusAreFlaggedLater(t *testing.T, db database.Database) {
+	slow, fast := "guillemot", "skua"
+	slowGpu := fakeDataInfo
+	fastGpu := fakeDataInfo
+	fastGpu.Uuid = uuid.MustParse("2b1e0c7a-55d4-4f0e-9a63-4c8e1d3f9a10")
+
+	now := time.Now()
+	for hostname, gpu := range map[string]uplink.GPUInfo{slow: slowGpu, fast: fastGpu} {
+		assert.NoError(t, db.UpdateLastSeen(hostname, now))
+		assert.NoError(t, db.UpdateGPUContext(hostname, gpu))
+
+		sample := fakeDataSample
+		sample.Uuid = gpu.Uuid
+		sample.Time = now.Add(-10 * time.Minute).Unix()
+		assert.NoError(t, db.AppendDataPoint(sample))
+	}
+
+	slower := map[string]time.Duration{slow: 20 * time.Minute}
+	events, err := db.FlagMissingGPUs(now, 5*time.Minute, slower)
+	assert.NoError(t, err)
+	assert.Equal(t, []broadcast.InventoryEvent{{
+		Time: now.Unix(), Hostname: fast, Gpu: fastGpu.Uuid,
+		Name: fastGpu.Name, Kind: broadcast.GPUMissing,
+	}}, events)
+
+	later := now.Add(15 * time.Minute)
+	assert.NoError(t, db.UpdateLastSeen(slow, later))
+	events, err = db.FlagMissingGPUs(later, 5*time.Minute, slower)
+	assert.NoError(t, err)
+	assert.Equal(t, []broadcast.InventoryEvent{{
+		Time: later.Unix(), Hostname: slow, Gpu: slowGpu.Uuid,
+		Name: slowGpu.Name, Kind: broadcast.GPUMissing,
+	}}, events)
+}
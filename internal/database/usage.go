@@ -0,0 +1,99 @@
+package database
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+	"github.com/gpuctl/gpuctl/internal/uplink"
+)
+
+// What usage is totalled up by
+type UsageBy string
+
+const (
+	UsageByUser    UsageBy = "user"
+	UsageByGroup   UsageBy = "group"
+	UsageByMachine UsageBy = "machine"
+)
+
+func (by UsageBy) Valid() bool {
+	return by == UsageByUser || by == UsageByGroup || by == UsageByMachine
+}
+
+// How much of a GPU each of its users is responsible for: in proportion to
+// the memory they're using, or evenly if we don't know that
+func usageShares(users []uplink.GPUUser) []float64 {
+	var total float64
+	for _, user := range users {
+		total += user.MemoryUsed
+	}
+
+	shares := make([]float64, len(users))
+	for i, user := range users {
+		if total > 0 {
+			shares[i] = user.MemoryUsed / total
+		} else {
+			shares[i] = 1 / float64(len(users))
+		}
+	}
+	return shares
+}
+
+// The time a sample accounts for, given how long it's been since the
+// previous one from its gpu. That's capped at maxGap for each sample it
+// stands for, so the first sample after a gpu went unreported for a while
+// doesn't charge its users for all that time
+func usageSeconds(since int64, samples int, maxGap time.Duration) float64 {
+	return min(float64(since), float64(samples)*maxGap.Seconds())
+}
+
+// running totals for one user, group or machine
+type usageTotal struct {
+	seconds     float64
+	utilSeconds float64 // utilisation weighted by time, for averaging
+	energy      float64
+}
+
+func (t *usageTotal) add(seconds float64, sample broadcast.GPU) {
+	t.seconds += seconds
+	t.utilSeconds += seconds * sample.GPUUtilisation
+	t.energy += seconds * sample.PowerDraw
+}
+
+// Split the time since a gpu's previous sample between its users, adding
+// each share to their total, or to owner's if usage isn't by user
+func addUsage(totals map[string]*usageTotal, by UsageBy, owner string, seconds float64, sample broadcast.GPU) {
+	for i, share := range usageShares(sample.Users) {
+		name := owner
+		if by == UsageByUser {
+			name = sample.Users[i].Name
+		}
+
+		if totals[name] == nil {
+			totals[name] = &usageTotal{}
+		}
+		totals[name].add(seconds*share, sample)
+	}
+}
+
+func usageFromTotals(totals map[string]*usageTotal) []broadcast.Usage {
+	result := []broadcast.Usage{}
+	for name, total := range totals {
+		usage := broadcast.Usage{
+			Name:     name,
+			GPUHours: total.seconds / 3600,
+			Energy:   total.energy,
+		}
+		if total.seconds > 0 {
+			usage.AverageUtilisation = total.utilSeconds / total.seconds
+		}
+		result = append(result, usage)
+	}
+
+	slices.SortFunc(result, func(a, b broadcast.Usage) int {
+		return cmp.Or(cmp.Compare(b.GPUHours, a.GPUHours), cmp.Compare(a.Name, b.Name))
+	})
+	return result
+}
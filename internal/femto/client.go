@@ -2,16 +2,19 @@ package femto
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
 	"net/http"
+	"time"
 )
 
 // Post data to the given URL
 func Post[T any](url string, data T) (*http.Response, error) {
-	// TODO:
-	// - Logging
-	// - Customizable transport
-
 	body, err := json.Marshal(data)
 	if err != nil {
 		return nil, err
@@ -25,3 +28,201 @@ func Post[T any](url string, data T) (*http.Response, error) {
 
 	return resp, err
 }
+
+// Defaults used by a Client when its fields are left as zero
+const (
+	DefaultTimeout    = 10 * time.Second
+	DefaultBackoff    = 500 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// StatusError is returned when the server replies with anything other than a
+// 2xx status
+type StatusError struct {
+	Status int
+	Body   string // the server's explanation, as written by http.Error
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server replied %d %s: %s", e.Status, http.StatusText(e.Status), e.Body)
+}
+
+// Client sends JSON requests, retrying with backoff if the server can't be
+// reached, or has an internal error. The zero value is ready to use, and
+// doesn't retry.
+type Client struct {
+	Transport  http.RoundTripper // http.DefaultTransport if nil
+	Timeout    time.Duration     // for each attempt, DefaultTimeout if zero
+	Retries    int               // how many more attempts to make after the first fails
+	Backoff    time.Duration     // delay before the first retry, DefaultBackoff if zero. Doubled for each subsequent retry
+	MaxBackoff time.Duration     // longest delay between retries, DefaultMaxBackoff if zero
+	Logger     *slog.Logger      // slog.Default() if nil
+
+	// How request bodies are sent. JSON, uncompressed, if left empty.
+	ContentType     string // ContentTypeJSON or ContentTypeCBOR
+	ContentEncoding string // EncodingIdentity, EncodingGzip or EncodingZstd
+}
+
+// PostJSON sends data to url, encoded as configured in the client, and
+// decodes the JSON response into R. An empty response body leaves R as its
+// zero value.
+//
+// This is a function rather than a method as methods can't have type
+// parameters.
+func PostJSON[T any, R any](ctx context.Context, c *Client, url string, data T) (R, error) {
+	var res R
+
+	body, err := encode(data, c.ContentType, c.ContentEncoding)
+	if err != nil {
+		return res, err
+	}
+
+	respBody, err := c.do(ctx, http.MethodPost, url, body)
+	if err != nil || len(respBody) == 0 {
+		return res, err
+	}
+
+	err = json.Unmarshal(respBody, &res)
+	return res, err
+}
+
+// GetJSON fetches url, and decodes the JSON response into R
+func GetJSON[R any](ctx context.Context, c *Client, url string) (R, error) {
+	var res R
+
+	respBody, err := c.do(ctx, http.MethodGet, url, nil)
+	if err != nil || len(respBody) == 0 {
+		return res, err
+	}
+
+	err = json.Unmarshal(respBody, &res)
+	return res, err
+}
+
+// make the request, retrying as configured, and return the response body
+func (c *Client) do(ctx context.Context, method string, url string, body []byte) ([]byte, error) {
+	log := c.logger().With("method", method, "url", url)
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		var respBody []byte
+		respBody, err = c.attempt(ctx, method, url, body)
+		if err == nil {
+			log.Debug("Request succeeded", "attempt", attempt)
+			return respBody, nil
+		}
+
+		if attempt >= c.Retries || !retryable(ctx, err) {
+			break
+		}
+
+		delay := c.backoff(attempt)
+		log.Warn("Request failed, retrying", "attempt", attempt, "delay", delay, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.Join(err, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+
+	log.Error("Request failed", "err", err)
+	return nil, err
+}
+
+func (c *Client) attempt(ctx context.Context, method string, url string, body []byte) ([]byte, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		contentType := c.ContentType
+		if contentType == "" {
+			contentType = ContentTypeJSON
+		}
+		req.Header.Set("Content-Type", contentType)
+		if c.ContentEncoding != EncodingIdentity {
+			req.Header.Set("Content-Encoding", c.ContentEncoding)
+		}
+	}
+
+	client := http.Client{Transport: c.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &StatusError{Status: resp.StatusCode, Body: string(bytes.TrimSpace(respBody))}
+	}
+
+	return respBody, nil
+}
+
+// Check the client's encoding settings are ones we support
+func (c *Client) CheckEncoding() error {
+	_, err := encode(struct{}{}, c.ContentType, c.ContentEncoding)
+	return err
+}
+
+// Whether it's worth trying again after this error. Connection errors and
+// timeouts might go away, as might server errors, but the server won't change
+// its mind about a bad request.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status >= 500
+	}
+	return true
+}
+
+// exponential backoff, with jitter so that satellites which lost the
+// groundstation at the same time don't all come back at once
+func (c *Client) backoff(attempt int) time.Duration {
+	base := c.Backoff
+	if base == 0 {
+		base = DefaultBackoff
+	}
+	max := c.MaxBackoff
+	if max == 0 {
+		max = DefaultMaxBackoff
+	}
+
+	delay := base
+	for i := 0; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	delay = min(delay, max)
+
+	// somewhere between half and all of the delay
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (c *Client) logger() *slog.Logger {
+	if c.Logger == nil {
+		return slog.Default()
+	}
+	return c.Logger
+}
@@ -1,11 +1,19 @@
 package femto_test
 
 import (
+	"context"
 	"errors"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/gpuctl/gpuctl/internal/femto"
+	"github.com/gpuctl/gpuctl/internal/types"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestPostToUnresolvable(t *testing.T) {
@@ -21,3 +29,134 @@ func TestPostToUnresolvable(t *testing.T) {
 		t.Fatal("Expected target name `lol.invalid`, but got: ", target.Name)
 	}
 }
+
+type echo struct {
+	Message string `json:"message"`
+}
+
+// a server which fails its first `failures` requests with the given status,
+// then echos the request back
+func flakyServer(t *testing.T, failures int, status int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(calls.Add(1)) <= failures {
+			http.Error(w, "not now", status)
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &calls
+}
+
+func fastRetries(retries int) *femto.Client {
+	return &femto.Client{Retries: retries, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+}
+
+func TestClientDecodesResponse(t *testing.T) {
+	t.Parallel()
+
+	srv, _ := flakyServer(t, 0, 0)
+
+	res, err := femto.PostJSON[echo, echo](context.Background(), &femto.Client{}, srv.URL, echo{"hello"})
+	assert.NoError(t, err)
+	assert.Equal(t, echo{"hello"}, res)
+}
+
+func TestClientRetriesServerErrors(t *testing.T) {
+	t.Parallel()
+
+	srv, calls := flakyServer(t, 2, http.StatusServiceUnavailable)
+
+	res, err := femto.PostJSON[echo, echo](context.Background(), fastRetries(3), srv.URL, echo{"hello"})
+	assert.NoError(t, err)
+	assert.Equal(t, echo{"hello"}, res)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestClientGivesUpAfterRetries(t *testing.T) {
+	t.Parallel()
+
+	srv, calls := flakyServer(t, 10, http.StatusInternalServerError)
+
+	_, err := femto.PostJSON[echo, echo](context.Background(), fastRetries(2), srv.URL, echo{"hello"})
+
+	var statusErr *femto.StatusError
+	if assert.ErrorAs(t, err, &statusErr) {
+		assert.Equal(t, http.StatusInternalServerError, statusErr.Status)
+		assert.Equal(t, "not now", statusErr.Body)
+	}
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestClientDoesNotRetryBadRequests(t *testing.T) {
+	t.Parallel()
+
+	srv, calls := flakyServer(t, 10, http.StatusBadRequest)
+
+	_, err := femto.PostJSON[echo, echo](context.Background(), fastRetries(3), srv.URL, echo{"hello"})
+
+	var statusErr *femto.StatusError
+	if assert.ErrorAs(t, err, &statusErr) {
+		assert.Equal(t, http.StatusBadRequest, statusErr.Status)
+	}
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestClientTimesOut(t *testing.T) {
+	t.Parallel()
+
+	hang := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer srv.Close()
+	defer close(hang)
+
+	client := &femto.Client{Timeout: 50 * time.Millisecond}
+	_, err := femto.GetJSON[echo](context.Background(), client, srv.URL)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestClientRetriesConnectionErrors(t *testing.T) {
+	t.Parallel()
+
+	var attempts atomic.Int32
+	client := fastRetries(2)
+	client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		attempts.Add(1)
+		return nil, errors.New("connection refused")
+	})
+
+	_, err := femto.PostJSON[echo, types.Unit](context.Background(), client, "http://groundstation", echo{"hello"})
+	assert.Error(t, err)
+	assert.Equal(t, int32(3), attempts.Load())
+}
+
+func TestClientStopsWhenCancelled(t *testing.T) {
+	t.Parallel()
+
+	srv, calls := flakyServer(t, 10, http.StatusServiceUnavailable)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &femto.Client{Retries: 10, Backoff: time.Hour, MaxBackoff: time.Hour}
+	go func() {
+		for calls.Load() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+
+	_, err := femto.PostJSON[echo, echo](ctx, client, srv.URL, echo{"hello"})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
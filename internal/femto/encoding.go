@@ -0,0 +1,128 @@
+package femto
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content types we can read and write request bodies in. CBOR is encoded
+// using the same struct tags as JSON.
+const (
+	ContentTypeJSON = "application/json"
+	ContentTypeCBOR = "application/cbor"
+)
+
+// Content encodings we can compress request bodies with
+const (
+	EncodingIdentity = ""
+	EncodingGzip     = "gzip"
+	EncodingZstd     = "zstd"
+)
+
+// Largest request body we'll decompress, so a small malicious body can't
+// expand to fill our memory
+const maxDecodedBody = 64 << 20
+
+var (
+	ErrUnsupportedContentType = errors.New("unsupported content type")
+	ErrUnsupportedEncoding    = errors.New("unsupported content encoding")
+	ErrBodyTooLarge           = errors.New("decoded body is too large")
+)
+
+// safe for concurrent use, so shared
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecodedBody))
+)
+
+// Human name of the format a body is in. Anything we don't recognise is
+// assumed to be JSON, as old clients don't always say.
+func formatName(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && mediaType == ContentTypeCBOR {
+		return "CBOR"
+	}
+	return "JSON"
+}
+
+// unmarshal a body in the named format
+func unmarshal(format string, body []byte, v any) error {
+	if format == "CBOR" {
+		return cbor.Unmarshal(body, v)
+	}
+	// a decoder, unlike json.Unmarshal, doesn't mind trailing data
+	return json.NewDecoder(bytes.NewReader(body)).Decode(v)
+}
+
+// decompress a body with the given content encoding
+func decompress(encoding string, body io.Reader) ([]byte, error) {
+	switch encoding {
+	case EncodingIdentity, "identity":
+		return io.ReadAll(body)
+	case EncodingGzip:
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+
+		decoded, err := io.ReadAll(io.LimitReader(gz, maxDecodedBody+1))
+		if err == nil && len(decoded) > maxDecodedBody {
+			err = ErrBodyTooLarge
+		}
+		return decoded, err
+	case EncodingZstd:
+		compressed, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		return zstdDecoder.DecodeAll(compressed, nil)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encoding)
+	}
+}
+
+// encode a value as the given content type, compressed with the given
+// encoding
+func encode(v any, contentType string, encoding string) ([]byte, error) {
+	var body []byte
+	var err error
+
+	switch contentType {
+	case "", ContentTypeJSON:
+		body, err = json.Marshal(v)
+	case ContentTypeCBOR:
+		body, err = cbor.Marshal(v)
+	default:
+		err = fmt.Errorf("%w: %s", ErrUnsupportedContentType, contentType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch encoding {
+	case EncodingIdentity:
+		return body, nil
+	case EncodingGzip:
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err = gz.Write(body)
+		if err != nil {
+			return nil, err
+		}
+		err = gz.Close()
+		return buf.Bytes(), err
+	case EncodingZstd:
+		return zstdEncoder.EncodeAll(body, nil), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encoding)
+	}
+}
@@ -0,0 +1,90 @@
+package femto_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gpuctl/gpuctl/internal/femto"
+	"github.com/gpuctl/gpuctl/internal/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type sample struct {
+	Uuid string  `json:"uuid"`
+	Temp float64 `json:"temp"`
+}
+
+// a femto server which passes on the samples it's posted
+func sampleServer(t *testing.T) (*httptest.Server, <-chan sample) {
+	t.Helper()
+
+	received := make(chan sample, 16)
+	mux := new(femto.Femto)
+	femto.OnPost(mux, "/sample", func(s sample, r *http.Request, l *slog.Logger) (*femto.EmptyBodyResponse, error) {
+		received <- s
+		return femto.Ok(types.Unit{})
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, received
+}
+
+func TestEncodingsRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	srv, received := sampleServer(t)
+	sent := sample{Uuid: "GPU-1234", Temp: 41.5}
+
+	for _, contentType := range []string{"", femto.ContentTypeJSON, femto.ContentTypeCBOR} {
+		for _, encoding := range []string{femto.EncodingIdentity, femto.EncodingGzip, femto.EncodingZstd} {
+			client := &femto.Client{ContentType: contentType, ContentEncoding: encoding}
+			_, err := femto.PostJSON[sample, types.Unit](context.Background(), client, srv.URL+"/sample", sent)
+			if assert.NoError(t, err, "%q encoded as %q", contentType, encoding) {
+				assert.Equal(t, sent, <-received, "%q encoded as %q", contentType, encoding)
+			}
+		}
+	}
+}
+
+func TestUnknownContentTypeIsJSON(t *testing.T) {
+	t.Parallel()
+
+	srv, received := sampleServer(t)
+
+	resp, err := http.Post(srv.URL+"/sample", "text/plain", bytes.NewBufferString(`{"uuid":"GPU-1234"}`))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, sample{Uuid: "GPU-1234"}, <-received)
+}
+
+func TestUnsupportedEncoding(t *testing.T) {
+	t.Parallel()
+
+	srv, _ := sampleServer(t)
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/sample", bytes.NewBufferString(`{}`))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Encoding", "br")
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+}
+
+func TestCheckEncoding(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, (&femto.Client{}).CheckEncoding())
+	assert.NoError(t, (&femto.Client{ContentType: femto.ContentTypeCBOR, ContentEncoding: femto.EncodingZstd}).CheckEncoding())
+	assert.ErrorIs(t, (&femto.Client{ContentType: "text/xml"}).CheckEncoding(), femto.ErrUnsupportedContentType)
+	assert.ErrorIs(t, (&femto.Client{ContentEncoding: "br"}).CheckEncoding(), femto.ErrUnsupportedEncoding)
+}
@@ -4,6 +4,7 @@ package femto
 import (
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"sync/atomic"
@@ -26,13 +27,24 @@ type Response[T any] struct {
 type EmptyBodyResponse = Response[types.Unit]
 
 type PostFunc[T any] func(T, *http.Request, *slog.Logger) (*EmptyBodyResponse, error)
+type PostResponseFunc[T any, R any] func(T, *http.Request, *slog.Logger) (*Response[R], error)
 type GetFunc[T any] func(*http.Request, *slog.Logger) (*Response[T], error)
 
+// StreamFunc handlers read the request and write the response themselves, for
+// bodies too big to hold in memory. Errors are only sent to the client if the
+// handler hasn't started its response yet
+type StreamFunc func(http.ResponseWriter, *http.Request, *slog.Logger) error
+
 func (femto *Femto) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	femto.mux.ServeHTTP(w, r)
 }
 
 func OnPost[T any](f *Femto, pattern string, handle PostFunc[T]) {
+	OnPostWithResponse(f, pattern, PostResponseFunc[T, types.Unit](handle))
+}
+
+// OnPostWithResponse is like OnPost, but for handlers that reply with a body
+func OnPostWithResponse[T any, R any](f *Femto, pattern string, handle PostResponseFunc[T, R]) {
 	f.mux.HandleFunc(pattern, func(writer http.ResponseWriter, request *http.Request) {
 		doPost(f, writer, request, handle)
 	})
@@ -44,6 +56,13 @@ func OnGet[T any](f *Femto, pattern string, handle GetFunc[T]) {
 	})
 }
 
+// OnStream handles requests with the given method by streaming them
+func OnStream(f *Femto, method string, pattern string, handle StreamFunc) {
+	f.mux.HandleFunc(pattern, func(writer http.ResponseWriter, request *http.Request) {
+		doStream(f, method, writer, request, handle)
+	})
+}
+
 // correctMethod returns whether the request had the given method.
 //
 // If not, it will return a suitable reponce: either No Content for an OPTIONS request,
@@ -123,6 +142,10 @@ func doGet[T any](f *Femto, w http.ResponseWriter, r *http.Request, handle GetFu
 
 	// Write the response data
 	w.WriteHeader(data.Status)
+	writeBody(w, data, ise)
+}
+
+func writeBody[T any](w http.ResponseWriter, data *Response[T], ise func(ctx string, status int, e error)) {
 	if w.Header().Get("Content-Type") == "application/json" {
 		jsonb, err := json.Marshal(data.Body)
 		if err != nil {
@@ -132,19 +155,15 @@ func doGet[T any](f *Femto, w http.ResponseWriter, r *http.Request, handle GetFu
 		w.Write(jsonb)
 	} else {
 		// Just dump the thing to the response
-		err = binary.Write(w, binary.LittleEndian, data.Body)
+		err := binary.Write(w, binary.LittleEndian, data.Body)
 		if err != nil {
 			ise("There was an error in trying to serialise the handler's response into bytes", data.Status, err)
 			return
 		}
 	}
-
-	if err != nil {
-		ise("There was an error in trying to write to the user", data.Status, err)
-	}
 }
 
-func doPost[T any](f *Femto, w http.ResponseWriter, r *http.Request, handle PostFunc[T]) {
+func doPost[T any, R any](f *Femto, w http.ResponseWriter, r *http.Request, handle PostResponseFunc[T, R]) {
 	reqNo := f.nextReqNo()
 	log := f.logger().With(slog.Uint64("req_no", reqNo))
 
@@ -156,11 +175,21 @@ func doPost[T any](f *Femto, w http.ResponseWriter, r *http.Request, handle Post
 
 	// TODO: Ensure all field's are present. I'm so mad this is hard.
 	var reqData T
-	decoder := json.NewDecoder(r.Body)
+	format := formatName(r.Header.Get("Content-Type"))
+
+	body, err := decompress(r.Header.Get("Content-Encoding"), r.Body)
+	if errors.Is(err, ErrUnsupportedEncoding) {
+		log.Info("Unsupported content encoding", "error", err)
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
 
-	if err := decoder.Decode(&reqData); err != nil {
-		log.Info("Failed to unmarshal JSON", "error", err)
-		http.Error(w, "Failed to decode the provided JSON: "+err.Error(), http.StatusBadRequest)
+	if err == nil {
+		err = unmarshal(format, body, &reqData)
+	}
+	if err != nil {
+		log.Info("Failed to unmarshal "+format, "error", err)
+		http.Error(w, "Failed to decode the provided "+format+": "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -169,7 +198,7 @@ func doPost[T any](f *Femto, w http.ResponseWriter, r *http.Request, handle Post
 	data, userErr := handle(reqData, r, log)
 
 	if data == nil {
-		data = &EmptyBodyResponse{}
+		data = &Response[R]{}
 	}
 
 	if data.Status == 0 {
@@ -189,7 +218,14 @@ func doPost[T any](f *Femto, w http.ResponseWriter, r *http.Request, handle Post
 	}
 
 	w.WriteHeader(data.Status)
-	_, err := w.Write(make([]byte, 0))
+
+	// handlers with nothing to say reply with an empty body
+	if _, empty := any(data.Body).(types.Unit); !empty {
+		writeBody(w, data, ise)
+		return
+	}
+
+	_, err = w.Write(make([]byte, 0))
 
 	if err != nil {
 		ise("There was an error in trying to write to the user", data.Status, err)
@@ -197,6 +233,52 @@ func doPost[T any](f *Femto, w http.ResponseWriter, r *http.Request, handle Post
 	}
 }
 
+func doStream(f *Femto, method string, w http.ResponseWriter, r *http.Request, handle StreamFunc) {
+	reqNo := f.nextReqNo()
+	log := f.logger().With(slog.Uint64("req_no", reqNo))
+
+	log.Info("New Request", "method", r.Method, "url", r.URL, "from", r.RemoteAddr)
+
+	if !correctMethod(method, r, w, log) {
+		return
+	}
+
+	started := &startedWriter{ResponseWriter: w}
+	err := handle(started, r, log)
+	if err == nil {
+		return
+	}
+
+	if started.started {
+		log.Error("There was an error in the server partway through a response", "err", err)
+		return
+	}
+	ise := generateErrorLogger(log, w)
+	ise("There was an error in the server when trying to handle the provided request", http.StatusInternalServerError, err)
+}
+
+// remembers whether anything has been written, so errors aren't written into
+// the middle of a response
+type startedWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (s *startedWriter) WriteHeader(status int) {
+	s.started = true
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *startedWriter) Write(b []byte) (int, error) {
+	s.started = true
+	return s.ResponseWriter.Write(b)
+}
+
+// for http.ResponseController
+func (s *startedWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
 func (f *Femto) nextReqNo() uint64 {
 	return f.reqNo.Add(1)
 }
@@ -321,3 +321,56 @@ func TestOk(t *testing.T) {
 		}
 	}
 }
+
+func TestPostWithResponse(t *testing.T) {
+	t.Parallel()
+
+	mux := new(femto.Femto)
+	femto.OnPostWithResponse(mux, "/double", func(n int, r *http.Request, l *slog.Logger) (*femto.Response[int], error) {
+		return femto.Ok(n * 2)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/double", strings.NewReader("21"))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "42", w.Body.String())
+}
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+
+	mux := new(femto.Femto)
+	femto.OnStream(mux, http.MethodPut, "/echo", func(w http.ResponseWriter, r *http.Request, l *slog.Logger) error {
+		_, err := io.Copy(w, r.Body)
+		return err
+	})
+	femto.OnStream(mux, http.MethodGet, "/early", func(w http.ResponseWriter, r *http.Request, l *slog.Logger) error {
+		return errors.New("broken before replying")
+	})
+	femto.OnStream(mux, http.MethodGet, "/late", func(w http.ResponseWriter, r *http.Request, l *slog.Logger) error {
+		w.Write([]byte("partial"))
+		return errors.New("broken while replying")
+	})
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/echo", strings.NewReader("hello")))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello", w.Body.String())
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/echo", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/early", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "broken before replying")
+
+	// errors aren't written into the middle of a response
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/late", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "partial", w.Body.String())
+}
@@ -0,0 +1,69 @@
+// Package filestore keeps the contents of attached files outside the
+// database, which only records where they are.
+package filestore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotFound = errors.New("no such file in the store")
+	ErrTooLarge = errors.New("file is too large")
+)
+
+type Store interface {
+	// Put stores everything read from r under key, replacing anything already
+	// there. Nothing is stored if reading fails
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get returns the contents stored under key, or ErrNotFound
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the contents stored under key, if there are any
+	Delete(ctx context.Context, key string) error
+}
+
+// Where Save put a file, and what it found out about it on the way
+type Saved struct {
+	Key      string
+	Size     int64  // bytes
+	Checksum string // hex sha256
+}
+
+// Save streams r into the store under a new key, as long as it's no more than
+// limit bytes, or any size if limit is zero
+func Save(ctx context.Context, store Store, r io.Reader, limit int64) (Saved, error) {
+	saved := Saved{Key: uuid.NewString()}
+
+	hash := sha256.New()
+	counted := &countingReader{r: io.TeeReader(r, hash), limit: limit}
+	err := store.Put(ctx, saved.Key, counted)
+	if err != nil {
+		return Saved{}, err
+	}
+
+	saved.Size = counted.read
+	saved.Checksum = hex.EncodeToString(hash.Sum(nil))
+	return saved, nil
+}
+
+// counts what's read through it, failing with ErrTooLarge past the limit
+type countingReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += int64(n)
+	if c.limit > 0 && c.read > c.limit {
+		return n, fmt.Errorf("%w: over %d bytes", ErrTooLarge, c.limit)
+	}
+	return n, err
+}
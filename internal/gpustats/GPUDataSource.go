@@ -1,8 +1,36 @@
 package gpustats
 
-import "github.com/gpuctl/gpuctl/internal/uplink"
+import (
+	"errors"
+	"os/exec"
+
+	"github.com/gpuctl/gpuctl/internal/procinfo"
+	"github.com/gpuctl/gpuctl/internal/uplink"
+)
+
+var (
+	ErrNoVendorTool = errors.New("could not find nvidia-smi or rocm-smi on this machine")
+)
 
 type GPUDataSource interface {
 	GetGPUStatus() ([]uplink.GPUStatSample, error)
 	GetGPUInformation() ([]uplink.GPUInfo, error)
 }
+
+// Detect builds a data source from every vendor tool installed on this
+// machine, so mixed machines report all of their cards.
+func Detect(lookup procinfo.UidLookup, filter func(uplink.GPUProcInfo) bool) (Composite, error) {
+	var backends Composite
+
+	if _, err := exec.LookPath("nvidia-smi"); err == nil {
+		backends = append(backends, Backend{NvidiaBackend, NvidiaGPUHandler{Lookup: lookup, ProcFilter: filter}})
+	}
+	if _, err := exec.LookPath("rocm-smi"); err == nil {
+		backends = append(backends, Backend{AMDBackend, AMDGPUHandler{Lookup: lookup, ProcFilter: filter}})
+	}
+
+	if len(backends) == 0 {
+		return nil, ErrNoVendorTool
+	}
+	return backends, nil
+}
@@ -0,0 +1,467 @@
+package gpustats
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gpuctl/gpuctl/internal/procinfo"
+	"github.com/gpuctl/gpuctl/internal/uplink"
+
+	"github.com/google/uuid"
+)
+
+const (
+	rocmSystemKey = "system"
+	rocmPidPrefix = "pid"
+	bytesInMiB    = 1024 * 1024
+)
+
+var (
+	ErrNoCards = errors.New("rocm-smi did not report any cards")
+
+	// AMD don't give us a uuid, so we derive one from the card's unique id
+	// inside our own namespace
+	rocmNamespace = uuid.MustParse("6c1b5a0e-1d4e-4b8e-9a55-6d0c7f0e3a21")
+
+	rocmCardKey  = regexp.MustCompile(`^card[0-9]+$`)
+	rocmNumber   = regexp.MustCompile(`[0-9]+(\.[0-9]+)?`)
+	rocmGpuIndex = regexp.MustCompile(`[0-9]+`)
+)
+
+// The keys rocm-smi uses have changed capitalisation and wording across ROCm
+// releases, so each field lists every spelling we have seen, all lower case.
+var (
+	rocmUniqueId    = []string{"unique id"}
+	rocmSerial      = []string{"serial number"}
+	rocmPciBus      = []string{"pci bus"}
+	rocmName        = []string{"card series", "marketing name", "card model"}
+	rocmVendor      = []string{"card vendor"}
+	rocmDriver      = []string{"driver version"}
+	rocmVramTotal   = []string{"vram total memory (b)"}
+	rocmVramUsed    = []string{"vram total used memory (b)"}
+	rocmGpuUse      = []string{"gpu use (%)"}
+	rocmMemUse      = []string{"gpu memory allocated (vram%)", "gpu memory use (%)", "gpu memory usage (%)"}
+	rocmFan         = []string{"fan speed (%)"}
+	rocmTemp        = []string{"temperature (sensor edge) (c)", "temperature (sensor junction) (c)"}
+	rocmMemTemp     = []string{"temperature (sensor memory) (c)"}
+	rocmVoltage     = []string{"voltage (mv)"}
+	rocmPower       = []string{"average graphics package power (w)", "current socket graphics package power (w)"}
+	rocmSclk        = []string{"sclk clock speed:"}
+	rocmSclkRange   = []string{"valid sclk range"}
+	rocmMclk        = []string{"mclk clock speed:"}
+	rocmMclkRange   = []string{"valid mclk range"}
+	rocmUnavailable = []string{"n/a", "unknown", "unsupported", ""}
+)
+
+// rocmCard holds the fields rocm-smi reports for a single card, keyed by the
+// lower cased field name.
+type rocmCard map[string]string
+
+// RocmSmiLog is the parsed output of `rocm-smi --json`.
+type RocmSmiLog struct {
+	Cards  []rocmCard        // cards, ordered by their index
+	System map[string]string // system wide fields such as driver version and pids
+
+	// which gpu indices each pid is running on, from `rocm-smi --showpidgpus`
+	ProcessGpus map[uint64][]int
+
+	// used to build stable identifiers for cards that don't report a unique id
+	Hostname string
+}
+
+func (smi RocmSmiLog) ExtractGPUInfo() ([]uplink.GPUInfo, error) {
+	var res []uplink.GPUInfo
+
+	for _, card := range smi.Cards {
+		uuid, err := smi.cardUuid(card)
+		if err != nil {
+			return nil, err
+		}
+		mem, err := parseRocmFloat(card, rocmVramTotal, "vram total")
+		if err != nil {
+			return nil, err
+		}
+
+		driver := card.get(rocmDriver)
+		if driver == "" {
+			driver = lookup(smi.System, rocmDriver)
+		}
+
+		res = append(res,
+			uplink.GPUInfo{
+				Uuid:          uuid,
+				Name:          card.get(rocmName),
+				Brand:         rocmBrand(card.get(rocmVendor)),
+				DriverVersion: driver,
+				MemoryTotal:   uint64(mem / bytesInMiB),
+			})
+	}
+
+	return res, nil
+}
+
+// Filter down the relevant information from our rocm-smi dump
+func (smi RocmSmiLog) ExtractGPUStatSample() ([]uplink.GPUStatSample, error) {
+	var res []uplink.GPUStatSample
+
+	procs, errs := smi.processes()
+
+	for i, card := range smi.Cards {
+		gpuUtil, err := parseRocmFloat(card, rocmGpuUse, "gpu util")
+		memUtil, err_ := parseRocmFloat(card, rocmMemUse, "mem util")
+		err = errors.Join(err, err_)
+		memUsed, err_ := parseRocmFloat(card, rocmVramUsed, "mem used")
+		err = errors.Join(err, err_)
+		fanSpeed, err_ := parseRocmFloat(card, rocmFan, "fan speed")
+		err = errors.Join(err, err_)
+		temp, err_ := parseRocmFloat(card, rocmTemp, "gpu temp")
+		err = errors.Join(err, err_)
+		memTemp, err_ := parseRocmFloat(card, rocmMemTemp, "mem temp")
+		err = errors.Join(err, err_)
+		voltage, err_ := parseRocmFloat(card, rocmVoltage, "gpu volt")
+		err = errors.Join(err, err_)
+		power, err_ := parseRocmFloat(card, rocmPower, "power draw")
+		err = errors.Join(err, err_)
+		gFreq, err_ := parseRocmFloat(card, rocmSclk, "gpu clock")
+		err = errors.Join(err, err_)
+		maxGFreq, err_ := parseRocmRangeMax(card, rocmSclkRange, "gpu max clock")
+		err = errors.Join(err, err_)
+		mFreq, err_ := parseRocmFloat(card, rocmMclk, "mem clock")
+		err = errors.Join(err, err_)
+		maxMFreq, err_ := parseRocmRangeMax(card, rocmMclkRange, "max mem clock")
+		err = errors.Join(err, err_)
+		uuid, err_ := smi.cardUuid(card)
+		err = errors.Join(err, err_)
+
+		running := procs[i]
+		if running == nil {
+			running = []uplink.GPUProcInfo{}
+		}
+
+		res = append(res, uplink.GPUStatSample{
+			Uuid:              uuid,
+			MemoryUtilisation: memUtil,
+			GPUUtilisation:    gpuUtil,
+			MemoryUsed:        memUsed / bytesInMiB,
+			FanSpeed:          fanSpeed,
+			Temp:              temp,
+			MemoryTemp:        memTemp,
+			GraphicsVoltage:   voltage,
+			PowerDraw:         power,
+			GraphicsClock:     gFreq,
+			MaxGraphicsClock:  maxGFreq,
+			MemoryClock:       mFreq,
+			MaxMemoryClock:    maxMFreq,
+			RunningProcesses:  running,
+		})
+		errs = errors.Join(errs, err)
+	}
+
+	return res, errs
+}
+
+// Work out which processes are running on each card.
+//
+// rocm-smi reports pids as `"PID1234": "name, gpu count, vram bytes, sdma, cu"`
+// in the system section, without saying which card they are on. That comes
+// from a separate --showpidgpus call, and if we don't have it, we can only
+// place processes when there's a single card.
+func (smi RocmSmiLog) processes() (map[int][]uplink.GPUProcInfo, error) {
+	res := make(map[int][]uplink.GPUProcInfo)
+	var errs error
+
+	for key, value := range smi.System {
+		pidStr, found := strings.CutPrefix(key, rocmPidPrefix)
+		if !found {
+			continue
+		}
+
+		pid, err := strconv.ParseUint(strings.TrimSpace(pidStr), 10, 0)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("parsing pid %s: %w", pidStr, err))
+			continue
+		}
+
+		fields := strings.Split(value, ",")
+		name := strings.TrimSpace(fields[0])
+
+		var vram float64
+		gpuCount := 1.0
+		if len(fields) > 2 {
+			count, err1 := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+			bytes, err2 := strconv.ParseFloat(strings.TrimSpace(fields[2]), 64)
+			if err := errors.Join(err1, err2); err != nil {
+				errs = errors.Join(errs, fmt.Errorf("parsing pid %d: %w", pid, err))
+				continue
+			}
+			if count > 0 {
+				gpuCount = count
+			}
+			vram = bytes
+		}
+
+		gpus, known := smi.ProcessGpus[pid]
+		if !known && len(smi.Cards) == 1 {
+			gpus = []int{0}
+		}
+
+		for _, gpu := range gpus {
+			res[gpu] = append(res[gpu], uplink.GPUProcInfo{
+				Pid:     pid,
+				Name:    name,
+				MemUsed: vram / gpuCount / bytesInMiB,
+			})
+		}
+	}
+
+	// map iteration order is random, so sort to keep output stable
+	for gpu := range res {
+		slices.SortFunc(res[gpu], func(a, b uplink.GPUProcInfo) int {
+			return cmp.Compare(a.Pid, b.Pid)
+		})
+	}
+
+	return res, errs
+}
+
+// AMD cards have a 64 bit unique id rather than a uuid, so hash it into one.
+// Consumer cards often don't report a unique id, so fall back to the serial
+// number, and finally the PCI bus of the card on this host.
+func (smi RocmSmiLog) cardUuid(card rocmCard) (uuid.UUID, error) {
+	if id := card.get(rocmUniqueId); id != "" {
+		return uuid.NewSHA1(rocmNamespace, []byte("unique:"+strings.ToLower(id))), nil
+	}
+	if serial := card.get(rocmSerial); serial != "" {
+		return uuid.NewSHA1(rocmNamespace, []byte("serial:"+serial)), nil
+	}
+	if bus := card.get(rocmPciBus); bus != "" {
+		return uuid.NewSHA1(rocmNamespace, []byte("bus:"+smi.Hostname+"/"+strings.ToLower(bus))), nil
+	}
+	return uuid.UUID{}, fmt.Errorf("%w: card has no unique id, serial or pci bus", ErrBadField)
+}
+
+func rocmBrand(vendor string) string {
+	if strings.Contains(vendor, "AMD") || strings.Contains(vendor, "Advanced Micro Devices") {
+		return "AMD"
+	}
+	return vendor
+}
+
+// get the first available value for any of the given keys
+func (card rocmCard) get(keys []string) string {
+	return lookup(card, keys)
+}
+
+func lookup(fields map[string]string, keys []string) string {
+	for _, key := range keys {
+		value := strings.TrimSpace(fields[key])
+		if !isRocmUnavailable(value) {
+			return value
+		}
+	}
+	return ""
+}
+
+func isRocmUnavailable(value string) bool {
+	for _, na := range rocmUnavailable {
+		if strings.EqualFold(value, na) {
+			return true
+		}
+	}
+	return false
+}
+
+// rocm-smi decorates numbers with units and brackets, eg "(1600Mhz)", so pull
+// out the first number we see. Missing values are interpreted as 0.
+func parseRocmFloat(card rocmCard, keys []string, name string) (float64, error) {
+	value := card.get(keys)
+	if value == "" {
+		return 0, nil
+	}
+
+	number := rocmNumber.FindString(value)
+	val, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		err = fmt.Errorf("parsing %s: %w", name, err)
+	}
+	return val, err
+}
+
+// parse the top of a range such as "500Mhz - 1700Mhz"
+func parseRocmRangeMax(card rocmCard, keys []string, name string) (float64, error) {
+	value := card.get(keys)
+	if value == "" {
+		return 0, nil
+	}
+
+	numbers := rocmNumber.FindAllString(value, -1)
+	if len(numbers) == 0 {
+		return 0, fmt.Errorf("parsing %s: no number in %q", name, value)
+	}
+	val, err := strconv.ParseFloat(numbers[len(numbers)-1], 64)
+	if err != nil {
+		err = fmt.Errorf("parsing %s: %w", name, err)
+	}
+	return val, err
+}
+
+// Helper function to unmarshal rocm-smi's JSON dump
+func ParseRocmSmi(input []byte) (RocmSmiLog, error) {
+	var raw map[string]map[string]string
+	if err := json.Unmarshal(input, &raw); err != nil {
+		return RocmSmiLog{}, err
+	}
+
+	result := RocmSmiLog{System: make(map[string]string)}
+
+	// cards are keyed "card0", "card1", ... so order them by index
+	indices := make(map[int]rocmCard)
+	maxIndex := -1
+	for key, fields := range raw {
+		key = strings.ToLower(key)
+		if key == rocmSystemKey {
+			result.System = lowerKeys(fields)
+			continue
+		}
+		if !rocmCardKey.MatchString(key) {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimPrefix(key, "card"))
+		if err != nil {
+			return RocmSmiLog{}, err
+		}
+		indices[index] = lowerKeys(fields)
+		maxIndex = max(maxIndex, index)
+	}
+
+	if maxIndex < 0 {
+		return RocmSmiLog{}, ErrNoCards
+	}
+
+	for i := 0; i <= maxIndex; i++ {
+		card, ok := indices[i]
+		if !ok {
+			return RocmSmiLog{}, fmt.Errorf("rocm-smi skipped card%d", i)
+		}
+		result.Cards = append(result.Cards, card)
+	}
+
+	return result, nil
+}
+
+// Helper function to unmarshal the output of `rocm-smi --showpidgpus --json`,
+// which maps each pid to the gpu indices it's using, eg `"PID1234": "[0, 1]"`
+func ParseRocmPidGpus(input []byte) (map[uint64][]int, error) {
+	var raw map[string]map[string]string
+	if err := json.Unmarshal(input, &raw); err != nil {
+		return nil, err
+	}
+
+	result := make(map[uint64][]int)
+	for section, fields := range raw {
+		if strings.ToLower(section) != rocmSystemKey {
+			continue
+		}
+		for key, value := range lowerKeys(fields) {
+			pidStr, found := strings.CutPrefix(key, rocmPidPrefix)
+			if !found {
+				continue
+			}
+			pid, err := strconv.ParseUint(strings.TrimSpace(pidStr), 10, 0)
+			if err != nil {
+				return nil, fmt.Errorf("parsing pid %s: %w", pidStr, err)
+			}
+
+			for _, index := range rocmGpuIndex.FindAllString(value, -1) {
+				gpu, err := strconv.Atoi(index)
+				if err != nil {
+					return nil, err
+				}
+				result[pid] = append(result[pid], gpu)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func lowerKeys(fields map[string]string) map[string]string {
+	res := make(map[string]string, len(fields))
+	for key, value := range fields {
+		res[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return res
+}
+
+// Get the AMD GPU status directly from the computer using `rocm-smi`
+func getRocmGPUStatus() (RocmSmiLog, error) {
+	output, err := exec.Command("rocm-smi",
+		"--showproductname", "--showuniqueid", "--showserial", "--showbus",
+		"--showdriverversion", "--showmeminfo", "vram", "--showuse",
+		"--showmemuse", "--showpower", "--showtemp", "--showfan",
+		"--showvoltage", "--showclocks", "--showsclkrange",
+		"--showmclkrange", "--showpids", "--json",
+	).Output()
+	if err != nil {
+		return RocmSmiLog{}, err
+	}
+
+	smi, err := ParseRocmSmi(output)
+	if err != nil {
+		return RocmSmiLog{}, err
+	}
+
+	// Not knowing which gpu a process is on isn't fatal, so we ignore errors
+	output, err = exec.Command("rocm-smi", "--showpidgpus", "--json").Output()
+	if err == nil {
+		smi.ProcessGpus, _ = ParseRocmPidGpus(output)
+	}
+
+	smi.Hostname, _ = os.Hostname()
+
+	return smi, nil
+}
+
+// Adapter for AMD cards, via rocm-smi
+type AMDGPUHandler struct {
+	Lookup     procinfo.UidLookup            // Which Uid maps to which user
+	ProcFilter func(uplink.GPUProcInfo) bool // How we determine if a process is worth considering
+}
+
+// Run the whole pipeline of getting GPU information
+func (h AMDGPUHandler) GetGPUStatus() ([]uplink.GPUStatSample, error) {
+	smi, err := getRocmGPUStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: We try and not report minor parsing errors as catastrophic ones
+	samples, err := smi.ExtractGPUStatSample()
+
+	if samples == nil {
+		return nil, err
+	}
+
+	// filters can match on owners and command lines, so find them first
+	uplink.PopulateNames(samples, h.Lookup)
+	uplink.PopulateCmdlines(samples)
+	uplink.FilterProcesses(samples, h.ProcFilter)
+	return samples, err
+}
+
+// Run the whole pipeline of getting GPU information
+func (h AMDGPUHandler) GetGPUInformation() ([]uplink.GPUInfo, error) {
+	smi, err := getRocmGPUStatus()
+	if err != nil {
+		return nil, err
+	}
+	return smi.ExtractGPUInfo()
+}
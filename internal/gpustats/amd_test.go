@@ -0,0 +1,175 @@
+package gpustats
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gpuctl/gpuctl/internal/uplink"
+)
+
+const (
+	amdTestDataRoot           = "testdata/amd"
+	amdWorkingDataExtension   = ".rocm"
+	amdPidGpusExtension       = ".pidgpus"
+	amdCorruptedDataExtension = ".corruptedrocm"
+)
+
+// read a rocm-smi dump, along with the --showpidgpus output if we have it
+func readRocmDump(t *testing.T, fileloc string) (RocmSmiLog, error) {
+	t.Helper()
+
+	dump, err := os.ReadFile(fileloc)
+	if err != nil {
+		t.Fatalf("Could not read test data: %v", err)
+	}
+	smi, err := ParseRocmSmi(dump)
+	if err != nil {
+		return smi, err
+	}
+
+	pidloc := strings.TrimSuffix(fileloc, filepath.Ext(fileloc)) + amdPidGpusExtension
+	pidgpus, err := os.ReadFile(pidloc)
+	if errors.Is(err, os.ErrNotExist) {
+		return smi, nil
+	} else if err != nil {
+		t.Fatalf("Could not read pid gpu data: %v", err)
+	}
+
+	smi.ProcessGpus, err = ParseRocmPidGpus(pidgpus)
+	return smi, err
+}
+
+func TestRocmSmiJSONParsing(t *testing.T) {
+	t.Parallel()
+	files, err := os.ReadDir(amdTestDataRoot)
+	if err != nil {
+		t.Fatalf("Could not read test data root: %v", err)
+	}
+	for _, file := range files {
+		filename := file.Name()
+		if filepath.Ext(filename) != amdWorkingDataExtension {
+			continue
+		}
+		fileloc := amdTestDataRoot + "/" + filename
+		res, err := readRocmDump(t, fileloc)
+		if err != nil {
+			t.Errorf("Could not parse the rocm-smi dump at %s: %v", fileloc, err)
+			continue
+		}
+
+		stats, err := res.ExtractGPUStatSample()
+		if err != nil {
+			t.Errorf("Could not extract GPU status from rocm-smi dump: %v (file %s)", err, fileloc)
+			continue
+		}
+
+		info, err := res.ExtractGPUInfo()
+		if err != nil {
+			t.Errorf("Could not extract general GPU info from rocm-smi dump: %v (file %s)", err, fileloc)
+			continue
+		}
+
+		result := uplink.GpuStatsUpload{Hostname: "", GPUInfos: info, Stats: stats}
+		resultJson, err := json.Marshal(result)
+		if err != nil {
+			t.Errorf("Could not marshal status packet to JSON: %v (file %s)", err, fileloc)
+			continue
+		}
+
+		// Compare parsed resultJson data with expected output
+		sp := strings.Split(filename, ".")
+		resloc := amdTestDataRoot + "/" + sp[0] + ".json"
+		expected_dump, err := os.ReadFile(resloc)
+		if err != nil {
+			t.Fatalf("Could not read test result data at %s: %v", resloc, err)
+		}
+
+		var expected uplink.GpuStatsUpload
+		err = json.Unmarshal(expected_dump, &expected)
+		if err != nil {
+			t.Fatalf("Could not unmarshal test result data at %s: %v", resloc, err)
+		}
+
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("Result data did not match expected. \nGot      %s \nexpected %s \n(file: %s)", resultJson, string(expected_dump), fileloc)
+		}
+	}
+}
+
+func TestRocmSmiFaultyInput(t *testing.T) {
+	t.Parallel()
+	files, err := os.ReadDir(amdTestDataRoot)
+	if err != nil {
+		t.Fatalf("Could not read test data root: %v", err)
+	}
+
+	for _, file := range files {
+		filename := file.Name()
+		if filepath.Ext(filename) != faultyCallDataExtension {
+			continue
+		}
+		fileloc := amdTestDataRoot + "/" + filename
+		dump, err := os.ReadFile(fileloc)
+		if err != nil {
+			t.Fatalf("Could not read test data: %v", err)
+		}
+		_, err = ParseRocmSmi(dump)
+		if err == nil {
+			t.Errorf("Accepted invalid rocm-smi dump (file %s)", fileloc)
+		}
+	}
+}
+
+func TestRocmSmiInvalidDataParse(t *testing.T) {
+	t.Parallel()
+	files, err := os.ReadDir(amdTestDataRoot)
+	if err != nil {
+		t.Fatalf("Could not read test data root: %v", err)
+	}
+
+	for _, file := range files {
+		filename := file.Name()
+		if filepath.Ext(filename) != amdCorruptedDataExtension {
+			continue
+		}
+		fileloc := amdTestDataRoot + "/" + filename
+		smi, err := readRocmDump(t, fileloc)
+		if err != nil {
+			t.Errorf("Could not parse file %s: %v", fileloc, err)
+			continue
+		}
+
+		_, err = smi.ExtractGPUStatSample()
+		if err == nil {
+			t.Errorf("Accepted mangled data in parsing fields of rocm-smi data (file %s)", fileloc)
+		}
+	}
+}
+
+func TestRocmSmiNoCards(t *testing.T) {
+	t.Parallel()
+
+	_, err := ParseRocmSmi([]byte(`{"system": {"Driver version": "6.3.6"}}`))
+	if !errors.Is(err, ErrNoCards) {
+		t.Errorf("Expected ErrNoCards, got %v", err)
+	}
+}
+
+func TestRocmPidGpus(t *testing.T) {
+	t.Parallel()
+
+	gpus, err := ParseRocmPidGpus([]byte(`{"system": {"PID12": "[0, 2]", "PID 40": "1", "Driver version": "6.3.6"}}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[uint64][]int{12: {0, 2}, 40: {1}}
+	if !reflect.DeepEqual(gpus, expected) {
+		t.Errorf("Got %v, expected %v", gpus, expected)
+	}
+}
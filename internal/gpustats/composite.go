@@ -0,0 +1,88 @@
+package gpustats
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/gpuctl/gpuctl/internal/uplink"
+)
+
+// Names of the vendor backends, reported alongside each GPU
+const (
+	NvidiaBackend = uplink.NvidiaCollector
+	AMDBackend    = uplink.AMDCollector
+)
+
+// A single vendor's data source, along with the name we tag its GPUs with
+type Backend struct {
+	Name   string
+	Source GPUDataSource
+}
+
+// Composite fans out to several backends, for machines with cards from more
+// than one vendor, and merges what they report.
+//
+// One backend failing doesn't stop us reporting the others, so errors are
+// joined and returned alongside whatever data we did get, in the same way
+// as [NvidiaSmiLog.ExtractGPUStatSample]. We only return nil when every
+// backend fails.
+type Composite []Backend
+
+// Only the backends with the given names
+func (c Composite) Only(names []string) Composite {
+	var res Composite
+	for _, backend := range c {
+		if slices.Contains(names, backend.Name) {
+			res = append(res, backend)
+		}
+	}
+	return res
+}
+
+func (c Composite) GetGPUStatus() ([]uplink.GPUStatSample, error) {
+	var res []uplink.GPUStatSample
+	var errs error
+	succeeded := false
+
+	for _, backend := range c {
+		samples, err := backend.Source.GetGPUStatus()
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("%s: %w", backend.Name, err))
+		}
+		if samples != nil {
+			succeeded = true
+			res = append(res, samples...)
+		}
+	}
+
+	if !succeeded {
+		return nil, errs
+	}
+	return res, errs
+}
+
+func (c Composite) GetGPUInformation() ([]uplink.GPUInfo, error) {
+	var res []uplink.GPUInfo
+	var errs error
+	succeeded := false
+
+	for _, backend := range c {
+		infos, err := backend.Source.GetGPUInformation()
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("%s: %w", backend.Name, err))
+			continue
+		}
+
+		succeeded = true
+		for _, info := range infos {
+			info.Backend = backend.Name
+			res = append(res, info)
+		}
+	}
+
+	if !succeeded {
+		return nil, errs
+	}
+	return res, errs
+}
@@ -0,0 +1,95 @@
+package gpustats_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gpuctl/gpuctl/internal/gpustats"
+	"github.com/gpuctl/gpuctl/internal/uplink"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/google/uuid"
+)
+
+var errBroken = errors.New("vendor tool exploded")
+
+type brokenSource struct{}
+
+func (brokenSource) GetGPUStatus() ([]uplink.GPUStatSample, error) {
+	return nil, errBroken
+}
+
+func (brokenSource) GetGPUInformation() ([]uplink.GPUInfo, error) {
+	return nil, errBroken
+}
+
+func fakeSource() gpustats.FakeGPU {
+	return gpustats.FakeGPU{Uuids: [2]uuid.UUID{uuid.New(), uuid.New()}}
+}
+
+func TestCompositeMergesBackends(t *testing.T) {
+	t.Parallel()
+
+	first, second := fakeSource(), fakeSource()
+	src := gpustats.Composite{{Name: "first", Source: first}, {Name: "second", Source: second}}
+
+	infos, err := src.GetGPUInformation()
+	assert.NoError(t, err)
+	assert.Len(t, infos, 4)
+	for i, info := range infos {
+		if i < 2 {
+			assert.Equal(t, first.Uuids[i], info.Uuid)
+			assert.Equal(t, "first", info.Backend)
+		} else {
+			assert.Equal(t, second.Uuids[i-2], info.Uuid)
+			assert.Equal(t, "second", info.Backend)
+		}
+	}
+
+	stats, err := src.GetGPUStatus()
+	assert.NoError(t, err)
+	assert.Len(t, stats, 4)
+}
+
+func TestCompositePartialFailure(t *testing.T) {
+	t.Parallel()
+
+	working := fakeSource()
+	src := gpustats.Composite{{Name: "broken", Source: brokenSource{}}, {Name: "working", Source: working}}
+
+	infos, err := src.GetGPUInformation()
+	assert.ErrorIs(t, err, errBroken)
+	assert.ErrorContains(t, err, "broken")
+	assert.Len(t, infos, 2)
+	assert.Equal(t, "working", infos[0].Backend)
+
+	stats, err := src.GetGPUStatus()
+	assert.ErrorIs(t, err, errBroken)
+	assert.Len(t, stats, 2)
+}
+
+func TestCompositeTotalFailure(t *testing.T) {
+	t.Parallel()
+
+	src := gpustats.Composite{{Name: "a", Source: brokenSource{}}, {Name: "b", Source: brokenSource{}}}
+
+	infos, err := src.GetGPUInformation()
+	assert.ErrorIs(t, err, errBroken)
+	assert.Nil(t, infos)
+
+	stats, err := src.GetGPUStatus()
+	assert.ErrorIs(t, err, errBroken)
+	assert.Nil(t, stats)
+}
+
+func TestCompositeOnly(t *testing.T) {
+	t.Parallel()
+
+	src := gpustats.Composite{{Name: gpustats.NvidiaBackend, Source: fakeSource()}, {Name: gpustats.AMDBackend, Source: fakeSource()}}
+
+	only := src.Only([]string{gpustats.AMDBackend, "intel"})
+	assert.Len(t, only, 1)
+	assert.Equal(t, gpustats.AMDBackend, only[0].Name)
+
+	assert.Empty(t, src.Only([]string{"intel"}))
+}
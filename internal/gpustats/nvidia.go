@@ -450,8 +450,10 @@ func (h NvidiaGPUHandler) GetGPUStatus() ([]uplink.GPUStatSample, error) {
 		return nil, err
 	}
 
-	uplink.FilterProcesses(samples, h.ProcFilter)
+	// filters can match on owners and command lines, so find them first
 	uplink.PopulateNames(samples, h.Lookup)
+	uplink.PopulateCmdlines(samples)
+	uplink.FilterProcesses(samples, h.ProcFilter)
 	return samples, err
 }
 
@@ -0,0 +1,10 @@
+#!/bin/sh
+# usage: gatherdata.sh <username> <host>...
+username=$1
+shift
+
+for h in "$@"
+do
+    ssh $username@$h "rocm-smi --showproductname --showuniqueid --showserial --showbus --showdriverversion --showmeminfo vram --showuse --showmemuse --showpower --showtemp --showfan --showvoltage --showclocks --showsclkrange --showmclkrange --showpids --json" > dump_$h.rocm
+    ssh $username@$h "rocm-smi --showpidgpus --json" > dump_$h.pidgpus
+done
@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gpuctl/gpuctl/internal/broadcast"
 	"github.com/gpuctl/gpuctl/internal/database"
 	"github.com/gpuctl/gpuctl/internal/tunnel"
@@ -30,10 +31,18 @@ func (edb *ErrorDB) AppendDataPoint(sample uplink.GPUStatSample) error {
 	return nil
 }
 
+func (edb *ErrorDB) AppendDataPoints(samples []uplink.GPUStatSample) error {
+	return nil
+}
+
 func (edb *ErrorDB) UpdateGPUContext(host string, info uplink.GPUInfo) error {
 	return nil
 }
 
+func (edb *ErrorDB) GPUMachines(uuids []uuid.UUID) (map[uuid.UUID]string, error) {
+	return nil, nil
+}
+
 func (edb *ErrorDB) LatestData() (broadcast.Workstations, error) {
 	return nil, nil
 }
@@ -50,7 +59,7 @@ func (edb *ErrorDB) UpdateMachine(changes broadcast.ModifyMachine) error {
 	return nil
 }
 
-func (edb *ErrorDB) Downsample(time time.Time) error {
+func (edb *ErrorDB) Downsample(time time.Time, policy database.RetentionPolicy) error {
 	return nil
 }
 
@@ -58,12 +67,48 @@ func (edb *ErrorDB) RemoveMachine(changes broadcast.RemoveMachine) error {
 	return nil
 }
 
-func (edb *ErrorDB) AttachFile(attach broadcast.AttachFile) error {
+func (edb *ErrorDB) Groups() ([]broadcast.GroupInfo, error) {
+	return nil, nil
+}
+
+func (edb *ErrorDB) CreateGroup(group broadcast.GroupInfo) error {
+	return nil
+}
+
+func (edb *ErrorDB) UpdateGroup(changes broadcast.ModifyGroup) error {
+	return nil
+}
+
+func (edb *ErrorDB) RemoveGroup(remove broadcast.RemoveGroup) error {
+	return nil
+}
+
+func (edb *ErrorDB) MoveMachines(move broadcast.MoveMachines) error {
 	return nil
 }
 
-func (edb *ErrorDB) GetFile(hostname string, filename string) (broadcast.AttachFile, error) {
-	var file broadcast.AttachFile
+func (edb *ErrorDB) AddTag(tag broadcast.MachineTag) error {
+	return nil
+}
+
+func (edb *ErrorDB) RemoveTag(tag broadcast.MachineTag) error {
+	return nil
+}
+
+func (edb *ErrorDB) SetMetadata(meta broadcast.SetMetadata) error {
+	return nil
+}
+
+func (edb *ErrorDB) RemoveMetadata(remove broadcast.RemoveMetadata) error {
+	return nil
+}
+
+func (edb *ErrorDB) AttachFile(file broadcast.FileInfo) (string, error) {
+	return "", nil
+}
+
+func (edb *ErrorDB) GetFile(hostname string, filename string) (broadcast.FileInfo, error) {
+	var file broadcast.FileInfo
 	return file, nil
 }
 
@@ -71,15 +116,15 @@ func (edb *ErrorDB) Drop() error {
 	return nil
 }
 
-func (edb *ErrorDB) ListFiles(hostname string) ([]string, error) {
+func (edb *ErrorDB) ListFiles(hostname string) ([]broadcast.FileInfo, error) {
 	return nil, nil
 }
 
-func (edb *ErrorDB) RemoveFile(rem broadcast.RemoveFile) error {
-	return nil
+func (edb *ErrorDB) RemoveFile(rem broadcast.RemoveFile) (string, error) {
+	return "", nil
 }
 
-func (edb *ErrorDB) HistoricalData(hostname string) (broadcast.HistoricalData, error) {
+func (edb *ErrorDB) HistoricalData(hostname string, query database.HistoryQuery) (broadcast.HistoricalData, error) {
 	return nil, nil
 }
 
@@ -87,6 +132,54 @@ func (edb *ErrorDB) AggregateData() (broadcast.AggregateData, error) {
 	return broadcast.AggregateData{}, nil
 }
 
+func (edb *ErrorDB) SettingsOverrides() ([]broadcast.SettingsOverride, error) {
+	return nil, nil
+}
+
+func (edb *ErrorDB) SetSettingsOverride(override broadcast.SettingsOverride) error {
+	return nil
+}
+
+func (edb *ErrorDB) SettingsFor(hostname string) (uplink.Settings, uplink.Settings, error) {
+	return uplink.Settings{}, uplink.Settings{}, nil
+}
+
+func (edb *ErrorDB) LatestProcesses(hostname string) ([]broadcast.GPUProcesses, error) {
+	return nil, nil
+}
+
+func (edb *ErrorDB) Jobs(hostname string, from time.Time, to time.Time) ([]broadcast.Job, error) {
+	return nil, nil
+}
+
+func (edb *ErrorDB) Usage(from time.Time, to time.Time, by database.UsageBy, maxGap time.Duration) ([]broadcast.Usage, error) {
+	return nil, nil
+}
+
+func (edb *ErrorDB) InventoryEvents(hostname string, from time.Time, to time.Time) ([]broadcast.InventoryEvent, error) {
+	return nil, nil
+}
+
+func (edb *ErrorDB) FlagMissingGPUs(now time.Time, timeout time.Duration, slower map[string]time.Duration) ([]broadcast.InventoryEvent, error) {
+	return nil, nil
+}
+
+func (edb *ErrorDB) RecordAlert(alert broadcast.Alert) (int64, error) {
+	return 0, nil
+}
+
+func (edb *ErrorDB) RemoveAlert(id int64) error {
+	return nil
+}
+
+func (edb *ErrorDB) ActiveAlerts() ([]broadcast.Alert, error) {
+	return nil, nil
+}
+
+func (edb *ErrorDB) AlertHistory(from time.Time, to time.Time) ([]broadcast.Alert, error) {
+	return nil, nil
+}
+
 func TestPing(t *testing.T) {
 	t.Parallel()
 
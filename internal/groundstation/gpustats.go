@@ -1,20 +1,47 @@
 package groundstation
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
 	"time"
 
+	"github.com/gpuctl/gpuctl/internal/alerting"
 	"github.com/gpuctl/gpuctl/internal/database"
 	"github.com/gpuctl/gpuctl/internal/femto"
 	"github.com/gpuctl/gpuctl/internal/types"
 	"github.com/gpuctl/gpuctl/internal/uplink"
+	"github.com/gpuctl/gpuctl/internal/uplinkauth"
+
+	"github.com/google/uuid"
 )
 
+var ErrForeignGPU = errors.New("satellite reported on another machine's gpu")
+
 func (gs *groundstation) gpustats(data uplink.GpuStatsUpload, req *http.Request, log *slog.Logger) (*femto.EmptyBodyResponse, error) {
 	log.Info("Got GPU stats", "stats", data.Stats)
 
-	err := gs.db.UpdateLastSeen(data.Hostname, time.Now())
+	err := uplinkauth.CheckHostname(req, data.Hostname)
+	if err != nil {
+		log.Warn("Rejected GPU stats", "err", err)
+		return &femto.EmptyBodyResponse{Status: http.StatusForbidden}, err
+	}
+
+	status, err := gs.checkVersion(data.Hostname, data.Version, log)
+	if err != nil {
+		return &femto.EmptyBodyResponse{Status: status}, err
+	}
+
+	err = gs.checkGPUsOwned(req, data)
+	if err != nil {
+		log.Warn("Rejected GPU stats", "err", err)
+		return &femto.EmptyBodyResponse{Status: http.StatusForbidden}, err
+	}
+
+	err = gs.db.UpdateLastSeen(data.Hostname, time.Now())
 	if err != nil {
 		return nil, err
 	}
@@ -28,16 +55,57 @@ func (gs *groundstation) gpustats(data uplink.GpuStatsUpload, req *http.Request,
 
 	if len(data.Stats) > 0 {
 		err := gs.handleGPUStatSamples(data.Hostname, data.Stats)
-		if err == database.ErrGpuNotPresent {
+		if errors.Is(err, database.ErrGpuNotPresent) {
 			return &femto.EmptyBodyResponse{Status: http.StatusBadRequest}, err
 		} else if err != nil {
 			return nil, err
 		}
+
+		// the samples are in, so failing to alert on them shouldn't have
+		// them sent again
+		if len(gs.rules) > 0 {
+			err = alerting.EvaluateSamples(gs.db, gs.rules, data.Hostname, data.Stats, time.Now(), log)
+			if err != nil {
+				log.Error("Error evaluating alert rules", "error", err)
+			}
+		}
 	}
 
 	return femto.Ok(types.Unit{})
 }
 
+// checkGPUsOwned makes sure an authenticated satellite only reports on gpus
+// that are new or already in its machine, so it can't take over another
+// machine's gpus or send stats for them. A gpu that really has moved is
+// refused until the machine it was in is removed. Without authentication any
+// satellite can claim to be any machine anyway, so gpus can move freely.
+func (gs *groundstation) checkGPUsOwned(req *http.Request, data uplink.GpuStatsUpload) error {
+	if _, ok := uplinkauth.Hostname(req.Context()); !ok {
+		return nil
+	}
+
+	var uuids []uuid.UUID
+	for _, info := range data.GPUInfos {
+		uuids = append(uuids, info.Uuid)
+	}
+	for _, sample := range data.Stats {
+		uuids = append(uuids, sample.Uuid)
+	}
+	slices.SortFunc(uuids, func(a, b uuid.UUID) int { return bytes.Compare(a[:], b[:]) })
+	uuids = slices.Compact(uuids)
+
+	machines, err := gs.db.GPUMachines(uuids)
+	if err != nil {
+		return err
+	}
+	for gpu, machine := range machines {
+		if machine != data.Hostname {
+			return fmt.Errorf("%w: %s is in %s, not %s", ErrForeignGPU, gpu, machine, data.Hostname)
+		}
+	}
+	return nil
+}
+
 func (gs *groundstation) handleGPUInfo(host string, infos []uplink.GPUInfo) error {
 	for _, info := range infos {
 		err := gs.db.UpdateGPUContext(host, info)
@@ -49,11 +117,5 @@ func (gs *groundstation) handleGPUInfo(host string, infos []uplink.GPUInfo) erro
 }
 
 func (gs *groundstation) handleGPUStatSamples(host string, stats []uplink.GPUStatSample) error {
-	for _, sample := range stats {
-		err := gs.db.AppendDataPoint(sample)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	return gs.db.AppendDataPoints(stats)
 }
@@ -2,16 +2,25 @@ package groundstation_test
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/gpuctl/gpuctl/internal/alerting"
+	"github.com/gpuctl/gpuctl/internal/config"
 	"github.com/gpuctl/gpuctl/internal/database"
+	"github.com/gpuctl/gpuctl/internal/femto"
 	"github.com/gpuctl/gpuctl/internal/groundstation"
+	"github.com/gpuctl/gpuctl/internal/types"
 	"github.com/gpuctl/gpuctl/internal/uplink"
+	"github.com/gpuctl/gpuctl/internal/uplinkauth"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 /* Submission Side-Effect Testing */
@@ -26,7 +35,7 @@ func simulateCorruptedSubmissionAndGetResponse(method string) *http.Response {
 	req := httptest.NewRequest(method, uplink.GPUStatsUrl, &corruptedReader{})
 	w := httptest.NewRecorder()
 
-	s := groundstation.NewServer(database.InMemory())
+	s := groundstation.NewServer(database.InMemory(), nil, uplink.Settings{})
 	s.ServeHTTP(w, req)
 
 	res := w.Result()
@@ -39,7 +48,7 @@ func simulateSubmissionAndGetResponse(submission []byte, method string) *http.Re
 	req := httptest.NewRequest(method, uplink.GPUStatsUrl, bytes.NewBuffer(submission))
 	w := httptest.NewRecorder()
 
-	s := groundstation.NewServer(database.InMemory())
+	s := groundstation.NewServer(database.InMemory(), nil, uplink.Settings{})
 	s.ServeHTTP(w, req)
 
 	res := w.Result()
@@ -95,3 +104,89 @@ func TestHandleBadJsonDeserialisation(t *testing.T) {
 
 // Once DB connection is made, should test that we can fail on error states during
 // interaction with DB
+
+func TestGPUsOfOtherMachinesAreRefused(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("groundstation secret")
+	db := database.InMemory()
+	srv := httptest.NewServer(groundstation.NewServer(db, &uplinkauth.Verifier{Secret: secret}, uplink.Settings{}))
+	defer srv.Close()
+
+	upload := func(hostname string, data uplink.GpuStatsUpload) int {
+		t.Helper()
+		client := &femto.Client{Transport: uplinkauth.Signer{Hostname: hostname, Token: uplinkauth.Token(secret, hostname)}}
+		data.Hostname = hostname
+
+		_, err := femto.PostJSON[uplink.GpuStatsUpload, types.Unit](context.Background(), client, srv.URL+uplink.GPUStatsUrl, data)
+		var statusErr *femto.StatusError
+		if errors.As(err, &statusErr) {
+			return statusErr.Status
+		}
+		require.NoError(t, err)
+		return http.StatusOK
+	}
+
+	ours, theirs := uuid.New(), uuid.New()
+	assert.Equal(t, http.StatusOK, upload("ash01", uplink.GpuStatsUpload{
+		GPUInfos: []uplink.GPUInfo{{Uuid: ours, Name: "GT 1030"}},
+		Stats:    []uplink.GPUStatSample{{Uuid: ours, Time: 1}},
+	}))
+
+	// ash02 can neither take over ash01's gpu, nor send stats for it
+	assert.Equal(t, http.StatusForbidden, upload("ash02", uplink.GpuStatsUpload{
+		GPUInfos: []uplink.GPUInfo{{Uuid: theirs}, {Uuid: ours}},
+	}))
+	assert.Equal(t, http.StatusForbidden, upload("ash02", uplink.GpuStatsUpload{
+		Stats: []uplink.GPUStatSample{{Uuid: ours, Time: 2}},
+	}))
+
+	machines, err := db.GPUMachines([]uuid.UUID{ours, theirs})
+	require.NoError(t, err)
+	assert.Equal(t, map[uuid.UUID]string{ours: "ash01"}, machines)
+
+	history, err := db.HistoricalData("ash01", database.HistoryQuery{})
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Len(t, history[0], 1)
+
+	// but it can still report on its own
+	assert.Equal(t, http.StatusOK, upload("ash02", uplink.GpuStatsUpload{
+		GPUInfos: []uplink.GPUInfo{{Uuid: theirs}},
+		Stats:    []uplink.GPUStatSample{{Uuid: theirs, Time: 2}},
+	}))
+}
+
+func TestEverySampleIsCheckedForAlerts(t *testing.T) {
+	t.Parallel()
+
+	rules, err := alerting.ParseRules([]config.AlertRule{{Name: "overheating", Rule: "gpu_temp > 88"}})
+	require.NoError(t, err)
+
+	db := database.InMemory()
+	gs := groundstation.NewServer(db, nil, uplink.Settings{})
+	gs.CheckAlerts(rules)
+	srv := httptest.NewServer(gs)
+	defer srv.Close()
+
+	// the spike is in the middle of the batch
+	gpu := uuid.New()
+	_, err = femto.PostJSON[uplink.GpuStatsUpload, types.Unit](context.Background(), &femto.Client{}, srv.URL+uplink.GPUStatsUrl, uplink.GpuStatsUpload{
+		Hostname: "ash01",
+		GPUInfos: []uplink.GPUInfo{{Uuid: gpu}},
+		Stats: []uplink.GPUStatSample{
+			{Uuid: gpu, Temp: 60, Time: 1},
+			{Uuid: gpu, Temp: 95, Time: 2},
+			{Uuid: gpu, Temp: 60, Time: 3},
+		},
+	})
+	require.NoError(t, err)
+
+	history, err := db.AlertHistory(time.Unix(0, 0), time.Unix(10, 0))
+	require.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, "ash01", history[0].Hostname)
+		assert.Equal(t, int64(2), history[0].FiredAt)
+		assert.Equal(t, int64(3), history[0].ResolvedAt)
+	}
+}
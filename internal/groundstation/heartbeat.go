@@ -6,18 +6,44 @@ import (
 	"time"
 
 	"github.com/gpuctl/gpuctl/internal/femto"
-	"github.com/gpuctl/gpuctl/internal/types"
 	"github.com/gpuctl/gpuctl/internal/uplink"
+	"github.com/gpuctl/gpuctl/internal/uplinkauth"
 )
 
-func (gs *groundstation) heartbeat(data uplink.HeartbeatReq, req *http.Request, log *slog.Logger) (*femto.EmptyBodyResponse, error) {
+func (gs *groundstation) heartbeat(data uplink.HeartbeatReq, req *http.Request, log *slog.Logger) (*femto.Response[uplink.HeartbeatResp], error) {
 	log.Info("Received a heartbeat", "satellite", data.Hostname)
 
-	err := gs.db.UpdateLastSeen(data.Hostname, time.Now())
+	err := uplinkauth.CheckHostname(req, data.Hostname)
+	if err != nil {
+		log.Warn("Rejected heartbeat", "err", err)
+		return &femto.Response[uplink.HeartbeatResp]{Status: http.StatusForbidden}, err
+	}
+
+	status, err := gs.checkVersion(data.Hostname, data.Version, log)
+	if err != nil {
+		return &femto.Response[uplink.HeartbeatResp]{Status: status}, err
+	}
+
+	err = gs.db.UpdateLastSeen(data.Hostname, time.Now())
+
+	if err != nil {
+		return nil, err
+	}
 
+	settings, err := gs.settingsFor(data.Hostname)
 	if err != nil {
 		return nil, err
 	}
 
-	return femto.Ok(types.Unit{})
+	return femto.Ok(uplink.HeartbeatResp{Settings: settings})
+}
+
+// The settings a satellite should run with: our defaults, overridden by its
+// group's, overridden by its own
+func (gs *groundstation) settingsFor(hostname string) (uplink.Settings, error) {
+	group, machine, err := gs.db.SettingsFor(hostname)
+	if err != nil {
+		return uplink.Settings{}, err
+	}
+	return gs.settings.Override(group).Override(machine), nil
 }
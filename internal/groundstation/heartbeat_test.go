@@ -2,20 +2,29 @@ package groundstation_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/gpuctl/gpuctl/internal/broadcast"
 	"github.com/gpuctl/gpuctl/internal/database"
+	"github.com/gpuctl/gpuctl/internal/femto"
 	"github.com/gpuctl/gpuctl/internal/groundstation"
+	"github.com/gpuctl/gpuctl/internal/pki"
+	"github.com/gpuctl/gpuctl/internal/types"
 	"github.com/gpuctl/gpuctl/internal/uplink"
+	"github.com/gpuctl/gpuctl/internal/uplinkauth"
 )
 
 func TestHeartbeatRace(t *testing.T) {
 	t.Parallel()
 
-	srv := groundstation.NewServer(database.InMemory())
+	srv := groundstation.NewServer(database.InMemory(), nil, uplink.Settings{})
 	var wg sync.WaitGroup
 
 	toSpawn := 100
@@ -43,3 +52,121 @@ func TestHeartbeatRace(t *testing.T) {
 		t.Error("one of the responces didn't return 200")
 	}
 }
+
+func TestHeartbeatAuthentication(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("groundstation secret")
+	srv := httptest.NewServer(groundstation.NewServer(database.InMemory(), &uplinkauth.Verifier{Secret: secret}, uplink.Settings{}))
+	defer srv.Close()
+
+	signed := &femto.Client{Transport: uplinkauth.Signer{Hostname: "ash01", Token: uplinkauth.Token(secret, "ash01")}}
+
+	testCases := []struct {
+		name     string
+		client   *femto.Client
+		hostname string
+		status   int
+	}{
+		{"Unsigned", &femto.Client{}, "ash01", http.StatusUnauthorized},
+		{"Signed", signed, "ash01", http.StatusOK},
+		{"Spoofed Hostname", signed, "ash02", http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status := sendHeartbeat(t, tc.client, srv.URL, tc.hostname)
+			if status != tc.status {
+				t.Errorf("Got status %d, expected %d", status, tc.status)
+			}
+		})
+	}
+}
+
+// send a heartbeat, returning the status the groundstation replied with
+func sendHeartbeat(t *testing.T, client *femto.Client, url string, hostname string) int {
+	t.Helper()
+
+	_, err := femto.PostJSON[uplink.HeartbeatReq, types.Unit](context.Background(), client, url+uplink.HeartbeatUrl, uplink.HeartbeatReq{Hostname: hostname})
+
+	var statusErr *femto.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status
+	} else if err != nil {
+		t.Fatalf("Failed to send heartbeat: %v", err)
+	}
+	return http.StatusOK
+}
+
+func TestHeartbeatClientCertificate(t *testing.T) {
+	t.Parallel()
+
+	ca, err := pki.NewCA()
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+
+	srv := httptest.NewUnstartedServer(groundstation.NewServer(database.InMemory(), nil, uplink.Settings{}))
+	certPEM, keyPEM, err := ca.IssueServer("127.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed to issue server certificate: %v", err)
+	}
+	srv.TLS, err = ca.ServerConfig(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("Failed to create server TLS config: %v", err)
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	certPEM, keyPEM, err = ca.IssueClient("ash01")
+	if err != nil {
+		t.Fatalf("Failed to issue client certificate: %v", err)
+	}
+	tlsConf, err := pki.ClientConfig(ca.CertPEM, certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("Failed to create client TLS config: %v", err)
+	}
+	client := &femto.Client{Transport: &http.Transport{TLSClientConfig: tlsConf}}
+
+	// a satellite can only send heartbeats for the machine on its certificate
+	for hostname, status := range map[string]int{"ash01": http.StatusOK, "ash02": http.StatusForbidden} {
+		got := sendHeartbeat(t, client, srv.URL, hostname)
+		if got != status {
+			t.Errorf("Heartbeat for %s got status %d, expected %d", hostname, got, status)
+		}
+	}
+}
+
+func TestHeartbeatSendsSettings(t *testing.T) {
+	t.Parallel()
+
+	db := database.InMemory()
+	defaults := uplink.Settings{DataInterval: time.Minute, HeartbeatInterval: 5 * time.Second}
+	srv := httptest.NewServer(groundstation.NewServer(db, nil, defaults))
+	defer srv.Close()
+
+	heartbeat := func() uplink.Settings {
+		t.Helper()
+		resp, err := femto.PostJSON[uplink.HeartbeatReq, uplink.HeartbeatResp](context.Background(), &femto.Client{}, srv.URL+uplink.HeartbeatUrl, uplink.HeartbeatReq{Hostname: "ash01"})
+		if err != nil {
+			t.Fatalf("Failed to send heartbeat: %v", err)
+		}
+		return resp.Settings
+	}
+
+	if got := heartbeat(); !reflect.DeepEqual(got, defaults) {
+		t.Errorf("Got settings %+v, expected the defaults %+v", got, defaults)
+	}
+
+	// changes are picked up by the next heartbeat
+	override := uplink.Settings{DataInterval: 10 * time.Second, ProcessFilter: &uplink.ProcessFilter{Include: []uplink.ProcessMatch{{Name: "ollama"}}}}
+	err := db.SetSettingsOverride(broadcast.SettingsOverride{Hostname: "ash01", Settings: override})
+	if err != nil {
+		t.Fatalf("Failed to override settings: %v", err)
+	}
+
+	expected := uplink.Settings{DataInterval: 10 * time.Second, HeartbeatInterval: 5 * time.Second, ProcessFilter: override.ProcessFilter}
+	if got := heartbeat(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got settings %+v, expected %+v", got, expected)
+	}
+}
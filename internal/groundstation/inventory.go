@@ -0,0 +1,70 @@
+package groundstation
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gpuctl/gpuctl/internal/config"
+	"github.com/gpuctl/gpuctl/internal/database"
+	"github.com/gpuctl/gpuctl/internal/uplink"
+)
+
+// Flags the gpus that stop sending samples while their machine is still
+// alive, and unflags them when they start again. Satellites are taken to run
+// with settings, unless there are overrides for them in the database, as the
+// groundstation asks them to
+func MonitorInventory(database database.Database, timeouts config.Timeouts, settings uplink.Settings, l *slog.Logger) error {
+	inventoryTicker := time.NewTicker(timeouts.MonitorInterval())
+
+	for t := range inventoryTicker.C {
+		err := flagMissing(database, t, timeouts.DeathTimeout(), settings, l)
+
+		if err != nil {
+			l.Error("Error flagging missing gpus:", "error", err)
+		}
+	}
+
+	return nil
+}
+
+// flags and unflags gpus as of now, logging each one that changed
+func flagMissing(database database.Database, now time.Time, timeout time.Duration, settings uplink.Settings, l *slog.Logger) error {
+	slower, err := slowerMachines(database, timeout, settings)
+	if err != nil {
+		return err
+	}
+
+	events, err := database.FlagMissingGPUs(now, timeout, slower)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		l.Warn("GPU inventory changed", "hostname", event.Hostname, "gpu", event.Gpu, "kind", event.Kind)
+	}
+	return nil
+}
+
+// The machines set to send samples too rarely for their gpus to be flagged
+// after timeout, and how long theirs should have instead: two of their data
+// intervals, so one late upload isn't taken as the gpus having gone
+func slowerMachines(database database.Database, timeout time.Duration, settings uplink.Settings) (map[string]time.Duration, error) {
+	seens, err := database.LastSeen()
+	if err != nil {
+		return nil, err
+	}
+
+	slower := make(map[string]time.Duration)
+	for _, seen := range seens {
+		group, machine, err := database.SettingsFor(seen.Hostname)
+		if err != nil {
+			return nil, err
+		}
+
+		interval := settings.Override(group).Override(machine).DataInterval
+		if 2*interval > timeout {
+			slower[seen.Hostname] = 2 * interval
+		}
+	}
+	return slower, nil
+}
@@ -0,0 +1,66 @@
+package groundstation
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+	"github.com/gpuctl/gpuctl/internal/database"
+	"github.com/gpuctl/gpuctl/internal/uplink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlagMissing(t *testing.T) {
+	db := database.InMemory()
+	logger := slog.Default()
+
+	gpu := uuid.New()
+	currentTime := time.Now()
+	require.NoError(t, db.UpdateGPUContext("machineAlive", uplink.GPUInfo{Uuid: gpu, Name: "GT 1030"}))
+	require.NoError(t, db.AppendDataPoint(uplink.GPUStatSample{Uuid: gpu, Time: currentTime.Add(-time.Hour).Unix()}))
+	require.NoError(t, db.UpdateLastSeen("machineAlive", currentTime))
+
+	err := flagMissing(db, currentTime, 5*time.Minute, uplink.Settings{}, logger)
+	assert.NoError(t, err)
+
+	events, err := db.InventoryEvents("machineAlive", currentTime.Add(-time.Minute), currentTime)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2, "Should have recorded the gpu appearing, then going missing")
+}
+
+func TestGPUsOfSlowMachinesArentFlaggedEarly(t *testing.T) {
+	db := database.InMemory()
+	logger := slog.Default()
+	now := time.Now()
+
+	// the lab sends every 10 minutes, and gpu02 every 20
+	lab := "lab"
+	require.NoError(t, db.NewMachine(broadcast.NewMachine{Hostname: "gpu01", Group: &lab}))
+	require.NoError(t, db.NewMachine(broadcast.NewMachine{Hostname: "gpu02", Group: &lab}))
+	require.NoError(t, db.SetSettingsOverride(broadcast.SettingsOverride{Group: lab, Settings: uplink.Settings{DataInterval: 10 * time.Minute}}))
+	require.NoError(t, db.SetSettingsOverride(broadcast.SettingsOverride{Hostname: "gpu02", Settings: uplink.Settings{DataInterval: 20 * time.Minute}}))
+
+	// each has been sending heartbeats, but not samples for half an hour
+	gpus := map[string]uuid.UUID{"gpu01": uuid.New(), "gpu02": uuid.New(), "gpu03": uuid.New()}
+	for hostname, gpu := range gpus {
+		require.NoError(t, db.UpdateGPUContext(hostname, uplink.GPUInfo{Uuid: gpu}))
+		require.NoError(t, db.AppendDataPoint(uplink.GPUStatSample{Uuid: gpu, Time: now.Add(-30 * time.Minute).Unix()}))
+		require.NoError(t, db.UpdateLastSeen(hostname, now))
+	}
+
+	// gpu03 sends every minute, as the groundstation asks by default
+	require.NoError(t, flagMissing(db, now, 5*time.Minute, uplink.Settings{DataInterval: time.Minute}, logger))
+
+	for hostname, gpu := range gpus {
+		events, err := db.InventoryEvents(hostname, now.Add(-time.Minute), now)
+		require.NoError(t, err)
+		missing := false
+		for _, event := range events {
+			missing = missing || (event.Gpu == gpu && event.Kind == broadcast.GPUMissing)
+		}
+		assert.Equal(t, hostname != "gpu02", missing, hostname)
+	}
+}
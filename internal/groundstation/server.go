@@ -1,33 +1,59 @@
 package groundstation
 
 import (
+	"log/slog"
 	"net/http"
+	"sync"
 
+	"github.com/gpuctl/gpuctl/internal/alerting"
 	"github.com/gpuctl/gpuctl/internal/database"
 	"github.com/gpuctl/gpuctl/internal/femto"
 	"github.com/gpuctl/gpuctl/internal/uplink"
+	"github.com/gpuctl/gpuctl/internal/uplinkauth"
 )
 
 type Server struct {
-	mux *femto.Femto
-	gs  *groundstation
+	mux     *femto.Femto
+	handler http.Handler
+	gs      *groundstation
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.mux.ServeHTTP(w, r)
+	s.handler.ServeHTTP(w, r)
 }
 
-func NewServer(db database.Database) *Server {
+// NewServer creates the groundstation. If auth is nil, requests aren't
+// signed, so any satellite can claim to be any machine, unless the listener
+// requires client certificates. Satellites are asked to run with settings,
+// unless there are overrides for them in the database.
+func NewServer(db database.Database, auth *uplinkauth.Verifier, settings uplink.Settings) *Server {
 	mux := new(femto.Femto)
-	gs := &groundstation{db}
+	gs := &groundstation{db: db, settings: settings}
 
 	/// Register routes.
-	femto.OnPost(mux, uplink.HeartbeatUrl, gs.heartbeat)
+	femto.OnPostWithResponse(mux, uplink.HandshakeUrl, gs.handshake)
+	femto.OnPostWithResponse(mux, uplink.HeartbeatUrl, gs.heartbeat)
 	femto.OnPost(mux, uplink.GPUStatsUrl, gs.gpustats)
+	femto.OnPost(mux, uplink.LegacyGPUStatsUrl, gs.gpustats)
 
-	return &Server{mux, gs}
+	var handler http.Handler = mux
+	if auth != nil {
+		handler = auth.Middleware(mux, slog.Default())
+	}
+	handler = uplinkauth.CertMiddleware(handler)
+
+	return &Server{mux, handler, gs}
+}
+
+// CheckAlerts has the groundstation check the alerting rules about gpus
+// against every sample it's sent. It has to be called before serving
+func (s *Server) CheckAlerts(rules []alerting.Rule) {
+	s.gs.rules = rules
 }
 
 type groundstation struct {
-	db database.Database
+	db       database.Database
+	settings uplink.Settings
+	rules    []alerting.Rule
+	warned   sync.Map // hostname/protocol of satellites we've warned are out of date
 }
@@ -0,0 +1,69 @@
+package groundstation
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gpuctl/gpuctl/internal/femto"
+	"github.com/gpuctl/gpuctl/internal/uplink"
+	"github.com/gpuctl/gpuctl/internal/uplinkauth"
+)
+
+func (gs *groundstation) handshake(data uplink.HandshakeReq, req *http.Request, log *slog.Logger) (*femto.Response[uplink.HandshakeResp], error) {
+	log.Info("Received a handshake", "satellite", data.Hostname, "protocol", data.Protocol, "satellite_version", data.SatelliteVersion)
+
+	err := uplinkauth.CheckHostname(req, data.Hostname)
+	if err != nil {
+		log.Warn("Rejected handshake", "err", err)
+		return &femto.Response[uplink.HandshakeResp]{Status: http.StatusForbidden}, err
+	}
+
+	settings, err := gs.settingsFor(data.Hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	// A satellite that's too old still gets told what we support, so it can
+	// say why it's giving up
+	resp, _ := femto.Ok(uplink.HandshakeResp{
+		MinProtocol: uplink.MinProtocolVersion,
+		MaxProtocol: uplink.ProtocolVersion,
+		Settings:    settings,
+	})
+
+	status, err := gs.checkVersion(data.Hostname, data.Version, log)
+	if err != nil {
+		resp.Status = status
+	}
+	return resp, nil
+}
+
+// checkVersion makes sure we can understand a satellite's messages, returning
+// the status to reply with if not. Satellites on older protocols we still
+// support are warned about once each, so they can be found and updated.
+func (gs *groundstation) checkVersion(hostname string, version uplink.Version, log *slog.Logger) (int, error) {
+	protocol := version.ProtocolOrLegacy()
+	log = log.With("satellite", hostname, "protocol", protocol, "satellite_version", version.SatelliteVersion)
+
+	switch {
+	case protocol < uplink.MinProtocolVersion:
+		log.Error("Satellite is too old for this groundstation, it must be updated", "min_protocol", uplink.MinProtocolVersion)
+		return http.StatusUpgradeRequired, fmt.Errorf("protocol %d is no longer supported, the oldest supported is %d", protocol, uplink.MinProtocolVersion)
+	case protocol < uplink.ProtocolVersion:
+		if gs.firstWarning(hostname, protocol) {
+			log.Warn("Satellite is using an old protocol, and should be updated", "current_protocol", uplink.ProtocolVersion)
+		}
+	case protocol > uplink.ProtocolVersion:
+		if gs.firstWarning(hostname, protocol) {
+			log.Warn("Satellite is using a newer protocol than this groundstation, some of what it sends may be ignored", "current_protocol", uplink.ProtocolVersion)
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// whether this is the first time we've seen a satellite using this protocol
+func (gs *groundstation) firstWarning(hostname string, protocol int) bool {
+	_, warned := gs.warned.LoadOrStore(fmt.Sprintf("%s/%d", hostname, protocol), struct{}{})
+	return !warned
+}
@@ -0,0 +1,71 @@
+package groundstation_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gpuctl/gpuctl/internal/database"
+	"github.com/gpuctl/gpuctl/internal/femto"
+	"github.com/gpuctl/gpuctl/internal/groundstation"
+	"github.com/gpuctl/gpuctl/internal/uplink"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandshake(t *testing.T) {
+	t.Parallel()
+
+	settings := uplink.Settings{DataInterval: 15 * time.Second, HeartbeatInterval: 5 * time.Second}
+	srv := httptest.NewServer(groundstation.NewServer(database.InMemory(), nil, settings))
+	defer srv.Close()
+
+	resp, err := femto.PostJSON[uplink.HandshakeReq, uplink.HandshakeResp](
+		context.Background(),
+		&femto.Client{},
+		srv.URL+uplink.HandshakeUrl,
+		uplink.HandshakeReq{Hostname: "ash01", Version: uplink.Version{Protocol: uplink.ProtocolVersion, SatelliteVersion: "abc123"}},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, uplink.HandshakeResp{
+		MinProtocol: uplink.MinProtocolVersion,
+		MaxProtocol: uplink.ProtocolVersion,
+		Settings:    settings,
+	}, resp)
+}
+
+// Version 1 satellites don't say what they are, and send stats to the old URL
+func TestLegacySatellite(t *testing.T) {
+	t.Parallel()
+
+	s := groundstation.NewServer(database.InMemory(), nil, uplink.Settings{})
+
+	for _, url := range []string{uplink.HeartbeatUrl, uplink.LegacyGPUStatsUrl} {
+		req := httptest.NewRequest(http.MethodPost, url, bytes.NewBufferString(`{"hostname": "ash01"}`))
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code, url)
+	}
+}
+
+func TestNewerSatelliteIsAccepted(t *testing.T) {
+	t.Parallel()
+
+	s := groundstation.NewServer(database.InMemory(), nil, uplink.Settings{})
+
+	req := httptest.NewRequest(http.MethodPost, uplink.GPUStatsUrl, bytes.NewBufferString(`{"hostname": "ash01", "protocol": 99, "unknown_field": 1}`))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestProtocolOrLegacy(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, 1, uplink.Version{}.ProtocolOrLegacy())
+	assert.Equal(t, uplink.ProtocolVersion, uplink.Version{Protocol: uplink.ProtocolVersion}.ProtocolOrLegacy())
+}
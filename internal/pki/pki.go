@@ -0,0 +1,292 @@
+// Package pki is a tiny certificate authority, so the control server can issue
+// client certificates to the satellites it onboards, and check them when they
+// connect to the groundstation.
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	caLifetime   = 10 * 365 * 24 * time.Hour
+	certLifetime = 2 * 365 * 24 * time.Hour
+
+	// Organisation name put on everything we issue
+	organisation = "gpuctl"
+)
+
+var (
+	ErrNoCertificate = errors.New("pki: no certificate in PEM data")
+	ErrNoKey         = errors.New("pki: no private key in PEM data")
+	ErrNotCA         = errors.New("pki: certificate is not a CA")
+	ErrNoHosts       = errors.New("pki: server certificate needs at least one host")
+	ErrPartialCA     = errors.New("pki: only one of the CA's certificate and key exists")
+)
+
+type CA struct {
+	Cert    *x509.Certificate
+	CertPEM []byte
+	key     crypto.Signer
+}
+
+// NewCA creates a fresh self-signed CA
+func NewCA() (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := newTemplate("gpuctl CA", caLifetime)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{cert, encodeCert(der), key}, nil
+}
+
+// LoadCA reads a CA certificate and key from PEM files
+func LoadCA(certPath string, keyPath string) (*CA, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, ErrNoCertificate
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, ErrNotCA
+	}
+
+	key, err := decodeKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{cert, certPEM, key}, nil
+}
+
+// LoadOrCreateCA loads the CA at the given paths, or creates one and saves it
+// there if neither file exists yet. If only one does, that's an error rather
+// than replacing it, as every certificate the CA issued would stop verifying
+func LoadOrCreateCA(certPath string, keyPath string) (*CA, error) {
+	certExists, err := exists(certPath)
+	if err != nil {
+		return nil, err
+	}
+	keyExists, err := exists(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case certExists && keyExists:
+		return LoadCA(certPath, keyPath)
+	case certExists:
+		return nil, fmt.Errorf("%w: %s is missing", ErrPartialCA, keyPath)
+	case keyExists:
+		return nil, fmt.Errorf("%w: %s is missing", ErrPartialCA, certPath)
+	}
+
+	ca, err := NewCA()
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := encodeKey(ca.key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(certPath, ca.CertPEM, 0o644); err != nil {
+		return nil, err
+	}
+
+	return ca, nil
+}
+
+func exists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// IssueClient creates a certificate for the satellite on the given host,
+// returning the PEM encoded certificate and key
+func (ca *CA) IssueClient(hostname string) (certPEM []byte, keyPEM []byte, err error) {
+	return ca.issue(hostname, []string{hostname}, x509.ExtKeyUsageClientAuth)
+}
+
+// IssueServer creates a certificate for the groundstation, valid for the given
+// DNS names or IP addresses
+func (ca *CA) IssueServer(hosts ...string) (certPEM []byte, keyPEM []byte, err error) {
+	if len(hosts) == 0 {
+		return nil, nil, ErrNoHosts
+	}
+	return ca.issue(hosts[0], hosts, x509.ExtKeyUsageServerAuth)
+}
+
+func (ca *CA) issue(name string, hosts []string, usage x509.ExtKeyUsage) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template, err := newTemplate(name, certLifetime)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encodeCert(der), keyPEM, nil
+}
+
+// ServerConfig is the TLS configuration for a groundstation that only accepts
+// satellites with a certificate issued by this CA
+func (ca *CA) ServerConfig(certPEM []byte, keyPEM []byte) (*tls.Config, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ClientConfig is the TLS configuration for a satellite, which only trusts
+// groundstations with a certificate from caPEM, and presents the given
+// certificate. The certificate is optional.
+func ClientConfig(caPEM []byte, certPEM []byte, keyPEM []byte) (*tls.Config, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, ErrNoCertificate
+	}
+
+	conf := &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if certPEM != nil || keyPEM != nil {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	return conf, nil
+}
+
+func newTemplate(name string, lifetime time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   name,
+			Organization: []string{organisation},
+		},
+		// allow for clocks being a little out
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(lifetime),
+	}, nil
+}
+
+func encodeCert(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func encodeKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func decodeKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, ErrNoKey
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrNoKey
+	}
+	return signer, nil
+}
@@ -0,0 +1,170 @@
+package pki_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gpuctl/gpuctl/internal/pki"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// start a server which requires clients to have a certificate from ca, and
+// replies with the name on it
+func mtlsServer(t *testing.T, ca *pki.CA) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+
+	certPEM, keyPEM, err := ca.IssueServer("127.0.0.1")
+	require.NoError(t, err)
+	srv.TLS, err = ca.ServerConfig(certPEM, keyPEM)
+	require.NoError(t, err)
+
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func clientFor(t *testing.T, caPEM []byte, certPEM []byte, keyPEM []byte) *http.Client {
+	t.Helper()
+
+	conf, err := pki.ClientConfig(caPEM, certPEM, keyPEM)
+	require.NoError(t, err)
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: conf}}
+}
+
+func TestClientWithCertificateIsAccepted(t *testing.T) {
+	t.Parallel()
+
+	ca, err := pki.NewCA()
+	require.NoError(t, err)
+	srv := mtlsServer(t, ca)
+
+	certPEM, keyPEM, err := ca.IssueClient("ash01")
+	require.NoError(t, err)
+
+	resp, err := clientFor(t, ca.CertPEM, certPEM, keyPEM).Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ash01", string(body))
+}
+
+func TestClientWithoutCertificateIsRejected(t *testing.T) {
+	t.Parallel()
+
+	ca, err := pki.NewCA()
+	require.NoError(t, err)
+	srv := mtlsServer(t, ca)
+
+	_, err = clientFor(t, ca.CertPEM, nil, nil).Get(srv.URL)
+	assert.Error(t, err)
+}
+
+func TestClientFromOtherCAIsRejected(t *testing.T) {
+	t.Parallel()
+
+	ca, err := pki.NewCA()
+	require.NoError(t, err)
+	srv := mtlsServer(t, ca)
+
+	other, err := pki.NewCA()
+	require.NoError(t, err)
+	certPEM, keyPEM, err := other.IssueClient("ash01")
+	require.NoError(t, err)
+
+	_, err = clientFor(t, ca.CertPEM, certPEM, keyPEM).Get(srv.URL)
+	assert.Error(t, err)
+}
+
+func TestServerFromOtherCAIsRejected(t *testing.T) {
+	t.Parallel()
+
+	ca, err := pki.NewCA()
+	require.NoError(t, err)
+	srv := mtlsServer(t, ca)
+
+	// the satellite pins a different CA, so mustn't trust the server
+	other, err := pki.NewCA()
+	require.NoError(t, err)
+	certPEM, keyPEM, err := ca.IssueClient("ash01")
+	require.NoError(t, err)
+
+	_, err = clientFor(t, other.CertPEM, certPEM, keyPEM).Get(srv.URL)
+	assert.Error(t, err)
+}
+
+func TestLoadOrCreateCA(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "ca.pem")
+	keyPath := filepath.Join(dir, "ca.key")
+
+	created, err := pki.LoadOrCreateCA(certPath, keyPath)
+	require.NoError(t, err)
+
+	loaded, err := pki.LoadOrCreateCA(certPath, keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, created.CertPEM, loaded.CertPEM)
+
+	// certificates issued by the loaded CA are trusted by the original
+	srv := mtlsServer(t, created)
+	certPEM, keyPEM, err := loaded.IssueClient("ash01")
+	require.NoError(t, err)
+
+	resp, err := clientFor(t, created.CertPEM, certPEM, keyPEM).Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+}
+
+func TestLoadOrCreateCAWithOneFileMissing(t *testing.T) {
+	t.Parallel()
+
+	for _, missing := range []string{"ca.pem", "ca.key"} {
+		t.Run(missing, func(t *testing.T) {
+			dir := t.TempDir()
+			certPath := filepath.Join(dir, "ca.pem")
+			keyPath := filepath.Join(dir, "ca.key")
+
+			_, err := pki.LoadOrCreateCA(certPath, keyPath)
+			require.NoError(t, err)
+
+			remaining := certPath
+			if missing == "ca.pem" {
+				remaining = keyPath
+			}
+			before, err := os.ReadFile(remaining)
+			require.NoError(t, err)
+			require.NoError(t, os.Remove(filepath.Join(dir, missing)))
+
+			_, err = pki.LoadOrCreateCA(certPath, keyPath)
+			assert.ErrorIs(t, err, pki.ErrPartialCA)
+
+			// what was left is untouched, and nothing is put in place of the other
+			after, err := os.ReadFile(remaining)
+			require.NoError(t, err)
+			assert.Equal(t, before, after)
+			assert.NoFileExists(t, filepath.Join(dir, missing))
+		})
+	}
+}
+
+func TestIssueServerNeedsHosts(t *testing.T) {
+	t.Parallel()
+
+	ca, err := pki.NewCA()
+	require.NoError(t, err)
+
+	_, _, err = ca.IssueServer()
+	assert.ErrorIs(t, err, pki.ErrNoHosts)
+}
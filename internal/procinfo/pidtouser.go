@@ -2,7 +2,9 @@ package procinfo
 
 import (
 	"errors"
+	"os"
 	"strconv"
+	"strings"
 
 	"golang.org/x/sys/unix"
 
@@ -31,6 +33,18 @@ func (lookup UidLookup) UserForPid(pid uint64) (string, error) {
 	return name, nil
 }
 
+// The command line a process was started with, with arguments separated by
+// spaces
+func CmdlineForPid(pid uint64) (string, error) {
+	cmdline, err := os.ReadFile("/proc/" + strconv.FormatUint(pid, 10) + "/cmdline")
+	if err != nil {
+		return "", err
+	}
+
+	// arguments are separated, and terminated, by NULs
+	return strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " ")), nil
+}
+
 func PasswdToLookup(entries passwd.Passwd) UidLookup {
 	lookup := make(UidLookup)
 	for _, entry := range entries {
@@ -40,3 +40,10 @@ func TestPidLookupOnSelf(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "Name", res)
 }
+
+func TestCmdlineOnSelf(t *testing.T) {
+	t.Parallel()
+	cmdline, err := CmdlineForPid(uint64(os.Getpid()))
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join(os.Args, " "), cmdline)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"os"
 	"path"
 	"strings"
 
@@ -13,6 +14,8 @@ import (
 
 	"github.com/gpuctl/gpuctl/internal/assets"
 	"github.com/gpuctl/gpuctl/internal/config"
+	"github.com/gpuctl/gpuctl/internal/pki"
+	"github.com/gpuctl/gpuctl/internal/uplinkauth"
 )
 
 var InvalidConfigError = errors.New("tunnel: invalid config")
@@ -24,6 +27,12 @@ type Config struct {
 	DataDirTemplate string
 	// The configuration to install on the remote.
 	RemoteConf config.SatelliteConfiguration
+	// Secret to derive each satellite's uplink token from. If empty,
+	// satellites are installed without a token.
+	UplinkSecret []byte
+	// CA to issue each satellite a client certificate from. If nil,
+	// satellites are installed without one.
+	CA *pki.CA
 
 	// SSH Options.
 	Signer      ssh.Signer
@@ -78,14 +87,31 @@ func Onboard(
 	}
 
 	// -- SCP over the config.toml --
-	configToml, err := config.ToToml(conf.RemoteConf)
+	remoteConf := conf.RemoteConf
+	if len(conf.UplinkSecret) != 0 {
+		// The token is tied to the name we know the machine by, so make
+		// sure the satellite reports under that name
+		remoteConf.Satellite.Hostname = hostname
+		remoteConf.Satellite.Token = uplinkauth.Token(conf.UplinkSecret, hostname)
+	}
+
+	// -- SCP over the certificates --
+	if conf.CA != nil {
+		err = installCertificates(scpClient, hostname, conf, &remoteConf.Groundstation)
+		if err != nil {
+			return fmt.Errorf("failed to install certificates: %w", err)
+		}
+	}
+
+	configToml, err := config.ToToml(remoteConf)
 	if err != nil {
 		return err
 	}
 	err = scpClient.CopyToRemote(
 		strings.NewReader(configToml),
 		path.Join(conf.installDir(), "satellite.toml"),
-		&scp.FileTransferOption{},
+		// it has the token in, so only the satellite's user should read it
+		&scp.FileTransferOption{Perm: 0o600},
 	)
 	if err != nil {
 		return err
@@ -100,6 +126,43 @@ func Onboard(
 	return nil
 }
 
+// issue the satellite a certificate, and copy it over with the CA, pointing
+// the remote's config at them
+func installCertificates(scpClient *scp.Client, hostname string, conf Config, gs *config.Groundstation) error {
+	certPEM, keyPEM, err := conf.CA.IssueClient(hostname)
+	if err != nil {
+		return err
+	}
+
+	files := []struct {
+		name     string
+		contents []byte
+		perm     os.FileMode
+		field    *string
+	}{
+		{"ca.pem", conf.CA.CertPEM, 0o644, &gs.CA},
+		{"satellite.pem", certPEM, 0o644, &gs.Cert},
+		{"satellite.key", keyPEM, 0o600, &gs.Key},
+	}
+
+	for _, file := range files {
+		remotePath := path.Join(conf.installDir(), file.name)
+		err = scpClient.CopyToRemote(
+			bytes.NewReader(file.contents),
+			remotePath,
+			&scp.FileTransferOption{Perm: file.perm},
+		)
+		if err != nil {
+			return err
+		}
+		*file.field = remotePath
+	}
+
+	// certificates are useless without TLS
+	gs.Protocol = "https"
+	return nil
+}
+
 func startSatellite(client *ssh.Client, conf Config) error {
 	installDir := conf.installDir()
 	command := fmt.Sprintf(
@@ -1,18 +1,24 @@
 package uplink
 
 import (
+	"cmp"
+	"slices"
+
 	"github.com/gpuctl/gpuctl/internal/procinfo"
 
 	"github.com/google/uuid"
 )
 
-// TODO: change to "/gs-api/gpu-stats"
-const GPUStatsUrl = "/gs-api/status/"
+const GPUStatsUrl = "/gs-api/gpu-stats/"
+
+// Where version 1 satellites send GPU stats
+const LegacyGPUStatsUrl = "/gs-api/status/"
 
 type GpuStatsUpload struct {
 	Hostname string          `json:"hostname"`
 	GPUInfos []GPUInfo       `json:"information"`
 	Stats    []GPUStatSample `json:"stats"`
+	Version
 }
 
 // Contextual information about the GPU
@@ -22,6 +28,7 @@ type GPUInfo struct {
 	Brand         string    `json:"gpu_brand"`
 	DriverVersion string    `json:"driver_ver"`
 	MemoryTotal   uint64    `json:"memory_total"`
+	Backend       string    `json:"backend,omitempty"` // Which vendor tool reported this GPU
 }
 
 // Temporal statistics for a GPU
@@ -40,7 +47,16 @@ type GPUStatSample struct {
 	MemoryClock       float64   `json:"memory_clock"`       // Mhz
 	MaxMemoryClock    float64   `json:"max_memory_clock"`   // Mhz
 	Time              int64     `json:"time"`
-	RunningProcesses  Processes `json:"processes"` // List of processes running
+	RunningProcesses  Processes `json:"processes"`               // List of processes running, that the satellite's filter counts as using the GPU
+	AllProcesses      Processes `json:"all_processes,omitempty"` // Every process, if the filter dropped any
+}
+
+// Every process running on the GPU, whether or not it counts as using it
+func (s GPUStatSample) Unfiltered() Processes {
+	if s.AllProcesses != nil {
+		return s.AllProcesses
+	}
+	return s.RunningProcesses
 }
 
 type Processes []GPUProcInfo
@@ -49,6 +65,7 @@ type GPUProcInfo struct {
 	Name    string  `json:"name"`
 	MemUsed float64 `json:"used_memory"`
 	Owner   string  `json:"owner"` // NOTE: This is the user that owns the process
+	Cmdline string  `json:"cmdline,omitempty"`
 }
 
 func PopulateNames(samples []GPUStatSample, lookup procinfo.UidLookup) {
@@ -63,6 +80,20 @@ func PopulateNames(samples []GPUStatSample, lookup procinfo.UidLookup) {
 	}
 }
 
+func PopulateCmdlines(samples []GPUStatSample) {
+	for i, sample := range samples {
+		for j, proc := range sample.RunningProcesses {
+			cmdline, err := procinfo.CmdlineForPid(proc.Pid)
+			// As with names, processes may have finished by now
+			if err == nil {
+				samples[i].RunningProcesses[j].Cmdline = cmdline
+			}
+		}
+	}
+}
+
+// Drop processes which the filter doesn't count as using a GPU, keeping the
+// full list in AllProcesses if there were any
 func FilterProcesses(samples []GPUStatSample, filter func(GPUProcInfo) bool) {
 	if filter == nil {
 		return
@@ -75,18 +106,41 @@ func FilterProcesses(samples []GPUStatSample, filter func(GPUProcInfo) bool) {
 				filtered = append(filtered, e)
 			}
 		}
+		if len(filtered) < len(sample.RunningProcesses) {
+			samples[i].AllProcesses = sample.RunningProcesses
+		}
 		samples[i].RunningProcesses = filtered
 	}
 }
 
+// Someone running processes on a GPU
+type GPUUser struct {
+	Name       string  `json:"name"`
+	MemoryUsed float64 `json:"memory_used"` // In megabytes, across all their processes
+	Processes  int     `json:"processes"`
+}
+
 // Summarise a running processes array
-// reduces to boolean specifying whether it's in use and a user
-func (procs Processes) Summarise() (inUse bool, user string) {
-	user = ""
+// reduces to boolean specifying whether it's in use, and everyone using it,
+// those using the most memory first
+func (procs Processes) Summarise() (inUse bool, users []GPUUser) {
 	inUse = len(procs) > 0
+	users = []GPUUser{}
 
-	if inUse {
-		user = procs[0].Owner
+	index := make(map[string]int)
+	for _, proc := range procs {
+		i, seen := index[proc.Owner]
+		if !seen {
+			i = len(users)
+			index[proc.Owner] = i
+			users = append(users, GPUUser{Name: proc.Owner})
+		}
+		users[i].MemoryUsed += proc.MemUsed
+		users[i].Processes++
 	}
+
+	slices.SortStableFunc(users, func(a, b GPUUser) int {
+		return cmp.Compare(b.MemoryUsed, a.MemoryUsed)
+	})
 	return
 }
@@ -6,4 +6,11 @@ const HeartbeatUrl = "/gs-api/heartbeat/"
 
 type HeartbeatReq struct {
 	Hostname string
+	Version
+}
+
+// The groundstation replies to heartbeats with how it would like the satellite
+// to run, so that changes reach satellites without them restarting
+type HeartbeatResp struct {
+	Settings Settings `json:"settings"`
 }
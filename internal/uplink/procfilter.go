@@ -0,0 +1,100 @@
+package uplink
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+)
+
+// Processes run by desktop sessions, rather than anyone's work, which hold
+// onto a GPU while they're running
+var DesktopProcesses = []string{
+	"Xorg", "Xwayland", "gnome-shell", "kwin_x11", "kwin_wayland",
+	"plasmashell", "cinnamon", "mutter", "gdm-x-session", "sddm-greeter",
+}
+
+// Which processes count as using a GPU. The zero value keeps everything.
+type ProcessFilter struct {
+	Include       []ProcessMatch `json:"include,omitempty" toml:"include,omitempty"`     // if any are given, processes must match one of them
+	Exclude       []ProcessMatch `json:"exclude,omitempty" toml:"exclude,omitempty"`     // processes matching any of these are dropped
+	MinMemory     float64        `json:"min_memory,omitempty" toml:"min_memory"`         // in megabytes, processes using less are dropped
+	IgnoreDesktop bool           `json:"ignore_desktop,omitempty" toml:"ignore_desktop"` // drop DesktopProcesses
+}
+
+// A process matches if every regular expression given matches. Expressions
+// aren't anchored, so "python" matches "/usr/bin/python3".
+type ProcessMatch struct {
+	Name    string `json:"name,omitempty" toml:"name,omitempty"`       // the process's name, as reported by the vendor tool
+	Cmdline string `json:"cmdline,omitempty" toml:"cmdline,omitempty"` // its full command line
+	Owner   string `json:"owner,omitempty" toml:"owner,omitempty"`     // the name of the user running it
+}
+
+// Matcher compiles the filter into a function for FilterProcesses. A nil
+// filter keeps everything.
+func (f *ProcessFilter) Matcher() (func(GPUProcInfo) bool, error) {
+	if f == nil {
+		return func(GPUProcInfo) bool { return true }, nil
+	}
+
+	include, err := compileMatches(f.Include)
+	if err != nil {
+		return nil, fmt.Errorf("include: %w", err)
+	}
+	exclude, err := compileMatches(f.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("exclude: %w", err)
+	}
+
+	minMemory, ignoreDesktop := f.MinMemory, f.IgnoreDesktop
+	return func(proc GPUProcInfo) bool {
+		if proc.MemUsed < minMemory {
+			return false
+		}
+		if ignoreDesktop && slices.Contains(DesktopProcesses, path.Base(proc.Name)) {
+			return false
+		}
+		if len(include) > 0 && !slices.ContainsFunc(include, proc.matches) {
+			return false
+		}
+		return !slices.ContainsFunc(exclude, proc.matches)
+	}, nil
+}
+
+type compiledMatch struct {
+	name, cmdline, owner *regexp.Regexp
+}
+
+func compileMatches(matches []ProcessMatch) ([]compiledMatch, error) {
+	compiled := make([]compiledMatch, len(matches))
+	for i, match := range matches {
+		var err error
+		compiled[i].name, err = compileOptional(match.Name)
+		if err != nil {
+			return nil, err
+		}
+		compiled[i].cmdline, err = compileOptional(match.Cmdline)
+		if err != nil {
+			return nil, err
+		}
+		compiled[i].owner, err = compileOptional(match.Owner)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return compiled, nil
+}
+
+// nil for an empty expression, which matches anything
+func compileOptional(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
+
+func (proc GPUProcInfo) matches(m compiledMatch) bool {
+	return (m.name == nil || m.name.MatchString(proc.Name)) &&
+		(m.cmdline == nil || m.cmdline.MatchString(proc.Cmdline)) &&
+		(m.owner == nil || m.owner.MatchString(proc.Owner))
+}
@@ -0,0 +1,89 @@
+package uplink_test
+
+import (
+	"testing"
+
+	"github.com/gpuctl/gpuctl/internal/uplink"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	xorg    = uplink.GPUProcInfo{Name: "/usr/lib/xorg/Xorg", MemUsed: 200, Owner: "root"}
+	python  = uplink.GPUProcInfo{Name: "/usr/bin/python3", MemUsed: 8000, Owner: "alona", Cmdline: "python3 train.py --epochs 10"}
+	julia   = uplink.GPUProcInfo{Name: "julia", MemUsed: 4000, Owner: "bob", Cmdline: "julia sim.jl"}
+	ollama  = uplink.GPUProcInfo{Name: "ollama", MemUsed: 12000, Owner: "ollama", Cmdline: "ollama serve"}
+	tiny    = uplink.GPUProcInfo{Name: "nvtop", MemUsed: 2, Owner: "alona"}
+	allProc = []uplink.GPUProcInfo{xorg, python, julia, ollama, tiny}
+)
+
+func kept(t *testing.T, filter *uplink.ProcessFilter) []uplink.GPUProcInfo {
+	t.Helper()
+
+	keep, err := filter.Matcher()
+	if err != nil {
+		t.Fatalf("Failed to compile filter: %v", err)
+	}
+
+	var res []uplink.GPUProcInfo
+	for _, proc := range allProc {
+		if keep(proc) {
+			res = append(res, proc)
+		}
+	}
+	return res
+}
+
+func TestProcessFilter(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		filter   *uplink.ProcessFilter
+		expected []uplink.GPUProcInfo
+	}{
+		{"Nil", nil, allProc},
+		{"Empty", &uplink.ProcessFilter{}, allProc},
+		{"Ignore Desktop", &uplink.ProcessFilter{IgnoreDesktop: true}, []uplink.GPUProcInfo{python, julia, ollama, tiny}},
+		{"Minimum Memory", &uplink.ProcessFilter{MinMemory: 100}, []uplink.GPUProcInfo{xorg, python, julia, ollama}},
+		{"Include Name", &uplink.ProcessFilter{Include: []uplink.ProcessMatch{{Name: "python"}, {Name: "^julia$"}}}, []uplink.GPUProcInfo{python, julia}},
+		{"Include Cmdline", &uplink.ProcessFilter{Include: []uplink.ProcessMatch{{Cmdline: `\.py\b`}}}, []uplink.GPUProcInfo{python}},
+		{"Exclude Owner", &uplink.ProcessFilter{Exclude: []uplink.ProcessMatch{{Owner: "^(root|ollama)$"}}}, []uplink.GPUProcInfo{python, julia, tiny}},
+		{"Match Needs Every Field", &uplink.ProcessFilter{Exclude: []uplink.ProcessMatch{{Name: "python", Owner: "bob"}}}, allProc},
+		{"Combined", &uplink.ProcessFilter{
+			Exclude:       []uplink.ProcessMatch{{Name: "ollama"}},
+			MinMemory:     100,
+			IgnoreDesktop: true,
+		}, []uplink.GPUProcInfo{python, julia}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, kept(t, tc.filter))
+		})
+	}
+}
+
+func TestProcessFilterInvalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := (&uplink.ProcessFilter{Include: []uplink.ProcessMatch{{Cmdline: "[unclosed"}}}).Matcher()
+	assert.ErrorContains(t, err, "include")
+}
+
+func TestFilterProcessesKeepsUnfiltered(t *testing.T) {
+	t.Parallel()
+
+	samples := []uplink.GPUStatSample{
+		{RunningProcesses: uplink.Processes{xorg, python}},
+		{RunningProcesses: uplink.Processes{julia}},
+	}
+	keep, _ := (&uplink.ProcessFilter{IgnoreDesktop: true}).Matcher()
+	uplink.FilterProcesses(samples, keep)
+
+	assert.Equal(t, uplink.Processes{python}, samples[0].RunningProcesses)
+	assert.Equal(t, uplink.Processes{xorg, python}, samples[0].Unfiltered())
+
+	// nothing was dropped, so there's no need to send the list twice
+	assert.Nil(t, samples[1].AllProcesses)
+	assert.Equal(t, uplink.Processes{julia}, samples[1].Unfiltered())
+}
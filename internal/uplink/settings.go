@@ -0,0 +1,149 @@
+package uplink
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Names of the GPU vendor tools satellites can collect from
+const (
+	NvidiaCollector = "nvidia"
+	AMDCollector    = "amd"
+)
+
+var Collectors = []string{NvidiaCollector, AMDCollector}
+
+// The shortest any interval can be set to, so satellites aren't asked to
+// spin collecting and sending
+const MinInterval = time.Second
+
+var ErrInvalidSettings = errors.New("invalid satellite settings")
+
+// How the groundstation would like a satellite to run, sent when it handshakes
+// and in reply to every heartbeat. Zero values leave the satellite's own
+// configuration alone. In JSON, intervals are strings like "15s", though
+// numbers of nanoseconds are still understood.
+type Settings struct {
+	DataInterval      time.Duration  `json:"data_interval,omitempty"`
+	SampleInterval    time.Duration  `json:"sample_interval,omitempty"`
+	HeartbeatInterval time.Duration  `json:"heartbeat_interval,omitempty"`
+	ProcessFilter     *ProcessFilter `json:"process_filter,omitempty"` // which processes count as using a GPU
+	Collectors        []string       `json:"collectors,omitempty"`     // names of the GPU vendor tools to collect from, all that are installed if empty
+}
+
+// Override returns s, with anything set in o replacing it
+func (s Settings) Override(o Settings) Settings {
+	if o.DataInterval > 0 {
+		s.DataInterval = o.DataInterval
+	}
+	if o.SampleInterval > 0 {
+		s.SampleInterval = o.SampleInterval
+	}
+	if o.HeartbeatInterval > 0 {
+		s.HeartbeatInterval = o.HeartbeatInterval
+	}
+	if o.ProcessFilter != nil {
+		s.ProcessFilter = o.ProcessFilter
+	}
+	if len(o.Collectors) > 0 {
+		s.Collectors = o.Collectors
+	}
+	return s
+}
+
+// Validate checks the settings can be used by a satellite
+func (s Settings) Validate() error {
+	intervals := []struct {
+		name     string
+		interval time.Duration
+	}{
+		{"data_interval", s.DataInterval},
+		{"sample_interval", s.SampleInterval},
+		{"heartbeat_interval", s.HeartbeatInterval},
+	}
+	for _, i := range intervals {
+		if i.interval != 0 && i.interval < MinInterval {
+			return fmt.Errorf("%w: %s must be at least %s, not %s", ErrInvalidSettings, i.name, MinInterval, i.interval)
+		}
+	}
+
+	for _, collector := range s.Collectors {
+		if !slices.Contains(Collectors, collector) {
+			return fmt.Errorf("%w: unknown collector %q, expected one of %q", ErrInvalidSettings, collector, Collectors)
+		}
+	}
+
+	if s.ProcessFilter == nil {
+		return nil
+	}
+	_, err := s.ProcessFilter.Matcher()
+	return err
+}
+
+// IsZero reports whether the settings leave everything as it is
+func (s Settings) IsZero() bool {
+	return s.DataInterval == 0 && s.SampleInterval == 0 && s.HeartbeatInterval == 0 &&
+		s.ProcessFilter == nil && len(s.Collectors) == 0
+}
+
+// Settings as they're written in JSON, with the intervals replaced
+type settingsAlias Settings
+type settingsJSON struct {
+	settingsAlias
+	DataInterval      jsonDuration `json:"data_interval,omitempty"`
+	SampleInterval    jsonDuration `json:"sample_interval,omitempty"`
+	HeartbeatInterval jsonDuration `json:"heartbeat_interval,omitempty"`
+}
+
+func (s Settings) MarshalJSON() ([]byte, error) {
+	return json.Marshal(settingsJSON{
+		settingsAlias:     settingsAlias(s),
+		DataInterval:      jsonDuration(s.DataInterval),
+		SampleInterval:    jsonDuration(s.SampleInterval),
+		HeartbeatInterval: jsonDuration(s.HeartbeatInterval),
+	})
+}
+
+func (s *Settings) UnmarshalJSON(data []byte) error {
+	var j settingsJSON
+	err := json.Unmarshal(data, &j)
+	if err != nil {
+		return err
+	}
+
+	*s = Settings(j.settingsAlias)
+	s.DataInterval = time.Duration(j.DataInterval)
+	s.SampleInterval = time.Duration(j.SampleInterval)
+	s.HeartbeatInterval = time.Duration(j.HeartbeatInterval)
+	return nil
+}
+
+// A duration written like "15s", or read from that or a number of nanoseconds
+type jsonDuration time.Duration
+
+func (d jsonDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *jsonDuration) UnmarshalJSON(data []byte) error {
+	var nanoseconds int64
+	if json.Unmarshal(data, &nanoseconds) == nil {
+		*d = jsonDuration(nanoseconds)
+		return nil
+	}
+
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = jsonDuration(parsed)
+	return nil
+}
@@ -0,0 +1,85 @@
+package uplink_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gpuctl/gpuctl/internal/uplink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettingsOverride(t *testing.T) {
+	t.Parallel()
+
+	defaults := uplink.Settings{
+		DataInterval:      time.Minute,
+		HeartbeatInterval: 5 * time.Second,
+		Collectors:        []string{"nvidia"},
+	}
+	group := uplink.Settings{
+		DataInterval:  15 * time.Second,
+		ProcessFilter: &uplink.ProcessFilter{Include: []uplink.ProcessMatch{{Name: "julia"}}},
+	}
+	machine := uplink.Settings{Collectors: []string{"amd"}}
+
+	assert.Equal(t, uplink.Settings{
+		DataInterval:      15 * time.Second,
+		HeartbeatInterval: 5 * time.Second,
+		ProcessFilter:     &uplink.ProcessFilter{Include: []uplink.ProcessMatch{{Name: "julia"}}},
+		Collectors:        []string{"amd"},
+	}, defaults.Override(group).Override(machine))
+
+	assert.Equal(t, defaults, defaults.Override(uplink.Settings{}))
+}
+
+func TestSettingsIsZero(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, uplink.Settings{}.IsZero())
+	assert.False(t, uplink.Settings{HeartbeatInterval: time.Second}.IsZero())
+	assert.False(t, uplink.Settings{ProcessFilter: &uplink.ProcessFilter{}}.IsZero())
+}
+
+func TestSettingsValidate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, uplink.Settings{}.Validate())
+	assert.NoError(t, uplink.Settings{ProcessFilter: &uplink.ProcessFilter{Exclude: []uplink.ProcessMatch{{Owner: "^root$"}}}}.Validate())
+	assert.Error(t, uplink.Settings{ProcessFilter: &uplink.ProcessFilter{Exclude: []uplink.ProcessMatch{{Owner: "("}}}}.Validate())
+}
+
+func TestSettingsValidateIntervalsAndCollectors(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, uplink.Settings{DataInterval: time.Second, Collectors: []string{"nvidia", "amd"}}.Validate())
+	assert.ErrorIs(t, uplink.Settings{DataInterval: 60}.Validate(), uplink.ErrInvalidSettings)
+	assert.ErrorIs(t, uplink.Settings{SampleInterval: time.Millisecond}.Validate(), uplink.ErrInvalidSettings)
+	assert.ErrorIs(t, uplink.Settings{HeartbeatInterval: -time.Minute}.Validate(), uplink.ErrInvalidSettings)
+	assert.ErrorIs(t, uplink.Settings{Collectors: []string{"intel"}}.Validate(), uplink.ErrInvalidSettings)
+}
+
+func TestSettingsJSON(t *testing.T) {
+	t.Parallel()
+
+	settings := uplink.Settings{
+		DataInterval:  15 * time.Second,
+		ProcessFilter: &uplink.ProcessFilter{IgnoreDesktop: true},
+		Collectors:    []string{"amd"},
+	}
+
+	data, err := json.Marshal(settings)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"data_interval":"15s","process_filter":{"ignore_desktop":true},"collectors":["amd"]}`, string(data))
+
+	var decoded uplink.Settings
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, settings, decoded)
+
+	// as overrides were saved before intervals were strings
+	require.NoError(t, json.Unmarshal([]byte(`{"heartbeat_interval":5000000000}`), &decoded))
+	assert.Equal(t, uplink.Settings{HeartbeatInterval: 5 * time.Second}, decoded)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"data_interval":"soon"}`), &decoded))
+}
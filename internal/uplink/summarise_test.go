@@ -4,20 +4,21 @@ import (
 	"testing"
 
 	"github.com/gpuctl/gpuctl/internal/uplink"
+	"github.com/stretchr/testify/assert"
 )
 
 // test summarising an empty processes list
 func TestSummariseEmpty(t *testing.T) {
 	p := uplink.Processes{}
 
-	inUse, user := p.Summarise()
+	inUse, users := p.Summarise()
 
 	if inUse {
 		t.Errorf("inUse value incorrect. was '%v', wanted false", inUse)
 	}
 
-	if user != "" {
-		t.Errorf("user value incorrect. was '%v', wanted empty string", user)
+	if len(users) != 0 {
+		t.Errorf("users value incorrect. was '%v', wanted no users", users)
 	}
 }
 
@@ -34,19 +35,17 @@ func TestSummariseOne(t *testing.T) {
 		},
 	}
 
-	inUse, user := p.Summarise()
+	inUse, users := p.Summarise()
 
 	if !inUse {
 		t.Errorf("inUse value incorrect. was '%v', wanted true", inUse)
 	}
 
-	if user != fakeUser {
-		t.Errorf("user value incorrect. was '%v', wanted '%v'", user, fakeUser)
-	}
+	assert.Equal(t, []uplink.GPUUser{{Name: fakeUser, MemoryUsed: 456.7, Processes: 1}}, users)
 }
 
 // test summarising a longer processes list
-// not necessarily the behaviour we want long term, I'm just documenting this behaviour in tests
+// everyone is included, heaviest user first
 func TestSummariseMulti(t *testing.T) {
 	fakeUser1 := "clive"
 	fakeUser2 := "brenda"
@@ -73,13 +72,31 @@ func TestSummariseMulti(t *testing.T) {
 		},
 	}
 
-	inUse, user := p.Summarise()
+	inUse, users := p.Summarise()
 
 	if !inUse {
 		t.Errorf("inUse value incorrect. was '%v', wanted true", inUse)
 	}
 
-	if user != fakeUser1 {
-		t.Errorf("user value incorrect. was '%v', wanted '%v'", user, fakeUser1)
+	assert.Equal(t, []uplink.GPUUser{
+		{Name: fakeUser3, MemoryUsed: 987.0, Processes: 1},
+		{Name: fakeUser1, MemoryUsed: 456.7, Processes: 1},
+		{Name: fakeUser2, MemoryUsed: 15.3, Processes: 1},
+	}, users)
+}
+
+// test someone running several processes is only counted once
+func TestSummariseSameUser(t *testing.T) {
+	p := uplink.Processes{
+		{Pid: 1, Name: "python", MemUsed: 100, Owner: "clive"},
+		{Pid: 2, Name: "python", MemUsed: 300, Owner: "brenda"},
+		{Pid: 3, Name: "python", MemUsed: 250, Owner: "clive"},
 	}
+
+	_, users := p.Summarise()
+
+	assert.Equal(t, []uplink.GPUUser{
+		{Name: "clive", MemoryUsed: 350, Processes: 2},
+		{Name: "brenda", MemoryUsed: 300, Processes: 1},
+	}, users)
 }
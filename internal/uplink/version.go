@@ -0,0 +1,45 @@
+package uplink
+
+// Versions of the uplink protocol. Bump ProtocolVersion whenever the types in
+// this package change in a way an older groundstation or satellite would
+// misread, and keep a shim for the previous version in the groundstation until
+// every satellite has been updated.
+//
+//   - 1: the original protocol. Messages don't say their version, and GPU stats
+//     are sent to LegacyGPUStatsUrl.
+//   - 2: messages carry the protocol and satellite versions, GPU stats are sent
+//     to GPUStatsUrl, and satellites handshake when they start.
+const (
+	ProtocolVersion    = 2
+	MinProtocolVersion = 1 // oldest satellites the groundstation still accepts
+)
+
+const HandshakeUrl = "/gs-api/handshake/"
+
+// Which build of which protocol a message came from. Messages from version 1
+// satellites leave these empty.
+type Version struct {
+	Protocol         int    `json:"protocol,omitempty"`
+	SatelliteVersion string `json:"satellite_version,omitempty"`
+}
+
+// The protocol a message was sent with, accounting for version 1 satellites
+// which don't say
+func (v Version) ProtocolOrLegacy() int {
+	if v.Protocol == 0 {
+		return 1
+	}
+	return v.Protocol
+}
+
+// Sent by a satellite when it starts, to check it can talk to the groundstation
+type HandshakeReq struct {
+	Hostname string `json:"hostname"`
+	Version
+}
+
+type HandshakeResp struct {
+	MinProtocol int      `json:"min_protocol"`
+	MaxProtocol int      `json:"max_protocol"`
+	Settings    Settings `json:"settings"`
+}
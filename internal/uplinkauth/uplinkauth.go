@@ -0,0 +1,230 @@
+// Package uplinkauth signs and verifies requests from satellites to the
+// groundstation, so that satellites can only report on themselves.
+//
+// Each satellite is given a token derived from a secret only the control
+// server knows, and its hostname. It signs every request with that token, and
+// the groundstation can rederive the token from the hostname to check it.
+//
+// Signatures cover a timestamp rather than a nonce, so a captured request can
+// be replayed until it's MaxSkew old. A replayed upload only resends what the
+// groundstation was already told, and samples it already has are ignored,
+// so this is accepted rather than remembering every request.
+package uplinkauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	HostnameHeader  = "X-Gpuctl-Hostname"
+	TimestampHeader = "X-Gpuctl-Timestamp"
+	SignatureHeader = "X-Gpuctl-Signature"
+)
+
+// How far a request's timestamp may be from the groundstation's clock
+const MaxSkew = 5 * time.Minute
+
+// Largest request body read to check its signature, by default. A satellite's
+// biggest uploads, catching up on its backlog, are well under this
+const DefaultMaxBody = 64 << 20
+
+var (
+	ErrUnsigned         = errors.New("uplink request is not signed")
+	ErrBadSignature     = errors.New("uplink request signature does not match")
+	ErrStale            = errors.New("uplink request timestamp is too far from now")
+	ErrHostnameMismatch = errors.New("hostname in request does not match the one it was signed for")
+)
+
+// Token derives the token for the satellite on the given host
+func Token(secret []byte, hostname string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(hostname))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sign a request, covering everything a forger might want to change
+func sign(token string, method string, path string, hostname string, timestamp string, body []byte) string {
+	// a client asking for "" makes the server see "/"
+	if path == "" {
+		path = "/"
+	}
+	bodyHash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, []byte(token))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%x", method, path, hostname, timestamp, bodyHash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Signer is a http.RoundTripper which signs requests on behalf of a
+// satellite before passing them on to Base
+type Signer struct {
+	Hostname string
+	Token    string
+	Base     http.RoundTripper // http.DefaultTransport if nil
+	Now      func() time.Time  // time.Now if nil
+}
+
+func (s Signer) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	timestamp := strconv.FormatInt(now().Unix(), 10)
+
+	// RoundTrippers mustn't modify the request they're given
+	signed := req.Clone(req.Context())
+	signed.Body = io.NopCloser(bytes.NewReader(body))
+	signed.Header.Set(HostnameHeader, s.Hostname)
+	signed.Header.Set(TimestampHeader, timestamp)
+	signed.Header.Set(SignatureHeader, sign(s.Token, req.Method, req.URL.Path, s.Hostname, timestamp, body))
+
+	base := s.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(signed)
+}
+
+// Verifier checks requests were signed by the satellite they claim to be from
+type Verifier struct {
+	Secret  []byte
+	Now     func() time.Time // time.Now if nil
+	MaxBody int64            // DefaultMaxBody if 0
+}
+
+// Verify a request, returning the hostname it was signed for. The body is
+// left in place for the handler to read.
+func (v Verifier) Verify(req *http.Request) (string, error) {
+	hostname := req.Header.Get(HostnameHeader)
+	timestamp := req.Header.Get(TimestampHeader)
+	signature := req.Header.Get(SignatureHeader)
+
+	if hostname == "" || timestamp == "" || signature == "" {
+		return "", ErrUnsigned
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrStale, err)
+	}
+
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+	skew := now().Sub(time.Unix(unix, 0))
+	if skew > MaxSkew || skew < -MaxSkew {
+		return "", ErrStale
+	}
+
+	maxBody := v.MaxBody
+	if maxBody == 0 {
+		maxBody = DefaultMaxBody
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = http.MaxBytesReader(nil, req.Body, maxBody)
+	}
+
+	body, err := readBody(req)
+	if err != nil {
+		return "", err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	expected := sign(Token(v.Secret, hostname), req.Method, req.URL.Path, hostname, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return "", ErrBadSignature
+	}
+
+	return hostname, nil
+}
+
+// Middleware rejects any request that isn't correctly signed, and otherwise
+// records the hostname it was signed for in the request's context
+func (v Verifier) Middleware(next http.Handler, log *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		hostname, err := v.Verify(req)
+		if err != nil {
+			log.Warn("Rejected uplink request", "url", req.URL, "from", req.RemoteAddr,
+				"hostname", req.Header.Get(HostnameHeader), "err", err)
+
+			status := http.StatusUnauthorized
+			var tooBig *http.MaxBytesError
+			if errors.As(err, &tooBig) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		// the client certificate, if there was one, must be for the same host
+		err = CheckHostname(req, hostname)
+		if err != nil {
+			log.Warn("Rejected uplink request", "url", req.URL, "from", req.RemoteAddr, "err", err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), hostnameKey{}, hostname)))
+	})
+}
+
+// CertMiddleware records the hostname from a satellite's client certificate
+// in the request's context, the same as Middleware does for signed requests.
+// The certificate must already have been verified, by requiring client
+// certificates on the listener. Requests without one, such as when TLS is
+// off, are passed straight through.
+func CertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		hostname := req.TLS.PeerCertificates[0].Subject.CommonName
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), hostnameKey{}, hostname)))
+	})
+}
+
+type hostnameKey struct{}
+
+// Hostname gets the hostname a request was signed for, if it went through the
+// middleware
+func Hostname(ctx context.Context) (string, bool) {
+	hostname, ok := ctx.Value(hostnameKey{}).(string)
+	return hostname, ok
+}
+
+// CheckHostname makes sure a satellite only reports on itself. Requests that
+// didn't go through the middleware are let through, as authentication is off.
+func CheckHostname(req *http.Request, claimed string) error {
+	signed, ok := Hostname(req.Context())
+	if ok && signed != claimed {
+		return fmt.Errorf("%w: signed for %q, claimed %q", ErrHostnameMismatch, signed, claimed)
+	}
+	return nil
+}
+
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	defer req.Body.Close()
+	return io.ReadAll(req.Body)
+}
@@ -0,0 +1,180 @@
+package uplinkauth_test
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gpuctl/gpuctl/internal/uplinkauth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var secret = []byte("correct horse battery staple")
+
+// a server which echos back the hostname requests were signed for
+func echoServer(t *testing.T, verifier uplinkauth.Verifier) *httptest.Server {
+	t.Helper()
+
+	handler := verifier.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hostname, ok := uplinkauth.Hostname(r.Context())
+		assert.True(t, ok)
+
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+
+		w.Write([]byte(hostname + ":" + string(body)))
+	}), slog.Default())
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func post(t *testing.T, client *http.Client, url string, body string) (int, string) {
+	t.Helper()
+
+	resp, err := client.Post(url, "application/json", bytes.NewBufferString(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	got, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(got)
+}
+
+func TestTokensDifferPerHost(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, uplinkauth.Token(secret, "ash01"), uplinkauth.Token(secret, "ash01"))
+	assert.NotEqual(t, uplinkauth.Token(secret, "ash01"), uplinkauth.Token(secret, "ash02"))
+	assert.NotEqual(t, uplinkauth.Token(secret, "ash01"), uplinkauth.Token([]byte("other"), "ash01"))
+}
+
+func TestSignedRequestIsAccepted(t *testing.T) {
+	t.Parallel()
+
+	srv := echoServer(t, uplinkauth.Verifier{Secret: secret})
+	client := &http.Client{Transport: uplinkauth.Signer{Hostname: "ash01", Token: uplinkauth.Token(secret, "ash01")}}
+
+	status, body := post(t, client, srv.URL+"/gs-api/status/", `{"hostname":"ash01"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `ash01:{"hostname":"ash01"}`, body)
+}
+
+func TestUnsignedRequestIsRejected(t *testing.T) {
+	t.Parallel()
+
+	srv := echoServer(t, uplinkauth.Verifier{Secret: secret})
+
+	status, body := post(t, http.DefaultClient, srv.URL, `{}`)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Contains(t, body, uplinkauth.ErrUnsigned.Error())
+}
+
+func TestOtherHostsTokenIsRejected(t *testing.T) {
+	t.Parallel()
+
+	srv := echoServer(t, uplinkauth.Verifier{Secret: secret})
+	client := &http.Client{Transport: uplinkauth.Signer{Hostname: "ash01", Token: uplinkauth.Token(secret, "ash02")}}
+
+	status, body := post(t, client, srv.URL, `{}`)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Contains(t, body, uplinkauth.ErrBadSignature.Error())
+}
+
+func TestStaleRequestIsRejected(t *testing.T) {
+	t.Parallel()
+
+	srv := echoServer(t, uplinkauth.Verifier{Secret: secret})
+	client := &http.Client{Transport: uplinkauth.Signer{
+		Hostname: "ash01",
+		Token:    uplinkauth.Token(secret, "ash01"),
+		Now:      func() time.Time { return time.Now().Add(-time.Hour) },
+	}}
+
+	status, body := post(t, client, srv.URL, `{}`)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Contains(t, body, uplinkauth.ErrStale.Error())
+}
+
+func TestOversizedBodyIsRejected(t *testing.T) {
+	t.Parallel()
+
+	srv := echoServer(t, uplinkauth.Verifier{Secret: secret, MaxBody: 16})
+	client := &http.Client{Transport: uplinkauth.Signer{Hostname: "ash01", Token: uplinkauth.Token(secret, "ash01")}}
+
+	status, _ := post(t, client, srv.URL, `{"hostname":"ash01"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+
+	status, body := post(t, client, srv.URL, `{}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `ash01:{}`, body)
+}
+
+func TestTamperedBodyIsRejected(t *testing.T) {
+	t.Parallel()
+
+	verifier := uplinkauth.Verifier{Secret: secret}
+
+	// sign a request, then swap out its body
+	var signed *http.Request
+	client := &http.Client{Transport: uplinkauth.Signer{
+		Hostname: "ash01",
+		Token:    uplinkauth.Token(secret, "ash01"),
+		Base: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			signed = r
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+		}),
+	}}
+	_, err := client.Post("http://groundstation/gs-api/status/", "application/json", bytes.NewBufferString(`{"hostname":"ash01"}`))
+	require.NoError(t, err)
+
+	hostname, err := verifier.Verify(signed)
+	assert.NoError(t, err)
+	assert.Equal(t, "ash01", hostname)
+
+	signed.Body = io.NopCloser(bytes.NewBufferString(`{"hostname":"ash02"}`))
+	_, err = verifier.Verify(signed)
+	assert.ErrorIs(t, err, uplinkauth.ErrBadSignature)
+}
+
+func TestCheckHostname(t *testing.T) {
+	t.Parallel()
+
+	// no middleware means authentication is off
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	assert.NoError(t, uplinkauth.CheckHostname(req, "anything"))
+
+	srv := verifyAndCheck(t, "ash01")
+	assert.Equal(t, http.StatusOK, srv(`ash01`))
+	assert.Equal(t, http.StatusForbidden, srv(`ash02`))
+}
+
+// run CheckHostname on a request signed by host, claiming to be the body
+func verifyAndCheck(t *testing.T, host string) func(string) int {
+	handler := uplinkauth.Verifier{Secret: secret}.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claimed, _ := io.ReadAll(r.Body)
+		if uplinkauth.CheckHostname(r, string(claimed)) != nil {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}), slog.Default())
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	client := &http.Client{Transport: uplinkauth.Signer{Hostname: host, Token: uplinkauth.Token(secret, host)}}
+	return func(claimed string) int {
+		status, _ := post(t, client, srv.URL, claimed)
+		return status
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -3,10 +3,15 @@ package webapi
 import (
 	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/gpuctl/gpuctl/internal/broadcast"
 	"github.com/gpuctl/gpuctl/internal/femto"
 )
 
+// How far back alerts are listed when the request doesn't say
+const DefaultAlertsWindow = 7 * 24 * time.Hour
+
 func (wa *Api) HandleOfflineMachineRequest(req *http.Request, log *slog.Logger) (*femto.Response[[]string], error) {
 	machine_data, err := wa.DB.LastSeen()
 
@@ -22,3 +27,35 @@ func (wa *Api) HandleOfflineMachineRequest(req *http.Request, log *slog.Logger)
 
 	return femto.Ok(names)
 }
+
+// The alerts that are pending or firing now
+func (wa *Api) ActiveAlerts(req *http.Request, log *slog.Logger) (*femto.Response[[]broadcast.Alert], error) {
+	alerts, err := wa.DB.ActiveAlerts()
+	if err != nil {
+		return nil, err
+	}
+
+	return femto.Ok(alerts)
+}
+
+// The alerts that were firing between the unix times from and to, which
+// default to the last week
+func (wa *Api) AlertHistory(req *http.Request, log *slog.Logger) (*femto.Response[[]broadcast.Alert], error) {
+	query := req.URL.Query()
+
+	to, err := unixParam(query.Get("to"), time.Now())
+	if err != nil {
+		return &femto.Response[[]broadcast.Alert]{Status: http.StatusBadRequest}, err
+	}
+	from, err := unixParam(query.Get("from"), to.Add(-DefaultAlertsWindow))
+	if err != nil {
+		return &femto.Response[[]broadcast.Alert]{Status: http.StatusBadRequest}, err
+	}
+
+	alerts, err := wa.DB.AlertHistory(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return femto.Ok(alerts)
+}
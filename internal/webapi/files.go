@@ -0,0 +1,293 @@
+package webapi
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gpuctl/gpuctl/internal/authentication"
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+	"github.com/gpuctl/gpuctl/internal/database"
+	"github.com/gpuctl/gpuctl/internal/femto"
+	"github.com/gpuctl/gpuctl/internal/filestore"
+	"github.com/gpuctl/gpuctl/internal/types"
+)
+
+// The largest file that can be attached, unless configured otherwise
+const DefaultMaxFileSize = 100 << 20
+
+var ErrChecksumMismatch = errors.New("file doesn't match the given checksum")
+
+func (a *Api) maxFileSize() int64 {
+	return cmp.Or(a.MaxFileSize, DefaultMaxFileSize)
+}
+
+// the status to reply with when attaching a file fails, 0 if it's our fault
+func fileErrorStatus(err error) int {
+	var tooBig *http.MaxBytesError
+	switch {
+	case errors.Is(err, filestore.ErrTooLarge), errors.As(err, &tooBig):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrChecksumMismatch):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrNoSuchMachine):
+		return http.StatusNotFound
+	default:
+		return 0
+	}
+}
+
+// Stream contents into the file store and record them as the file, replacing
+// any file of the same name on the machine. If checksum isn't empty, the
+// contents' sha256 must match it
+func (a *Api) storeFile(ctx context.Context, file broadcast.FileInfo, contents io.Reader, checksum string, l *slog.Logger) (broadcast.FileInfo, error) {
+	saved, err := filestore.Save(ctx, a.Files, contents, a.maxFileSize())
+	if err != nil {
+		return file, err
+	}
+
+	if checksum != "" && !strings.EqualFold(checksum, saved.Checksum) {
+		return file, errors.Join(ErrChecksumMismatch, a.Files.Delete(ctx, saved.Key))
+	}
+
+	file.Key = saved.Key
+	file.Size = saved.Size
+	file.Checksum = saved.Checksum
+	file.UploadedAt = time.Now().UTC().Truncate(time.Microsecond)
+
+	replaced, err := a.DB.AttachFile(file)
+	if err != nil {
+		return file, errors.Join(err, a.Files.Delete(ctx, saved.Key))
+	}
+
+	a.deleteContents(ctx, replaced, l)
+	return file, nil
+}
+
+// Delete the contents of a file that's no longer in the database. Failing
+// only leaves an orphan in the store, so isn't worth failing the request over
+func (a *Api) deleteContents(ctx context.Context, key string, l *slog.Logger) {
+	if key == "" {
+		return
+	}
+	err := a.Files.Delete(ctx, key)
+	if err != nil {
+		l.Error("Failed to delete file contents from the store", "key", key, "err", err)
+	}
+}
+
+// Takes a multipart form with the file in a part called "file", for the
+// machine in the hostname parameter, or in a "hostname" part before the file.
+// An optional sha256 parameter is checked against what arrives. Replies with
+// what was stored
+func (a *Api) UploadFile(w http.ResponseWriter, r *http.Request, l *slog.Logger) error {
+	params := r.URL.Query()
+	hostname := params.Get("hostname")
+
+	// leave room for the multipart headers around the file
+	r.Body = http.MaxBytesReader(w, r.Body, a.maxFileSize()+1<<20)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			http.Error(w, "missing file", http.StatusBadRequest)
+			return nil
+		} else if err != nil {
+			http.Error(w, err.Error(), cmp.Or(fileErrorStatus(err), http.StatusBadRequest))
+			return nil
+		}
+
+		if part.FormName() == "hostname" && hostname == "" {
+			name, err := io.ReadAll(io.LimitReader(part, 1024))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return nil
+			}
+			hostname = string(name)
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		if hostname == "" {
+			http.Error(w, "missing hostname", http.StatusBadRequest)
+			return nil
+		}
+		if part.FileName() == "" {
+			http.Error(w, "missing filename", http.StatusBadRequest)
+			return nil
+		}
+
+		file := broadcast.FileInfo{
+			Hostname: hostname,
+			Filename: part.FileName(),
+			Mime:     cmp.Or(part.Header.Get("Content-Type"), "application/octet-stream"),
+			Uploader: authentication.UserOf(r),
+		}
+		file, err = a.storeFile(r.Context(), file, part, params.Get("sha256"), l)
+		if status := fileErrorStatus(err); status != 0 {
+			http.Error(w, err.Error(), status)
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		l.Info("Attached file", "host", file.Hostname, "file", file.Filename, "size", file.Size, "uploader", file.Uploader)
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(file)
+	}
+}
+
+// Streams a file straight from the store, with its checksum in the
+// X-Checksum-Sha256 header
+func (a *Api) DownloadFile(w http.ResponseWriter, r *http.Request, l *slog.Logger) error {
+	hostname := r.URL.Query().Get("hostname")
+	filename := r.URL.Query().Get("file")
+	if hostname == "" || filename == "" {
+		http.Error(w, "missing hostname or file", http.StatusBadRequest)
+		return nil
+	}
+
+	file, err := a.DB.GetFile(hostname, filename)
+	if errors.Is(err, database.ErrFileNotPresent) {
+		http.NotFound(w, r)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	contents, err := a.Files.Get(r.Context(), file.Key)
+	if err != nil {
+		return err
+	}
+	defer contents.Close()
+
+	w.Header().Set("Content-Type", file.Mime)
+	w.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": file.Hostname + "_" + file.Filename,
+	}))
+	w.Header().Set("X-Checksum-Sha256", file.Checksum)
+	w.WriteHeader(http.StatusOK)
+
+	_, err = io.Copy(w, contents)
+	return err
+}
+
+// Everything known about a machine's files, except their contents
+func (a *Api) FileInfo(r *http.Request, l *slog.Logger) (*femto.Response[[]broadcast.FileInfo], error) {
+	hostname := r.URL.Query().Get("hostname")
+	if hostname == "" {
+		return &femto.Response[[]broadcast.FileInfo]{Status: http.StatusBadRequest}, nil
+	}
+
+	files, err := a.DB.ListFiles(hostname)
+	if errors.Is(err, database.ErrNoSuchMachine) {
+		return &femto.Response[[]broadcast.FileInfo]{Status: http.StatusNotFound}, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	return femto.Ok(files)
+}
+
+// Like UploadFile, but with the whole file in JSON as base64. Kept for older
+// clients
+func (a *Api) AttachFile(attach broadcast.AttachFile, r *http.Request, l *slog.Logger) (*femto.EmptyBodyResponse, error) {
+	contents, err := base64.StdEncoding.DecodeString(attach.EncodedFile)
+	if err != nil {
+		return &femto.EmptyBodyResponse{Status: http.StatusBadRequest}, err
+	}
+
+	file := broadcast.FileInfo{
+		Hostname: attach.Hostname,
+		Filename: attach.Filename,
+		Mime:     attach.Mime,
+		Uploader: authentication.UserOf(r),
+	}
+	_, err = a.storeFile(r.Context(), file, bytes.NewReader(contents), "", l)
+	if err != nil {
+		return &femto.EmptyBodyResponse{Status: fileErrorStatus(err)}, err
+	}
+	return femto.Ok(types.Unit{})
+}
+
+func (a *Api) RemoveFile(rem broadcast.RemoveFile, r *http.Request, l *slog.Logger) (*femto.EmptyBodyResponse, error) {
+	removed, err := a.DB.RemoveFile(rem)
+	if err != nil {
+		return nil, err
+	}
+	a.deleteContents(r.Context(), removed, l)
+	return femto.Ok(types.Unit{})
+}
+
+func (a *Api) ListFiles(r *http.Request, l *slog.Logger) (*femto.Response[[]string], error) {
+	hostname := r.URL.Query().Get("hostname")
+	if hostname == "" {
+		return &femto.Response[[]string]{Status: http.StatusBadRequest, Body: make([]string, 0), Headers: map[string]string{"Content-Type": "application/json"}}, nil
+	}
+
+	files, err := a.DB.ListFiles(hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, file := range files {
+		names = append(names, file.Filename)
+	}
+	return femto.Ok(names)
+}
+
+// Like DownloadFile, but reads the whole file into memory first. Kept for
+// older clients
+func (a *Api) GetFile(r *http.Request, l *slog.Logger) (*femto.Response[[]byte], error) {
+	hostname := r.URL.Query().Get("hostname")
+	filename := r.URL.Query().Get("file")
+	if hostname == "" || filename == "" {
+		return &femto.Response[[]byte]{Status: http.StatusBadRequest}, nil
+	}
+
+	file, err := a.DB.GetFile(hostname, filename)
+	if errors.Is(err, database.ErrFileNotPresent) {
+		// Handle the error of not finding a file gracefully
+		return &femto.Response[[]byte]{Status: http.StatusNotFound}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	contents, err := a.Files.Get(r.Context(), file.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer contents.Close()
+
+	respbytes, err := io.ReadAll(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	// Respond with the file
+	return &femto.Response[[]byte]{
+		Status: http.StatusOK,
+		Body:   respbytes,
+		Headers: map[string]string{
+			"Content-Type":        file.Mime,
+			"Content-Disposition": "attachment; filename=" + file.Hostname + "_" + file.Filename,
+		},
+	}, nil
+}
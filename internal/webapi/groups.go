@@ -0,0 +1,56 @@
+package webapi
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+	"github.com/gpuctl/gpuctl/internal/database"
+	"github.com/gpuctl/gpuctl/internal/femto"
+	"github.com/gpuctl/gpuctl/internal/types"
+)
+
+func groupResponse(err error) (*femto.EmptyBodyResponse, error) {
+	switch {
+	case errors.Is(err, database.ErrInvalidGroup):
+		return &femto.EmptyBodyResponse{Status: http.StatusBadRequest}, err
+	case errors.Is(err, database.ErrNoSuchGroup), errors.Is(err, database.ErrNoSuchMachine):
+		return &femto.EmptyBodyResponse{Status: http.StatusNotFound}, err
+	case errors.Is(err, database.ErrGroupExists):
+		return &femto.EmptyBodyResponse{Status: http.StatusConflict}, err
+	case err != nil:
+		return nil, err
+	default:
+		return femto.Ok(types.Unit{})
+	}
+}
+
+// Every group, whether or not it's been created, in order
+func (a *Api) Groups(r *http.Request, l *slog.Logger) (*femto.Response[[]broadcast.GroupInfo], error) {
+	groups, err := a.DB.Groups()
+	if err != nil {
+		return nil, err
+	}
+	return femto.Ok(groups)
+}
+
+func (a *Api) CreateGroup(group broadcast.GroupInfo, r *http.Request, l *slog.Logger) (*femto.EmptyBodyResponse, error) {
+	l.Info("Tried to create group", "group", group.Name, "description", group.Description, "order", group.Order)
+	return groupResponse(a.DB.CreateGroup(group))
+}
+
+func (a *Api) UpdateGroup(changes broadcast.ModifyGroup, r *http.Request, l *slog.Logger) (*femto.EmptyBodyResponse, error) {
+	l.Info("Tried to modify group", "group", changes.Name, "changes", changes)
+	return groupResponse(a.DB.UpdateGroup(changes))
+}
+
+func (a *Api) RemoveGroup(remove broadcast.RemoveGroup, r *http.Request, l *slog.Logger) (*femto.EmptyBodyResponse, error) {
+	l.Info("Tried to remove group", "group", remove.Name, "move_to", remove.MoveTo)
+	return groupResponse(a.DB.RemoveGroup(remove))
+}
+
+func (a *Api) MoveMachines(move broadcast.MoveMachines, r *http.Request, l *slog.Logger) (*femto.EmptyBodyResponse, error) {
+	l.Info("Tried to move machines", "hosts", move.Hostnames, "group", move.Group)
+	return groupResponse(a.DB.MoveMachines(move))
+}
@@ -0,0 +1,43 @@
+package webapi
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+	"github.com/gpuctl/gpuctl/internal/database"
+	"github.com/gpuctl/gpuctl/internal/femto"
+)
+
+// How far back inventory changes are listed when the request doesn't say
+const DefaultInventoryWindow = 30 * 24 * time.Hour
+
+// The changes to a machine's gpus between the unix times from and to, which
+// default to the last 30 days
+func (a *Api) Inventory(r *http.Request, l *slog.Logger) (*femto.Response[[]broadcast.InventoryEvent], error) {
+	query := r.URL.Query()
+	hostname := query.Get("hostname")
+	if hostname == "" {
+		return &femto.Response[[]broadcast.InventoryEvent]{Status: http.StatusBadRequest}, nil
+	}
+
+	to, err := unixParam(query.Get("to"), time.Now())
+	if err != nil {
+		return &femto.Response[[]broadcast.InventoryEvent]{Status: http.StatusBadRequest}, err
+	}
+	from, err := unixParam(query.Get("from"), to.Add(-DefaultInventoryWindow))
+	if err != nil {
+		return &femto.Response[[]broadcast.InventoryEvent]{Status: http.StatusBadRequest}, err
+	}
+
+	events, err := a.DB.InventoryEvents(hostname, from, to)
+	if errors.Is(err, database.ErrNoSuchMachine) {
+		return &femto.Response[[]broadcast.InventoryEvent]{Status: http.StatusNotFound}, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	return femto.Ok(events)
+}
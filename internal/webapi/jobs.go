@@ -0,0 +1,57 @@
+package webapi
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+	"github.com/gpuctl/gpuctl/internal/database"
+	"github.com/gpuctl/gpuctl/internal/femto"
+)
+
+// How far back jobs are listed when the request doesn't say
+const DefaultJobsWindow = 24 * time.Hour
+
+// The jobs that ran on a machine between the unix times from and to, which
+// default to the last day
+func (a *Api) Jobs(r *http.Request, l *slog.Logger) (*femto.Response[[]broadcast.Job], error) {
+	query := r.URL.Query()
+	hostname := query.Get("hostname")
+	if hostname == "" {
+		return &femto.Response[[]broadcast.Job]{Status: http.StatusBadRequest}, nil
+	}
+
+	to, err := unixParam(query.Get("to"), time.Now())
+	if err != nil {
+		return &femto.Response[[]broadcast.Job]{Status: http.StatusBadRequest}, err
+	}
+	from, err := unixParam(query.Get("from"), to.Add(-DefaultJobsWindow))
+	if err != nil {
+		return &femto.Response[[]broadcast.Job]{Status: http.StatusBadRequest}, err
+	}
+
+	jobs, err := a.DB.Jobs(hostname, from, to)
+	if errors.Is(err, database.ErrNoSuchMachine) {
+		return &femto.Response[[]broadcast.Job]{Status: http.StatusNotFound}, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	return femto.Ok(jobs)
+}
+
+// Parse a unix time from a query parameter, or use fallback if it's missing
+func unixParam(param string, fallback time.Time) (time.Time, error) {
+	if param == "" {
+		return fallback, nil
+	}
+
+	secs, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(secs, 0), nil
+}
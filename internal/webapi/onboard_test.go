@@ -37,7 +37,7 @@ func TestOnboardNoKey(t *testing.T) {
 	serv := webapi.NewServer(nil, alwaysAuth{}, tunnel.Config{
 		DataDirTemplate: "/foo",
 		User:            "JFK",
-	}, &totalEnergy)
+	}, nil, 0, 0, &totalEnergy)
 
 	req := httptest.NewRequest(http.MethodPost, "/api/admin/add_workstation", strings.NewReader(`{"hostname": "foo.net"}`))
 	req.AddCookie(emptyAuthCookie)
@@ -61,7 +61,7 @@ func TestOnboardNoHostname(t *testing.T) {
 		DataDirTemplate: "/foo",
 		User:            "root",
 		Signer:          sign,
-	}, &totalEnergy)
+	}, nil, 0, 0, &totalEnergy)
 
 	req := httptest.NewRequest(http.MethodPost, "/api/admin/add_workstation", strings.NewReader("{}"))
 	req.AddCookie(emptyAuthCookie)
@@ -1,18 +1,23 @@
 package webapi
 
 import (
-	"encoding/base64"
 	"errors"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"sync/atomic"
+	"time"
 
 	"github.com/gpuctl/gpuctl/internal/authentication"
 	"github.com/gpuctl/gpuctl/internal/broadcast"
 	"github.com/gpuctl/gpuctl/internal/database"
 	"github.com/gpuctl/gpuctl/internal/femto"
+	"github.com/gpuctl/gpuctl/internal/filestore"
 	"github.com/gpuctl/gpuctl/internal/tunnel"
 	"github.com/gpuctl/gpuctl/internal/types"
+
+	"github.com/google/uuid"
 )
 
 type Server struct {
@@ -20,8 +25,12 @@ type Server struct {
 	api *Api
 }
 type Api struct {
-	DB         database.Database
-	tunnelConf tunnel.Config
+	DB          database.Database
+	Files       filestore.Store // where attached files' contents are kept
+	MaxFileSize int64           // bytes, DefaultMaxFileSize if zero
+	// longest a sample can account for in usage, DefaultMaxSampleGap if zero
+	MaxSampleGap time.Duration
+	tunnelConf   tunnel.Config
 
 	totalEnergy *atomic.Uint64
 }
@@ -31,14 +40,20 @@ type APIAuthCredientals struct {
 	Password string
 }
 
-func NewServer(db database.Database, auth authentication.Authenticator[APIAuthCredientals], tunnelConf tunnel.Config, totalEnergy *atomic.Uint64) *Server {
+func NewServer(db database.Database, auth authentication.Authenticator[APIAuthCredientals], tunnelConf tunnel.Config, files filestore.Store, maxFileSize int64, maxSampleGap time.Duration, totalEnergy *atomic.Uint64) *Server {
 	mux := new(femto.Femto)
-	api := &Api{db, tunnelConf, totalEnergy}
+	api := &Api{db, files, maxFileSize, maxSampleGap, tunnelConf, totalEnergy}
 
 	femto.OnGet(mux, "/api/stats/all", api.AllStatistics)
 	femto.OnGet(mux, "/api/stats/offline", api.HandleOfflineMachineRequest)
 	femto.OnGet(mux, "/api/stats/historical", api.historicalData)
 	femto.OnGet(mux, "/api/stats/aggregate", api.aggregateData)
+	femto.OnGet(mux, "/api/stats/jobs", api.Jobs)
+	femto.OnGet(mux, "/api/stats/inventory", api.Inventory)
+	femto.OnGet(mux, "/api/stats/usage", api.Usage)
+	femto.OnGet(mux, "/api/stats/usage.csv", api.UsageCSV)
+	femto.OnGet(mux, "/api/alerts", api.ActiveAlerts)
+	femto.OnGet(mux, "/api/alerts/history", api.AlertHistory)
 
 	// Set up authentication and logging-out endpoint
 	femto.OnPost(mux, "/api/admin/auth", func(packet APIAuthCredientals, r *http.Request, l *slog.Logger) (*femto.EmptyBodyResponse, error) {
@@ -56,6 +71,21 @@ func NewServer(db database.Database, auth authentication.Authenticator[APIAuthCr
 	femto.OnPost(mux, "/api/admin/remove_file", authentication.AuthWrapPost(auth, api.RemoveFile))
 	femto.OnGet(mux, "/api/admin/list_files", authentication.AuthWrapGet(auth, api.ListFiles))
 	femto.OnGet(mux, "/api/admin/get_file", authentication.AuthWrapGet(auth, api.GetFile))
+	femto.OnStream(mux, http.MethodPost, "/api/admin/upload_file", authentication.AuthWrapStream(auth, api.UploadFile))
+	femto.OnStream(mux, http.MethodGet, "/api/admin/download_file", authentication.AuthWrapStream(auth, api.DownloadFile))
+	femto.OnGet(mux, "/api/admin/file_info", authentication.AuthWrapGet(auth, api.FileInfo))
+	femto.OnGet(mux, "/api/admin/groups", authentication.AuthWrapGet(auth, api.Groups))
+	femto.OnPost(mux, "/api/admin/groups/create", authentication.AuthWrapPost(auth, api.CreateGroup))
+	femto.OnPost(mux, "/api/admin/groups/update", authentication.AuthWrapPost(auth, api.UpdateGroup))
+	femto.OnPost(mux, "/api/admin/groups/remove", authentication.AuthWrapPost(auth, api.RemoveGroup))
+	femto.OnPost(mux, "/api/admin/groups/move", authentication.AuthWrapPost(auth, api.MoveMachines))
+	femto.OnPost(mux, "/api/admin/add_tag", authentication.AuthWrapPost(auth, api.AddTag))
+	femto.OnPost(mux, "/api/admin/remove_tag", authentication.AuthWrapPost(auth, api.RemoveTag))
+	femto.OnPost(mux, "/api/admin/set_metadata", authentication.AuthWrapPost(auth, api.SetMetadata))
+	femto.OnPost(mux, "/api/admin/remove_metadata", authentication.AuthWrapPost(auth, api.RemoveMetadata))
+	femto.OnGet(mux, "/api/admin/list_settings", authentication.AuthWrapGet(auth, api.SettingsOverrides))
+	femto.OnPost(mux, "/api/admin/set_settings", authentication.AuthWrapPost(auth, api.SetSettingsOverride))
+	femto.OnGet(mux, "/api/admin/processes", authentication.AuthWrapGet(auth, api.Processes))
 	femto.OnGet(mux, "/api/admin/confirm", authentication.AuthWrapGet(auth, func(r *http.Request, l *slog.Logger) (*femto.Response[UsernameReminder], error) {
 		return api.ConfirmAdmin(auth, r, l)
 	}))
@@ -72,6 +102,8 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // This function involves a lot of weird unwrapping
 // TODO: See if we can get the database layer to do it for us
+//
+// With tag parameters, only machines with all of those tags are included
 func (a *Api) AllStatistics(r *http.Request, l *slog.Logger) (*femto.Response[broadcast.Workstations], error) {
 	data, err := a.DB.LatestData()
 
@@ -79,6 +111,8 @@ func (a *Api) AllStatistics(r *http.Request, l *slog.Logger) (*femto.Response[br
 		return nil, err
 	}
 
+	data = filterByTags(data, r.URL.Query()["tag"])
+
 	if data == nil {
 		// dont just return nil, which would not be marshalled properly
 		return femto.Ok(broadcast.Workstations{})
@@ -87,13 +121,21 @@ func (a *Api) AllStatistics(r *http.Request, l *slog.Logger) (*femto.Response[br
 	return femto.Ok(data)
 }
 
+// Optionally limited to the unix times from and to and one gpu, and averaged
+// into buckets of step, either in seconds or as a duration like "5m"
 func (a *Api) historicalData(r *http.Request, l *slog.Logger) (*femto.Response[broadcast.HistoricalData], error) {
-	hostname := r.URL.Query().Get("hostname")
+	params := r.URL.Query()
+	hostname := params.Get("hostname")
 	if hostname == "" {
 		return &femto.Response[broadcast.HistoricalData]{Status: http.StatusBadRequest}, nil
 	}
 
-	data, err := a.DB.HistoricalData(hostname)
+	query, err := historyQuery(params)
+	if err != nil {
+		return &femto.Response[broadcast.HistoricalData]{Status: http.StatusBadRequest}, err
+	}
+
+	data, err := a.DB.HistoricalData(hostname, query)
 
 	if err != nil {
 		return nil, err
@@ -102,6 +144,43 @@ func (a *Api) historicalData(r *http.Request, l *slog.Logger) (*femto.Response[b
 	return femto.Ok(data)
 }
 
+func historyQuery(params url.Values) (database.HistoryQuery, error) {
+	var query database.HistoryQuery
+	var err error
+
+	query.From, err = unixParam(params.Get("from"), time.Time{})
+	if err != nil {
+		return query, err
+	}
+	query.To, err = unixParam(params.Get("to"), time.Time{})
+	if err != nil {
+		return query, err
+	}
+
+	if gpu := params.Get("gpu"); gpu != "" {
+		query.Gpu, err = uuid.Parse(gpu)
+		if err != nil {
+			return query, err
+		}
+	}
+
+	if step := params.Get("step"); step != "" {
+		if secs, err := strconv.ParseInt(step, 10, 64); err == nil {
+			query.Step = time.Duration(secs) * time.Second
+		} else {
+			query.Step, err = time.ParseDuration(step)
+			if err != nil {
+				return query, err
+			}
+		}
+	}
+
+	if !query.Valid() {
+		return query, database.ErrInvalidStep
+	}
+	return query, nil
+}
+
 func (a *Api) aggregateData(r *http.Request, l *slog.Logger) (*femto.Response[broadcast.AggregateData], error) {
 	// TODO: add functionality for variable number of days
 	totalEnergy := a.totalEnergy.Load()
@@ -158,71 +237,6 @@ func (a *Api) addMachine(machine broadcast.NewMachine, r *http.Request, l *slog.
 	return femto.Ok(types.Unit{})
 }
 
-func (a *Api) AttachFile(attach broadcast.AttachFile, r *http.Request, l *slog.Logger) (*femto.EmptyBodyResponse, error) {
-	err := a.DB.AttachFile(attach)
-	if err != nil {
-		return nil, err
-	}
-	return femto.Ok(types.Unit{})
-}
-
-func (a *Api) RemoveFile(rem broadcast.RemoveFile, r *http.Request, l *slog.Logger) (*femto.EmptyBodyResponse, error) {
-	err := a.DB.RemoveFile(rem)
-	if err != nil {
-		return nil, err
-	}
-	return femto.Ok(types.Unit{})
-}
-
-func (a *Api) ListFiles(r *http.Request, l *slog.Logger) (*femto.Response[[]string], error) {
-	hostname := r.URL.Query().Get("hostname")
-	if hostname == "" {
-		return &femto.Response[[]string]{Status: http.StatusBadRequest, Body: make([]string, 0), Headers: map[string]string{"Content-Type": "application/json"}}, nil
-	}
-
-	// TODO: make sure that we are returning sensible json
-	files, err := a.DB.ListFiles(hostname)
-	if err != nil {
-		return nil, err
-	}
-
-	return femto.Ok[[]string](files)
-
-}
-
-func (a *Api) GetFile(r *http.Request, l *slog.Logger) (*femto.Response[[]byte], error) {
-	hostname := r.URL.Query().Get("hostname")
-	filename := r.URL.Query().Get("file")
-	if hostname == "" || filename == "" {
-		return &femto.Response[[]byte]{Status: http.StatusBadRequest}, nil
-	}
-
-	dbresp, err := a.DB.GetFile(hostname, filename)
-
-	if errors.Is(err, database.ErrFileNotPresent) {
-		// Handle the error of not finding a file gracefully
-		return &femto.Response[[]byte]{Status: http.StatusNotFound}, nil
-	} else if err != nil {
-		return nil, err
-	}
-
-	// Decode the stored file
-	respbytes, err := base64.StdEncoding.DecodeString(dbresp.EncodedFile)
-	if err != nil {
-		return nil, err
-	}
-
-	// Respond with the file
-	return &femto.Response[[]byte]{
-		Status: http.StatusOK,
-		Body:   respbytes,
-		Headers: map[string]string{
-			"Content-Type":        dbresp.Mime,
-			"Content-Disposition": "attachment; filename=" + dbresp.Hostname + "_" + dbresp.Filename,
-		},
-	}, nil
-}
-
 func (a *Api) removeMachine(rm broadcast.RemoveMachineInfo, r *http.Request, l *slog.Logger) (*femto.EmptyBodyResponse, error) {
 	const statusOnboardErr = 512
 	const statusDbErr = 513
@@ -234,10 +248,16 @@ func (a *Api) removeMachine(rm broadcast.RemoveMachineInfo, r *http.Request, l *
 	if deboardErrOccurred {
 		slog.Error("Got error trying to deboard!", "err", deboardErr)
 	}
+	// the database forgets the machine's files, so find them first
+	files, _ := a.DB.ListFiles(rm.Hostname)
 	dbErr := a.DB.RemoveMachine(broadcast.RemoveMachine{Hostname: rm.Hostname})
 	dbErrOccurred := dbErr != nil
 	if dbErrOccurred {
 		slog.Error("Got error removing from the database!", "err", dbErr)
+	} else {
+		for _, file := range files {
+			a.deleteContents(r.Context(), file.Key, l)
+		}
 	}
 
 	// XXX: return back errors in the form of a specific status code. Ugly imo...
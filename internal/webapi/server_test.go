@@ -2,11 +2,19 @@ package webapi_test
 
 import (
 	"bytes"
+	"crypto/sha256"
 	_ "embed"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -14,12 +22,14 @@ import (
 	"github.com/gpuctl/gpuctl/internal/authentication"
 	"github.com/gpuctl/gpuctl/internal/broadcast"
 	"github.com/gpuctl/gpuctl/internal/database"
+	"github.com/gpuctl/gpuctl/internal/filestore"
 	"github.com/gpuctl/gpuctl/internal/tunnel"
 	"github.com/gpuctl/gpuctl/internal/uplink"
 	"github.com/gpuctl/gpuctl/internal/webapi"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 //go:embed testdata/uploadtest.pdf
@@ -39,6 +49,14 @@ var (
 	joeCreds = webapi.APIAuthCredientals{Username: "joe", Password: "mama"}
 )
 
+func localStore(t *testing.T) filestore.Store {
+	store, err := filestore.Local(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
 func TestAuthenticate(t *testing.T) {
 	mockLogger := slog.Default()
 
@@ -167,7 +185,7 @@ func TestAllStatistics(t *testing.T) {
 func TestListFiles(t *testing.T) {
 	mockDB := database.InMemory()
 	mockLogger := slog.Default()
-	api := &webapi.Api{DB: mockDB}
+	api := &webapi.Api{DB: mockDB, Files: localStore(t)}
 	hostname := "machine01"
 	mockDB.UpdateLastSeen(hostname, time.Now())
 
@@ -208,7 +226,7 @@ func TestListFiles(t *testing.T) {
 func TestRemovingFile(t *testing.T) {
 	mockDB := database.InMemory()
 	mockLogger := slog.Default()
-	api := &webapi.Api{DB: mockDB}
+	api := &webapi.Api{DB: mockDB, Files: localStore(t)}
 	hostname := "machine09"
 
 	token, err := joeAuth.CreateToken(joeCreds)
@@ -250,7 +268,7 @@ func TestRemovingFile(t *testing.T) {
 func TestAttachingFile(t *testing.T) {
 	mockDB := database.InMemory()
 	mockLogger := slog.Default()
-	api := &webapi.Api{DB: mockDB}
+	api := &webapi.Api{DB: mockDB, Files: localStore(t)}
 
 	mockDB.UpdateLastSeen("testmachine", time.Now())
 
@@ -287,6 +305,322 @@ func TestAttachingFile(t *testing.T) {
 	assert.Equal(t, uploadPdfBytes, getresp.Body)
 }
 
+// a multipart upload of contents as filename, to the upload_file endpoint
+func uploadRequest(t *testing.T, query string, filename string, contents []byte) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filename)
+	require.NoError(t, err)
+	_, err = part.Write(contents)
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/upload_file?"+query, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.AddCookie(&http.Cookie{Name: authentication.TokenCookieName, Value: "example_token"})
+	return req
+}
+
+func TestStreamingFiles(t *testing.T) {
+	mockDB := database.InMemory()
+	assert.NoError(t, mockDB.UpdateLastSeen("testmachine", time.Now()))
+
+	auth := webapi.ConfigFileAuthenticator{
+		Username:      "joe",
+		Password:      "mama",
+		CurrentTokens: map[authentication.AuthToken]bool{"example_token": true},
+	}
+	var totalEnergy atomic.Uint64
+	server := webapi.NewServer(mockDB, &auth, tunnel.Config{}, localStore(t), 1024, 0, &totalEnergy)
+
+	sum := sha256.Sum256(uploadTxtBytes)
+	checksum := hex.EncodeToString(sum[:])
+
+	// upload, checking the checksum on the way
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, uploadRequest(t, "hostname=testmachine&sha256="+checksum, "notes.txt", uploadTxtBytes))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var file broadcast.FileInfo
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &file))
+	assert.Equal(t, "notes.txt", file.Filename)
+	assert.Equal(t, int64(len(uploadTxtBytes)), file.Size)
+	assert.Equal(t, checksum, file.Checksum)
+	assert.Equal(t, "joe", file.Uploader)
+
+	// and back down again
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/download_file?hostname=testmachine&file=notes.txt", nil)
+	req.AddCookie(&http.Cookie{Name: authentication.TokenCookieName, Value: "example_token"})
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, uploadTxtBytes, w.Body.Bytes())
+	assert.Equal(t, checksum, w.Header().Get("X-Checksum-Sha256"))
+	assert.Equal(t, strconv.Itoa(len(uploadTxtBytes)), w.Header().Get("Content-Length"))
+
+	// the metadata is kept too
+	infos, err := mockDB.ListFiles("testmachine")
+	assert.NoError(t, err)
+	if assert.Len(t, infos, 1) {
+		assert.Equal(t, "joe", infos[0].Uploader)
+		assert.False(t, infos[0].UploadedAt.IsZero())
+	}
+
+	// too big, corrupted, or for a machine we don't know
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, uploadRequest(t, "hostname=testmachine", "big.pdf", uploadPdfBytes))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, uploadRequest(t, "hostname=testmachine&sha256=00", "notes.txt", uploadTxtBytes))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, uploadRequest(t, "hostname=mystery", "notes.txt", uploadTxtBytes))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// none of which replaced the original
+	file2, err := mockDB.GetFile("testmachine", "notes.txt")
+	assert.NoError(t, err)
+	file2.Key = "" // which clients aren't told
+	assert.Equal(t, file, file2)
+
+	// browsers send the hostname as part of the form
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	require.NoError(t, form.WriteField("hostname", "testmachine"))
+	part, err := form.CreateFormFile("file", "form.txt")
+	require.NoError(t, err)
+	_, err = part.Write(uploadTxtBytes)
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+
+	req = httptest.NewRequest(http.MethodPost, "/api/admin/upload_file", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.AddCookie(&http.Cookie{Name: authentication.TokenCookieName, Value: "example_token"})
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	_, err = mockDB.GetFile("testmachine", "form.txt")
+	assert.NoError(t, err)
+}
+
+func TestStatisticsFilteredByTag(t *testing.T) {
+	mockDB := database.InMemory()
+	api := webapi.Api{DB: mockDB}
+	lab, office := "lab", "office"
+
+	assert.NoError(t, mockDB.NewMachine(broadcast.NewMachine{Hostname: "gpu01", Group: &lab}))
+	assert.NoError(t, mockDB.NewMachine(broadcast.NewMachine{Hostname: "gpu02", Group: &lab}))
+	assert.NoError(t, mockDB.NewMachine(broadcast.NewMachine{Hostname: "desk01", Group: &office}))
+	for _, tag := range []broadcast.MachineTag{
+		{Hostname: "gpu01", Tag: "teaching"},
+		{Hostname: "gpu01", Tag: "a100"},
+		{Hostname: "gpu02", Tag: "teaching"},
+	} {
+		assert.NoError(t, mockDB.AddTag(tag))
+	}
+
+	names := func(query string) []string {
+		req := httptest.NewRequest(http.MethodGet, "/api/stats/all?"+query, nil)
+		resp, err := api.AllStatistics(req, slog.Default())
+		require.NoError(t, err)
+
+		names := []string{}
+		for _, group := range resp.Body {
+			for _, machine := range group.Workstations {
+				names = append(names, machine.Name)
+			}
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	assert.Equal(t, []string{"desk01", "gpu01", "gpu02"}, names(""))
+	assert.Equal(t, []string{"gpu01", "gpu02"}, names("tag=teaching"))
+	assert.Equal(t, []string{"gpu01"}, names("tag=teaching&tag=a100"))
+	assert.Equal(t, []string{}, names("tag=nothing"))
+
+	// groups keep everything but their other machines
+	description, order := "Teaching lab", 3
+	assert.NoError(t, mockDB.UpdateGroup(broadcast.ModifyGroup{Name: lab, Description: &description, Order: &order}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/stats/all?tag=teaching", nil)
+	resp, err := api.AllStatistics(req, slog.Default())
+	require.NoError(t, err)
+	if assert.Len(t, resp.Body, 1) {
+		assert.Equal(t, lab, resp.Body[0].Name)
+		assert.Equal(t, description, resp.Body[0].Description)
+		assert.Equal(t, order, resp.Body[0].Order)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	mockDB := database.InMemory()
+	assert.NoError(t, mockDB.UpdateLastSeen("gpu01", time.Now()))
+	assert.NoError(t, mockDB.UpdateLastSeen("gpu02", time.Now()))
+
+	auth := webapi.ConfigFileAuthenticator{
+		Username:      "joe",
+		Password:      "mama",
+		CurrentTokens: map[authentication.AuthToken]bool{"example_token": true},
+	}
+	var totalEnergy atomic.Uint64
+	server := webapi.NewServer(mockDB, &auth, tunnel.Config{}, localStore(t), 0, 0, &totalEnergy)
+
+	post := func(endpoint string, body string) int {
+		req := httptest.NewRequest(http.MethodPost, endpoint, strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: authentication.TokenCookieName, Value: "example_token"})
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, post("/api/admin/groups/create", `{"name":"lab","description":"Huxley 219","order":-1}`))
+	assert.Equal(t, http.StatusConflict, post("/api/admin/groups/create", `{"name":"lab"}`))
+	assert.Equal(t, http.StatusOK, post("/api/admin/groups/move", `{"hostnames":["gpu01","gpu02"],"group":"lab"}`))
+	assert.Equal(t, http.StatusOK, post("/api/admin/groups/update", `{"name":"lab","new_name":"ml lab"}`))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/groups", nil)
+	req.AddCookie(&http.Cookie{Name: authentication.TokenCookieName, Value: "example_token"})
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var groups []broadcast.GroupInfo
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &groups))
+	assert.Equal(t, []broadcast.GroupInfo{{Name: "ml lab", Description: "Huxley 219", Order: -1}}, groups)
+
+	data, err := mockDB.LatestData()
+	assert.NoError(t, err)
+	if assert.Len(t, data, 1) {
+		assert.Equal(t, "ml lab", data[0].Name)
+		assert.Len(t, data[0].Workstations, 2)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	mockDB := database.InMemory()
+	mockLogger := slog.Default()
+	api := &webapi.Api{DB: mockDB}
+	hostname := "gpu03"
+	gpu := uuid.MustParse("1fa6c3a8-4a2b-4c37-9d2a-6ab0b3c8e0f1")
+
+	assert.NoError(t, mockDB.UpdateLastSeen(hostname, time.Now()))
+	assert.NoError(t, mockDB.UpdateGPUContext(hostname, uplink.GPUInfo{Uuid: gpu}))
+
+	lastNight := time.Now().Add(-12 * time.Hour)
+	assert.NoError(t, mockDB.AppendDataPoint(uplink.GPUStatSample{
+		Uuid:             gpu,
+		Time:             lastNight.Unix(),
+		RunningProcesses: uplink.Processes{{Pid: 42, Name: "python3", MemUsed: 2048, Owner: "alice"}},
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/stats/jobs?hostname="+hostname, nil)
+	resp, err := api.Jobs(req, mockLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.Status)
+	if assert.Len(t, resp.Body, 1) {
+		assert.Equal(t, "alice", resp.Body[0].Owner)
+		assert.True(t, resp.Body[0].Running)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/stats/jobs?hostname=%s&from=%d", hostname, time.Now().Add(-time.Hour).Unix()), nil)
+	resp, err = api.Jobs(req, mockLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.Status)
+	assert.Empty(t, resp.Body)
+}
+
+func TestInventory(t *testing.T) {
+	mockDB := database.InMemory()
+	mockLogger := slog.Default()
+	api := &webapi.Api{DB: mockDB}
+	hostname := "gpu03"
+	gpu := uuid.MustParse("0e5b7d2c-9f1a-4c3e-8b6d-2a4f6e8c0b13")
+
+	assert.NoError(t, mockDB.UpdateLastSeen(hostname, time.Now()))
+	assert.NoError(t, mockDB.UpdateGPUContext(hostname, uplink.GPUInfo{Uuid: gpu, DriverVersion: "550.54"}))
+	assert.NoError(t, mockDB.UpdateGPUContext(hostname, uplink.GPUInfo{Uuid: gpu, DriverVersion: "550.67"}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/stats/inventory?hostname="+hostname, nil)
+	resp, err := api.Inventory(req, mockLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.Status)
+	if assert.Len(t, resp.Body, 2) {
+		assert.Equal(t, broadcast.GPUDriverChanged, resp.Body[0].Kind)
+		assert.Equal(t, "550.54", resp.Body[0].From)
+		assert.Equal(t, "550.67", resp.Body[0].To)
+		assert.Equal(t, broadcast.GPUAppeared, resp.Body[1].Kind)
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	mockDB := database.InMemory()
+	mockLogger := slog.Default()
+	api := &webapi.Api{DB: mockDB}
+	lastWeek := time.Now().Add(-8 * 24 * time.Hour)
+
+	_, err := mockDB.RecordAlert(broadcast.Alert{
+		Rule: "offline", Hostname: "gpu03", State: broadcast.AlertFiring,
+		Since: time.Now().Unix(), FiredAt: time.Now().Unix(),
+	})
+	assert.NoError(t, err)
+	_, err = mockDB.RecordAlert(broadcast.Alert{
+		Rule: "offline", Hostname: "gpu04", State: broadcast.AlertResolved,
+		Since: lastWeek.Unix(), FiredAt: lastWeek.Unix(), ResolvedAt: lastWeek.Add(time.Hour).Unix(),
+	})
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/alerts", nil)
+	resp, err := api.ActiveAlerts(req, mockLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.Status)
+	if assert.Len(t, resp.Body, 1) {
+		assert.Equal(t, "gpu03", resp.Body[0].Hostname)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/alerts/history", nil)
+	resp, err = api.AlertHistory(req, mockLogger)
+	assert.NoError(t, err)
+	assert.Len(t, resp.Body, 1)
+
+	req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/alerts/history?from=%d", lastWeek.Add(-time.Hour).Unix()), nil)
+	resp, err = api.AlertHistory(req, mockLogger)
+	assert.NoError(t, err)
+	assert.Len(t, resp.Body, 2)
+}
+
+func TestUsageCSV(t *testing.T) {
+	mockDB := database.InMemory()
+	mockLogger := slog.Default()
+	// sampled every half hour, so that's not a gap in the samples
+	api := &webapi.Api{DB: mockDB, MaxSampleGap: time.Hour}
+	hostname := "gpu03"
+	gpu := uuid.MustParse("5c0b0a61-3f43-4d0c-8b55-3c1f1c6c9a27")
+
+	assert.NoError(t, mockDB.UpdateLastSeen(hostname, time.Now()))
+	assert.NoError(t, mockDB.UpdateGPUContext(hostname, uplink.GPUInfo{Uuid: gpu}))
+
+	start := time.Now().Add(-2 * time.Hour)
+	for i := range 3 {
+		assert.NoError(t, mockDB.AppendDataPoint(uplink.GPUStatSample{
+			Uuid:             gpu,
+			Time:             start.Add(time.Duration(i) * 30 * time.Minute).Unix(),
+			GPUUtilisation:   80,
+			PowerDraw:        250,
+			RunningProcesses: uplink.Processes{{Pid: 42, Name: "python3", MemUsed: 2048, Owner: "alice"}},
+		}))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/stats/usage.csv?by=user", nil)
+	resp, err := api.UsageCSV(req, mockLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.Status)
+	assert.Equal(t, "text/csv", resp.Headers["Content-Type"])
+	assert.Equal(t, "user,gpu_hours,average_utilisation,energy_joules\nalice,1.000,80.0,900000\n", string(resp.Body))
+}
+
 func TestServerEndpoints(t *testing.T) {
 	mockDB := database.InMemory()
 
@@ -298,7 +632,7 @@ func TestServerEndpoints(t *testing.T) {
 
 	var totalEnergy atomic.Uint64
 	totalEnergy.Store(420)
-	server := webapi.NewServer(mockDB, &auth, tunnel.Config{}, &totalEnergy)
+	server := webapi.NewServer(mockDB, &auth, tunnel.Config{}, localStore(t), 0, 0, &totalEnergy)
 
 	tests := []struct {
 		name           string
@@ -369,6 +703,34 @@ func TestServerEndpoints(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 			headers:        map[string]string{"Cookie": "token=example_token"},
 		},
+		{
+			name:           "Test streaming uploads are authenticated",
+			method:         http.MethodPost,
+			endpoint:       "/api/admin/upload_file?hostname=test",
+			expectedStatus: http.StatusUnauthorized,
+			headers:        map[string]string{"Cookie": "token=wrongtoken"},
+		},
+		{
+			name:           "Test streaming uploads need a form",
+			method:         http.MethodPost,
+			endpoint:       "/api/admin/upload_file?hostname=test",
+			expectedStatus: http.StatusBadRequest,
+			headers:        map[string]string{"Cookie": "token=example_token"},
+		},
+		{
+			name:           "Test streaming downloads are authenticated",
+			method:         http.MethodGet,
+			endpoint:       "/api/admin/download_file?hostname=test&file=test",
+			expectedStatus: http.StatusUnauthorized,
+			headers:        map[string]string{"Cookie": "token=wrongtoken"},
+		},
+		{
+			name:           "Test streaming downloads of missing files",
+			method:         http.MethodGet,
+			endpoint:       "/api/admin/download_file?hostname=test&file=test",
+			expectedStatus: http.StatusNotFound,
+			headers:        map[string]string{"Cookie": "token=example_token"},
+		},
 		{
 			name:           "Test listing files is autheticated",
 			method:         http.MethodGet,
@@ -384,6 +746,254 @@ func TestServerEndpoints(t *testing.T) {
 			body:           []byte(`{"hostname":"bogus", "filename":"bogus"}`),
 			headers:        map[string]string{"Cookie": "token=wrongtoken"},
 		},
+		{
+			name:           "Test listing groups is authenticated",
+			method:         http.MethodGet,
+			endpoint:       "/api/admin/groups",
+			expectedStatus: http.StatusUnauthorized,
+			headers:        map[string]string{"Cookie": "token=wrongtoken"},
+		},
+		{
+			name:           "Test creating groups is authenticated",
+			method:         http.MethodPost,
+			endpoint:       "/api/admin/groups/create",
+			expectedStatus: http.StatusUnauthorized,
+			body:           []byte(`{"name":"lab"}`),
+			headers:        map[string]string{"Cookie": "token=wrongtoken"},
+		},
+		{
+			name:           "Test groups need a name",
+			method:         http.MethodPost,
+			endpoint:       "/api/admin/groups/create",
+			expectedStatus: http.StatusBadRequest,
+			body:           []byte(`{"name":" "}`),
+			headers:        map[string]string{"Cookie": "token=example_token"},
+		},
+		{
+			name:           "Test removing unknown groups",
+			method:         http.MethodPost,
+			endpoint:       "/api/admin/groups/remove",
+			expectedStatus: http.StatusNotFound,
+			body:           []byte(`{"name":"bogus"}`),
+			headers:        map[string]string{"Cookie": "token=example_token"},
+		},
+		{
+			name:           "Test moving unknown machines",
+			method:         http.MethodPost,
+			endpoint:       "/api/admin/groups/move",
+			expectedStatus: http.StatusNotFound,
+			body:           []byte(`{"hostnames":["bogus"], "group":"lab"}`),
+			headers:        map[string]string{"Cookie": "token=example_token"},
+		},
+		{
+			name:           "Test tagging is authenticated",
+			method:         http.MethodPost,
+			endpoint:       "/api/admin/add_tag",
+			expectedStatus: http.StatusUnauthorized,
+			body:           []byte(`{"hostname":"bogus", "tag":"teaching"}`),
+			headers:        map[string]string{"Cookie": "token=wrongtoken"},
+		},
+		{
+			name:           "Test tagging unknown machines",
+			method:         http.MethodPost,
+			endpoint:       "/api/admin/add_tag",
+			expectedStatus: http.StatusNotFound,
+			body:           []byte(`{"hostname":"bogus", "tag":"teaching"}`),
+			headers:        map[string]string{"Cookie": "token=example_token"},
+		},
+		{
+			name:           "Test setting metadata is authenticated",
+			method:         http.MethodPost,
+			endpoint:       "/api/admin/set_metadata",
+			expectedStatus: http.StatusUnauthorized,
+			body:           []byte(`{"hostname":"bogus", "key":"room", "type":"string", "value":"219"}`),
+			headers:        map[string]string{"Cookie": "token=wrongtoken"},
+		},
+		{
+			name:           "Test metadata of the wrong type is rejected",
+			method:         http.MethodPost,
+			endpoint:       "/api/admin/set_metadata",
+			expectedStatus: http.StatusBadRequest,
+			body:           []byte(`{"hostname":"bogus", "key":"warranty", "type":"date", "value":"soon"}`),
+			headers:        map[string]string{"Cookie": "token=example_token"},
+		},
+		{
+			name:           "Test listing settings is authenticated",
+			method:         http.MethodGet,
+			endpoint:       "/api/admin/list_settings",
+			expectedStatus: http.StatusUnauthorized,
+			headers:        map[string]string{"Cookie": "token=wrongtoken"},
+		},
+		{
+			name:           "Test setting settings is authenticated",
+			method:         http.MethodPost,
+			endpoint:       "/api/admin/set_settings",
+			expectedStatus: http.StatusUnauthorized,
+			body:           []byte(`{"group":"lab", "settings":{"data_interval":1000000000}}`),
+			headers:        map[string]string{"Cookie": "token=wrongtoken"},
+		},
+		{
+			name:           "Test setting group settings",
+			method:         http.MethodPost,
+			endpoint:       "/api/admin/set_settings",
+			expectedStatus: http.StatusOK,
+			body:           []byte(`{"group":"lab", "settings":{"data_interval":1000000000}}`),
+			headers:        map[string]string{"Cookie": "token=example_token"},
+		},
+		{
+			name:           "Test settings need a group or machine",
+			method:         http.MethodPost,
+			endpoint:       "/api/admin/set_settings",
+			expectedStatus: http.StatusBadRequest,
+			body:           []byte(`{"settings":{"data_interval":1000000000}}`),
+			headers:        map[string]string{"Cookie": "token=example_token"},
+		},
+		{
+			name:           "Test settings for unknown machines",
+			method:         http.MethodPost,
+			endpoint:       "/api/admin/set_settings",
+			expectedStatus: http.StatusNotFound,
+			body:           []byte(`{"hostname":"bogus", "settings":{"data_interval":1000000000}}`),
+			headers:        map[string]string{"Cookie": "token=example_token"},
+		},
+		{
+			name:           "Test listing settings",
+			method:         http.MethodGet,
+			endpoint:       "/api/admin/list_settings",
+			expectedStatus: http.StatusOK,
+			headers:        map[string]string{"Cookie": "token=example_token"},
+		},
+		{
+			name:           "Test invalid process filters are rejected",
+			method:         http.MethodPost,
+			endpoint:       "/api/admin/set_settings",
+			expectedStatus: http.StatusBadRequest,
+			body:           []byte(`{"group":"lab", "settings":{"process_filter":{"include":[{"name":"("}]}}}`),
+			headers:        map[string]string{"Cookie": "token=example_token"},
+		},
+		{
+			name:           "Test intervals can be given as durations",
+			method:         http.MethodPost,
+			endpoint:       "/api/admin/set_settings",
+			expectedStatus: http.StatusOK,
+			body:           []byte(`{"group":"lab", "settings":{"data_interval":"15s", "heartbeat_interval":"2s"}}`),
+			headers:        map[string]string{"Cookie": "token=example_token"},
+		},
+		{
+			name:           "Test intervals under a second are rejected",
+			method:         http.MethodPost,
+			endpoint:       "/api/admin/set_settings",
+			expectedStatus: http.StatusBadRequest,
+			body:           []byte(`{"group":"lab", "settings":{"data_interval":60}}`),
+			headers:        map[string]string{"Cookie": "token=example_token"},
+		},
+		{
+			name:           "Test unknown collectors are rejected",
+			method:         http.MethodPost,
+			endpoint:       "/api/admin/set_settings",
+			expectedStatus: http.StatusBadRequest,
+			body:           []byte(`{"group":"lab", "settings":{"collectors":["intel"]}}`),
+			headers:        map[string]string{"Cookie": "token=example_token"},
+		},
+		{
+			name:           "Test listing jobs needs a hostname",
+			method:         http.MethodGet,
+			endpoint:       "/api/stats/jobs",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Test listing jobs needs valid times",
+			method:         http.MethodGet,
+			endpoint:       "/api/stats/jobs?hostname=bogus&from=yesterday",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Test listing jobs for unknown machines",
+			method:         http.MethodGet,
+			endpoint:       "/api/stats/jobs?hostname=bogus",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Test listing inventory changes needs a hostname",
+			method:         http.MethodGet,
+			endpoint:       "/api/stats/inventory",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Test listing inventory changes for unknown machines",
+			method:         http.MethodGet,
+			endpoint:       "/api/stats/inventory?hostname=bogus",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Test active alerts",
+			method:         http.MethodGet,
+			endpoint:       "/api/alerts",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Test alert history needs valid times",
+			method:         http.MethodGet,
+			endpoint:       "/api/alerts/history?from=yesterday",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Test usage",
+			method:         http.MethodGet,
+			endpoint:       "/api/stats/usage?by=group",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Test usage as CSV",
+			method:         http.MethodGet,
+			endpoint:       "/api/stats/usage.csv?by=machine&from=0",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Test usage needs a known grouping",
+			method:         http.MethodGet,
+			endpoint:       "/api/stats/usage?by=colour",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Test historical data",
+			method:         http.MethodGet,
+			endpoint:       "/api/stats/historical?hostname=bogus&from=0&step=5m",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Test historical data needs a valid step",
+			method:         http.MethodGet,
+			endpoint:       "/api/stats/historical?hostname=bogus&step=10ms",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Test historical data needs a valid gpu",
+			method:         http.MethodGet,
+			endpoint:       "/api/stats/historical?hostname=bogus&gpu=gpu0",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Test listing processes is authenticated",
+			method:         http.MethodGet,
+			endpoint:       "/api/admin/processes?hostname=bogus",
+			expectedStatus: http.StatusUnauthorized,
+			headers:        map[string]string{"Cookie": "token=wrongtoken"},
+		},
+		{
+			name:           "Test listing processes needs a hostname",
+			method:         http.MethodGet,
+			endpoint:       "/api/admin/processes",
+			expectedStatus: http.StatusBadRequest,
+			headers:        map[string]string{"Cookie": "token=example_token"},
+		},
+		{
+			name:           "Test listing processes for unknown machines",
+			method:         http.MethodGet,
+			endpoint:       "/api/admin/processes?hostname=bogus",
+			expectedStatus: http.StatusNotFound,
+			headers:        map[string]string{"Cookie": "token=example_token"},
+		},
 	}
 
 	for _, tc := range tests {
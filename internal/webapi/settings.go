@@ -0,0 +1,58 @@
+package webapi
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+	"github.com/gpuctl/gpuctl/internal/database"
+	"github.com/gpuctl/gpuctl/internal/femto"
+	"github.com/gpuctl/gpuctl/internal/types"
+)
+
+func (a *Api) SettingsOverrides(r *http.Request, l *slog.Logger) (*femto.Response[[]broadcast.SettingsOverride], error) {
+	overrides, err := a.DB.SettingsOverrides()
+	if err != nil {
+		return nil, err
+	}
+	return femto.Ok(overrides)
+}
+
+// Satellites pick up the change with their next heartbeat
+func (a *Api) SetSettingsOverride(override broadcast.SettingsOverride, r *http.Request, l *slog.Logger) (*femto.EmptyBodyResponse, error) {
+	l.Info("Tried to override satellite settings", "group", override.Group, "host", override.Hostname, "settings", override.Settings)
+
+	err := override.Settings.Validate()
+	if err != nil {
+		return &femto.EmptyBodyResponse{Status: http.StatusBadRequest}, err
+	}
+
+	err = a.DB.SetSettingsOverride(override)
+	if errors.Is(err, database.ErrInvalidOverride) {
+		return &femto.EmptyBodyResponse{Status: http.StatusBadRequest}, err
+	} else if errors.Is(err, database.ErrNoSuchMachine) {
+		return &femto.EmptyBodyResponse{Status: http.StatusNotFound}, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	return femto.Ok(types.Unit{})
+}
+
+// Everything running on a machine's gpus, including processes its filter drops
+func (a *Api) Processes(r *http.Request, l *slog.Logger) (*femto.Response[[]broadcast.GPUProcesses], error) {
+	hostname := r.URL.Query().Get("hostname")
+	if hostname == "" {
+		return &femto.Response[[]broadcast.GPUProcesses]{Status: http.StatusBadRequest}, nil
+	}
+
+	procs, err := a.DB.LatestProcesses(hostname)
+	if errors.Is(err, database.ErrNoSuchMachine) {
+		return &femto.Response[[]broadcast.GPUProcesses]{Status: http.StatusNotFound}, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	return femto.Ok(procs)
+}
@@ -0,0 +1,85 @@
+package webapi
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+	"github.com/gpuctl/gpuctl/internal/database"
+	"github.com/gpuctl/gpuctl/internal/femto"
+	"github.com/gpuctl/gpuctl/internal/types"
+)
+
+// the status to reply with when labelling a machine fails, 0 if it's our fault
+func labelErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrInvalidTag), errors.Is(err, database.ErrInvalidMetadata):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrNoSuchMachine):
+		return http.StatusNotFound
+	default:
+		return 0
+	}
+}
+
+func labelResponse(err error) (*femto.EmptyBodyResponse, error) {
+	if status := labelErrorStatus(err); status != 0 {
+		return &femto.EmptyBodyResponse{Status: status}, err
+	} else if err != nil {
+		return nil, err
+	}
+	return femto.Ok(types.Unit{})
+}
+
+func (a *Api) AddTag(tag broadcast.MachineTag, r *http.Request, l *slog.Logger) (*femto.EmptyBodyResponse, error) {
+	l.Info("Tried to tag machine", "host", tag.Hostname, "tag", tag.Tag)
+	return labelResponse(a.DB.AddTag(tag))
+}
+
+func (a *Api) RemoveTag(tag broadcast.MachineTag, r *http.Request, l *slog.Logger) (*femto.EmptyBodyResponse, error) {
+	l.Info("Tried to untag machine", "host", tag.Hostname, "tag", tag.Tag)
+	return labelResponse(a.DB.RemoveTag(tag))
+}
+
+func (a *Api) SetMetadata(meta broadcast.SetMetadata, r *http.Request, l *slog.Logger) (*femto.EmptyBodyResponse, error) {
+	l.Info("Tried to set machine metadata", "host", meta.Hostname, "key", meta.Key, "type", meta.Type, "value", meta.Value)
+	return labelResponse(a.DB.SetMetadata(meta))
+}
+
+func (a *Api) RemoveMetadata(remove broadcast.RemoveMetadata, r *http.Request, l *slog.Logger) (*femto.EmptyBodyResponse, error) {
+	l.Info("Tried to remove machine metadata", "host", remove.Hostname, "key", remove.Key)
+	return labelResponse(a.DB.RemoveMetadata(remove))
+}
+
+// Only the machines with every one of tags, leaving out groups with none left
+func filterByTags(data broadcast.Workstations, tags []string) broadcast.Workstations {
+	if len(tags) == 0 {
+		return data
+	}
+
+	filtered := broadcast.Workstations{}
+	for _, group := range data {
+		var machines []broadcast.Workstation
+		for _, machine := range group.Workstations {
+			if hasTags(machine, tags) {
+				machines = append(machines, machine)
+			}
+		}
+		if len(machines) > 0 {
+			group.Workstations = machines
+			filtered = append(filtered, group)
+		}
+	}
+	return filtered
+}
+
+func hasTags(machine broadcast.Workstation, tags []string) bool {
+	for _, tag := range tags {
+		if !slices.Contains(machine.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
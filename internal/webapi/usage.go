@@ -0,0 +1,101 @@
+package webapi
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gpuctl/gpuctl/internal/broadcast"
+	"github.com/gpuctl/gpuctl/internal/database"
+	"github.com/gpuctl/gpuctl/internal/femto"
+)
+
+// How far back usage is totalled when the request doesn't say
+const DefaultUsageWindow = 30 * 24 * time.Hour
+
+// The longest a sample accounts for in usage, unless configured otherwise.
+// Any more time since the one before it is when the gpu went unreported
+const DefaultMaxSampleGap = 5 * time.Minute
+
+func (a *Api) maxSampleGap() time.Duration {
+	return cmp.Or(a.MaxSampleGap, DefaultMaxSampleGap)
+}
+
+// What each user, group or machine (by) used between the unix times from and
+// to, which default to the last 30 days
+func (a *Api) Usage(r *http.Request, l *slog.Logger) (*femto.Response[[]broadcast.Usage], error) {
+	usage, status, err := a.usage(r)
+	if err != nil || status != http.StatusOK {
+		return &femto.Response[[]broadcast.Usage]{Status: status}, err
+	}
+	return femto.Ok(usage)
+}
+
+// The same as Usage, as a CSV file
+func (a *Api) UsageCSV(r *http.Request, l *slog.Logger) (*femto.Response[[]byte], error) {
+	usage, status, err := a.usage(r)
+	if err != nil || status != http.StatusOK {
+		return &femto.Response[[]byte]{Status: status}, err
+	}
+
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		by = string(database.UsageByUser)
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{by, "gpu_hours", "average_utilisation", "energy_joules"})
+	for _, u := range usage {
+		w.Write([]string{
+			u.Name,
+			strconv.FormatFloat(u.GPUHours, 'f', 3, 64),
+			strconv.FormatFloat(u.AverageUtilisation, 'f', 1, 64),
+			strconv.FormatFloat(u.Energy, 'f', 0, 64),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+
+	return &femto.Response[[]byte]{
+		Status: http.StatusOK,
+		Body:   buf.Bytes(),
+		Headers: map[string]string{
+			"Content-Type":        "text/csv",
+			"Content-Disposition": "attachment; filename=usage_by_" + by + ".csv",
+		},
+	}, nil
+}
+
+func (a *Api) usage(r *http.Request) ([]broadcast.Usage, int, error) {
+	query := r.URL.Query()
+
+	by := database.UsageBy(query.Get("by"))
+	if by == "" {
+		by = database.UsageByUser
+	}
+	if !by.Valid() {
+		return nil, http.StatusBadRequest, database.ErrInvalidUsageBy
+	}
+
+	to, err := unixParam(query.Get("to"), time.Now())
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	from, err := unixParam(query.Get("from"), to.Add(-DefaultUsageWindow))
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	usage, err := a.DB.Usage(from, to, by, a.maxSampleGap())
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return usage, http.StatusOK, nil
+}